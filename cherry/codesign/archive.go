@@ -0,0 +1,198 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/scratch/cherry/codesign/machosign"
+)
+
+// Static archives are in the BSD ar format written by Apple's libtool
+// and ar: a global header followed by members, each with a 60-byte
+// header and its data, padded to an even size. A name that does not
+// fit in the header, or contains spaces, is written as "#1/n" and
+// stored in the first n bytes of the data.
+const (
+	arMagic     = "!<arch>\n"
+	arHeaderLen = 60
+	arLongName  = "#1/"
+)
+
+// An arMember is a member of a static archive.
+type arMember struct {
+	name    string
+	header  []byte // the 60-byte header
+	nameLen int    // length of the name at the start of data, for long names
+	data    []byte // including a long name
+	off     int64  // file offset of the header
+}
+
+// body returns the contents of m, without a long name.
+func (m *arMember) body() []byte { return m.data[m.nameLen:] }
+
+// isArchive reports whether r is a static archive.
+func isArchive(r io.ReaderAt) bool {
+	var magic [len(arMagic)]byte
+	_, err := r.ReadAt(magic[:], 0)
+	return err == nil && string(magic[:]) == arMagic
+}
+
+// readArchive parses the static archive data.
+func readArchive(data []byte) ([]*arMember, error) {
+	var ms []*arMember
+	off := int64(len(arMagic))
+	for off < int64(len(data)) {
+		if off+arHeaderLen > int64(len(data)) {
+			return nil, fmt.Errorf("truncated member header at %#x", off)
+		}
+		h := data[off : off+arHeaderLen]
+		if string(h[58:]) != "`\n" {
+			return nil, fmt.Errorf("bad member header at %#x", off)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(h[48:58])), 10, 64)
+		if err != nil || size < 0 || off+arHeaderLen+size > int64(len(data)) {
+			return nil, fmt.Errorf("bad member size at %#x", off)
+		}
+		m := &arMember{
+			name:   strings.TrimRight(string(h[:16]), " "),
+			header: h,
+			data:   data[off+arHeaderLen : off+arHeaderLen+size],
+			off:    off,
+		}
+		if n, ok := strings.CutPrefix(m.name, arLongName); ok {
+			m.nameLen, err = strconv.Atoi(n)
+			if err != nil || m.nameLen > len(m.data) {
+				return nil, fmt.Errorf("bad member name %q at %#x", m.name, off)
+			}
+			m.name = strings.TrimRight(string(m.data[:m.nameLen]), "\x00")
+		}
+		ms = append(ms, m)
+		off += arHeaderLen + size + size&1
+	}
+	return ms, nil
+}
+
+// signArchive signs the Mach-O members of the static archive f, named
+// fname, and rewrites f. Members that cannot be signed, such as object
+// files, which have no __LINKEDIT segment, are left as they are, and
+// the symbol table is updated for the new member offsets.
+func signArchive(f *os.File, fname string) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, st.Size())
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return err
+	}
+	ms, err := readArchive(data)
+	if err != nil {
+		return err
+	}
+
+	signed := 0
+	for _, m := range ms {
+		body := m.body()
+		if !bytes.HasPrefix(body, machOMagic64) {
+			continue
+		}
+		buf := machosign.NewBuffer(bytes.Clone(body))
+		err := signUUID(fmt.Sprintf("%s(%s)", fname, m.name), buf, options(buf))
+		if errors.Is(err, machosign.ErrNotMachO) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+		m.data = append(m.data[:m.nameLen:m.nameLen], buf.Bytes()...)
+		signed++
+	}
+	if signed == 0 {
+		return fmt.Errorf("%w: no Mach-O members with a __LINKEDIT segment", machosign.ErrNotMachO)
+	}
+
+	// Lay out the members again and fix up the symbol table, whose
+	// entries refer to members by the offset of their header.
+	newOff := make(map[int64]int64)
+	off := int64(len(arMagic))
+	for _, m := range ms {
+		newOff[m.off] = off
+		n := int64(len(m.data))
+		off += arHeaderLen + n + n&1
+	}
+	for _, m := range ms {
+		if strings.HasPrefix(m.name, "__.SYMDEF") {
+			if err := fixSymdef(m, newOff); err != nil {
+				return err
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString(arMagic)
+	for _, m := range ms {
+		h := bytes.Clone(m.header)
+		copy(h[48:58], fmt.Sprintf("%-10d", len(m.data)))
+		out.Write(h)
+		out.Write(m.data)
+		if len(m.data)&1 != 0 {
+			out.WriteByte('\n')
+		}
+	}
+	if _, err := f.WriteAt(out.Bytes(), 0); err != nil {
+		return err
+	}
+	return f.Truncate(int64(out.Len()))
+}
+
+// fixSymdef rewrites the member offsets of the symbol table member m,
+// mapping old offsets to new ones with newOff. The table is a list of
+// (string index, member offset) pairs preceded by its size in bytes,
+// all 32-bit or, in __.SYMDEF_64, 64-bit little endian values.
+func fixSymdef(m *arMember, newOff map[int64]int64) error {
+	body := bytes.Clone(m.body())
+	size := 4
+	if strings.HasPrefix(m.name, "__.SYMDEF_64") {
+		size = 8
+	}
+	get := func(b []byte) int64 {
+		if size == 8 {
+			return int64(binary.LittleEndian.Uint64(b))
+		}
+		return int64(binary.LittleEndian.Uint32(b))
+	}
+	put := func(b []byte, x int64) {
+		if size == 8 {
+			binary.LittleEndian.PutUint64(b, uint64(x))
+		} else {
+			binary.LittleEndian.PutUint32(b, uint32(x))
+		}
+	}
+	if len(body) < size {
+		return fmt.Errorf("%s: truncated", m.name)
+	}
+	n := get(body)
+	if n < 0 || n%int64(2*size) != 0 || int64(size)+n > int64(len(body)) {
+		return fmt.Errorf("%s: bad table size %d", m.name, n)
+	}
+	for p := int64(size); p < int64(size)+n; p += int64(2 * size) {
+		e := body[p+int64(size):]
+		off, ok := newOff[get(e)]
+		if !ok {
+			return fmt.Errorf("%s: entry at %#x refers to no member (offset %#x)", m.name, p, get(e))
+		}
+		put(e, off)
+	}
+	m.data = append(m.data[:m.nameLen:m.nameLen], body...)
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"golang.org/x/scratch/cherry/codesign/machosign"
+)
+
+// batchResult is the outcome of signing one file in batch mode.
+type batchResult struct {
+	File   string             `json:"file"`
+	CDHash machosign.HexBytes `json:"cdhash,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	err    error
+}
+
+// signBatch signs the files in place, several at a time, and reports
+// the outcome for each file to w, in the order given. With -r,
+// directories are searched recursively for Mach-O files. It reports
+// the error of the first file that failed to be signed, if any, and
+// any error walking the directories or writing the report.
+func signBatch(w io.Writer, args []string) (failed, err error) {
+	var files []string
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil || !st.IsDir() || !*recursive {
+			files = append(files, arg)
+			continue
+		}
+		found, err := findMachO(arg)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+
+	results := make([]batchResult, len(files))
+	var wg sync.WaitGroup
+	sem := make(chan bool, runtime.GOMAXPROCS(0))
+	for i, file := range files {
+		wg.Add(1)
+		sem <- true
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			r := &results[i]
+			r.File = file
+			name, err := signFile(file)
+			if err == nil && *cdhash {
+				var f *os.File
+				if f, err = os.Open(name); err == nil {
+					r.CDHash, err = readCDHash(f)
+					f.Close()
+				}
+				if err != nil {
+					err = fmt.Errorf("%s: %w", name, err)
+				}
+			}
+			if err != nil {
+				r.err = err
+				r.Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		var err error
+		switch {
+		case *jsonOut:
+			err = json.NewEncoder(w).Encode(r)
+		case r.Error != "":
+			_, err = fmt.Fprintf(w, "FAIL %s\n", r.Error) // includes the file name
+		case r.CDHash != nil:
+			_, err = fmt.Fprintf(w, "ok   %s %v\n", r.File, r.CDHash)
+		default:
+			_, err = fmt.Fprintf(w, "ok   %s\n", r.File)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if failed == nil {
+			failed = r.err
+		}
+	}
+	return failed, nil
+}
+
+// findMachO returns the 64-bit Mach-O files in the tree rooted at dir.
+// Symbolic links are not followed.
+func findMachO(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var magic [4]byte
+		if _, err := io.ReadFull(f, magic[:]); err == nil && bytes.Equal(magic[:], machOMagic64) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// machOMagic64 is the magic number of 64-bit little endian Mach-O
+// files, as it appears at the start of the file.
+var machOMagic64 = []byte{0xcf, 0xfa, 0xed, 0xfe}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/scratch/cherry/codesign/machosign"
+)
+
+// batchDir writes a directory tree of two Mach-O files, one file that
+// starts like one but is not, and other files, and returns its path
+// and the Mach-O files in the order signBatch finds them.
+func batchDir(t *testing.T) (dir string, machO []string) {
+	exe := helloBinary(t)
+	dir = t.TempDir()
+	writeFiles(t, dir, map[string][]byte{
+		"b/hello2":   exe,
+		"bad":        append(slices.Clone(machOMagic64), "not really"...),
+		"hello":      exe,
+		"readme.txt": []byte("hello\n"),
+		"short":      machOMagic64[:2],
+	})
+	if err := os.Symlink("hello", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir, []string{filepath.Join(dir, "b", "hello2"), filepath.Join(dir, "bad"), filepath.Join(dir, "hello")}
+}
+
+// cdHashOf returns the cdhash of the signed file name.
+func cdHashOf(t *testing.T, name string) machosign.HexBytes {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cd, err := machosign.ReadCodeDirectory(f)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	h, err := cd.CDHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestFindMachO(t *testing.T) {
+	dir, want := batchDir(t)
+	got, err := findMachO(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("findMachO = %q, want %q", got, want)
+	}
+}
+
+// TestSignBatch checks that signBatch -r signs the Mach-O files in a
+// directory, reports each in order, with its cdhash with -cdhash, and
+// returns the error of the one that cannot be signed.
+func TestSignBatch(t *testing.T) {
+	defer func(r, c, j bool) { *recursive, *cdhash, *jsonOut = r, c, j }(*recursive, *cdhash, *jsonOut)
+	*recursive, *cdhash, *jsonOut = true, true, false
+
+	dir, files := batchDir(t)
+	var out bytes.Buffer
+	failed, err := signBatch(&out, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed == nil || !strings.Contains(failed.Error(), files[1]) {
+		t.Errorf("signBatch failure = %v, want an error for %s", failed, files[1])
+	}
+	want := fmt.Sprintf("ok   %s %v\nFAIL %v\nok   %s %v\n",
+		files[0], cdHashOf(t, files[0]), failed, files[2], cdHashOf(t, files[2]))
+	if out.String() != want {
+		t.Errorf("signBatch output:\n%s\nwant:\n%s", &out, want)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "readme.txt")); err != nil || string(got) != "hello\n" {
+		t.Errorf("readme.txt = %q, %v; want it unchanged", got, err)
+	}
+}
+
+// TestSignBatchJSON checks the JSON report of signBatch, for a
+// directory and a file given by name.
+func TestSignBatchJSON(t *testing.T) {
+	defer func(r, c, j bool) { *recursive, *cdhash, *jsonOut = r, c, j }(*recursive, *cdhash, *jsonOut)
+	*recursive, *cdhash, *jsonOut = true, false, true
+
+	dir, files := batchDir(t)
+	missing := filepath.Join(dir, "missing")
+	var out bytes.Buffer
+	failed, err := signBatch(&out, []string{filepath.Join(dir, "b"), missing, files[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.IsNotExist(failed) {
+		t.Errorf("signBatch failure = %v, want not exist", failed)
+	}
+	var got []batchResult
+	for d := json.NewDecoder(&out); d.More(); {
+		var r batchResult
+		if err := d.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	want := []batchResult{{File: files[0]}, {File: missing, Error: failed.Error()}, {File: files[2]}}
+	if !slices.EqualFunc(got, want, func(a, b batchResult) bool {
+		return a.File == b.File && a.Error == b.Error && a.CDHash == nil
+	}) {
+		t.Errorf("signBatch JSON = %+v, want %+v", got, want)
+	}
+	for _, f := range []string{files[0], files[2]} {
+		cdHashOf(t, f) // fails unless signed
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A bundle is an .app or .framework bundle directory.
+type bundle struct {
+	exe           string   // path of the main executable
+	contents      string   // directory holding the bundle's files
+	exeRel        string   // exe, relative to contents
+	infoRel       string   // Info.plist, relative to contents
+	infoPlist     []byte   // contents of Info.plist
+	codeResources []byte   // contents of _CodeSignature/CodeResources
+	nested        []string // other Mach-O files of a framework
+}
+
+// openBundle reads the Info.plist of the bundle dir. For a framework,
+// it also finds the Mach-O files besides the main executable, e.g.
+// helper dylibs, which must be signed before the bundle's
+// CodeResources are read, as the latter record their hashes.
+//
+// An .app bundle keeps its files in Contents, with the executable in
+// Contents/MacOS. A framework keeps them in Versions/Current, with
+// Info.plist in Resources, or for shallow (iOS-style) frameworks,
+// in the bundle directory itself.
+func openBundle(dir string) (*bundle, error) {
+	b := new(bundle)
+	var err error
+	if strings.HasSuffix(filepath.Clean(dir), ".framework") {
+		b.contents, b.infoRel = dir, "Info.plist"
+		if cur := filepath.Join(dir, "Versions", "Current"); exists(cur) {
+			b.infoRel = "Resources/Info.plist"
+			b.contents, err = filepath.EvalSymlinks(cur)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		b.contents, b.infoRel = filepath.Join(dir, "Contents"), "Info.plist"
+	}
+	b.infoPlist, err = os.ReadFile(filepath.Join(b.contents, filepath.FromSlash(b.infoRel)))
+	if err != nil {
+		return nil, err
+	}
+	name, err := plistString(b.infoPlist, "CFBundleExecutable")
+	if err != nil {
+		return nil, fmt.Errorf("%s: Info.plist: %v", dir, err)
+	}
+	if strings.HasSuffix(filepath.Clean(dir), ".framework") {
+		b.exeRel = name
+		found, err := findMachO(b.contents)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			if f != filepath.Join(b.contents, name) {
+				b.nested = append(b.nested, f)
+			}
+		}
+	} else {
+		b.exeRel = "MacOS/" + name
+	}
+	b.exe = filepath.Join(b.contents, filepath.FromSlash(b.exeRel))
+	return b, nil
+}
+
+// readCodeResources reads the CodeResources of the bundle. If the
+// bundle has no CodeResources file, one is generated and written to
+// the bundle. If it has one, the hashes of the nested code in it,
+// which signing the nested code changes, are brought up to date.
+func (b *bundle) readCodeResources() error {
+	crPath := filepath.Join(b.contents, "_CodeSignature", "CodeResources")
+	old, err := os.ReadFile(crPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		b.codeResources, err = genCodeResources(b.contents, b.exeRel, b.infoRel)
+	case err == nil:
+		b.codeResources, err = b.updateNested(old)
+	}
+	if err != nil || bytes.Equal(b.codeResources, old) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(crPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(crPath, b.codeResources, 0o644)
+}
+
+var (
+	// plistData matches a data element of a property list.
+	plistData = regexp.MustCompile(`(<data>\s*)[A-Za-z0-9+/=]*(\s*</data>)`)
+	// plistHash matches a hash of a files2 entry of CodeResources.
+	plistHash = regexp.MustCompile(`<key>(hash|hash2|cdhash)</key>\s*<data>\s*[A-Za-z0-9+/=]*\s*</data>`)
+)
+
+// updateNested returns the CodeResources cr with the hashes of the
+// nested code of the bundle updated: its SHA-1 in "files", and its
+// SHA-1, SHA-256 and cdhash in "files2", whichever cr records. If cr
+// has no "files2" entry for some nested code, it returns newly
+// generated CodeResources instead.
+func (b *bundle) updateNested(cr []byte) ([]byte, error) {
+	for _, n := range b.nested {
+		rel, err := filepath.Rel(b.contents, n)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(n)
+		if err != nil {
+			return nil, err
+		}
+		cdh, err := readCDHash(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n, err)
+		}
+		h1, h2 := sha1.Sum(data), sha256.Sum256(data)
+		hashes := map[string][]byte{"hash": h1[:], "hash2": h2[:], "cdhash": cdh}
+		setData := func(m []byte, h []byte) []byte {
+			return plistData.ReplaceAll(m, []byte("${1}"+base64.StdEncoding.EncodeToString(h)+"${2}"))
+		}
+
+		var esc strings.Builder
+		xml.EscapeText(&esc, []byte(filepath.ToSlash(rel)))
+		key := `<key>` + regexp.QuoteMeta(esc.String()) + `</key>\s*`
+		// The entry in "files" is the hash itself, that in "files2" a dict.
+		cr = regexp.MustCompile(key+`<data>\s*[A-Za-z0-9+/=]*\s*</data>`).ReplaceAllFunc(cr, func(m []byte) []byte {
+			return setData(m, h1[:])
+		})
+		files2 := regexp.MustCompile(`(?s)` + key + `<dict>.*?</dict>`)
+		if !files2.Match(cr) {
+			return genCodeResources(b.contents, b.exeRel, b.infoRel)
+		}
+		cr = files2.ReplaceAllFunc(cr, func(m []byte) []byte {
+			return plistHash.ReplaceAllFunc(m, func(m []byte) []byte {
+				return setData(m, hashes[string(plistHash.FindSubmatch(m)[1])])
+			})
+		})
+	}
+	return cr, nil
+}
+
+// exists reports whether the file name exists.
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// plistString returns the string value of key in the top-level
+// dictionary of the XML property list data.
+func plistString(data []byte, key string) (string, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	found := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return "", fmt.Errorf("no %s string", key)
+		}
+		if err != nil {
+			return "", err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			// plist > dict > key
+			if depth != 3 {
+				continue
+			}
+			var s string
+			if err := d.DecodeElement(&s, &tok); err != nil {
+				return "", err
+			}
+			depth--
+			switch {
+			case found && tok.Name.Local == "string":
+				return s, nil
+			case found:
+				return "", fmt.Errorf("%s is a %s, not a string", key, tok.Name.Local)
+			}
+			found = tok.Name.Local == "key" && s == key
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// genCodeResources generates a CodeResources property list for the
+// bundle directory contents, recording the hashes of all files in it
+// except the main executable exe, the Info.plist file info and the
+// signature itself, which are covered by the CodeDirectory. Like codesign, it lists the
+// SHA-1 hashes of the files under Resources in "files", and the
+// SHA-256 hashes of all files in "files2".
+func genCodeResources(contents, exe, info string) ([]byte, error) {
+	type entry struct {
+		name         string
+		sha1, sha256 []byte
+		symlink      string
+		isResource   bool
+	}
+	var files []entry
+	err := filepath.WalkDir(contents, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contents, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == "_CodeSignature" && d.IsDir():
+			return fs.SkipDir
+		case d.IsDir(), rel == exe, rel == info, rel == "PkgInfo":
+			return nil
+		}
+		e := entry{name: rel, isResource: strings.HasPrefix(rel, "Resources/")}
+		if d.Type()&fs.ModeSymlink != 0 {
+			e.symlink, err = os.Readlink(p)
+			files = append(files, e)
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		h1, h2 := sha1.Sum(data), sha256.Sum256(data)
+		e.sha1, e.sha256 = h1[:], h2[:]
+		files = append(files, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	p := func(indent int, format string, args ...any) {
+		b.WriteString(strings.Repeat("\t", indent))
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+	key := func(indent int, k string) {
+		var esc strings.Builder
+		xml.EscapeText(&esc, []byte(k))
+		p(indent, "<key>%s</key>", esc.String())
+	}
+	data := func(indent int, h []byte) {
+		p(indent, "<data>")
+		p(indent, "%s", base64.StdEncoding.EncodeToString(h))
+		p(indent, "</data>")
+	}
+
+	p(0, `<?xml version="1.0" encoding="UTF-8"?>`)
+	p(0, `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">`)
+	p(0, `<plist version="1.0">`)
+	p(0, "<dict>")
+	key(1, "files")
+	p(1, "<dict>")
+	for _, e := range files {
+		if e.isResource && e.symlink == "" {
+			key(2, e.name)
+			data(2, e.sha1)
+		}
+	}
+	p(1, "</dict>")
+	key(1, "files2")
+	p(1, "<dict>")
+	for _, e := range files {
+		key(2, e.name)
+		p(2, "<dict>")
+		if e.symlink != "" {
+			key(3, "symlink")
+			var esc strings.Builder
+			xml.EscapeText(&esc, []byte(path.Clean(e.symlink)))
+			p(3, "<string>%s</string>", esc.String())
+		} else {
+			key(3, "hash2")
+			data(3, e.sha256)
+		}
+		p(2, "</dict>")
+	}
+	p(1, "</dict>")
+	key(1, "rules")
+	p(1, "<dict>")
+	key(2, "^Resources/")
+	p(2, "<true/>")
+	p(1, "</dict>")
+	key(1, "rules2")
+	p(1, "<dict>")
+	key(2, "^.*")
+	p(2, "<true/>")
+	for _, k := range []string{"^" + regexp.QuoteMeta(info) + "$", `^PkgInfo$`} {
+		key(2, k)
+		p(2, "<dict>")
+		key(3, "omit")
+		p(3, "<true/>")
+		key(3, "weight")
+		p(3, "<real>20</real>")
+		p(2, "</dict>")
+	}
+	p(1, "</dict>")
+	p(0, "</dict>")
+	p(0, "</plist>")
+	return b.Bytes(), nil
+}
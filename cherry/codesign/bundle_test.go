@@ -0,0 +1,56 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestGenCodeResources checks the CodeResources generated for a small
+// .app bundle against testdata/CodeResources.golden: the executable,
+// Info.plist and PkgInfo are left out, the files under Resources are in
+// "files" too, and symbolic links and names needing escapes are
+// recorded as such.
+func TestGenCodeResources(t *testing.T) {
+	contents := filepath.Join(t.TempDir(), "Hello.app", "Contents")
+	writeFiles(t, contents, map[string][]byte{
+		"Info.plist":                              infoPlist("Hello"),
+		"PkgInfo":                                 []byte("APPL????"),
+		"MacOS/Hello":                             []byte("not really an executable"),
+		"MacOS/helper":                            []byte("#!/bin/sh\necho helper\n"),
+		"Resources/en.lproj/Localizable.strings":  []byte(`"hello" = "Hello";` + "\n"),
+		"Resources/Fish & Chips.txt":              []byte("cod\n"),
+		"_CodeSignature/CodeResources":            []byte("an old signature"),
+		"Frameworks/Nested.framework/Resources/x": []byte("x\n"),
+	})
+	if err := os.Symlink("en.lproj/./Localizable.strings", filepath.Join(contents, "Resources", "Base.strings")); err != nil {
+		t.Skipf("cannot make a symbolic link: %v", err)
+	}
+
+	got, err := genCodeResources(contents, "MacOS/Hello", "Info.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "CodeResources.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("genCodeResources =\n%s\nwant\n%s", got, want)
+	}
+}
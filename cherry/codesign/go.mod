@@ -0,0 +1,3 @@
+module golang.org/x/scratch/cherry/codesign
+
+go 1.22
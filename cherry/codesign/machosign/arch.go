@@ -0,0 +1,57 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"debug/macho"
+	"fmt"
+)
+
+// CPU subtypes. The high byte of the subtype holds capability bits,
+// which must be masked off to compare subtypes. For arm64e, it holds
+// the pointer authentication ABI flag and version.
+const (
+	CPU_SUBTYPE_MASK        = 0xff000000 // capability bits
+	CPU_SUBTYPE_PTRAUTH_ABI = 0x80000000 // arm64e: versioned pointer authentication ABI
+
+	CPU_SUBTYPE_X86_64_ALL = 3
+	CPU_SUBTYPE_X86_64_H   = 8 // Haswell
+	CPU_SUBTYPE_ARM64_ALL  = 0
+	CPU_SUBTYPE_ARM64_V8   = 1
+	CPU_SUBTYPE_ARM64E     = 2
+)
+
+// checkCPU reports an error wrapping ErrNotMachO if mf is not for a
+// CPU that darwin signs code for: amd64, arm64 or arm64e.
+func checkCPU(mf *macho.File) error {
+	switch mf.Cpu {
+	case macho.CpuAmd64, macho.CpuArm64:
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported CPU %v", ErrNotMachO, mf.Cpu)
+}
+
+// ArchName returns the name of the architecture of a Mach-O file
+// with the given CPU type and subtype, as Apple's tools print it,
+// e.g. "arm64e". The capability bits of the subtype are ignored.
+func ArchName(cpu macho.Cpu, subCpu uint32) string {
+	sub := subCpu &^ CPU_SUBTYPE_MASK
+	switch cpu {
+	case macho.CpuAmd64:
+		if sub == CPU_SUBTYPE_X86_64_H {
+			return "x86_64h"
+		}
+		return "x86_64"
+	case macho.CpuArm64:
+		switch sub {
+		case CPU_SUBTYPE_ARM64E:
+			return "arm64e"
+		case CPU_SUBTYPE_ARM64_V8:
+			return "arm64v8"
+		}
+		return "arm64"
+	}
+	return fmt.Sprintf("%v(%#x)", cpu, subCpu)
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"errors"
+	"io"
+)
+
+// A Buffer is an in-memory file that can be passed to Sign, for
+// signing binaries that are not on disk.
+type Buffer struct {
+	data []byte
+	off  int64
+}
+
+// NewBuffer returns a Buffer holding data. The Buffer takes
+// ownership of data.
+func NewBuffer(data []byte) *Buffer {
+	return &Buffer{data: data}
+}
+
+// Bytes returns the contents of the buffer.
+func (b *Buffer) Bytes() []byte { return b.data }
+
+func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *Buffer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if end := off + int64(len(p)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	return copy(b.data[off:], p), nil
+}
+
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	b.off = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the buffer.
+func (b *Buffer) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	if size > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, size-int64(len(b.data)))...)
+	}
+	b.data = b.data[:size]
+	return nil
+}
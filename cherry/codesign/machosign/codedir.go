@@ -0,0 +1,383 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// codeDirectoryVersion is the CodeDirectory version Sign emits.
+const codeDirectoryVersion = 0x20400
+
+// CodeDirectory is a CodeDirectory blob. The fields up to LinkageSize
+// are the fixed header, in file order; fields introduced after the
+// blob's Version are not present in the encoded form and are zero
+// when parsed. The remaining fields hold the data the header refers to.
+//
+// A parsed CodeDirectory also keeps the bytes it was parsed from, so
+// that Marshal preserves what the fields do not model, such as the
+// pre-encryption hashes and the linkage data, and the padding.
+type CodeDirectory struct {
+	Magic         uint32 // magic number (CSMAGIC_CODEDIRECTORY)
+	Length        uint32 // total length of CodeDirectory blob
+	Version       uint32 // compatibility version
+	Flags         uint32 // setup and mode flags
+	HashOffset    uint32 // offset of hash slot element at index zero
+	IdentOffset   uint32 // offset of identifier string
+	NSpecialSlots uint32 // number of special hash slots
+	NCodeSlots    uint32 // number of ordinary (code) hash slots
+	CodeLimit     uint32 // limit to main image signature range
+	HashSize      uint8  // size of each hash in bytes
+	HashType      uint8  // type of hash (cdHashType* constants)
+	Platform      uint8  // platform identifier; zero if not platform binary
+	PageSize      uint8  // log2(page size in bytes); 0 => infinite
+	Spare2        uint32 // unused (must be zero)
+
+	// Version 0x20100
+	ScatterOffset uint32 // offset of optional scatter vector
+
+	// Version 0x20200
+	TeamOffset uint32 // offset of optional team identifier
+
+	// Version 0x20300
+	Spare3      uint32 // unused (must be zero)
+	CodeLimit64 uint64 // limit to main image signature range, 64 bits
+
+	// Version 0x20400
+	ExecSegBase  uint64 // offset of executable segment
+	ExecSegLimit uint64 // limit of executable segment
+	ExecSegFlags uint64 // executable segment flags
+
+	// Version 0x20500
+	Runtime          uint32 // hardened runtime version
+	PreEncryptOffset uint32 // offset of optional pre-encryption hash slots
+
+	// Version 0x20600
+	LinkageHashType           uint8  // type of hash of the linkage data
+	LinkageApplicationType    uint8  // application type of the linkage
+	LinkageApplicationSubType uint16 // application subtype of the linkage
+	LinkageOffset             uint32 // offset of optional linkage data
+	LinkageSize               uint32 // size of the linkage data
+
+	Identifier   string    // at IdentOffset
+	TeamID       string    // at TeamOffset, if non-zero
+	Scatter      []Scatter // at ScatterOffset, if non-zero, without the terminator
+	SpecialSlots [][]byte  // hashes of special slots; SpecialSlots[i] is slot -(i+1)
+	CodeSlots    [][]byte  // hashes of code pages
+
+	raw []byte // the blob as parsed, if it was
+}
+
+// codeDirectorySize returns the size of the fixed CodeDirectory header
+// of the given version.
+func codeDirectorySize(version uint32) int {
+	switch {
+	case version < 0x20100:
+		return 44
+	case version < 0x20200:
+		return 48
+	case version < 0x20300:
+		return 52
+	case version < 0x20400:
+		return 64
+	case version < 0x20500:
+		return 88
+	case version < 0x20600:
+		return 96
+	}
+	return 108
+}
+
+// put writes the fixed header of c, as of c.Version, to out.
+func (c *CodeDirectory) put(out []byte) []byte {
+	out = put32be(out, c.Magic)
+	out = put32be(out, c.Length)
+	out = put32be(out, c.Version)
+	out = put32be(out, c.Flags)
+	out = put32be(out, c.HashOffset)
+	out = put32be(out, c.IdentOffset)
+	out = put32be(out, c.NSpecialSlots)
+	out = put32be(out, c.NCodeSlots)
+	out = put32be(out, c.CodeLimit)
+	out = put8(out, c.HashSize)
+	out = put8(out, c.HashType)
+	out = put8(out, c.Platform)
+	out = put8(out, c.PageSize)
+	out = put32be(out, c.Spare2)
+	if c.Version >= 0x20100 {
+		out = put32be(out, c.ScatterOffset)
+	}
+	if c.Version >= 0x20200 {
+		out = put32be(out, c.TeamOffset)
+	}
+	if c.Version >= 0x20300 {
+		out = put32be(out, c.Spare3)
+		out = put64be(out, c.CodeLimit64)
+	}
+	if c.Version >= 0x20400 {
+		out = put64be(out, c.ExecSegBase)
+		out = put64be(out, c.ExecSegLimit)
+		out = put64be(out, c.ExecSegFlags)
+	}
+	if c.Version >= 0x20500 {
+		out = put32be(out, c.Runtime)
+		out = put32be(out, c.PreEncryptOffset)
+	}
+	if c.Version >= 0x20600 {
+		out = put8(out, c.LinkageHashType)
+		out = put8(out, c.LinkageApplicationType)
+		out = put16be(out, c.LinkageApplicationSubType)
+		out = put32be(out, c.LinkageOffset)
+		out = put32be(out, c.LinkageSize)
+	}
+	return out
+}
+
+// SetCodeLimit sets the limit of the signed range of c to limit. If
+// it does not fit in 32 bits, CodeLimit is zero and CodeLimit64 holds
+// the limit, which requires version 0x20300 or later.
+func (c *CodeDirectory) SetCodeLimit(limit uint64) error {
+	if limit <= math.MaxUint32 {
+		c.CodeLimit, c.CodeLimit64 = uint32(limit), 0
+		return nil
+	}
+	if c.Version < 0x20300 {
+		return fmt.Errorf("code limit %#x needs CodeDirectory version 0x20300, have %#x", limit, c.Version)
+	}
+	c.CodeLimit, c.CodeLimit64 = 0, limit
+	return nil
+}
+
+// Limit returns the limit of the signed range of c.
+func (c *CodeDirectory) Limit() uint64 {
+	if c.CodeLimit64 != 0 {
+		return c.CodeLimit64
+	}
+	return uint64(c.CodeLimit)
+}
+
+// ParseCodeDirectory decodes a CodeDirectory blob.
+func ParseCodeDirectory(data []byte) (*CodeDirectory, error) {
+	if len(data) < codeDirectorySize(0) {
+		return nil, errors.New("CodeDirectory too short")
+	}
+	c := &CodeDirectory{
+		Magic:         get32be(data),
+		Length:        get32be(data[4:]),
+		Version:       get32be(data[8:]),
+		Flags:         get32be(data[12:]),
+		HashOffset:    get32be(data[16:]),
+		IdentOffset:   get32be(data[20:]),
+		NSpecialSlots: get32be(data[24:]),
+		NCodeSlots:    get32be(data[28:]),
+		CodeLimit:     get32be(data[32:]),
+		HashSize:      data[36],
+		HashType:      data[37],
+		Platform:      data[38],
+		PageSize:      data[39],
+		Spare2:        get32be(data[40:]),
+	}
+	if c.Magic != CSMAGIC_CODEDIRECTORY {
+		return nil, fmt.Errorf("bad CodeDirectory magic %#x", c.Magic)
+	}
+	if int(c.Length) > len(data) || int(c.Length) < codeDirectorySize(c.Version) {
+		return nil, fmt.Errorf("bad CodeDirectory length %d", c.Length)
+	}
+	data = data[:c.Length]
+	if c.Version >= 0x20100 {
+		c.ScatterOffset = get32be(data[44:])
+	}
+	if c.Version >= 0x20200 {
+		c.TeamOffset = get32be(data[48:])
+	}
+	if c.Version >= 0x20300 {
+		c.Spare3 = get32be(data[52:])
+		c.CodeLimit64 = get64be(data[56:])
+	}
+	if c.Version >= 0x20400 {
+		c.ExecSegBase = get64be(data[64:])
+		c.ExecSegLimit = get64be(data[72:])
+		c.ExecSegFlags = get64be(data[80:])
+	}
+	if c.Version >= 0x20500 {
+		c.Runtime = get32be(data[88:])
+		c.PreEncryptOffset = get32be(data[92:])
+	}
+	if c.Version >= 0x20600 {
+		c.LinkageHashType = data[96]
+		c.LinkageApplicationType = data[97]
+		c.LinkageApplicationSubType = get16be(data[98:])
+		c.LinkageOffset = get32be(data[100:])
+		c.LinkageSize = get32be(data[104:])
+	}
+	c.raw = bytes.Clone(data)
+
+	var err error
+	if c.Identifier, err = cstring(data, c.IdentOffset); err != nil {
+		return nil, fmt.Errorf("CodeDirectory identifier: %v", err)
+	}
+	if c.TeamOffset != 0 {
+		if c.TeamID, err = cstring(data, c.TeamOffset); err != nil {
+			return nil, fmt.Errorf("CodeDirectory team ID: %v", err)
+		}
+	}
+	if c.ScatterOffset != 0 {
+		if c.Scatter, err = parseScatter(data, c.ScatterOffset); err != nil {
+			return nil, fmt.Errorf("CodeDirectory: %v", err)
+		}
+	}
+	hs := uint64(c.HashSize)
+	if uint64(c.NSpecialSlots)*hs > uint64(c.HashOffset) ||
+		uint64(c.HashOffset)+uint64(c.NCodeSlots)*hs > uint64(len(data)) {
+		return nil, errors.New("CodeDirectory hash slots out of range")
+	}
+	for i := uint64(1); i <= uint64(c.NSpecialSlots); i++ {
+		off := uint64(c.HashOffset) - i*hs
+		c.SpecialSlots = append(c.SpecialSlots, data[off:off+hs:off+hs])
+	}
+	for i := uint64(0); i < uint64(c.NCodeSlots); i++ {
+		off := uint64(c.HashOffset) + i*hs
+		c.CodeSlots = append(c.CodeSlots, data[off:off+hs:off+hs])
+	}
+	return c, nil
+}
+
+// Marshal encodes c as a CodeDirectory blob. The identifier, team ID
+// and hashes are placed at the offsets given in the header, over the
+// bytes c was parsed from, if any, so Marshal is the inverse of
+// ParseCodeDirectory.
+func (c *CodeDirectory) Marshal() ([]byte, error) {
+	hs := int(c.HashSize)
+	if len(c.SpecialSlots) != int(c.NSpecialSlots) || len(c.CodeSlots) != int(c.NCodeSlots) {
+		return nil, errors.New("CodeDirectory slot counts do not match hashes")
+	}
+	if int(c.HashOffset) < codeDirectorySize(c.Version)+len(c.SpecialSlots)*hs {
+		return nil, errors.New("CodeDirectory special slots overlap header")
+	}
+	n := int(c.HashOffset) + len(c.CodeSlots)*hs
+	n = max(n, int(c.IdentOffset)+len(c.Identifier)+1)
+	if c.TeamOffset != 0 {
+		n = max(n, int(c.TeamOffset)+len(c.TeamID)+1)
+	}
+	if c.ScatterOffset != 0 {
+		n = max(n, int(c.ScatterOffset)+(len(c.Scatter)+1)*scatterSize)
+	}
+	if int(c.Length) < n {
+		return nil, fmt.Errorf("CodeDirectory length %d too small, need %d", c.Length, n)
+	}
+	out := make([]byte, c.Length)
+	if len(c.raw) == len(out) {
+		copy(out, c.raw)
+	}
+	c.put(out)
+	copy(out[c.IdentOffset:], c.Identifier)
+	if c.TeamOffset != 0 {
+		copy(out[c.TeamOffset:], c.TeamID)
+	}
+	if c.ScatterOffset != 0 {
+		p := out[c.ScatterOffset:]
+		for _, s := range c.Scatter {
+			p = s.put(p)
+		}
+	}
+	for i, h := range c.SpecialSlots {
+		if len(h) != hs {
+			return nil, fmt.Errorf("special slot %d hash has size %d, want %d", i+1, len(h), hs)
+		}
+		copy(out[int(c.HashOffset)-(i+1)*hs:], h)
+	}
+	for i, h := range c.CodeSlots {
+		if len(h) != hs {
+			return nil, fmt.Errorf("code slot %d hash has size %d, want %d", i, len(h), hs)
+		}
+		copy(out[int(c.HashOffset)+i*hs:], h)
+	}
+	return out, nil
+}
+
+// CDHash returns the code directory hash of c, which identifies the
+// signed code: the hash of the encoded blob, truncated to 20 bytes. For
+// a CodeDirectory as parsed, that is the hash of the bytes parsed.
+func (c *CodeDirectory) CDHash() ([]byte, error) {
+	h := newHash(c.HashType)
+	if h == nil {
+		return nil, fmt.Errorf("unknown hash type %d", c.HashType)
+	}
+	b, err := c.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	h.Write(b)
+	return h.Sum(nil)[:20], nil
+}
+
+// A FieldDiff is a difference in one field of two CodeDirectories.
+type FieldDiff struct {
+	Field string // field name, e.g. "Flags" or "CodeSlots[3]"
+	A, B  string // formatted values
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %s != %s", d.Field, d.A, d.B)
+}
+
+// Diff compares a and b field by field and returns the differences.
+// Hash slots are compared individually.
+func Diff(a, b *CodeDirectory) []FieldDiff {
+	var diffs []FieldDiff
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		name := t.Field(i).Name
+		fa, fb := va.Field(i), vb.Field(i)
+		switch x := fa.Interface().(type) {
+		case [][]byte:
+			y := fb.Interface().([][]byte)
+			for j := 0; j < max(len(x), len(y)); j++ {
+				ha, hb := "<none>", "<none>"
+				if j < len(x) {
+					ha = hex.EncodeToString(x[j])
+				}
+				if j < len(y) {
+					hb = hex.EncodeToString(y[j])
+				}
+				if ha != hb {
+					diffs = append(diffs, FieldDiff{fmt.Sprintf("%s[%d]", name, j), ha, hb})
+				}
+			}
+		case []Scatter:
+			y := fb.Interface().([]Scatter)
+			for j := 0; j < max(len(x), len(y)); j++ {
+				sa, sb := "<none>", "<none>"
+				if j < len(x) {
+					sa = x[j].String()
+				}
+				if j < len(y) {
+					sb = y[j].String()
+				}
+				if sa != sb {
+					diffs = append(diffs, FieldDiff{fmt.Sprintf("%s[%d]", name, j), sa, sb})
+				}
+			}
+		case string:
+			if y := fb.String(); x != y {
+				diffs = append(diffs, FieldDiff{name, fmt.Sprintf("%q", x), fmt.Sprintf("%q", y)})
+			}
+		default:
+			if x, y := fa.Uint(), fb.Uint(); x != y {
+				diffs = append(diffs, FieldDiff{name, fmt.Sprintf("%#x", x), fmt.Sprintf("%#x", y)})
+			}
+		}
+	}
+	return diffs
+}
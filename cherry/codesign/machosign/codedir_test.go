@@ -0,0 +1,197 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func testCodeDirectory() *CodeDirectory {
+	hash := func(s string) []byte { h := sha256.Sum256([]byte(s)); return h[:] }
+	c := &CodeDirectory{
+		Magic:         CSMAGIC_CODEDIRECTORY,
+		Version:       codeDirectoryVersion,
+		Flags:         CS_ADHOC | CS_LINKER_SIGNED,
+		NSpecialSlots: 2,
+		NCodeSlots:    3,
+		CodeLimit:     0x2345,
+		HashSize:      sha256.Size,
+		HashType:      kSecCodeSignatureHashSHA256,
+		PageSize:      pageSizeBits,
+		ExecSegLimit:  0x1000,
+		ExecSegFlags:  CS_EXECSEG_MAIN_BINARY,
+		Identifier:    "test",
+		SpecialSlots:  [][]byte{hash("info"), hash("requirements")},
+		CodeSlots:     [][]byte{hash("page0"), hash("page1"), hash("page2")},
+	}
+	c.IdentOffset = uint32(codeDirectorySize(c.Version))
+	c.HashOffset = c.IdentOffset + uint32(len(c.Identifier)+1) + 2*sha256.Size
+	c.Length = c.HashOffset + 3*sha256.Size
+	return c
+}
+
+func TestCodeDirectoryRoundTrip(t *testing.T) {
+	c := testCodeDirectory()
+	b, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ParseCodeDirectory(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c2.raw, b) {
+		t.Errorf("parsed CodeDirectory keeps %x, want %x", c2.raw, b)
+	}
+	c2.raw = nil // c was built, not parsed
+	if !reflect.DeepEqual(c, c2) {
+		t.Errorf("round trip mismatch:\n%+v\n%+v", c, c2)
+	}
+	b2, err := c2.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Errorf("re-encoding differs")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b := testCodeDirectory(), testCodeDirectory()
+	if d := Diff(a, b); len(d) != 0 {
+		t.Errorf("Diff of equal CodeDirectories = %v, want none", d)
+	}
+	b.Flags |= CS_RUNTIME
+	b.Identifier = "other"
+	b.CodeSlots[1] = make([]byte, sha256.Size)
+	var got []string
+	for _, d := range Diff(a, b) {
+		got = append(got, d.Field)
+	}
+	want := []string{"Flags", "Identifier", "CodeSlots[1]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff fields = %v, want %v", got, want)
+	}
+}
+
+func TestCodeLimit64(t *testing.T) {
+	c := testCodeDirectory()
+	const limit = 5 << 30
+	if err := c.SetCodeLimit(limit); err != nil {
+		t.Fatal(err)
+	}
+	if c.CodeLimit != 0 || c.CodeLimit64 != limit {
+		t.Errorf("CodeLimit, CodeLimit64 = %#x, %#x, want 0, %#x", c.CodeLimit, c.CodeLimit64, limit)
+	}
+	b, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ParseCodeDirectory(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c2.Limit(); got != limit {
+		t.Errorf("parsed limit = %#x, want %#x", got, limit)
+	}
+
+	c.Version = 0x20200
+	if err := c.SetCodeLimit(limit); err == nil {
+		t.Errorf("SetCodeLimit succeeded for version %#x", c.Version)
+	}
+}
+
+// appleCodeDirectory returns a CodeDirectory blob of the given version,
+// 0x20500 or later, laid out as Apple's codesign lays out those of
+// hardened-runtime binaries: the header, the identifier, the special
+// and code slots, then the pre-encryption hashes and, from 0x20600,
+// the linkage data, which the CodeDirectory fields do not model.
+func appleCodeDirectory(version uint32) []byte {
+	hdr := uint32(codeDirectorySize(version))
+	identOff := hdr
+	hashOff := identOff + uint32(len("hr\x00")) + 2*sha256.Size
+	preEncOff := hashOff + 2*sha256.Size
+	linkOff := preEncOff + 2*sha256.Size
+	length := linkOff
+	if version >= 0x20600 {
+		length += 16
+	}
+	b := make([]byte, length)
+	be := binary.BigEndian
+	for i, v := range []uint32{CSMAGIC_CODEDIRECTORY, length, version, CS_RUNTIME | CS_ADHOC, hashOff, identOff, 2, 2, 0x1800} {
+		be.PutUint32(b[4*i:], v)
+	}
+	copy(b[36:], []byte{sha256.Size, kSecCodeSignatureHashSHA256, 0, pageSizeBits})
+	be.PutUint64(b[72:], 0x1000)                 // exec segment limit
+	be.PutUint64(b[80:], CS_EXECSEG_MAIN_BINARY) // exec segment flags
+	be.PutUint32(b[88:], 0x000e0000)             // runtime 14.0.0
+	be.PutUint32(b[92:], preEncOff)
+	if version >= 0x20600 {
+		copy(b[96:], []byte{kSecCodeSignatureHashSHA256, 1})
+		be.PutUint16(b[98:], 2)
+		be.PutUint32(b[100:], linkOff)
+		be.PutUint32(b[104:], 16)
+		copy(b[linkOff:], "linkage data....")
+	}
+	copy(b[identOff:], "hr")
+	for i := range 6 {
+		h := sha256.Sum256([]byte{byte(i)})
+		copy(b[hashOff-2*sha256.Size+uint32(i)*sha256.Size:], h[:])
+	}
+	return b
+}
+
+func TestCodeDirectoryHardenedRuntime(t *testing.T) {
+	for _, tt := range []struct {
+		version uint32
+		cdhash  string
+	}{
+		{0x20500, "b591ffc85a7f565c44e24e1152a05417716a470b"},
+		{0x20600, "8d1794af809f8748af8fb46f73e5893a2b01bcd7"},
+	} {
+		blob := appleCodeDirectory(tt.version)
+		c, err := ParseCodeDirectory(blob)
+		if err != nil {
+			t.Fatalf("%#x: %v", tt.version, err)
+		}
+		if c.Runtime != 0x000e0000 || c.PreEncryptOffset != uint32(len(blob))-2*sha256.Size-uint32(c.LinkageSize) {
+			t.Errorf("%#x: Runtime, PreEncryptOffset = %#x, %d", tt.version, c.Runtime, c.PreEncryptOffset)
+		}
+		if tt.version >= 0x20600 && (c.LinkageHashType != kSecCodeSignatureHashSHA256 || c.LinkageApplicationType != 1 ||
+			c.LinkageApplicationSubType != 2 || c.LinkageOffset+c.LinkageSize != uint32(len(blob))) {
+			t.Errorf("%#x: linkage = %d %d %d %d %d", tt.version, c.LinkageHashType, c.LinkageApplicationType,
+				c.LinkageApplicationSubType, c.LinkageOffset, c.LinkageSize)
+		}
+		b, err := c.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, blob) {
+			t.Errorf("%#x: Marshal = %x, want the bytes parsed, %x", tt.version, b, blob)
+		}
+		h, err := c.CDHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := sha256.Sum256(blob)
+		if !bytes.Equal(h, want[:20]) || hex.EncodeToString(h) != tt.cdhash {
+			t.Errorf("%#x: CDHash = %x, want %x and %s", tt.version, h, want[:20], tt.cdhash)
+		}
+
+		c2, err := ParseCodeDirectory(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c2.Runtime = 0x000f0000
+		if d := Diff(c, c2); len(d) != 1 || d[0].Field != "Runtime" {
+			t.Errorf("%#x: Diff with another runtime = %v", tt.version, d)
+		}
+	}
+}
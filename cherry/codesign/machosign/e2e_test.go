@@ -0,0 +1,139 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin
+
+package machosign
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+var e2e = flag.Bool("e2e", false, "run end-to-end tests that build, sign and execute a binary")
+
+const helloSrc = `package main
+
+func main() { println("hello") }
+`
+
+// buildHello builds a hello-world program for the host and returns
+// the path to the binary.
+func buildHello(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "hello.go")
+	if err := os.WriteFile(src, []byte(helloSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "hello")
+	cmd := exec.Command("go", "build", "-o", exe, src)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %v\n%s", err, out)
+	}
+	return exe
+}
+
+// corruptSignature flips the bits of the first byte of the hash of
+// the first code page in the signature of the Mach-O file data, if it
+// has one, so that the kernel refuses to run it where it checks
+// signatures. It reports whether data was signed.
+func corruptSignature(t *testing.T, data []byte) bool {
+	t.Helper()
+	sig, err := ReadSignature(bytes.NewReader(data))
+	if errors.Is(err, ErrNotSigned) {
+		return false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range sig.Blobs {
+		if b.Slot != CSSLOT_CODEDIRECTORY || b.Magic != CSMAGIC_CODEDIRECTORY {
+			continue
+		}
+		off := sig.Offset + int64(b.Offset)
+		cd, err := ParseCodeDirectory(data[off : off+int64(b.Length)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[off+int64(cd.HashOffset)] ^= 0xff
+		return true
+	}
+	t.Fatal("no CodeDirectory")
+	return false
+}
+
+// TestSignAndExec checks that a binary whose signature is broken does
+// not run, where the kernel checks signatures, and that it runs once
+// signed by Sign.
+func TestSignAndExec(t *testing.T) {
+	if !*e2e {
+		t.Skip("skipping end-to-end test; use -e2e to enable")
+	}
+	exe := buildHello(t)
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the signature of the linker, if any, so that the binary
+	// only runs if Sign gives it a valid one. The kernel caches the
+	// signature of a file it has run, so the binary is then signed
+	// as a new file.
+	signed := corruptSignature(t, data)
+	corrupt := filepath.Join(filepath.Dir(exe), "hello-corrupt")
+	if err := os.WriteFile(corrupt, data, 0777); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(corrupt).CombinedOutput()
+	switch {
+	case runtime.GOARCH != "arm64":
+		t.Logf("binary with a broken or no signature: %v, %q (not checked on %s)", err, out, runtime.GOARCH)
+	case !signed:
+		t.Fatal("the linker did not sign the binary")
+	case err == nil:
+		t.Fatalf("binary with a broken signature ran, printing %q", out)
+	}
+
+	exe = filepath.Join(filepath.Dir(exe), "hello-signed")
+	if err := os.WriteFile(exe, data, 0777); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(exe, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Sign(f, Options{})
+	f.Close()
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// The kernel kills binaries with an invalid signature on arm64,
+	// so a successful run means the OS accepted the signature.
+	out, err = exec.Command(exe).CombinedOutput()
+	if err != nil {
+		t.Fatalf("running signed binary failed: %v\n%s", err, out)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("signed binary printed %q, want %q", out, "hello\n")
+	}
+
+	codesign, err := exec.LookPath("codesign")
+	if err != nil {
+		t.Log("codesign not found; skipping codesign --verify")
+		return
+	}
+	out, err = exec.Command(codesign, "--verify", "--verbose", exe).CombinedOutput()
+	if err != nil {
+		t.Errorf("codesign --verify failed: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// entitlementsBlobs returns the entitlements blob and the DER
+// entitlements blob for the XML property list ent.
+func entitlementsBlobs(ent []byte) (xmlBlob, derBlob []byte, err error) {
+	v, err := parsePlist(ent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("entitlements: %v", err)
+	}
+	if _, ok := v.(plistDict); !ok {
+		return nil, nil, errors.New("entitlements: not a dictionary")
+	}
+	der, err := entitlementsDER(v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("entitlements: %v", err)
+	}
+	return wrapBlob(CSMAGIC_EMBEDDED_ENTITLEMENTS, ent), wrapBlob(CSMAGIC_EMBEDDED_DER_ENTITLEMENTS, der), nil
+}
+
+// wrapBlob returns data prefixed with a blob header.
+func wrapBlob(magic uint32, data []byte) []byte {
+	out := make([]byte, 8+len(data))
+	p := put32be(out, magic)
+	p = put32be(p, uint32(len(out)))
+	copy(p, data)
+	return out
+}
+
+// A plistDict is a property list dictionary, in file order.
+type plistDict []plistEntry
+
+type plistEntry struct {
+	key   string
+	value any // plistDict, []any, string, bool or *big.Int
+}
+
+// parsePlist parses an XML property list. It supports the value
+// types that can appear in entitlements: dictionaries, arrays,
+// strings, booleans and integers.
+func parsePlist(data []byte) (any, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("no plist element")
+			}
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			if se.Name.Local != "plist" {
+				return nil, fmt.Errorf("unexpected <%s>, want <plist>", se.Name.Local)
+			}
+			v, end, err := plistValue(d)
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				return nil, errors.New("empty plist")
+			}
+			return v, nil
+		}
+	}
+}
+
+// plistValue decodes the next value element from d. It reports
+// end if it finds the end of the enclosing element instead.
+func plistValue(d *xml.Decoder) (v any, end bool, err error) {
+	var se xml.StartElement
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			return nil, true, nil
+		}
+		var ok bool
+		if se, ok = tok.(xml.StartElement); ok {
+			break
+		}
+	}
+	switch se.Name.Local {
+	case "dict":
+		var dict plistDict
+		for {
+			var key string
+			tok, err := nextStart(d)
+			if err != nil {
+				return nil, false, err
+			}
+			if tok == nil {
+				return dict, false, nil
+			}
+			if tok.Name.Local != "key" {
+				return nil, false, fmt.Errorf("unexpected <%s> in dict, want <key>", tok.Name.Local)
+			}
+			if err := d.DecodeElement(&key, tok); err != nil {
+				return nil, false, err
+			}
+			v, end, err := plistValue(d)
+			if err != nil {
+				return nil, false, err
+			}
+			if end {
+				return nil, false, fmt.Errorf("missing value for key %q", key)
+			}
+			dict = append(dict, plistEntry{key, v})
+		}
+	case "array":
+		list := []any{}
+		for {
+			v, end, err := plistValue(d)
+			if err != nil {
+				return nil, false, err
+			}
+			if end {
+				return list, false, nil
+			}
+			list = append(list, v)
+		}
+	case "string":
+		var s string
+		err := d.DecodeElement(&s, &se)
+		return s, false, err
+	case "true", "false":
+		return se.Name.Local == "true", false, d.Skip()
+	case "integer":
+		var s string
+		if err := d.DecodeElement(&s, &se); err != nil {
+			return nil, false, err
+		}
+		n, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+		if !ok {
+			return nil, false, fmt.Errorf("invalid integer %q", s)
+		}
+		return n, false, nil
+	}
+	return nil, false, fmt.Errorf("unsupported plist element <%s>", se.Name.Local)
+}
+
+// nextStart returns the next start element from d, or nil if it
+// finds an end element first.
+func nextStart(d *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			return &tok, nil
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
+
+// DER tags used in DER entitlements.
+const (
+	derBoolean    = 0x01
+	derInteger    = 0x02
+	derUTF8String = 0x0c
+	derSequence   = 0x30
+	derDict       = 0xb0 // [16] constructed, context-specific
+	derTop        = 0x70 // [APPLICATION 16] constructed
+)
+
+// entitlementsDER encodes the entitlements dictionary v in the DER
+// form macOS expects in the CSSLOT_DER_ENTITLEMENTS slot:
+//
+//	[APPLICATION 16] { INTEGER 1, [16] { SEQUENCE { key, value }... } }
+//
+// with dictionary entries sorted by key.
+func entitlementsDER(v any) ([]byte, error) {
+	body, err := derValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return derTLV(derTop, append(derTLV(derInteger, []byte{1}), body...)), nil
+}
+
+func derValue(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case plistDict:
+		v = slices.Clone(v)
+		slices.SortStableFunc(v, func(a, b plistEntry) int { return strings.Compare(a.key, b.key) })
+		var body []byte
+		for i, e := range v {
+			if i > 0 && v[i-1].key == e.key {
+				return nil, fmt.Errorf("duplicate key %q", e.key)
+			}
+			val, err := derValue(e.value)
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, derTLV(derSequence, append(derTLV(derUTF8String, []byte(e.key)), val...))...)
+		}
+		return derTLV(derDict, body), nil
+	case []any:
+		var body []byte
+		for _, e := range v {
+			val, err := derValue(e)
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, val...)
+		}
+		return derTLV(derSequence, body), nil
+	case string:
+		return derTLV(derUTF8String, []byte(v)), nil
+	case bool:
+		if v {
+			return derTLV(derBoolean, []byte{0xff}), nil
+		}
+		return derTLV(derBoolean, []byte{0}), nil
+	case *big.Int:
+		// Two's complement, minimal length.
+		b := v.Bytes()
+		switch {
+		case v.Sign() == 0:
+			b = []byte{0}
+		case v.Sign() < 0:
+			n := new(big.Int).Lsh(big.NewInt(1), uint(8*(len(b)+1)))
+			b = n.Add(n, v).Bytes()
+			for len(b) > 1 && b[0] == 0xff && b[1]&0x80 != 0 {
+				b = b[1:]
+			}
+		case b[0]&0x80 != 0:
+			b = append([]byte{0}, b...)
+		}
+		return derTLV(derInteger, b), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v)
+}
+
+// derTLV encodes a DER tag, length and value.
+func derTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	if n := len(value); n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var l []byte
+		for ; n > 0; n >>= 8 {
+			l = append([]byte{byte(n)}, l...)
+		}
+		out = append(out, 0x80|byte(len(l)))
+		out = append(out, l...)
+	}
+	return append(out, value...)
+}
+
+// keptEntitlements returns the entitlements blobs of the existing
+// signature described by li, in slot order, to be carried over into
+// the new signature, unless opts provide entitlements or strip them.
+func keptEntitlements(r io.ReaderAt, li *loadInfo, opts Options) ([]slotBlob, error) {
+	if li.sigSz == 0 || opts.Entitlements != nil || opts.StripEntitlements {
+		return nil, nil
+	}
+	data := make([]byte, li.sigSz)
+	if _, err := r.ReadAt(data, int64(li.sigOff)); err != nil {
+		return nil, fmt.Errorf("reading signature at %#x: %w", li.sigOff, err)
+	}
+	var sig Signature
+	if err := sig.decode(data); err != nil {
+		// Not a signature we understand; it will be overwritten.
+		return nil, nil
+	}
+	var kept []slotBlob
+	for _, b := range sig.Blobs {
+		if b.Slot == CSSLOT_ENTITLEMENTS && b.Magic == CSMAGIC_EMBEDDED_ENTITLEMENTS ||
+			b.Slot == CSSLOT_DER_ENTITLEMENTS && b.Magic == CSMAGIC_EMBEDDED_DER_ENTITLEMENTS {
+			kept = append(kept, slotBlob{b.Slot, bytes.Clone(data[b.Offset : b.Offset+b.Length])})
+		}
+	}
+	slices.SortFunc(kept, func(a, b slotBlob) int { return int(a.slot) - int(b.slot) })
+	return kept, nil
+}
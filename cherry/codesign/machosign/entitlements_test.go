@@ -0,0 +1,156 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// plistText returns v, as parsePlist returns it, in a compact text
+// form, for comparisons.
+func plistText(v any) string {
+	switch v := v.(type) {
+	case plistDict:
+		var s []string
+		for _, e := range v {
+			s = append(s, e.key+": "+plistText(e.value))
+		}
+		return "{" + strings.Join(s, ", ") + "}"
+	case []any:
+		var s []string
+		for _, e := range v {
+			s = append(s, plistText(e))
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(v) // bool or *big.Int
+}
+
+func TestParsePlist(t *testing.T) {
+	const in = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>yes</key>
+	<true/>
+	<key>no</key>
+	<false/>
+	<key>string</key>
+	<string>a &amp; b</string>
+	<key>array</key>
+	<array>
+		<string>x</string>
+		<true/>
+		<array/>
+	</array>
+	<key>dict</key>
+	<dict>
+		<key>inner</key>
+		<dict>
+			<key>n</key>
+			<integer>1</integer>
+		</dict>
+	</dict>
+	<key>ints</key>
+	<array>
+		<integer>0</integer>
+		<integer>127</integer>
+		<integer>128</integer>
+		<integer> -1 </integer>
+	</array>
+</dict>
+</plist>
+`
+	const want = `{yes: true, no: false, string: "a & b", array: ["x", true, []], dict: {inner: {n: 1}}, ints: [0, 127, 128, -1]}`
+	v, err := parsePlist([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plistText(v); got != want {
+		t.Errorf("parsePlist:\ngot  %s\nwant %s", got, want)
+	}
+
+	for _, bad := range []string{
+		``,
+		`<dict/>`,
+		`<plist></plist>`,
+		`<plist><dict><key>k</key></dict></plist>`,
+		`<plist><dict><string>k</string></dict></plist>`,
+		`<plist><integer>1.5</integer></plist>`,
+		`<plist><date>2024-01-01T00:00:00Z</date></plist>`,
+	} {
+		if v, err := parsePlist([]byte(bad)); err == nil {
+			t.Errorf("parsePlist(%q) = %s, want error", bad, plistText(v))
+		}
+	}
+}
+
+func TestDERValue(t *testing.T) {
+	tests := []struct {
+		v    any
+		want string // hex
+	}{
+		{true, "0101ff"},
+		{false, "010100"},
+		{"a", "0c0161"},
+		{"", "0c00"},
+		{strings.Repeat("x", 200), "0c81c8" + strings.Repeat("78", 200)},
+		{big.NewInt(0), "020100"},
+		{big.NewInt(1), "020101"},
+		{big.NewInt(127), "02017f"},
+		{big.NewInt(128), "02020080"},
+		{big.NewInt(256), "02020100"},
+		{big.NewInt(-1), "0201ff"},
+		{big.NewInt(-128), "020180"},
+		{big.NewInt(-129), "0202ff7f"},
+		{[]any{}, "3000"},
+		{[]any{"a", true}, "30060c01610101ff"},
+		// Entries are sorted by key.
+		{plistDict{{"b", "x"}, {"a", true}}, "b010" + "30060c01610101ff" + "30060c01620c0178"},
+		{plistDict{{"k", plistDict{{"n", big.NewInt(1)}}}}, "b00f300d0c016bb008" + "30060c016e020101"},
+	}
+	for _, tt := range tests {
+		got, err := derValue(tt.v)
+		if err != nil {
+			t.Errorf("derValue(%s): %v", plistText(tt.v), err)
+			continue
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("derValue(%s) = %x, want %s", plistText(tt.v), got, tt.want)
+		}
+	}
+
+	for _, bad := range []any{
+		plistDict{{"k", true}, {"k", false}},
+		[]any{1.5},
+	} {
+		if der, err := derValue(bad); err == nil {
+			t.Errorf("derValue(%v) = %x, want error", bad, der)
+		}
+	}
+}
+
+func TestEntitlementsDER(t *testing.T) {
+	const key = "com.apple.security.get-task-allow"
+	v, err := parsePlist([]byte(`<plist><dict><key>` + key + `</key><true/></dict></plist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := entitlementsDER(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As Apple's codesign encodes it: version 1, then the dictionary.
+	want := "702d" + "020101" + "b028" + "3026" + "0c21" + hex.EncodeToString([]byte(key)) + "0101ff"
+	if hex.EncodeToString(der) != want {
+		t.Errorf("entitlementsDER = %x, want %s", der, want)
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"debug/macho"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update testdata/golden.txt")
+
+// A fixture describes a small Mach-O file built by fixtureFile.
+type fixture struct {
+	name        string
+	typ         macho.Type
+	headerSpace bool // whether there is room to add LC_CODE_SIGNATURE
+	signed      bool // whether the file is signed before the test
+	arm64e      bool // arm64e with the pointer authentication ABI, instead of amd64
+}
+
+var fixtures = []fixture{
+	{"exec", macho.TypeExec, true, false, false},
+	{"exec-signed", macho.TypeExec, true, true, false},
+	{"exec-noroom", macho.TypeExec, false, false, false},
+	{"dylib", macho.TypeDylib, true, false, false},
+	{"dylib-signed", macho.TypeDylib, true, true, false},
+	{"dylib-noroom", macho.TypeDylib, false, false, false},
+	{"arm64e", macho.TypeExec, true, false, true},
+	{"arm64e-signed", macho.TypeExec, true, true, true},
+}
+
+// Layout of the fixture files.
+const (
+	fixtureText     = 0x2000 // size of __TEXT
+	fixtureLinkedit = 0x20   // size of __LINKEDIT: one symbol and the string table
+	fixtureLoadSize = 72 + 80 + 72 + 24 + 24
+	fixtureVMAddr   = 0x100000000
+)
+
+// fixtureFile returns a minimal 64-bit Mach-O file as described by
+// fx, with a __TEXT segment holding one section, and a __LINKEDIT
+// segment holding a symbol table. Without headerSpace, the section
+// starts right after the load commands.
+func fixtureFile(fx fixture) []byte {
+	cpu, subCpu := macho.CpuAmd64, uint32(CPU_SUBTYPE_X86_64_ALL)
+	if fx.arm64e {
+		cpu, subCpu = macho.CpuArm64, CPU_SUBTYPE_ARM64E|CPU_SUBTYPE_PTRAUTH_ABI
+	}
+	textOff := uint64(0x400)
+	if !fx.headerSpace {
+		textOff = fileHeaderSize64 + fixtureLoadSize
+	}
+	data := make([]byte, fixtureText+fixtureLinkedit)
+	le := func(p []byte, vs ...any) []byte {
+		for _, v := range vs {
+			switch v := v.(type) {
+			case uint32:
+				p = put32le(p, v)
+			case uint64:
+				p = put64le(p, v)
+			case string:
+				p = p[copy(p[:16], v)+16-len(v):]
+			}
+		}
+		return p
+	}
+	p := le(data, uint32(macho.Magic64), uint32(cpu), subCpu, uint32(fx.typ),
+		uint32(4), uint32(fixtureLoadSize), uint32(0), uint32(0))
+	p = le(p, uint32(macho.LoadCmdSegment64), uint32(72+80), "__TEXT",
+		uint64(fixtureVMAddr), uint64(fixtureText), uint64(0), uint64(fixtureText),
+		uint32(5), uint32(5), uint32(1), uint32(0))
+	p = le(p, "__text", "__TEXT", uint64(fixtureVMAddr+textOff), uint64(fixtureText-textOff),
+		uint32(textOff), uint32(4), uint32(0), uint32(0), uint32(0x80000400), uint32(0), uint32(0), uint32(0))
+	p = le(p, uint32(macho.LoadCmdSegment64), uint32(72), "__LINKEDIT",
+		uint64(fixtureVMAddr+fixtureText), uint64(0x4000), uint64(fixtureText), uint64(fixtureLinkedit),
+		uint32(1), uint32(1), uint32(0), uint32(0))
+	p = le(p, uint32(LC_UUID), uint32(24))
+	for i := range 16 {
+		p[i] = byte(i)
+	}
+	p = le(p[16:], uint32(LC_SYMTAB), uint32(24),
+		uint32(fixtureText), uint32(1), uint32(fixtureText+16), uint32(16))
+	for i := textOff; i < fixtureText; i++ {
+		data[i] = byte(i * 7)
+	}
+	p = data[fixtureText:]
+	p = le(p, uint32(1), uint32(0x010f), uint64(fixtureVMAddr+textOff))
+	copy(p, "\x00_main\x00")
+	return data
+}
+
+func TestGolden(t *testing.T) {
+	golden := readGolden(t)
+	var out strings.Builder
+	for _, fx := range fixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			in := fixtureFile(fx)
+			if fx.signed {
+				b := NewBuffer(in)
+				if err := Sign(b, Options{Identifier: "fixture"}); err != nil {
+					t.Fatalf("signing fixture: %v", err)
+				}
+				in = b.Bytes()
+			}
+			if !fx.headerSpace {
+				l, err := Plan(NewBuffer(in), int64(len(in)), Options{})
+				if err != nil || l.HeaderSpaceOK || l.Shift != headerShift {
+					t.Fatalf("Plan = %+v, %v; want HeaderSpaceOK = false, Shift = %#x", l, err, headerShift)
+				}
+			}
+			b := NewBuffer(bytes.Clone(in))
+			if err := Sign(b, Options{Identifier: "golden"}); err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			signed := b.Bytes()
+			checkSigned(t, signed, in)
+
+			cd, err := ReadCodeDirectory(NewBuffer(signed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, err := cd.CDHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := ReadSignature(NewBuffer(signed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			line := fmt.Sprintf("%s %s %x %x\n", fx.name, sig.Arch, sha256.Sum256(signed), h)
+			out.WriteString(line)
+			if want := golden[fx.name]; !*update && line != want {
+				t.Errorf("got  %swant %s", line, want)
+			}
+
+			// Signing again must not change anything.
+			b = NewBuffer(bytes.Clone(signed))
+			if err := Sign(b, Options{Identifier: "golden"}); err != nil {
+				t.Fatalf("re-signing: %v", err)
+			}
+			if !bytes.Equal(b.Bytes(), signed) {
+				t.Errorf("re-signing changed the file")
+			}
+		})
+	}
+	if *update {
+		if err := os.WriteFile(goldenFile, []byte(out.String()), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkSigned checks that signed, the file in after signing, parses
+// with debug/macho with the same header fields and sections at the
+// same addresses with the same contents, that LC_CODE_SIGNATURE
+// and __LINKEDIT describe the signature at the end of the file, and
+// that the code hashes match its pages.
+func checkSigned(t *testing.T, signed, in []byte) {
+	t.Helper()
+	mf, err := macho.NewFile(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatalf("debug/macho cannot parse the signed file: %v", err)
+	}
+	orig, err := macho.NewFile(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.Type != orig.Type || mf.Cpu != orig.Cpu || mf.SubCpu != orig.SubCpu || mf.Flags != orig.Flags {
+		t.Errorf("header = %v %v %#x %#x, want %v %v %#x %#x",
+			mf.Type, mf.Cpu, mf.SubCpu, mf.Flags, orig.Type, orig.Cpu, orig.SubCpu, orig.Flags)
+	}
+	for _, o := range orig.Sections {
+		s := mf.Section(o.Name)
+		if s == nil || s.Addr != o.Addr || s.Size != o.Size {
+			t.Errorf("section %s moved: %+v, was %+v", o.Name, s, o)
+			continue
+		}
+		d, err1 := s.Data()
+		od, err2 := o.Data()
+		if err1 != nil || err2 != nil || !bytes.Equal(d, od) {
+			t.Errorf("section %s changed (errors %v, %v)", o.Name, err1, err2)
+		}
+	}
+	li, err := scanLoads(mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if li.sigSz == 0 {
+		t.Fatal("no LC_CODE_SIGNATURE")
+	}
+	if end := li.sigOff + li.sigSz; end != len(signed) {
+		t.Errorf("signature ends at %#x, file at %#x", end, len(signed))
+	}
+	seg := li.linkeditSeg
+	if end := seg.Offset + seg.Filesz; end != uint64(len(signed)) {
+		t.Errorf("__LINKEDIT ends at %#x, file at %#x", end, len(signed))
+	}
+	if seg.Memsz < seg.Filesz {
+		t.Errorf("__LINKEDIT vmsize %#x < filesize %#x", seg.Memsz, seg.Filesz)
+	}
+
+	cd, err := ReadCodeDirectory(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd.CodeLimit != uint32(li.sigOff) {
+		t.Errorf("code limit = %#x, want %#x", cd.CodeLimit, li.sigOff)
+	}
+	var wantFlags uint64
+	if mf.Type == macho.TypeExec {
+		wantFlags = CS_EXECSEG_MAIN_BINARY
+	}
+	if cd.ExecSegFlags != wantFlags {
+		t.Errorf("exec segment flags = %#x, want %#x", cd.ExecSegFlags, wantFlags)
+	}
+	ps := 1 << cd.PageSize
+	for i, h := range cd.CodeSlots {
+		page := signed[i*ps : min((i+1)*ps, li.sigOff)]
+		if want := sha256.Sum256(page); !bytes.Equal(h, want[:]) {
+			t.Errorf("code slot %d = %x, want %x", i, h, want)
+		}
+	}
+}
+
+var goldenFile = filepath.Join("testdata", "golden.txt")
+
+// readGolden returns the lines of the golden file, by fixture name.
+func readGolden(t *testing.T) map[string]string {
+	golden := make(map[string]string)
+	f, err := os.Open(goldenFile)
+	if err != nil {
+		if *update {
+			return golden
+		}
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		name, _, _ := strings.Cut(s.Text(), " ")
+		golden[name] = s.Text() + "\n"
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return golden
+}
+
+// TestSignLarge checks that a file too large for LC_CODE_SIGNATURE to
+// refer to a signature at its end is left untouched. The file is
+// sparse, so it takes little space on most file systems.
+func TestSignLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true})); err != nil {
+		t.Fatal(err)
+	}
+	const size = 5 << 30
+	if err := f.Truncate(size); err != nil {
+		t.Skipf("cannot create a large file: %v", err)
+	}
+	if _, err := Plan(f, size, Options{}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Plan error = %v, want ErrTooLarge", err)
+	}
+	if err := Sign(f, Options{}); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Sign error = %v, want ErrTooLarge", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() != size {
+		t.Errorf("file size changed to %#x", st.Size())
+	}
+}
+
+func TestSignatureSize(t *testing.T) {
+	for _, opts := range []Options{
+		{Identifier: "golden"},
+		{Identifier: "com.example.golden", PageSize: 16 << 10},
+		{InfoPlist: []byte("<plist/>"), CodeResources: []byte("<plist/>")},
+	} {
+		b := NewBuffer(fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true}))
+		if err := Sign(b, opts); err != nil {
+			t.Fatal(err)
+		}
+		mf, err := macho.NewFile(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		li, err := scanLoads(mf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sz, err := SignatureSize(int64(li.sigOff), opts.PageSize, len(opts.id())-1, CS_HASHTYPE_SHA256, opts.nSpecialSlots())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sz != int64(li.sigSz) || sz != Size(int64(li.sigOff), opts) {
+			t.Errorf("%+v: SignatureSize = %d, Size = %d, want %d", opts, sz, Size(int64(li.sigOff), opts), li.sigSz)
+		}
+	}
+	for _, ht := range []uint8{CS_HASHTYPE_SHA1, CS_HASHTYPE_SHA256_TRUNCATED, CS_HASHTYPE_SHA384, 99} {
+		if _, err := SignatureSize(0x1000, 0, 5, ht, 0); err == nil {
+			t.Errorf("SignatureSize succeeded with hash type %d, which Sign does not use", ht)
+		}
+	}
+}
+
+func TestKeepEntitlements(t *testing.T) {
+	const ent = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>com.apple.security.get-task-allow</key><true/></dict></plist>
+`
+	b := NewBuffer(fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true}))
+	if err := Sign(b, Options{Entitlements: []byte(ent)}); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := ReadSignature(NewBuffer(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, strip := range []bool{false, true} {
+		signed := NewBuffer(bytes.Clone(b.Bytes()))
+		if err := Sign(signed, Options{Identifier: "resigned", StripEntitlements: strip}); err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ReadSignature(NewBuffer(signed.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strip {
+			if len(sig.Blobs) != 1 || sig.CodeDirectory.NSpecialSlots != 0 {
+				t.Errorf("stripped: got blobs %+v, %d special slots; want only the CodeDirectory", sig.Blobs, sig.CodeDirectory.NSpecialSlots)
+			}
+			continue
+		}
+		if len(sig.Blobs) != len(orig.Blobs) {
+			t.Fatalf("got blobs %+v, want %+v", sig.Blobs, orig.Blobs)
+		}
+		for i, bi := range sig.Blobs[1:] {
+			o := orig.Blobs[i+1]
+			got := signed.Bytes()[sig.Offset+int64(bi.Offset):][:bi.Length]
+			want := b.Bytes()[orig.Offset+int64(o.Offset):][:o.Length]
+			if bi.Slot != o.Slot || !bytes.Equal(got, want) {
+				t.Errorf("blob %d: slot %d %q, want slot %d %q", i+1, bi.Slot, got, o.Slot, want)
+			}
+		}
+		if !slices.EqualFunc(sig.CodeDirectory.SpecialSlots, orig.CodeDirectory.SpecialSlots, func(a, b HexBytes) bool { return bytes.Equal(a, b) }) {
+			t.Errorf("special slots = %v, want %v", sig.CodeDirectory.SpecialSlots, orig.CodeDirectory.SpecialSlots)
+		}
+	}
+}
+
+// addCMS returns the signed Mach-O file data with a CMS signature
+// blob added to its signature, as codesign adds with a signing
+// identity, growing the signature and __LINKEDIT to hold it.
+func addCMS(t *testing.T, data []byte) []byte {
+	t.Helper()
+	mf, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	li, err := scanLoads(mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ReadSignature(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := data[sig.Offset:][:sig.Length]
+	n := len(sig.Blobs)
+	blobs := old[12+8*n:]
+	cms := []byte("not really a CMS signature")
+
+	sb := make([]byte, 12+8*(n+1), 12+8*(n+1)+len(blobs)+8+len(cms))
+	p := put32be(sb, CSMAGIC_EMBEDDED_SIGNATURE)
+	p = put32be(p, uint32(cap(sb)))
+	p = put32be(p, uint32(n+1))
+	for _, b := range sig.Blobs {
+		p = put32be(p, b.Slot)
+		p = put32be(p, b.Offset+8)
+	}
+	p = put32be(p, CSSLOT_SIGNATURESLOT)
+	put32be(p, uint32(len(sb)+len(blobs)))
+	sb = append(sb, blobs...)
+	sb = append(sb, make([]byte, 8)...)
+	put32be(sb[len(sb)-8:], CSMAGIC_BLOBWRAPPER)
+	put32be(sb[len(sb)-4:], uint32(8+len(cms)))
+	sb = append(sb, cms...)
+
+	out := append(bytes.Clone(data[:sig.Offset]), sb...)
+	put32le(out[li.sigCmdOff+12:], uint32(len(sb)))
+	seg := li.linkeditSeg
+	filesz := uint64(len(out)) - seg.Offset
+	put64le(out[li.linkeditOff+48:], filesz)
+	put64le(out[li.linkeditOff+32:], max(seg.Memsz, uint64(roundUp(int(filesz), 0x4000))))
+	return out
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"crypto/sha256"
+	"io"
+	"sync"
+)
+
+// pagesPerChunk is the number of pages a hashing worker reads at once.
+const pagesPerChunk = 256
+
+// hashPages writes the SHA-256 hashes of the ps-sized pages of f
+// in [0, limit) to out, which must have room for all of them. The
+// last page may be short. The pages are hashed by up to jobs
+// goroutines, in chunks of pagesPerChunk pages.
+func hashPages(out []byte, f io.ReaderAt, limit int64, ps int, jobs int) error {
+	npages := int((limit + int64(ps) - 1) / int64(ps))
+	nchunks := (npages + pagesPerChunk - 1) / pagesPerChunk
+	jobs = max(1, min(jobs, nchunks))
+
+	hashChunk := func(buf []byte, c int) error {
+		off := int64(c) * pagesPerChunk * int64(ps)
+		n := min(int64(len(buf)), limit-off)
+		if _, err := f.ReadAt(buf[:n], off); err != nil && err != io.EOF {
+			return err
+		}
+		for i, p := 0, c*pagesPerChunk; int64(i) < n; i, p = i+ps, p+1 {
+			h := sha256.Sum256(buf[i:min(int64(i+ps), n)])
+			copy(out[p*sha256.Size:], h[:])
+		}
+		return nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	chunks := make(chan int)
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pagesPerChunk*ps)
+			for c := range chunks {
+				if err := hashChunk(buf, c); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for c := range nchunks {
+		chunks <- c
+	}
+	close(chunks)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/macho"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// serialHashes returns the SHA-256 hashes of the ps-sized pages of
+// data, one after the other.
+func serialHashes(data []byte, ps int) []byte {
+	var out []byte
+	for off := 0; off < len(data); off += ps {
+		h := sha256.Sum256(data[off:min(off+ps, len(data))])
+		out = append(out, h[:]...)
+	}
+	return out
+}
+
+// TestHashPages checks that hashing pages in parallel gives the same
+// hashes as hashing them one by one, for files of several chunks,
+// with and without a short last page and chunk.
+func TestHashPages(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	data := make([]byte, 3*pagesPerChunk*16<<10+5000)
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	for _, ps := range []int{4 << 10, 16 << 10} {
+		for _, limit := range []int{1, ps, ps + 1, pagesPerChunk * ps, pagesPerChunk*ps + 1, 3*pagesPerChunk*ps - 7, len(data)} {
+			want := serialHashes(data[:limit], ps)
+			for _, jobs := range []int{0, 1, 2, 3, 8, 100} {
+				t.Run(fmt.Sprintf("ps=%d/limit=%d/jobs=%d", ps, limit, jobs), func(t *testing.T) {
+					out := make([]byte, len(want))
+					if err := hashPages(out, bytes.NewReader(data), int64(limit), ps, jobs); err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(out, want) {
+						for i := 0; i < len(want); i += sha256.Size {
+							if !bytes.Equal(out[i:i+sha256.Size], want[i:i+sha256.Size]) {
+								t.Fatalf("page %d hash = %x, want %x", i/sha256.Size, out[i:i+sha256.Size], want[i:i+sha256.Size])
+							}
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// errReaderAt fails to read at and after off.
+type errReaderAt struct {
+	r   io.ReaderAt
+	off int64
+}
+
+var errRead = errors.New("read error")
+
+func (r errReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > r.off {
+		return 0, errRead
+	}
+	return r.r.ReadAt(p, off)
+}
+
+func TestHashPagesError(t *testing.T) {
+	const ps = 4 << 10
+	data := make([]byte, 4*pagesPerChunk*ps)
+	out := make([]byte, 4*pagesPerChunk*sha256.Size)
+	r := errReaderAt{bytes.NewReader(data), 2*pagesPerChunk*ps + 1}
+	for _, jobs := range []int{1, 4} {
+		if err := hashPages(out, r, int64(len(data)), ps, jobs); !errors.Is(err, errRead) {
+			t.Errorf("jobs=%d: hashPages error = %v, want %v", jobs, err, errRead)
+		}
+	}
+}
+
+// TestSignJobs checks that signing a file of many pages gives the same
+// bytes whatever the number of jobs.
+func TestSignJobs(t *testing.T) {
+	payload := make([]byte, 2*pagesPerChunk*4<<10+123)
+	for i := range payload {
+		payload[i] = byte(i * 13)
+	}
+	in, _ := addLinkeditData(fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true}), LC_SEGMENT_SPLIT_INFO, payload)
+	put64le(in[fixtureLinkeditSeg+32:], 4<<20) // vmsize
+	var want []byte
+	for _, jobs := range []int{0, 1, 2, 5, 64} {
+		b := NewBuffer(bytes.Clone(in))
+		if err := Sign(b, Options{Identifier: "jobs", Jobs: jobs}); err != nil {
+			t.Fatalf("Jobs=%d: Sign: %v", jobs, err)
+		}
+		if want == nil {
+			checkSigned(t, b.Bytes(), in)
+			want = b.Bytes()
+		} else if !bytes.Equal(b.Bytes(), want) {
+			t.Errorf("Jobs=%d: signed file differs from Jobs=0", jobs)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"io"
+	"unsafe"
+)
+
+// A Layout describes the changes Sign would make to a file.
+type Layout struct {
+	FileSize    int64 `json:"file_size"`     // current size of the file
+	NewFileSize int64 `json:"new_file_size"` // size of the signed file
+	SigOffset   int64 `json:"sig_offset"`    // file offset of the signature
+	SigSize     int64 `json:"sig_size"`      // size of the signature
+	OldSigSize  int64 `json:"old_sig_size"`  // size of the existing signature, or 0
+
+	CmdOffset     int64 `json:"cmd_offset"`      // file offset of the LC_CODE_SIGNATURE command
+	AddCmd        bool  `json:"add_cmd"`         // whether LC_CODE_SIGNATURE is added
+	HeaderSpace   int64 `json:"header_space"`    // free space after the load commands
+	HeaderSpaceOK bool  `json:"header_space_ok"` // whether there is room to add LC_CODE_SIGNATURE
+	Shift         int64 `json:"shift,omitempty"` // if there is no room, bytes inserted to make some
+
+	LinkeditFilesz    uint64 `json:"linkedit_filesz"`
+	LinkeditVMSize    uint64 `json:"linkedit_vmsize"`
+	OldLinkeditFilesz uint64 `json:"old_linkedit_filesz"`
+	OldLinkeditVMSize uint64 `json:"old_linkedit_vmsize"`
+}
+
+// Plan returns the layout of the signature Sign would produce for
+// the Mach-O file r of the given size, without modifying it. It
+// returns the error Sign would return, if any, except for a lack of
+// header space, which is reported in the Layout: HeaderSpaceOK is
+// false, and Shift is zero if the file cannot be rewritten to make
+// room.
+func Plan(r io.ReaderAt, size int64, opts Options) (*Layout, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	mf, err := openMachO(r, size)
+	if err != nil {
+		return nil, err
+	}
+	li, err := scanLoads(mf)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(mf, size, li); err != nil {
+		return nil, err
+	}
+	if err := checkExisting(r, li, opts); err != nil {
+		return nil, err
+	}
+	if opts.kept, err = keptEntitlements(r, li, opts); err != nil {
+		return nil, err
+	}
+	if (opts.RegenerateUUID || opts.UUID != nil) && uuidOffset(mf) < 0 {
+		return nil, ErrNoUUID
+	}
+	l := &Layout{
+		FileSize:          size,
+		SigOffset:         int64(li.sigOff),
+		OldSigSize:        int64(li.sigSz),
+		CmdOffset:         int64(li.sigCmdOff),
+		OldLinkeditFilesz: li.linkeditSeg.Filesz,
+		OldLinkeditVMSize: li.linkeditSeg.Memsz,
+	}
+	l.HeaderSpace = dataStart(mf, size) - int64(li.loadOff)
+	l.HeaderSpaceOK = true
+	if li.sigSz == 0 {
+		l.SigOffset = int64(roundUp(int(size), 16))
+		l.CmdOffset = int64(li.loadOff)
+		l.AddCmd = true
+		l.HeaderSpaceOK = l.HeaderSpace >= int64(unsafe.Sizeof(linkeditDataCmd{}))
+		if !l.HeaderSpaceOK && canShift(mf, opts) == nil {
+			l.Shift = headerShift
+			l.SigOffset = int64(roundUp(int(size+l.Shift), 16))
+		}
+	}
+	if err := fixLinkedit(nil, mf, li.linkeditSeg, uint64(l.SigOffset-l.Shift)); err != nil {
+		return nil, err
+	}
+	if err := checkScatter(opts.Scatter, (l.SigOffset+int64(opts.pageSize())-1)/int64(opts.pageSize())); err != nil {
+		return nil, err
+	}
+	l.SigSize = Size(l.SigOffset, opts)
+	if err := checkSigOffset(l.SigOffset, l.SigSize); err != nil {
+		return nil, err
+	}
+	l.NewFileSize = l.SigOffset + l.SigSize
+	l.LinkeditFilesz, l.LinkeditVMSize = l.OldLinkeditFilesz, l.OldLinkeditVMSize
+	if l.SigSize != l.OldSigSize {
+		segSz := l.NewFileSize - int64(li.linkeditSeg.Offset) - l.Shift
+		l.LinkeditFilesz = uint64(segSz)
+		l.LinkeditVMSize = uint64(roundUp(int(segSz), 0x4000))
+	}
+	return l, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"debug/macho"
+	"fmt"
+	"io"
+)
+
+// Load commands that refer to data in __LINKEDIT.
+const (
+	LC_SYMTAB                   = 0x2
+	LC_DYSYMTAB                 = 0xb
+	LC_SEGMENT_SPLIT_INFO       = 0x1e
+	LC_DYLD_INFO                = 0x22
+	LC_FUNCTION_STARTS          = 0x26
+	LC_DATA_IN_CODE             = 0x29
+	LC_DYLIB_CODE_SIGN_DRS      = 0x2b
+	LC_LINKER_OPTIMIZATION_HINT = 0x2e
+	LC_DYLD_INFO_ONLY           = 0x80000022
+	LC_DYLD_EXPORTS_TRIE        = 0x80000033
+	LC_DYLD_CHAINED_FIXUPS      = 0x80000034
+)
+
+// A linkeditRange is a range of file data described by a load command.
+type linkeditRange struct {
+	name     string // e.g. "LC_SYMTAB string table"
+	off      uint64 // file offset of the data
+	size     uint64 // size of the data in bytes
+	sizeAt   int64  // for the string table, file offset of the strsize field
+	isStrtab bool   // string table, which may be trimmed
+}
+
+func (r linkeditRange) String() string {
+	return fmt.Sprintf("%s [%#x, %#x)", r.name, r.off, r.off+r.size)
+}
+
+// linkeditRanges returns the ranges of file data referred to by the
+// load commands of mf, other than LC_CODE_SIGNATURE.
+func linkeditRanges(mf *macho.File) []linkeditRange {
+	var rs []linkeditRange
+	add := func(name string, off, size uint32) {
+		if size != 0 {
+			rs = append(rs, linkeditRange{name: name, off: uint64(off), size: uint64(size)})
+		}
+	}
+	loadOff := int64(fileHeaderSize64)
+	for _, l := range mf.Loads {
+		data := l.Raw()
+		cmd := get32le(data)
+		field := func(i int) uint32 { return get32le(data[i:]) }
+		switch cmd {
+		case LC_SYMTAB:
+			add("LC_SYMTAB symbols", field(8), field(12)*16)
+			if field(20) != 0 {
+				rs = append(rs, linkeditRange{
+					name:     "LC_SYMTAB string table",
+					off:      uint64(field(16)),
+					size:     uint64(field(20)),
+					sizeAt:   loadOff + 20,
+					isStrtab: true,
+				})
+			}
+		case LC_DYSYMTAB:
+			add("LC_DYSYMTAB table of contents", field(32), field(36)*8)
+			add("LC_DYSYMTAB module table", field(40), field(44)*56)
+			add("LC_DYSYMTAB external references", field(48), field(52)*4)
+			add("LC_DYSYMTAB indirect symbols", field(56), field(60)*4)
+			add("LC_DYSYMTAB external relocations", field(64), field(68)*8)
+			add("LC_DYSYMTAB local relocations", field(72), field(76)*8)
+		case LC_DYLD_INFO, LC_DYLD_INFO_ONLY:
+			add("LC_DYLD_INFO rebase", field(8), field(12))
+			add("LC_DYLD_INFO bind", field(16), field(20))
+			add("LC_DYLD_INFO weak bind", field(24), field(28))
+			add("LC_DYLD_INFO lazy bind", field(32), field(36))
+			add("LC_DYLD_INFO export", field(40), field(44))
+		case LC_SEGMENT_SPLIT_INFO:
+			add("LC_SEGMENT_SPLIT_INFO", field(8), field(12))
+		case LC_FUNCTION_STARTS:
+			add("LC_FUNCTION_STARTS", field(8), field(12))
+		case LC_DATA_IN_CODE:
+			add("LC_DATA_IN_CODE", field(8), field(12))
+		case LC_DYLIB_CODE_SIGN_DRS:
+			add("LC_DYLIB_CODE_SIGN_DRS", field(8), field(12))
+		case LC_LINKER_OPTIMIZATION_HINT:
+			add("LC_LINKER_OPTIMIZATION_HINT", field(8), field(12))
+		case LC_DYLD_EXPORTS_TRIE:
+			add("LC_DYLD_EXPORTS_TRIE", field(8), field(12))
+		case LC_DYLD_CHAINED_FIXUPS:
+			add("LC_DYLD_CHAINED_FIXUPS", field(8), field(12))
+		}
+		loadOff += int64(get32le(data[4:]))
+	}
+	return rs
+}
+
+// fixLinkedit checks that the data referred to by load commands,
+// such as chained fixups or split segment info, which ld64 places
+// after the symbol table, is within __LINKEDIT and ends before the
+// code signature at sigOff, so that the signature never overwrites
+// it. A string table that runs into the signature (e.g. padding
+// left over from a previous signature) is trimmed; the new size is
+// written to f, unless f is nil. Any other violation is an error, as
+// dyld and lldb reject binaries with such ranges.
+func fixLinkedit(f io.WriterAt, mf *macho.File, linkedit *macho.Segment, sigOff uint64) error {
+	for _, r := range linkeditRanges(mf) {
+		end := r.off + r.size
+		if r.off < linkedit.Offset {
+			return fmt.Errorf("%v starts before __LINKEDIT at %#x", r, linkedit.Offset)
+		}
+		if end <= sigOff {
+			if lend := linkedit.Offset + linkedit.Filesz; end > lend {
+				return fmt.Errorf("%v ends beyond __LINKEDIT at %#x", r, lend)
+			}
+			continue
+		}
+		if !r.isStrtab || r.off >= sigOff {
+			return fmt.Errorf("%v overlaps code signature at %#x", r, sigOff)
+		}
+		if f == nil {
+			continue
+		}
+		var tmp [4]byte
+		put32le(tmp[:], uint32(sigOff-r.off))
+		if _, err := f.WriteAt(tmp[:], r.sizeAt); err != nil {
+			return fmt.Errorf("trimming %v: %w", r, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"debug/macho"
+	"errors"
+	"testing"
+)
+
+// fixtureLinkeditSeg is the offset of the __LINKEDIT segment command in the
+// fixture files.
+const fixtureLinkeditSeg = fileHeaderSize64 + 72 + 80
+
+// addLinkeditData appends payload to __LINKEDIT of the fixture file
+// data, after the symbol table, as ld64 does for chained fixups and
+// split segment info, and adds a load command of type cmd referring
+// to it. It returns the new file and the offset of the payload.
+func addLinkeditData(data []byte, cmd uint32, payload []byte) ([]byte, int) {
+	off := roundUp(len(data), 8)
+	data = append(data, make([]byte, off-len(data))...)
+	data = append(data, payload...)
+	put64le(data[fixtureLinkeditSeg+48:], uint64(len(data))-get64le(data[fixtureLinkeditSeg+40:])) // filesize
+
+	ncmds, cmdsz := get32le(data[16:]), get32le(data[20:])
+	p := data[fileHeaderSize64+cmdsz:]
+	p = put32le(p, cmd)
+	p = put32le(p, 16)
+	p = put32le(p, uint32(off))
+	put32le(p, uint32(len(payload)))
+	put32le(data[16:], ncmds+1)
+	put32le(data[20:], cmdsz+16)
+	return data, off
+}
+
+func TestLinkeditData(t *testing.T) {
+	fixups := bytes.Repeat([]byte("fixups"), 20)
+	split := bytes.Repeat([]byte("split"), 9)
+	for _, signed := range []bool{false, true} {
+		in := fixtureFile(fixture{typ: macho.TypeDylib, headerSpace: true})
+		in, fixupsOff := addLinkeditData(in, LC_DYLD_CHAINED_FIXUPS, fixups)
+		in, splitOff := addLinkeditData(in, LC_SEGMENT_SPLIT_INFO, split)
+		if signed {
+			b := NewBuffer(in)
+			if err := Sign(b, Options{Identifier: "fixture", PageSize: 16 << 10}); err != nil {
+				t.Fatalf("signing fixture: %v", err)
+			}
+			in = b.Bytes()
+		}
+
+		b := NewBuffer(bytes.Clone(in))
+		if err := Sign(b, Options{Identifier: "linkedit"}); err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		out := b.Bytes()
+		checkSigned(t, out, in)
+		if got := out[fixupsOff:][:len(fixups)]; !bytes.Equal(got, fixups) {
+			t.Errorf("signed=%v: chained fixups changed: %q", signed, got)
+		}
+		if got := out[splitOff:][:len(split)]; !bytes.Equal(got, split) {
+			t.Errorf("signed=%v: split info changed: %q", signed, got)
+		}
+		sig, err := ReadSignature(NewBuffer(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if end := int64(splitOff + len(split)); sig.Offset < end {
+			t.Errorf("signed=%v: signature at %#x, before the end of the split info at %#x", signed, sig.Offset, end)
+		}
+	}
+}
+
+func TestLinkeditDataOverlap(t *testing.T) {
+	tests := []struct {
+		name    string
+		signed  bool
+		corrupt func(data []byte, cmdOff int)
+	}{
+		{"beyond __LINKEDIT", false, func(data []byte, cmdOff int) {
+			put64le(data[fixtureLinkeditSeg+48:], get64le(data[fixtureLinkeditSeg+48:])-0x20) // filesize
+		}},
+		{"in signature", true, func(data []byte, cmdOff int) {
+			sig, _ := ReadSignature(NewBuffer(data))
+			put32le(data[cmdOff+8:], uint32(sig.Offset+8)) // dataoff
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true})
+			data, _ = addLinkeditData(data, LC_DYLD_CHAINED_FIXUPS, make([]byte, 0x40))
+			if tt.signed {
+				b := NewBuffer(data)
+				if err := Sign(b, Options{}); err != nil {
+					t.Fatal(err)
+				}
+				data = b.Bytes()
+			}
+			tt.corrupt(data, fileHeaderSize64+fixtureLoadSize)
+			orig := bytes.Clone(data)
+			b := NewBuffer(data)
+			err := Sign(b, Options{})
+			if err == nil {
+				t.Fatal("Sign succeeded")
+			}
+			if errors.Is(err, ErrNotMachO) {
+				t.Errorf("Sign error = %v, want a linkedit error", err)
+			}
+			if !bytes.Equal(b.Bytes(), orig) {
+				t.Errorf("Sign modified the file")
+			}
+		})
+	}
+}
@@ -0,0 +1,803 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package machosign does ad-hoc code signing of Mach-O files.
+// It tries to do what darwin linker does.
+package machosign
+
+import (
+	"crypto/sha256"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+const (
+	pageSizeBits = 12
+	pageSize     = 1 << pageSizeBits // default code page size
+)
+
+const LC_CODE_SIGNATURE = 0x1d
+
+var (
+	// ErrNotMachO is returned if a file is not a Mach-O file of
+	// a kind this package supports (64-bit, little endian).
+	ErrNotMachO = errors.New("not a 64-bit little endian Mach-O file")
+
+	// ErrAlreadySigned is returned by Sign if a file carries a
+	// signature that an ad-hoc signature would not replace faithfully,
+	// such as one with a CMS signature from a signing identity,
+	// unless Options.Replace is set.
+	ErrAlreadySigned = errors.New("already signed")
+
+	// ErrTooLarge is returned if a file is too large for
+	// LC_CODE_SIGNATURE, whose 32-bit fields can only refer to a
+	// signature ending in the first 4GB of the file.
+	ErrTooLarge = errors.New("file too large for LC_CODE_SIGNATURE")
+)
+
+const fileHeaderSize64 = 8 * 4
+
+const (
+	CSMAGIC_REQUIREMENT               = 0xfade0c00 // single Requirement blob
+	CSMAGIC_REQUIREMENTS              = 0xfade0c01 // Requirements vector (internal requirements)
+	CSMAGIC_CODEDIRECTORY             = 0xfade0c02 // CodeDirectory blob
+	CSMAGIC_EMBEDDED_SIGNATURE        = 0xfade0cc0 // embedded form of signature data
+	CSMAGIC_DETACHED_SIGNATURE        = 0xfade0cc1 // multi-arch collection of embedded signatures
+	CSMAGIC_BLOBWRAPPER               = 0xfade0b01 // CMS signature, among other things
+	CSMAGIC_EMBEDDED_ENTITLEMENTS     = 0xfade7171 // embedded entitlements
+	CSMAGIC_EMBEDDED_DER_ENTITLEMENTS = 0xfade7172 // embedded DER encoded entitlements
+
+	CSSLOT_CODEDIRECTORY             = 0       // slot index for CodeDirectory
+	CSSLOT_INFOSLOT                  = 1       // Info.plist
+	CSSLOT_REQUIREMENTS              = 2       // internal requirements
+	CSSLOT_RESOURCEDIR               = 3       // resource directory (CodeResources)
+	CSSLOT_APPLICATION               = 4       // application specific slot
+	CSSLOT_ENTITLEMENTS              = 5       // embedded entitlements
+	CSSLOT_DER_ENTITLEMENTS          = 7       // embedded DER encoded entitlements
+	CSSLOT_ALTERNATE_CODEDIRECTORIES = 0x1000  // first alternate CodeDirectory, if any
+	CSSLOT_SIGNATURESLOT             = 0x10000 // CMS signature
+)
+
+const (
+	CS_VALID            = 0x1     // dynamically valid
+	CS_ADHOC            = 0x2     // ad hoc signed
+	CS_GET_TASK_ALLOW   = 0x4     // has get-task-allow entitlement
+	CS_INSTALLER        = 0x8     // has installer entitlement
+	CS_HARD             = 0x100   // don't load invalid pages
+	CS_KILL             = 0x200   // kill process if it becomes invalid
+	CS_CHECK_EXPIRATION = 0x400   // force expiration checking
+	CS_RESTRICT         = 0x800   // tell dyld to treat restricted
+	CS_ENFORCEMENT      = 0x1000  // require enforcement
+	CS_REQUIRE_LV       = 0x2000  // require library validation
+	CS_RUNTIME          = 0x10000 // apply hardened runtime policies
+	CS_LINKER_SIGNED    = 0x20000 // automatically signed by the linker
+)
+
+const (
+	kSecCodeSignatureNoHash              = 0 // null value
+	kSecCodeSignatureHashSHA1            = 1 // SHA-1
+	kSecCodeSignatureHashSHA256          = 2 // SHA-256
+	kSecCodeSignatureHashSHA256Truncated = 3 // SHA-256 truncated to first 20 bytes
+	kSecCodeSignatureHashSHA384          = 4 // SHA-384
+	kSecCodeSignatureHashSHA512          = 5 // SHA-512
+)
+
+// Hash types of the CodeDirectory, as the kernel names them.
+const (
+	CS_HASHTYPE_SHA1             = kSecCodeSignatureHashSHA1
+	CS_HASHTYPE_SHA256           = kSecCodeSignatureHashSHA256
+	CS_HASHTYPE_SHA256_TRUNCATED = kSecCodeSignatureHashSHA256Truncated
+	CS_HASHTYPE_SHA384           = kSecCodeSignatureHashSHA384
+)
+
+const (
+	CS_EXECSEG_MAIN_BINARY     = 0x1   // executable segment denotes main binary
+	CS_EXECSEG_ALLOW_UNSIGNED  = 0x10  // allow unsigned pages (for debugging)
+	CS_EXECSEG_DEBUGGER        = 0x20  // main binary is debugger
+	CS_EXECSEG_JIT             = 0x40  // JIT enabled
+	CS_EXECSEG_SKIP_LV         = 0x80  // skip library validation
+	CS_EXECSEG_CAN_LOAD_CDHASH = 0x100 // can bless cdhash for execution
+	CS_EXECSEG_CAN_EXEC_CDHASH = 0x200 // can execute blessed cdhash
+)
+
+type Blob struct {
+	typ    uint32 // type of entry
+	offset uint32 // offset of entry
+	// data follows
+}
+
+func (b *Blob) put(out []byte) []byte {
+	out = put32be(out, b.typ)
+	out = put32be(out, b.offset)
+	return out
+}
+
+type SuperBlob struct {
+	magic  uint32 // magic number
+	length uint32 // total length of SuperBlob
+	count  uint32 // number of index entries following
+	// blobs []Blob
+}
+
+func (s *SuperBlob) put(out []byte) []byte {
+	out = put32be(out, s.magic)
+	out = put32be(out, s.length)
+	out = put32be(out, s.count)
+	return out
+}
+
+type linkeditDataCmd struct {
+	cmd      uint32
+	cmdsize  uint32 // sizeof(struct linkedit_data_command)
+	dataoff  uint32 // file offset of data in __LINKEDIT segment
+	datasize uint32 // file size of data in __LINKEDIT segment
+}
+
+func (l *linkeditDataCmd) put(out []byte) []byte {
+	// load command is little endian
+	out = put32le(out, l.cmd)
+	out = put32le(out, l.cmdsize)
+	out = put32le(out, l.dataoff)
+	out = put32le(out, l.datasize)
+	return out
+}
+
+func get32le(b []byte) uint32           { return binary.LittleEndian.Uint32(b) }
+func get16be(b []byte) uint16           { return binary.BigEndian.Uint16(b) }
+func get32be(b []byte) uint32           { return binary.BigEndian.Uint32(b) }
+func get64be(b []byte) uint64           { return binary.BigEndian.Uint64(b) }
+func get64le(b []byte) uint64           { return binary.LittleEndian.Uint64(b) }
+func put32le(b []byte, x uint32) []byte { binary.LittleEndian.PutUint32(b, x); return b[4:] }
+func put16be(b []byte, x uint16) []byte { binary.BigEndian.PutUint16(b, x); return b[2:] }
+func put32be(b []byte, x uint32) []byte { binary.BigEndian.PutUint32(b, x); return b[4:] }
+func put64le(b []byte, x uint64) []byte { binary.LittleEndian.PutUint64(b, x); return b[8:] }
+func put64be(b []byte, x uint64) []byte { binary.BigEndian.PutUint64(b, x); return b[8:] }
+func put8(b []byte, x uint8) []byte     { b[0] = x; return b[1:] }
+func puts(b, s []byte) []byte           { n := copy(b, s); return b[n:] }
+
+// round x up to a multiple of n. n must be a power of 2.
+func roundUp(x, n int) int { return (x + n - 1) &^ (n - 1) }
+
+const verbose = false
+
+// ReadWriteSeeker is the interface that groups the methods Sign uses
+// to read and rewrite a Mach-O file in place. *os.File implements it.
+type ReadWriteSeeker interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Seeker
+}
+
+// Options controls how a file is signed.
+type Options struct {
+	// Identifier is the signing identifier recorded in the CodeDirectory.
+	// If empty, "a.out" is used.
+	Identifier string
+
+	// PageSize is the size of the code pages that are hashed, in bytes.
+	// It must be a power of 2 between 4K and 64K. If zero, 4K is used,
+	// as the darwin linker does. Apple's codesign uses 16K for arm64.
+	PageSize int
+
+	// InfoPlist and CodeResources are the contents of the Info.plist
+	// and _CodeSignature/CodeResources files of the bundle containing
+	// the binary. If set, their hashes are recorded in the
+	// corresponding special slots of the CodeDirectory.
+	InfoPlist     []byte
+	CodeResources []byte
+
+	// Jobs is the number of goroutines hashing code pages.
+	// If zero, pages are hashed sequentially.
+	Jobs int
+
+	// Scatter, if set, is the scatter vector of the CodeDirectory.
+	// Only the pages it lists are hashed.
+	Scatter []Scatter
+
+	// Entitlements, if set, is an XML property list of entitlements.
+	// It is embedded both as is and in the DER form newer versions
+	// of macOS require. If nil, the entitlements of an existing
+	// signature, if any, are carried over into the new one.
+	Entitlements []byte
+
+	// StripEntitlements drops the entitlements of an existing
+	// signature instead of carrying them over.
+	StripEntitlements bool
+
+	// Flags are the CodeDirectory flags, e.g. CS_RUNTIME for the
+	// hardened runtime. CS_ADHOC is always set. If zero,
+	// CS_ADHOC|CS_LINKER_SIGNED is used, as the darwin linker does.
+	Flags uint32
+
+	// Replace allows replacing a signature from a signing identity,
+	// such as one made by Apple's codesign, with an ad-hoc signature.
+	// Its CMS signature, requirements and other blobs, except for the
+	// entitlements, are dropped.
+	// Otherwise, Sign returns an error wrapping ErrAlreadySigned.
+	Replace bool
+
+	// Debuggable marks a main executable as allowing unsigned pages
+	// (CS_EXECSEG_ALLOW_UNSIGNED), as for get-task-allow builds, so
+	// that debuggers can patch its code. It has no effect on dylibs
+	// and bundles.
+	Debuggable bool
+
+	// RegenerateUUID sets the LC_UUID to the ComputeUUID of the file,
+	// once signing has made any changes to its layout, so that it
+	// matches the binary's final contents. The file must have an
+	// LC_UUID load command.
+	RegenerateUUID bool
+
+	// UUID, if set, is the LC_UUID to give the file, set once Sign has
+	// checked that it can sign it. The file must have an LC_UUID load
+	// command. It cannot be set with RegenerateUUID.
+	UUID *UUID
+
+	// kept are the entitlements blobs of the existing signature,
+	// set by Sign and Plan.
+	kept []slotBlob
+}
+
+func (opts *Options) flags() uint32 {
+	if opts.Flags == 0 {
+		return CS_ADHOC | CS_LINKER_SIGNED
+	}
+	return opts.Flags | CS_ADHOC
+}
+
+// A slotBlob is a blob embedded in the signature besides the
+// CodeDirectory.
+type slotBlob struct {
+	slot uint32
+	data []byte // including the blob header
+}
+
+// blobs returns the blobs to embed besides the CodeDirectory,
+// in slot order.
+func (opts *Options) blobs() ([]slotBlob, error) {
+	if opts.Entitlements == nil {
+		return opts.kept, nil
+	}
+	ent, der, err := entitlementsBlobs(opts.Entitlements)
+	if err != nil {
+		return nil, err
+	}
+	return []slotBlob{{CSSLOT_ENTITLEMENTS, ent}, {CSSLOT_DER_ENTITLEMENTS, der}}, nil
+}
+
+// nSpecialSlots returns the number of special slots of the
+// CodeDirectory, which is the highest slot used.
+func (opts *Options) nSpecialSlots() int {
+	n := 0
+	switch {
+	case opts.CodeResources != nil:
+		n = CSSLOT_RESOURCEDIR
+	case opts.InfoPlist != nil:
+		n = CSSLOT_INFOSLOT
+	}
+	switch {
+	case opts.Entitlements != nil:
+		n = CSSLOT_DER_ENTITLEMENTS
+	case len(opts.kept) > 0:
+		n = max(n, int(opts.kept[len(opts.kept)-1].slot))
+	}
+	return n
+}
+
+// specialSlot returns the contents hashed into special slot i,
+// or nil if the slot is unused. blobs are the embedded blobs,
+// which are hashed into their own slots.
+func (opts *Options) specialSlot(i int, blobs []slotBlob) []byte {
+	switch i {
+	case CSSLOT_INFOSLOT:
+		return opts.InfoPlist
+	case CSSLOT_RESOURCEDIR:
+		return opts.CodeResources
+	}
+	for _, b := range blobs {
+		if b.slot == uint32(i) {
+			return b.data
+		}
+	}
+	return nil
+}
+
+// execSegFlags returns the executable segment flags for a file of
+// type typ. As in ld64, only MH_EXECUTE files are main binaries;
+// dylibs, bundles and other types get no flags, since the kernel
+// only honors the flags of the main binary.
+func (opts *Options) execSegFlags(typ macho.Type) uint64 {
+	if typ != macho.TypeExec {
+		return 0
+	}
+	flags := uint64(CS_EXECSEG_MAIN_BINARY)
+	if opts.Debuggable {
+		flags |= CS_EXECSEG_ALLOW_UNSIGNED
+	}
+	return flags
+}
+
+func (opts *Options) pageSize() int {
+	if opts.PageSize == 0 {
+		return pageSize
+	}
+	return opts.PageSize
+}
+
+func (opts *Options) check() error {
+	ps := opts.pageSize()
+	if ps < 4<<10 || ps > 64<<10 || ps&(ps-1) != 0 {
+		return fmt.Errorf("invalid page size %d", ps)
+	}
+	if opts.RegenerateUUID && opts.UUID != nil {
+		return errors.New("both RegenerateUUID and UUID set")
+	}
+	_, err := opts.blobs()
+	return err
+}
+
+// cdLayout is the layout of the CodeDirectory Sign emits.
+type cdLayout struct {
+	scatterOff int // offset of the scatter vector, or 0
+	idOff      int // offset of the identifier
+	hashOff    int // offset of code slot 0
+	nhashes    int // number of code slots
+	size       int // size of the CodeDirectory
+}
+
+// layout returns the layout of the CodeDirectory for codeSize bytes
+// of code.
+func (opts *Options) layout(codeSize int64) cdLayout {
+	var l cdLayout
+	ps := int64(opts.pageSize())
+	l.nhashes = int((codeSize + ps - 1) / ps)
+	off := codeDirectorySize(codeDirectoryVersion)
+	if len(opts.Scatter) > 0 {
+		l.scatterOff = off
+		off += (len(opts.Scatter) + 1) * scatterSize // plus terminator
+		last := opts.Scatter[len(opts.Scatter)-1]
+		l.nhashes = int(last.Base + last.Count)
+	}
+	l.idOff = off
+	l.hashOff = l.idOff + len(opts.id()) + opts.nSpecialSlots()*sha256.Size
+	l.size = l.hashOff + l.nhashes*sha256.Size
+	return l
+}
+
+func (opts *Options) id() string {
+	if opts.Identifier == "" {
+		return "a.out\000"
+	}
+	return opts.Identifier + "\000"
+}
+
+// Size returns the size of the code signature for codeSize bytes of
+// code, that is, the size of the data LC_CODE_SIGNATURE describes.
+// If opts are invalid (e.g. malformed entitlements), the result is
+// meaningless; Sign reports the error.
+func Size(codeSize int64, opts Options) int64 {
+	blobs, _ := opts.blobs()
+	sz := int64(unsafe.Sizeof(SuperBlob{})) + int64(len(blobs)+1)*int64(unsafe.Sizeof(Blob{}))
+	sz += int64(opts.layout(codeSize).size)
+	for _, b := range blobs {
+		sz += int64(len(b.data))
+	}
+	return sz
+}
+
+// SignatureSize returns the exact size of a signature holding only
+// a CodeDirectory, as Sign writes it when there are no entitlements,
+// for codeSize bytes of code hashed in pages of pageSize bytes (4K
+// if zero), an identifier of identLen bytes, not counting its NUL
+// terminator, and nSpecialSlots special slots. The hash type must be
+// CS_HASHTYPE_SHA256, the only one Sign uses; others are rejected, as
+// Sign would never write a signature of that size. Each blob embedded
+// besides the CodeDirectory adds its size plus 8 bytes.
+//
+// A producer such as a linker can use it to reserve the signature in
+// __LINKEDIT ahead of time, with codeSize being the offset of the
+// signature; Sign then fills it in without resizing anything.
+func SignatureSize(codeSize int64, pageSize, identLen int, hashType uint8, nSpecialSlots int) (int64, error) {
+	if hashType != CS_HASHTYPE_SHA256 {
+		return 0, fmt.Errorf("hash type %d: Sign only uses SHA-256 (CS_HASHTYPE_SHA256)", hashType)
+	}
+	hs := sha256.Size
+	opts := Options{PageSize: pageSize}
+	if err := opts.check(); err != nil {
+		return 0, err
+	}
+	if codeSize < 0 || identLen < 0 || nSpecialSlots < 0 {
+		return 0, fmt.Errorf("invalid sizes: code %d, identifier %d, special slots %d", codeSize, identLen, nSpecialSlots)
+	}
+	ps := int64(opts.pageSize())
+	nhashes := (codeSize + ps - 1) / ps
+	sz := int64(unsafe.Sizeof(SuperBlob{})) + int64(unsafe.Sizeof(Blob{}))
+	sz += int64(codeDirectorySize(codeDirectoryVersion)) + int64(identLen+1)
+	sz += (int64(nSpecialSlots) + nhashes) * int64(hs)
+	return sz, nil
+}
+
+// Sign ad-hoc signs the 64-bit little endian Mach-O file f in place.
+// If f has no LC_CODE_SIGNATURE load command, one is added and the
+// signature is appended to the end of the file, growing __LINKEDIT
+// to cover it. If there is no room for the load command, the file is
+// rewritten to make some, moving the section data, if possible. If f
+// is already signed, the old signature is replaced,
+// resizing __LINKEDIT and the file as needed. If the new signature
+// is smaller and f has no Truncate(int64) error method, the old
+// region is reused instead, zero-filling what is left of it.
+func Sign(f ReadWriteSeeker, opts Options) error {
+	if err := opts.check(); err != nil {
+		return err
+	}
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	mf, err := openMachO(f, fileSize)
+	if err != nil {
+		return err
+	}
+	li, err := scanLoads(mf)
+	if err != nil {
+		return err
+	}
+	if err := validate(mf, fileSize, li); err != nil {
+		return err
+	}
+	if err := checkExisting(f, li, opts); err != nil {
+		return err
+	}
+	if opts.kept, err = keptEntitlements(f, li, opts); err != nil {
+		return err
+	}
+	if (opts.RegenerateUUID || opts.UUID != nil) && uuidOffset(mf) < 0 {
+		return ErrNoUUID
+	}
+	sigOff, sigSz, sigCmdOff := li.sigOff, li.sigSz, li.sigCmdOff
+	linkeditSeg, linkeditOff, textSeg, loadOff := li.linkeditSeg, li.linkeditOff, li.textSeg, li.loadOff
+	if sigOff == 0 {
+		sigOff = roundUp(int(fileSize), 16) // round up to 16 bytes ???
+	}
+
+	// compute sizes
+	id := opts.id()
+	ps := opts.pageSize()
+	layout := opts.layout(int64(sigOff))
+	nspecial := opts.nSpecialSlots()
+	sz := int(Size(int64(sigOff), opts))
+	csCmdSz := int(unsafe.Sizeof(linkeditDataCmd{}))
+
+	// Check everything before writing anything, so that a file
+	// that cannot be signed is left as it was.
+	if err := checkSigOffset(int64(sigOff), int64(sz)); err != nil {
+		return err
+	}
+	if err := fixLinkedit(nil, mf, linkeditSeg, uint64(sigOff)); err != nil {
+		return err
+	}
+	if err := checkScatter(opts.Scatter, int64(sigOff+ps-1)/int64(ps)); err != nil {
+		return err
+	}
+	if start := dataStart(mf, fileSize); sigSz == 0 && int64(loadOff+csCmdSz) > start {
+		if err := canShift(mf, opts); err != nil {
+			return fmt.Errorf("no space for adding LC_CODE_SIGNATURE at %#x: first section at %#x, and cannot make room: %v", loadOff, start, err)
+		}
+		if err := shiftFile(f, mf, fileSize, start); err != nil {
+			return err
+		}
+		return Sign(f, opts) // there is room now
+	}
+
+	if pad := int64(sigOff) - fileSize; pad > 0 {
+		_, err = f.WriteAt(make([]byte, pad), fileSize)
+		if err != nil {
+			return fmt.Errorf("padding file at %#x: %w", fileSize, err)
+		}
+	}
+	if err := fixLinkedit(f, mf, linkeditSeg, uint64(sigOff)); err != nil {
+		return err
+	}
+
+	// dataSz is the size of the region LC_CODE_SIGNATURE describes.
+	// It is larger than the signature if the old region is reused.
+	dataSz := sz
+	if _, ok := f.(truncater); !ok && sigSz > sz && int64(sigOff+sigSz) == fileSize {
+		dataSz = sigSz
+	}
+
+	var tmp [8]byte
+	csCmd := linkeditDataCmd{
+		cmd:      LC_CODE_SIGNATURE,
+		cmdsize:  uint32(csCmdSz),
+		dataoff:  uint32(sigOff),
+		datasize: uint32(dataSz),
+	}
+	switch {
+	case sigSz == 0: // LC_CODE_SIGNATURE does not exist. Add one.
+		out := make([]byte, csCmdSz)
+		csCmd.put(out)
+		_, err = f.WriteAt(out, int64(loadOff))
+		if err != nil {
+			return fmt.Errorf("adding LC_CODE_SIGNATURE at %#x: %w", loadOff, err)
+		}
+
+		// fix up header: update Ncmd and Cmdsz
+		put32le(tmp[:4], mf.FileHeader.Ncmd+1)
+		_, err = f.WriteAt(tmp[:4], int64(unsafe.Offsetof(mf.FileHeader.Ncmd)))
+		if err != nil {
+			return fmt.Errorf("updating Mach-O header: %w", err)
+		}
+		put32le(tmp[:4], mf.FileHeader.Cmdsz+uint32(csCmdSz))
+		_, err = f.WriteAt(tmp[:4], int64(unsafe.Offsetof(mf.FileHeader.Cmdsz)))
+		if err != nil {
+			return fmt.Errorf("updating Mach-O header: %w", err)
+		}
+	case sigSz != dataSz:
+		// LC_CODE_SIGNATURE exists but with a different size, e.g.
+		// signed with a different identifier or by another tool.
+		// Rewrite it to describe the new signature, which is
+		// regenerated from scratch at the same offset.
+		out := make([]byte, csCmdSz)
+		csCmd.put(out)
+		_, err = f.WriteAt(out, int64(sigCmdOff))
+		if err != nil {
+			return fmt.Errorf("rewriting LC_CODE_SIGNATURE at %#x: %w", sigCmdOff, err)
+		}
+	}
+
+	if sigSz != dataSz {
+		// fix up LINKEDIT segment: update Memsz and Filesz
+		segSz := sigOff + dataSz - int(linkeditSeg.Offset)
+		put64le(tmp[:8], uint64(roundUp(segSz, 0x4000))) // round up to physical page size
+		_, err = f.WriteAt(tmp[:8], int64(linkeditOff)+int64(unsafe.Offsetof(macho.Segment64{}.Memsz)))
+		if err != nil {
+			return fmt.Errorf("updating __LINKEDIT segment command at %#x: %w", linkeditOff, err)
+		}
+		put64le(tmp[:8], uint64(segSz))
+		_, err = f.WriteAt(tmp[:8], int64(linkeditOff)+int64(unsafe.Offsetof(macho.Segment64{}.Filesz)))
+		if err != nil {
+			return fmt.Errorf("updating __LINKEDIT segment command at %#x: %w", linkeditOff, err)
+		}
+	}
+
+	// emit blob headers
+	blobs, err := opts.blobs()
+	if err != nil {
+		return err
+	}
+	sb := SuperBlob{
+		magic:  CSMAGIC_EMBEDDED_SIGNATURE,
+		length: uint32(sz),
+		count:  uint32(len(blobs) + 1),
+	}
+	cdOff := int(unsafe.Sizeof(SuperBlob{})) + (len(blobs)+1)*int(unsafe.Sizeof(Blob{}))
+	index := []Blob{{typ: CSSLOT_CODEDIRECTORY, offset: uint32(cdOff)}}
+	blobOff := cdOff + layout.size
+	for _, b := range blobs {
+		index = append(index, Blob{typ: b.slot, offset: uint32(blobOff)})
+		blobOff += len(b.data)
+	}
+	cdir := CodeDirectory{
+		Magic:         CSMAGIC_CODEDIRECTORY,
+		Length:        uint32(layout.size),
+		Version:       codeDirectoryVersion,
+		Flags:         opts.flags(),
+		HashOffset:    uint32(layout.hashOff),
+		IdentOffset:   uint32(layout.idOff),
+		NSpecialSlots: uint32(nspecial),
+		NCodeSlots:    uint32(layout.nhashes),
+		HashSize:      sha256.Size,
+		HashType:      kSecCodeSignatureHashSHA256,
+		PageSize:      uint8(bits.TrailingZeros(uint(ps))),
+		ScatterOffset: uint32(layout.scatterOff),
+		ExecSegBase:   textSeg.Offset,
+		ExecSegLimit:  textSeg.Filesz,
+		ExecSegFlags:  opts.execSegFlags(mf.Type),
+	}
+	if err := cdir.SetCodeLimit(uint64(sigOff)); err != nil {
+		return err
+	}
+
+	out := make([]byte, dataSz) // zero after sz, if reusing the old region
+	outp := out
+
+	outp = sb.put(outp)
+	for _, b := range index {
+		outp = b.put(outp)
+	}
+	outp = cdir.put(outp)
+	if len(opts.Scatter) > 0 {
+		for _, s := range opts.Scatter {
+			outp = s.put(outp)
+		}
+		outp = outp[scatterSize:] // zero terminator
+	}
+	outp = puts(outp, []byte(id))
+
+	// emit special slot hashes, which precede the code hashes in
+	// reverse order; unused slots are zero
+	for i := nspecial; i > 0; i-- {
+		var b [sha256.Size]byte
+		if data := opts.specialSlot(i, blobs); data != nil {
+			b = sha256.Sum256(data)
+		}
+		outp = puts(outp, b[:])
+	}
+
+	// The UUID is covered by the code hashes, so it must be final
+	// before hashing.
+	switch {
+	case opts.RegenerateUUID:
+		if err := regenerateUUID(f); err != nil {
+			return err
+		}
+	case opts.UUID != nil:
+		if _, err := SetUUID(f, *opts.UUID); err != nil {
+			return err
+		}
+	}
+
+	// emit hashes
+	if len(opts.Scatter) == 0 {
+		if err := hashPages(outp, f, int64(sigOff), ps, opts.Jobs); err != nil {
+			return err
+		}
+	}
+	for _, s := range opts.Scatter {
+		start := int64(s.Base) * int64(ps)
+		end := min(start+int64(s.Count)*int64(ps), int64(sigOff))
+		r := io.NewSectionReader(f, start, end-start)
+		if err := hashPages(outp[int(s.Base)*sha256.Size:], r, end-start, ps, opts.Jobs); err != nil {
+			return err
+		}
+	}
+
+	// emit the other blobs after the CodeDirectory
+	for i, b := range blobs {
+		copy(out[index[i+1].offset:], b.data)
+	}
+
+	if verbose {
+		for i := 0; i < len(out); i += 16 {
+			end := i + 16
+			if end > len(out) {
+				end = len(out)
+			}
+			fmt.Printf("% x\n", out[i:end])
+		}
+	}
+
+	_, err = f.WriteAt(out, int64(sigOff))
+	if err != nil {
+		return fmt.Errorf("writing signature at %#x: %w", sigOff, err)
+	}
+
+	// If the old signature was larger, drop what is left of it.
+	if end := int64(sigOff + dataSz); end < fileSize {
+		t, ok := f.(truncater)
+		if !ok {
+			return fmt.Errorf("signature shrinks from %d to %d bytes, but file cannot be truncated", sigSz, sz)
+		}
+		if err := t.Truncate(end); err != nil {
+			return fmt.Errorf("truncating file to %#x: %w", end, err)
+		}
+	}
+	return nil
+}
+
+// openMachO parses the Mach-O file r of the given size and checks
+// that it is a 64-bit little endian file, the only kind Sign supports.
+func openMachO(r io.ReaderAt, size int64) (*macho.File, error) {
+	mf, err := newFile(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if mf.Magic != macho.Magic64 {
+		return nil, fmt.Errorf("%w: not 64-bit", ErrNotMachO)
+	}
+	if mf.ByteOrder != binary.LittleEndian {
+		return nil, fmt.Errorf("%w: not little endian", ErrNotMachO)
+	}
+	if err := checkCPU(mf); err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
+
+// newFile is macho.NewFile, with format errors wrapping ErrNotMachO.
+func newFile(r io.ReaderAt) (*macho.File, error) {
+	mf, err := macho.NewFile(r)
+	var ferr *macho.FormatError
+	if errors.As(err, &ferr) {
+		return nil, fmt.Errorf("%w: %v", ErrNotMachO, err)
+	}
+	return mf, err
+}
+
+// checkExisting returns an error wrapping ErrAlreadySigned if the
+// existing signature described by li contains a CMS signature and
+// opts do not allow replacing it.
+func checkExisting(r io.ReaderAt, li *loadInfo, opts Options) error {
+	if li.sigSz == 0 || opts.Replace {
+		return nil
+	}
+	data := make([]byte, li.sigSz)
+	if _, err := r.ReadAt(data, int64(li.sigOff)); err != nil {
+		return fmt.Errorf("reading signature at %#x: %w", li.sigOff, err)
+	}
+	var sig Signature
+	if err := sig.decode(data); err != nil {
+		// Not a signature we understand; it will be overwritten.
+		return nil
+	}
+	for _, b := range sig.Blobs {
+		if b.Slot == CSSLOT_SIGNATURESLOT && b.Magic == CSMAGIC_BLOBWRAPPER && b.Length > 8 {
+			return fmt.Errorf("%w: signature at %#x has a CMS signature", ErrAlreadySigned, li.sigOff)
+		}
+	}
+	return nil
+}
+
+// checkSigOffset checks that a signature of size sz at offset off can
+// be described by LC_CODE_SIGNATURE, whose fields are 32-bit. The code
+// limit of the CodeDirectory could go beyond 4GB, but an embedded
+// signature, which follows the code, cannot. The error wraps
+// ErrTooLarge.
+func checkSigOffset(off, sz int64) error {
+	if off+sz > math.MaxUint32 {
+		return fmt.Errorf("%w: signature at %#x, size %#x, beyond the 4GB it can refer to", ErrTooLarge, off, sz)
+	}
+	return nil
+}
+
+// loadInfo is what Sign needs to know about the load commands.
+type loadInfo struct {
+	sigOff, sigSz int // existing code signature, or zero
+	sigCmdOff     int // file offset of existing LC_CODE_SIGNATURE
+	linkeditSeg   *macho.Segment
+	linkeditOff   int // file offset of the __LINKEDIT segment command
+	textSeg       *macho.Segment
+	loadOff       int // file offset of the end of the load commands
+}
+
+// scanLoads finds the existing LC_CODE_SIGNATURE and the __TEXT and
+// __LINKEDIT segments of mf.
+func scanLoads(mf *macho.File) (*loadInfo, error) {
+	li := &loadInfo{loadOff: fileHeaderSize64}
+	for _, l := range mf.Loads {
+		data := l.Raw()
+		cmd, sz := get32le(data), get32le(data[4:])
+		if cmd == LC_CODE_SIGNATURE {
+			li.sigOff = int(get32le(data[8:]))
+			li.sigSz = int(get32le(data[12:]))
+			li.sigCmdOff = li.loadOff
+		}
+		if seg, ok := l.(*macho.Segment); ok {
+			switch seg.Name {
+			case "__LINKEDIT":
+				li.linkeditSeg = seg
+				li.linkeditOff = li.loadOff
+			case "__TEXT":
+				li.textSeg = seg
+			}
+		}
+		li.loadOff += int(sz)
+	}
+	if li.linkeditSeg == nil || li.textSeg == nil {
+		return nil, fmt.Errorf("%w: missing __TEXT or __LINKEDIT segment", ErrNotMachO)
+	}
+	return li, nil
+}
+
+// truncater is implemented by files that can be truncated, like *os.File.
+type truncater interface {
+	Truncate(size int64) error
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scatterSize is the encoded size of a Scatter.
+const scatterSize = 24
+
+// A Scatter is an entry of a CodeDirectory's scatter vector, which
+// describes the signed regions of a non-contiguous layout, such as a
+// kernel cache. Code slot i then holds the hash of page i, for the
+// pages listed in the vector; the other slots are zero.
+type Scatter struct {
+	Count        uint32 `json:"count"`         // number of pages
+	Base         uint32 `json:"base"`          // first page number
+	TargetOffset uint64 `json:"target_offset"` // offset in target
+	Spare        uint64 `json:"spare,omitempty"`
+}
+
+func (s *Scatter) put(out []byte) []byte {
+	out = put32be(out, s.Count)
+	out = put32be(out, s.Base)
+	out = put64be(out, s.TargetOffset)
+	out = put64be(out, s.Spare)
+	return out
+}
+
+func (s Scatter) String() string {
+	return fmt.Sprintf("pages [%d, %d) target=%#x", s.Base, s.Base+s.Count, s.TargetOffset)
+}
+
+// parseScatter decodes the scatter vector at off in data, up to
+// and not including the terminating entry with a zero count.
+func parseScatter(data []byte, off uint32) ([]Scatter, error) {
+	var v []Scatter
+	for o := uint64(off); ; o += scatterSize {
+		if o+scatterSize > uint64(len(data)) {
+			return nil, errors.New("unterminated scatter vector")
+		}
+		s := Scatter{
+			Count:        get32be(data[o:]),
+			Base:         get32be(data[o+4:]),
+			TargetOffset: get64be(data[o+8:]),
+			Spare:        get64be(data[o+16:]),
+		}
+		if s.Count == 0 {
+			return v, nil
+		}
+		v = append(v, s)
+	}
+}
+
+// checkScatter checks that the scatter vector v lists non-empty,
+// ascending, non-overlapping page ranges within npages pages.
+func checkScatter(v []Scatter, npages int64) error {
+	next := int64(0)
+	for i, s := range v {
+		base, end := int64(s.Base), int64(s.Base)+int64(s.Count)
+		switch {
+		case s.Count == 0:
+			return fmt.Errorf("scatter entry %d is empty", i)
+		case base < next:
+			return fmt.Errorf("scatter entry %d (%v) overlaps or precedes the previous one", i, s)
+		case end > npages:
+			return fmt.Errorf("scatter entry %d (%v) beyond the %d code pages", i, s, npages)
+		}
+		next = end
+	}
+	return nil
+}
+
+// ParseScatterFlag parses a scatter vector of the form
+// "base:count[@target],...", e.g. "0:16,32:8@0x40000".
+// Numbers may be given in any base accepted by strconv.ParseUint.
+func ParseScatterFlag(s string) ([]Scatter, error) {
+	var v []Scatter
+	for _, f := range strings.Split(s, ",") {
+		rng, target, hasTarget := strings.Cut(f, "@")
+		base, count, ok := strings.Cut(rng, ":")
+		if !ok {
+			return nil, fmt.Errorf("scatter entry %q: want base:count[@target]", f)
+		}
+		var e Scatter
+		b, err1 := strconv.ParseUint(base, 0, 32)
+		c, err2 := strconv.ParseUint(count, 0, 32)
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, fmt.Errorf("scatter entry %q: %v", f, err)
+		}
+		e.Base, e.Count = uint32(b), uint32(c)
+		if hasTarget {
+			if e.TargetOffset, err1 = strconv.ParseUint(target, 0, 64); err1 != nil {
+				return nil, fmt.Errorf("scatter entry %q: %v", f, err1)
+			}
+		}
+		v = append(v, e)
+	}
+	return v, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseScatterFlag(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []Scatter
+		err  string // substring of the error, if any
+	}{
+		{in: "0:16", want: []Scatter{{Count: 16}}},
+		{in: "0:16,32:8@0x40000", want: []Scatter{{Count: 16}, {Base: 32, Count: 8, TargetOffset: 0x40000}}},
+		{in: "0x10:0b11@0o17", want: []Scatter{{Base: 16, Count: 3, TargetOffset: 15}}},
+		{in: "4294967295:1@18446744073709551615", want: []Scatter{{Base: 1<<32 - 1, Count: 1, TargetOffset: 1<<64 - 1}}},
+		{in: "0:0", want: []Scatter{{}}}, // left to checkScatter
+		{in: "", err: `scatter entry "": want base:count[@target]`},
+		{in: "16", err: `scatter entry "16": want base:count[@target]`},
+		{in: "0:16,", err: `scatter entry "": want base:count[@target]`},
+		{in: "x:1", err: `scatter entry "x:1": strconv.ParseUint: parsing "x": invalid syntax`},
+		{in: "1:y", err: `parsing "y": invalid syntax`},
+		{in: "-1:1", err: `parsing "-1": invalid syntax`},
+		{in: "4294967296:1", err: `parsing "4294967296": value out of range`},
+		{in: "0:1@", err: `scatter entry "0:1@": strconv.ParseUint: parsing "": invalid syntax`},
+		{in: "0:1@z", err: `parsing "z": invalid syntax`},
+	} {
+		v, err := ParseScatterFlag(tt.in)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseScatterFlag(%q) = %v, %v; want error containing %q", tt.in, v, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("ParseScatterFlag(%q): %v", tt.in, err)
+		case !slices.Equal(v, tt.want):
+			t.Errorf("ParseScatterFlag(%q) = %v, want %v", tt.in, v, tt.want)
+		}
+	}
+}
+
+func TestCheckScatter(t *testing.T) {
+	for _, tt := range []struct {
+		v      []Scatter
+		npages int64
+		err    string // substring of the error, if any
+	}{
+		{v: nil, npages: 0},
+		{v: []Scatter{{Base: 0, Count: 4}}, npages: 4},
+		{v: []Scatter{{Base: 0, Count: 2}, {Base: 2, Count: 2}}, npages: 4},
+		{v: []Scatter{{Base: 1, Count: 1}, {Base: 3, Count: 1}}, npages: 10},
+		{v: []Scatter{{Base: 0, Count: 0}}, npages: 4, err: "scatter entry 0 is empty"},
+		{v: []Scatter{{Base: 0, Count: 1}, {Base: 2, Count: 0}}, npages: 4, err: "scatter entry 1 is empty"},
+		{v: []Scatter{{Base: 0, Count: 5}}, npages: 4, err: "scatter entry 0 (pages [0, 5) target=0x0) beyond the 4 code pages"},
+		{v: []Scatter{{Base: 0, Count: 3}, {Base: 2, Count: 1}}, npages: 4, err: "scatter entry 1 (pages [2, 3) target=0x0) overlaps or precedes the previous one"},
+		{v: []Scatter{{Base: 3, Count: 1}, {Base: 1, Count: 1}}, npages: 4, err: "scatter entry 1 (pages [1, 2) target=0x0) overlaps or precedes"},
+		{v: []Scatter{{Base: 1<<32 - 1, Count: 1<<32 - 1}}, npages: 1 << 40, err: ""}, // no overflow
+		{v: []Scatter{{Base: 1<<32 - 1, Count: 2}}, npages: 1 << 32, err: "beyond the 4294967296 code pages"},
+	} {
+		err := checkScatter(tt.v, tt.npages)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("checkScatter(%v, %d) = %v, want error containing %q", tt.v, tt.npages, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("checkScatter(%v, %d): %v", tt.v, tt.npages, err)
+		}
+	}
+}
+
+// encodeScatter returns the encoding of v, followed by the terminating
+// entry unless unterminated.
+func encodeScatter(v []Scatter, unterminated bool) []byte {
+	if !unterminated {
+		v = append(v, Scatter{})
+	}
+	out := make([]byte, len(v)*scatterSize)
+	p := out
+	for _, s := range v {
+		p = s.put(p)
+	}
+	return out
+}
+
+func TestParseScatter(t *testing.T) {
+	two := []Scatter{{Base: 0, Count: 16}, {Base: 32, Count: 8, TargetOffset: 0x40000, Spare: 7}}
+	for _, tt := range []struct {
+		name string
+		data []byte
+		off  uint32
+		want []Scatter
+		err  bool
+	}{
+		{name: "empty", data: encodeScatter(nil, false)},
+		{name: "two", data: encodeScatter(two, false), want: two},
+		{name: "offset", data: append([]byte("header"), encodeScatter(two, false)...), off: 6, want: two},
+		{name: "stops at zero count", data: encodeScatter(append([]Scatter{two[0], {Base: 5}}, two[1]), true), want: two[:1]},
+		{name: "unterminated", data: encodeScatter(two, true), err: true},
+		{name: "truncated", data: encodeScatter(two, false)[:3*scatterSize-1], err: true},
+		{name: "offset beyond data", data: encodeScatter(two, false), off: 1000, err: true},
+		{name: "offset at end", data: encodeScatter(two, false), off: 3 * scatterSize, err: true},
+		{name: "nil", data: nil, err: true},
+	} {
+		v, err := parseScatter(tt.data, tt.off)
+		switch {
+		case tt.err:
+			if err == nil || err.Error() != "unterminated scatter vector" {
+				t.Errorf("%s: parseScatter = %v, %v; want unterminated scatter vector error", tt.name, v, err)
+			}
+		case err != nil:
+			t.Errorf("%s: parseScatter: %v", tt.name, err)
+		case !slices.Equal(v, tt.want):
+			t.Errorf("%s: parseScatter = %v, want %v", tt.name, v, tt.want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"debug/macho"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Load commands that shiftFile needs to know about besides those
+// referring to __LINKEDIT data.
+const (
+	LC_ENCRYPTION_INFO_64 = 0x2c
+	LC_NOTE               = 0x31
+	LC_MAIN               = 0x80000028
+)
+
+// headerShift is the number of bytes shiftFile inserts after the load
+// commands. It is a multiple of the page size of every architecture,
+// so that segments stay page aligned.
+const headerShift = 0x4000
+
+// A file without room after its load commands for LC_CODE_SIGNATURE is
+// rewritten to make some: headerShift bytes are inserted before the
+// first section, and the __TEXT segment, which starts with the header,
+// grows downwards in memory by as much, so that the contents of all
+// segments keep their addresses. Every file offset after the load
+// commands moves, as well as the few things located relative to the
+// start of the image, like the entry point. __PAGEZERO, if any,
+// shrinks to make room in memory.
+//
+// Data that is located relative to the start of the image and cannot
+// be rewritten in place, such as the export trie or chained fixups,
+// makes a file impossible to shift; canShift reports why. Fixups in
+// __TEXT itself, which are unusual, are not adjusted.
+//
+// The __mh_execute_header or __mh_dylib_header symbol moves with the
+// header, but code that refers to the header relative to the program
+// counter, as C code taking the address of the symbol does, would point
+// into the inserted bytes instead. Such references cannot be told
+// apart from others in general, so canShift refuses a file whose code
+// has an instruction that looks like one (see headerRef), rather than
+// risk breaking it.
+
+// canShift reports why the file mf cannot be shifted by shiftFile,
+// if it cannot.
+func canShift(mf *macho.File, opts Options) error {
+	if len(opts.Scatter) > 0 {
+		return errors.New("the file has a scatter vector")
+	}
+	text := mf.Segment("__TEXT")
+	if text == nil || text.Offset != 0 {
+		return errors.New("__TEXT does not start at the beginning of the file")
+	}
+	if text.Addr < headerShift {
+		return fmt.Errorf("__TEXT at %#x cannot grow downwards", text.Addr)
+	}
+	for _, l := range mf.Loads {
+		if seg, ok := l.(*macho.Segment); ok && seg != text && seg.Memsz != 0 &&
+			seg.Addr < text.Addr && seg.Addr+seg.Memsz > text.Addr-headerShift && seg.Addr != 0 {
+			return fmt.Errorf("segment %s is in the way of __TEXT", seg.Name)
+		}
+		data := l.Raw()
+		field := func(i int) uint32 { return get32le(data[i:]) }
+		switch cmd := get32le(data); cmd {
+		case LC_DYLD_CHAINED_FIXUPS:
+			return errors.New("the file has chained fixups (LC_DYLD_CHAINED_FIXUPS)")
+		case LC_DYLD_EXPORTS_TRIE:
+			return errors.New("the file has an export trie (LC_DYLD_EXPORTS_TRIE)")
+		case LC_DYLD_INFO, LC_DYLD_INFO_ONLY:
+			if field(44) != 0 {
+				return errors.New("the file has an export trie (LC_DYLD_INFO)")
+			}
+		case LC_FUNCTION_STARTS, LC_SEGMENT_SPLIT_INFO, LC_LINKER_OPTIMIZATION_HINT:
+			if field(12) != 0 {
+				return fmt.Errorf("the file has image-relative data (load command %#x)", cmd)
+			}
+		case LC_ENCRYPTION_INFO_64:
+			return errors.New("the file is encrypted (LC_ENCRYPTION_INFO_64)")
+		}
+	}
+	if mf.Section("__unwind_info") != nil {
+		return errors.New("the file has a __unwind_info section")
+	}
+	for _, s := range mf.Sections {
+		if s.Seg != "__TEXT" || s.Flags&(S_ATTR_PURE_INSTRUCTIONS|S_ATTR_SOME_INSTRUCTIONS) == 0 {
+			continue
+		}
+		code, err := s.Data()
+		if err != nil {
+			return fmt.Errorf("reading section %s: %v", s.Name, err)
+		}
+		if pc, ok := headerRef(mf.Cpu, code, s.Addr, text.Addr); ok {
+			return fmt.Errorf("the code at %#x refers to the header", pc)
+		}
+	}
+	return nil
+}
+
+// Section attributes of code.
+const (
+	S_ATTR_PURE_INSTRUCTIONS = 0x80000000
+	S_ATTR_SOME_INSTRUCTIONS = 0x400
+)
+
+// headerRef reports the address of the first instruction in code,
+// which is at addr, that seems to compute the address hdr of the header
+// relative to the program counter, if any: on amd64, an instruction
+// with a RIP-relative operand at hdr, such as LEA, whose ModRM byte it
+// reports, as where the instruction starts is not known, and on arm64,
+// an ADR of hdr, or an ADRP of its page followed shortly by an ADD of
+// its offset in the page to the same register. Bytes that are not
+// instructions may look like such, so it errs on the side of reporting.
+func headerRef(cpu macho.Cpu, code []byte, addr, hdr uint64) (uint64, bool) {
+	switch cpu {
+	case macho.CpuAmd64:
+		// The displacement of a RIP-relative operand follows a ModRM
+		// byte with mod 0 and r/m 5, and is relative to the end of the
+		// instruction, which it ends unless the instruction has an
+		// immediate operand too.
+		for i := 1; i+4 <= len(code); i++ {
+			if code[i-1]&0xc7 != 0x05 {
+				continue
+			}
+			next := addr + uint64(i) + 4
+			if next+uint64(int64(int32(get32le(code[i:])))) == hdr {
+				return addr + uint64(i) - 1, true
+			}
+		}
+	case macho.CpuArm64:
+		for i := 0; i+4 <= len(code); i += 4 {
+			pc := addr + uint64(i)
+			insn := get32le(code[i:])
+			// imm is the signed 21-bit immediate of ADR and ADRP.
+			imm := int64(int32((((insn>>5)&0x7ffff)<<2|(insn>>29)&3)<<11) >> 11)
+			switch insn & 0x9f000000 {
+			case 0x10000000: // ADR
+				if pc+uint64(imm) == hdr {
+					return pc, true
+				}
+			case 0x90000000: // ADRP
+				if pc&^0xfff+uint64(imm<<12) != hdr&^0xfff {
+					continue
+				}
+				rd := insn & 0x1f
+				for j := i + 4; j < i+4*8 && j+4 <= len(code); j += 4 {
+					// ADD Xd, Xrd, #hdr&0xfff, 64-bit, unshifted.
+					add := get32le(code[j:])
+					if add&0xffc00000 == 0x91000000 && add>>5&0x1f == rd && uint64(add>>10&0xfff) == hdr&0xfff {
+						return pc, true
+					}
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// shiftFile inserts headerShift bytes at offset start, where the
+// section data of f, which is described by mf and has the given size,
+// begins, and updates the load commands and the data that refer to
+// the moved data or to the start of the image.
+func shiftFile(f ReadWriteSeeker, mf *macho.File, size, start int64) error {
+	if err := moveData(f, start, start+headerShift, size-start); err != nil {
+		return fmt.Errorf("moving data at %#x: %w", start, err)
+	}
+	if _, err := f.WriteAt(make([]byte, headerShift), start); err != nil {
+		return fmt.Errorf("clearing header padding at %#x: %w", start, err)
+	}
+
+	text := mf.Segment("__TEXT")
+	// fix32 and fix64 update the 32-bit or 64-bit file offset at
+	// data[i:], if it is after start.
+	fix32 := func(data []byte, i int) {
+		if off := get32le(data[i:]); int64(off) >= start {
+			put32le(data[i:], off+headerShift)
+		}
+	}
+	fix64 := func(data []byte, i int) {
+		if off := get64le(data[i:]); int64(off) >= start {
+			put64le(data[i:], off+headerShift)
+		}
+	}
+	var cmds []byte
+	var dataInCode []byte // LC_DATA_IN_CODE, in cmds
+	var symtab []byte     // LC_SYMTAB, in cmds
+	for _, l := range mf.Loads {
+		data := append([]byte(nil), l.Raw()...)
+		switch get32le(data) {
+		case uint32(macho.LoadCmdSegment64):
+			seg := l.(*macho.Segment)
+			switch {
+			case seg == text:
+				put64le(data[24:], seg.Addr-headerShift)
+				put64le(data[32:], seg.Memsz+headerShift)
+				put64le(data[48:], seg.Filesz+headerShift)
+			case seg.Addr+seg.Memsz == text.Addr && seg.Filesz == 0: // __PAGEZERO
+				put64le(data[32:], seg.Memsz-headerShift)
+			case seg.Filesz != 0:
+				fix64(data, 40)
+			}
+			for i := 0; i < int(seg.Nsect); i++ {
+				sect := data[72+80*i:]
+				if get32le(sect[48:]) != 0 {
+					fix32(sect, 48) // offset
+				}
+				if get32le(sect[60:]) != 0 {
+					fix32(sect, 56) // reloff
+				}
+			}
+		case LC_SYMTAB:
+			fix32(data, 8)
+			fix32(data, 16)
+			symtab = data
+		case LC_DYSYMTAB:
+			for i := 32; i <= 72; i += 8 {
+				fix32(data, i)
+			}
+		case LC_DYLD_INFO, LC_DYLD_INFO_ONLY:
+			for i := 8; i <= 40; i += 8 {
+				fix32(data, i)
+			}
+		case LC_CODE_SIGNATURE, LC_SEGMENT_SPLIT_INFO, LC_FUNCTION_STARTS, LC_DATA_IN_CODE,
+			LC_DYLIB_CODE_SIGN_DRS, LC_LINKER_OPTIMIZATION_HINT, LC_DYLD_EXPORTS_TRIE, LC_DYLD_CHAINED_FIXUPS:
+			fix32(data, 8)
+			if get32le(data) == LC_DATA_IN_CODE {
+				dataInCode = data
+			}
+		case LC_NOTE:
+			fix64(data, 24)
+		case LC_MAIN:
+			put64le(data[8:], get64le(data[8:])+headerShift) // offset of the entry point in __TEXT
+		}
+		cmds = append(cmds, data...)
+	}
+	if _, err := f.WriteAt(cmds, fileHeaderSize64); err != nil {
+		return fmt.Errorf("rewriting load commands: %w", err)
+	}
+
+	// Data in code entries are offsets from the start of the image.
+	if dataInCode != nil {
+		off, n := int64(get32le(dataInCode[8:])), int(get32le(dataInCode[12:]))
+		entries := make([]byte, n)
+		if _, err := f.ReadAt(entries, off); err != nil {
+			return fmt.Errorf("reading LC_DATA_IN_CODE at %#x: %w", off, err)
+		}
+		for i := 0; i+8 <= n; i += 8 {
+			put32le(entries[i:], get32le(entries[i:])+headerShift)
+		}
+		if _, err := f.WriteAt(entries, off); err != nil {
+			return fmt.Errorf("rewriting LC_DATA_IN_CODE at %#x: %w", off, err)
+		}
+	}
+
+	// The __mh_execute_header or __mh_dylib_header symbol is the
+	// address of the header.
+	if symtab != nil {
+		if err := fixHeaderSymbol(f, mf, symtab, text.Addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixHeaderSymbol moves the symbols of mf at the start of __TEXT,
+// addr, with the header. symtab is the updated LC_SYMTAB command.
+func fixHeaderSymbol(f ReadWriteSeeker, mf *macho.File, symtab []byte, addr uint64) error {
+	if mf.Symtab == nil {
+		return nil
+	}
+	symoff := int64(get32le(symtab[8:]))
+	for i, s := range mf.Symtab.Syms {
+		if s.Value != addr || s.Type&0x0e != 0x0e { // N_SECT
+			continue
+		}
+		var v [8]byte
+		put64le(v[:], addr-headerShift)
+		off := symoff + int64(i)*16 + 8
+		if _, err := f.WriteAt(v[:], off); err != nil {
+			return fmt.Errorf("rewriting symbol %s at %#x: %w", s.Name, off, err)
+		}
+	}
+	return nil
+}
+
+// moveData copies n bytes at offset from in f to offset to, which is
+// after from, starting from the end so that the data is not
+// overwritten before it is copied.
+func moveData(f ReadWriteSeeker, from, to, n int64) error {
+	buf := make([]byte, 1<<20)
+	for n > 0 {
+		chunk := min(n, int64(len(buf)))
+		n -= chunk
+		if _, err := f.ReadAt(buf[:chunk], from+n); err != nil && err != io.EOF {
+			return err
+		}
+		if _, err := f.WriteAt(buf[:chunk], to+n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// arm64 returns the little-endian encoding of the instructions insns.
+func arm64(insns ...uint32) []byte {
+	var b []byte
+	for _, insn := range insns {
+		b = binary.LittleEndian.AppendUint32(b, insn)
+	}
+	return b
+}
+
+// lea returns an amd64 LEA RAX, [RIP+disp].
+func lea(disp int64) []byte {
+	return binary.LittleEndian.AppendUint32([]byte{0x48, 0x8d, 0x05}, uint32(disp))
+}
+
+// adr returns an ADR (op 0) or ADRP (op 1) of Xrd with the immediate imm.
+func adr(op, rd uint32, imm int32) uint32 {
+	u := uint32(imm) & 0x1fffff
+	return op<<31 | (u&3)<<29 | 0x10000000 | (u>>2)<<5 | rd
+}
+
+// addImm returns an ADD Xrd, Xrn, #imm.
+func addImm(rd, rn, imm uint32) uint32 {
+	return 0x91000000 | imm<<10 | rn<<5 | rd
+}
+
+func TestHeaderRef(t *testing.T) {
+	const (
+		hdr  = 0x100000000
+		code = hdr + 0x4000 // address of the code
+	)
+	leaHdr := lea(hdr - (code + 7)) // at code
+	for _, tt := range []struct {
+		name string
+		cpu  macho.Cpu
+		code []byte
+		pc   uint64 // of the reference (the ModRM byte on amd64), or 0
+	}{
+		{"amd64/lea", macho.CpuAmd64, leaHdr, code + 2},
+		{"amd64/lea-later", macho.CpuAmd64, append([]byte{0x90, 0x90}, lea(hdr-(code+9))...), code + 4},
+		{"amd64/lea-other", macho.CpuAmd64, lea(0), 0},
+		{"amd64/not-rip-relative", macho.CpuAmd64, append([]byte{0x48, 0x8d, 0x04}, leaHdr[3:]...), 0},
+		{"amd64/truncated", macho.CpuAmd64, leaHdr[:6], 0},
+		{"arm64/adr", macho.CpuArm64, arm64(0xd503201f, adr(0, 3, hdr-(code+4))), code + 4},
+		{"arm64/adr-other", macho.CpuArm64, arm64(adr(0, 3, hdr-code+8)), 0},
+		{"arm64/adrp-add", macho.CpuArm64, arm64(adr(1, 0, -4), addImm(0, 0, 0)), code},
+		{"arm64/adrp-add-later", macho.CpuArm64, arm64(adr(1, 5, -4), 0xd503201f, 0xd503201f, addImm(1, 5, 0)), code},
+		{"arm64/adrp-add-offset", macho.CpuArm64, arm64(adr(1, 0, -4), addImm(0, 0, 0x10)), 0},
+		{"arm64/adrp-add-other-reg", macho.CpuArm64, arm64(adr(1, 0, -4), addImm(0, 1, 0)), 0},
+		{"arm64/adrp-other-page", macho.CpuArm64, arm64(adr(1, 0, -3), addImm(0, 0, 0)), 0},
+		{"arm64/adrp-only", macho.CpuArm64, arm64(adr(1, 0, -4)), 0},
+		{"arm64/add-too-late", macho.CpuArm64, append(arm64(adr(1, 0, -4)), append(bytes.Repeat(arm64(0xd503201f), 8), arm64(addImm(0, 0, 0))...)...), 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pc, ok := headerRef(tt.cpu, tt.code, code, hdr)
+			if ok != (tt.pc != 0) || pc != tt.pc {
+				t.Errorf("headerRef = %#x, %v; want %#x, %v", pc, ok, tt.pc, tt.pc != 0)
+			}
+		})
+	}
+}
+
+// TestShiftHeaderSymbol checks that shifting a file moves its header
+// symbol with the header, and that a file whose code refers to the
+// header is not shifted.
+func TestShiftHeaderSymbol(t *testing.T) {
+	const (
+		textOff = fileHeaderSize64 + fixtureLoadSize // of the no-room fixture
+		symVal  = fixtureText + 8                    // offset of the value of its symbol
+	)
+	in := fixtureFile(fixture{typ: macho.TypeExec})
+	put64le(in[symVal:], fixtureVMAddr)
+
+	b := NewBuffer(bytes.Clone(in))
+	if err := Sign(b, Options{Identifier: "shift"}); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	checkSigned(t, b.Bytes(), in)
+	mf, err := macho.NewFile(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mf.Symtab.Syms[0].Value, uint64(fixtureVMAddr-headerShift); got != want {
+		t.Errorf("header symbol = %#x, want %#x", got, want)
+	}
+	if got, want := mf.Segment("__TEXT").Addr, uint64(fixtureVMAddr-headerShift); got != want {
+		t.Errorf("__TEXT at %#x, want %#x", got, want)
+	}
+
+	// LEA RAX, [RIP+__mh_execute_header] at the start of __text.
+	copy(in[textOff:], lea(-(textOff + 7)))
+	b = NewBuffer(bytes.Clone(in))
+	err = Sign(b, Options{Identifier: "shift"})
+	if want := "refers to the header"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("Sign = %v, want error containing %q", err, want)
+	}
+	if !bytes.Equal(b.Bytes(), in) {
+		t.Errorf("Sign changed the file it refused to sign")
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ErrNotSigned is returned by ReadSignature if the file has no
+// LC_CODE_SIGNATURE load command.
+var ErrNotSigned = errors.New("no LC_CODE_SIGNATURE")
+
+// HexBytes is a byte slice that is printed and marshaled in hex.
+type HexBytes []byte
+
+func (b HexBytes) String() string { return hex.EncodeToString(b) }
+
+func (b HexBytes) MarshalText() ([]byte, error) { return []byte(b.String()), nil }
+
+// Signature is a decoded embedded code signature.
+type Signature struct {
+	Offset        int64              `json:"offset"` // file offset, from LC_CODE_SIGNATURE
+	Size          int64              `json:"size"`   // size, from LC_CODE_SIGNATURE
+	Arch          string             `json:"arch"`   // architecture of the file, e.g. "arm64e"
+	Magic         uint32             `json:"magic"`
+	Length        uint32             `json:"length"` // length of the SuperBlob
+	Blobs         []BlobInfo         `json:"blobs"`
+	CodeDirectory *CodeDirectoryInfo `json:"code_directory,omitempty"`
+}
+
+// BlobInfo describes a blob contained in the SuperBlob.
+type BlobInfo struct {
+	Slot   uint32 `json:"slot"`
+	Offset uint32 `json:"offset"` // offset from the start of the SuperBlob
+	Magic  uint32 `json:"magic"`
+	Type   string `json:"type"` // BlobName(Magic)
+	Length uint32 `json:"length"`
+}
+
+// CodeDirectoryInfo holds the decoded fields of a CodeDirectory blob.
+// Fields that do not exist in the blob's version are zero.
+type CodeDirectoryInfo struct {
+	Version       uint32    `json:"version"`
+	Flags         uint32    `json:"flags"`
+	Identifier    string    `json:"identifier"`
+	TeamID        string    `json:"team_id,omitempty"`
+	HashType      uint8     `json:"hash_type"`
+	HashSize      uint8     `json:"hash_size"`
+	PageSize      int       `json:"page_size"` // in bytes; 0 means infinite
+	NSpecialSlots uint32    `json:"n_special_slots"`
+	NCodeSlots    uint32    `json:"n_code_slots"`
+	CodeLimit     uint64    `json:"code_limit"`
+	ExecSegBase   uint64    `json:"exec_seg_base"`
+	ExecSegLimit  uint64    `json:"exec_seg_limit"`
+	ExecSegFlags  uint64    `json:"exec_seg_flags"`
+	Scatter       []Scatter `json:"scatter,omitempty"`
+	Runtime       uint32    `json:"runtime,omitempty"`
+	CDHash        HexBytes  `json:"cdhash"`
+
+	// Offsets within the CodeDirectory blob.
+	HashOffset    uint32 `json:"hash_offset"`
+	IdentOffset   uint32 `json:"ident_offset"`
+	ScatterOffset uint32 `json:"scatter_offset,omitempty"`
+	TeamOffset    uint32 `json:"team_offset,omitempty"`
+
+	PreEncryptOffset uint32 `json:"pre_encrypt_offset,omitempty"`
+	LinkageOffset    uint32 `json:"linkage_offset,omitempty"`
+	LinkageSize      uint32 `json:"linkage_size,omitempty"`
+
+	// SpecialSlots[i] is the hash of special slot i+1, or zero
+	// if the slot is unused. CodeSlots[i] is the hash of code
+	// page i, which starts at file offset i*PageSize.
+	SpecialSlots []HexBytes `json:"special_slots,omitempty"`
+	CodeSlots    []HexBytes `json:"code_slots"`
+}
+
+// ReadSignature decodes the embedded code signature of the Mach-O file r.
+func ReadSignature(r io.ReaderAt) (*Signature, error) {
+	mf, err := newFile(r)
+	if err != nil {
+		return nil, err
+	}
+	sig := &Signature{Arch: ArchName(mf.Cpu, mf.SubCpu)}
+	for _, l := range mf.Loads {
+		data := l.Raw()
+		if mf.ByteOrder.Uint32(data) == LC_CODE_SIGNATURE {
+			sig.Offset = int64(mf.ByteOrder.Uint32(data[8:]))
+			sig.Size = int64(mf.ByteOrder.Uint32(data[12:]))
+		}
+	}
+	if sig.Size == 0 {
+		return nil, ErrNotSigned
+	}
+	data := make([]byte, sig.Size)
+	if _, err := r.ReadAt(data, sig.Offset); err != nil {
+		return nil, fmt.Errorf("reading signature at offset %#x: %w", sig.Offset, err)
+	}
+	if err := sig.decode(data); err != nil {
+		return nil, fmt.Errorf("signature at offset %#x: %v", sig.Offset, err)
+	}
+	return sig, nil
+}
+
+// ReadCodeDirectory returns the CodeDirectory of the embedded code
+// signature of the Mach-O file r.
+func ReadCodeDirectory(r io.ReaderAt) (*CodeDirectory, error) {
+	sig, err := ReadSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range sig.Blobs {
+		if b.Slot != CSSLOT_CODEDIRECTORY || b.Magic != CSMAGIC_CODEDIRECTORY {
+			continue
+		}
+		data := make([]byte, b.Length)
+		if _, err := r.ReadAt(data, sig.Offset+int64(b.Offset)); err != nil {
+			return nil, err
+		}
+		return ParseCodeDirectory(data)
+	}
+	return nil, errors.New("no CodeDirectory")
+}
+
+func (sig *Signature) decode(data []byte) error {
+	if len(data) < 12 {
+		return errors.New("SuperBlob too short")
+	}
+	sig.Magic = get32be(data)
+	sig.Length = get32be(data[4:])
+	count := get32be(data[8:])
+	if sig.Magic != CSMAGIC_EMBEDDED_SIGNATURE {
+		return fmt.Errorf("bad SuperBlob magic %#x", sig.Magic)
+	}
+	if int64(sig.Length) > int64(len(data)) {
+		return fmt.Errorf("SuperBlob length %d exceeds signature size %d", sig.Length, len(data))
+	}
+	data = data[:sig.Length]
+	if uint64(count)*8+12 > uint64(len(data)) {
+		return fmt.Errorf("SuperBlob index with %d entries too large", count)
+	}
+	for i := 0; i < int(count); i++ {
+		e := data[12+8*i:]
+		b := BlobInfo{Slot: get32be(e), Offset: get32be(e[4:])}
+		if uint64(b.Offset)+8 > uint64(len(data)) {
+			return fmt.Errorf("blob %d offset %#x out of range", i, b.Offset)
+		}
+		b.Magic = get32be(data[b.Offset:])
+		b.Type = BlobName(b.Magic)
+		b.Length = get32be(data[b.Offset+4:])
+		if uint64(b.Offset)+uint64(b.Length) > uint64(len(data)) {
+			return fmt.Errorf("blob %d at offset %#x with length %d out of range", i, b.Offset, b.Length)
+		}
+		sig.Blobs = append(sig.Blobs, b)
+		if b.Slot == CSSLOT_CODEDIRECTORY && b.Magic == CSMAGIC_CODEDIRECTORY {
+			cd, err := decodeCodeDirectory(data[b.Offset : b.Offset+b.Length])
+			if err != nil {
+				return err
+			}
+			sig.CodeDirectory = cd
+		}
+	}
+	return nil
+}
+
+func decodeCodeDirectory(data []byte) (*CodeDirectoryInfo, error) {
+	c, err := ParseCodeDirectory(data)
+	if err != nil {
+		return nil, err
+	}
+	cd := &CodeDirectoryInfo{
+		Version:       c.Version,
+		Flags:         c.Flags,
+		Identifier:    c.Identifier,
+		TeamID:        c.TeamID,
+		HashType:      c.HashType,
+		HashSize:      c.HashSize,
+		NSpecialSlots: c.NSpecialSlots,
+		NCodeSlots:    c.NCodeSlots,
+		CodeLimit:     c.Limit(),
+		ExecSegBase:   c.ExecSegBase,
+		ExecSegLimit:  c.ExecSegLimit,
+		ExecSegFlags:  c.ExecSegFlags,
+		Scatter:       c.Scatter,
+		Runtime:       c.Runtime,
+		HashOffset:    c.HashOffset,
+		IdentOffset:   c.IdentOffset,
+		ScatterOffset: c.ScatterOffset,
+		TeamOffset:    c.TeamOffset,
+
+		PreEncryptOffset: c.PreEncryptOffset,
+		LinkageOffset:    c.LinkageOffset,
+		LinkageSize:      c.LinkageSize,
+	}
+	for _, h := range c.SpecialSlots {
+		cd.SpecialSlots = append(cd.SpecialSlots, h)
+	}
+	for _, h := range c.CodeSlots {
+		cd.CodeSlots = append(cd.CodeSlots, h)
+	}
+	if c.PageSize != 0 {
+		cd.PageSize = 1 << c.PageSize
+	}
+	h := newHash(c.HashType)
+	if h == nil {
+		return nil, fmt.Errorf("unknown hash type %d", c.HashType)
+	}
+	h.Write(data[:c.Length])
+	cd.CDHash = h.Sum(nil)[:20] // cdhash is truncated to 20 bytes
+	return cd, nil
+}
+
+// cstring returns the NUL-terminated string at offset off in data.
+func cstring(data []byte, off uint32) (string, error) {
+	if uint64(off) >= uint64(len(data)) {
+		return "", fmt.Errorf("offset %#x out of range", off)
+	}
+	s, _, ok := strings.Cut(string(data[off:]), "\000")
+	if !ok {
+		return "", errors.New("missing NUL terminator")
+	}
+	return s, nil
+}
+
+// newHash returns a new hash for the given kSecCodeSignatureHash* type,
+// or nil if the type is unknown.
+func newHash(hashType uint8) hash.Hash {
+	switch hashType {
+	case kSecCodeSignatureHashSHA1:
+		return sha1.New()
+	case kSecCodeSignatureHashSHA256, kSecCodeSignatureHashSHA256Truncated:
+		return sha256.New()
+	case kSecCodeSignatureHashSHA384:
+		return sha512.New384()
+	case kSecCodeSignatureHashSHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+// HashTypeName returns a human readable name of a
+// kSecCodeSignatureHash* hash type.
+func HashTypeName(hashType uint8) string {
+	switch hashType {
+	case kSecCodeSignatureNoHash:
+		return "none"
+	case kSecCodeSignatureHashSHA1:
+		return "sha1"
+	case kSecCodeSignatureHashSHA256:
+		return "sha256"
+	case kSecCodeSignatureHashSHA256Truncated:
+		return "sha256-truncated"
+	case kSecCodeSignatureHashSHA384:
+		return "sha384"
+	case kSecCodeSignatureHashSHA512:
+		return "sha512"
+	}
+	return fmt.Sprintf("unknown(%d)", hashType)
+}
+
+var flagNames = []struct {
+	flag uint32
+	name string
+}{
+	{CS_VALID, "valid"},
+	{CS_ADHOC, "adhoc"},
+	{CS_GET_TASK_ALLOW, "get-task-allow"},
+	{CS_INSTALLER, "installer"},
+	{CS_HARD, "hard"},
+	{CS_KILL, "kill"},
+	{CS_CHECK_EXPIRATION, "check-expiration"},
+	{CS_RESTRICT, "restrict"},
+	{CS_ENFORCEMENT, "enforcement"},
+	{CS_REQUIRE_LV, "library-validation"},
+	{CS_RUNTIME, "runtime"},
+	{CS_LINKER_SIGNED, "linker-signed"},
+}
+
+// FlagsString returns a human readable form of CodeDirectory flags,
+// similar to what codesign -d prints, e.g. "0x20002(adhoc,linker-signed)".
+func FlagsString(flags uint32) string {
+	var names []string
+	rest := flags
+	for _, f := range flagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			rest &^= f.flag
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", rest))
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("%#x(none)", flags)
+	}
+	return fmt.Sprintf("%#x(%s)", flags, strings.Join(names, ","))
+}
+
+// BlobName returns a human readable name of a blob magic number.
+func BlobName(magic uint32) string {
+	switch magic {
+	case CSMAGIC_REQUIREMENT:
+		return "requirement"
+	case CSMAGIC_REQUIREMENTS:
+		return "requirements"
+	case CSMAGIC_CODEDIRECTORY:
+		return "code directory"
+	case CSMAGIC_EMBEDDED_SIGNATURE:
+		return "embedded signature"
+	case CSMAGIC_DETACHED_SIGNATURE:
+		return "detached signature"
+	case CSMAGIC_BLOBWRAPPER:
+		return "blob wrapper"
+	case CSMAGIC_EMBEDDED_ENTITLEMENTS:
+		return "entitlements"
+	case CSMAGIC_EMBEDDED_DER_ENTITLEMENTS:
+		return "DER entitlements"
+	}
+	return fmt.Sprintf("unknown(%#x)", magic)
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/macho"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const LC_UUID = 0x1b
+
+// ErrNoUUID is returned if a file has no LC_UUID load command.
+var ErrNoUUID = errors.New("no LC_UUID")
+
+// A UUID is the value of an LC_UUID load command.
+type UUID [16]byte
+
+// String returns u in the form dwarfdump and dyld print,
+// e.g. "6F8E6C3A-1B2C-3D4E-8F90-A1B2C3D4E5F6".
+func (u UUID) String() string {
+	s := strings.ToUpper(hex.EncodeToString(u[:]))
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// ParseUUID parses a UUID in the form returned by UUID.String.
+// Case and dashes are ignored.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != len(u) {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+// uuidOffset returns the file offset of the UUID in the LC_UUID load
+// command of mf, or -1 if there is none.
+func uuidOffset(mf *macho.File) int64 {
+	loadOff := int64(fileHeaderSize64)
+	for _, l := range mf.Loads {
+		data := l.Raw()
+		if get32le(data) == LC_UUID {
+			return loadOff + 8
+		}
+		loadOff += int64(get32le(data[4:]))
+	}
+	return -1
+}
+
+// ReadUUID returns the LC_UUID of the Mach-O file r.
+func ReadUUID(r io.ReaderAt) (UUID, error) {
+	var u UUID
+	mf, err := newFile(r)
+	if err != nil {
+		return u, err
+	}
+	off := uuidOffset(mf)
+	if off < 0 {
+		return u, ErrNoUUID
+	}
+	_, err = r.ReadAt(u[:], off)
+	return u, err
+}
+
+// SetUUID sets the LC_UUID of the Mach-O file f to u and returns the
+// old value. As the UUID is covered by the code signature, f must be
+// signed afterwards.
+func SetUUID(f ReadWriteSeeker, u UUID) (old UUID, err error) {
+	mf, err := newFile(f)
+	if err != nil {
+		return old, err
+	}
+	off := uuidOffset(mf)
+	if off < 0 {
+		return old, ErrNoUUID
+	}
+	if _, err := f.ReadAt(old[:], off); err != nil {
+		return old, err
+	}
+	_, err = f.WriteAt(u[:], off)
+	return old, err
+}
+
+// ComputeUUID returns a UUID derived from the contents of the 64-bit
+// Mach-O file r. It hashes the load commands and the file data from
+// the first section on, leaving out the UUID itself and everything
+// that signing changes (the header, LC_CODE_SIGNATURE, the __LINKEDIT
+// segment command, and the padding and signature at the end of
+// __LINKEDIT), so the result is the same before and after signing.
+// A string table that runs into the signature, which signing trims
+// (see fixLinkedit), is hashed as if trimmed already.
+func ComputeUUID(r io.ReaderAt) (UUID, error) {
+	var u UUID
+	mf, err := newFile(r)
+	if err != nil {
+		return u, err
+	}
+	if mf.Magic != macho.Magic64 {
+		return u, fmt.Errorf("%w: not 64-bit", ErrNotMachO)
+	}
+	sigOff := uint64(math.MaxUint64)
+	for _, l := range mf.Loads {
+		if data := l.Raw(); get32le(data) == LC_CODE_SIGNATURE {
+			sigOff = uint64(get32le(data[8:]))
+		}
+	}
+	h := sha256.New()
+	var end int64
+	for _, l := range mf.Loads {
+		data := l.Raw()
+		switch get32le(data) {
+		case LC_UUID, LC_CODE_SIGNATURE:
+			continue
+		case LC_SYMTAB:
+			stroff, strsize := uint64(get32le(data[16:])), uint64(get32le(data[20:]))
+			if stroff < sigOff && stroff+strsize > sigOff {
+				data = bytes.Clone(data)
+				put32le(data[20:], uint32(sigOff-stroff))
+			}
+		}
+		if seg, ok := l.(*macho.Segment); ok {
+			if seg.Name == "__LINKEDIT" {
+				continue
+			}
+			end = max(end, int64(seg.Offset+seg.Filesz))
+		}
+		h.Write(data)
+	}
+	// The data in __LINKEDIT ends where the last range referred to by
+	// a load command ends; what follows is padding and the signature.
+	for _, r := range linkeditRanges(mf) {
+		e := r.off + r.size
+		if r.isStrtab && r.off < sigOff {
+			e = min(e, sigOff)
+		}
+		end = max(end, int64(e))
+	}
+	// Start at the first section, skipping the header padding that
+	// LC_CODE_SIGNATURE is added to.
+	start := end
+	for _, s := range mf.Sections {
+		if s.Offset != 0 {
+			start = min(start, int64(s.Offset))
+		}
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(r, start, end-start)); err != nil {
+		return u, err
+	}
+	copy(u[:], h.Sum(nil))
+	// Mark it as a name-based (version 5) RFC 4122 UUID.
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return u, nil
+}
+
+// regenerateUUID sets the LC_UUID of f to its ComputeUUID.
+func regenerateUUID(f ReadWriteSeeker) error {
+	u, err := ComputeUUID(f)
+	if err != nil {
+		return fmt.Errorf("computing LC_UUID: %w", err)
+	}
+	_, err = SetUUID(f, u)
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"debug/macho"
+	"errors"
+	"testing"
+)
+
+// fixtureStrsize is the offset of the strsize field of LC_SYMTAB in the
+// fixture files.
+const fixtureStrsize = fixtureLinkeditSeg + 72 + 24 + 20
+
+func TestComputeUUIDSignTwice(t *testing.T) {
+	data := fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true})
+	strsize := get32le(data[fixtureStrsize:])
+	want, err := ComputeUUID(NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBuffer(bytes.Clone(data))
+	for i := 1; i <= 2; i++ {
+		if err := Sign(b, Options{Identifier: "uuid"}); err != nil {
+			t.Fatalf("signing %d times: %v", i, err)
+		}
+		if got := get32le(b.Bytes()[fixtureStrsize:]); got != strsize {
+			t.Fatalf("signing %d times: strsize = %#x, want %#x", i, got, strsize)
+		}
+		u, err := ComputeUUID(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != want {
+			t.Errorf("signing %d times: ComputeUUID = %v, want %v as before signing", i, u, want)
+		}
+		if i == 1 {
+			// Let the string table run into the signature, as padding
+			// left over from an earlier signature does, for signing
+			// again to trim.
+			put32le(b.Bytes()[fixtureStrsize:], strsize+0x40)
+			if u, err := ComputeUUID(b); err != nil || u != want {
+				t.Errorf("with the string table in the signature: ComputeUUID = %v, %v, want %v", u, err, want)
+			}
+		}
+	}
+}
+
+func TestRegenerateUUID(t *testing.T) {
+	data := fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true})
+	want, err := ComputeUUID(NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBuffer(bytes.Clone(data))
+	for i := 1; i <= 2; i++ {
+		if err := Sign(b, Options{RegenerateUUID: true}); err != nil {
+			t.Fatalf("signing %d times: %v", i, err)
+		}
+		checkSigned(t, b.Bytes(), data)
+		if u, err := ReadUUID(b); err != nil || u != want {
+			t.Errorf("signing %d times: LC_UUID = %v, %v, want %v, the ComputeUUID of the unsigned file", i, u, err, want)
+		}
+	}
+}
+
+// TestRegenerateUUIDNoUUID checks that Sign with RegenerateUUID leaves
+// a file without LC_UUID as it was.
+func TestRegenerateUUIDNoUUID(t *testing.T) {
+	for _, signed := range []bool{false, true} {
+		data := fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true})
+		put32le(data[fixtureLinkeditSeg+72:], 0x2a) // LC_UUID -> LC_SOURCE_VERSION
+		if signed {
+			b := NewBuffer(data)
+			if err := Sign(b, Options{Identifier: "fixture"}); err != nil {
+				t.Fatalf("signing fixture: %v", err)
+			}
+			data = b.Bytes()
+		}
+		b := NewBuffer(bytes.Clone(data))
+		if err := Sign(b, Options{RegenerateUUID: true}); !errors.Is(err, ErrNoUUID) {
+			t.Errorf("signed=%v: Sign error = %v, want ErrNoUUID", signed, err)
+		}
+		if !bytes.Equal(b.Bytes(), data) {
+			t.Errorf("signed=%v: Sign changed the file it failed to sign", signed)
+		}
+	}
+}
+
+// TestSignUUID checks that Sign sets Options.UUID, and only if it
+// signs the file.
+func TestSignUUID(t *testing.T) {
+	data := fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true})
+	u := UUID{0xde, 0xad, 0xbe, 0xef, 15: 1}
+	b := NewBuffer(bytes.Clone(data))
+	if err := Sign(b, Options{UUID: &u}); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	checkSigned(t, b.Bytes(), data)
+	if got, err := ReadUUID(b); err != nil || got != u {
+		t.Errorf("LC_UUID = %v, %v, want %v", got, err, u)
+	}
+
+	cms := addCMS(t, b.Bytes())
+	u2 := UUID{0xca, 0xfe, 15: 2}
+	b = NewBuffer(bytes.Clone(cms))
+	if err := Sign(b, Options{UUID: &u2}); !errors.Is(err, ErrAlreadySigned) {
+		t.Errorf("Sign over a CMS signature = %v, want ErrAlreadySigned", err)
+	}
+	if !bytes.Equal(b.Bytes(), cms) {
+		t.Errorf("Sign changed the file it refused to sign")
+	}
+	if err := Sign(b, Options{UUID: &u2, Replace: true}); err != nil {
+		t.Fatalf("Sign with Replace: %v", err)
+	}
+	if got, err := ReadUUID(b); err != nil || got != u2 {
+		t.Errorf("with Replace: LC_UUID = %v, %v, want %v", got, err, u2)
+	}
+
+	if err := Sign(NewBuffer(bytes.Clone(data)), Options{UUID: &u, RegenerateUUID: true}); err == nil {
+		t.Errorf("Sign with both UUID and RegenerateUUID succeeded")
+	}
+}
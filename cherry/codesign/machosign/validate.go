@@ -0,0 +1,153 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"debug/macho"
+	"fmt"
+	"strings"
+)
+
+// A ValidationError lists the problems found in a Mach-O file that
+// Sign refuses to modify. It wraps ErrNotMachO.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid Mach-O file:\n\t" + strings.Join(e.Problems, "\n\t")
+}
+
+func (e *ValidationError) Unwrap() error { return ErrNotMachO }
+
+// Section types whose sections have no file data.
+const (
+	S_ZEROFILL              = 0x1
+	S_GB_ZEROFILL           = 0xc
+	S_THREAD_LOCAL_ZEROFILL = 0x12
+)
+
+func hasFileData(s *macho.Section) bool {
+	switch s.Flags & 0xff {
+	case S_ZEROFILL, S_GB_ZEROFILL, S_THREAD_LOCAL_ZEROFILL:
+		return false
+	}
+	return s.Size != 0
+}
+
+// dataStart returns the file offset of the first section data, which
+// the load commands must end before, or the file size if there is no
+// section data.
+func dataStart(mf *macho.File, size int64) int64 {
+	start := size
+	for _, s := range mf.Sections {
+		if hasFileData(s) && s.Offset != 0 {
+			start = min(start, int64(s.Offset))
+		}
+	}
+	return start
+}
+
+// validate checks the load commands of mf, a file of the given size,
+// li being what scanLoads found, before Sign rewrites anything: the
+// load commands must fit in the header, before the section data; the
+// segments must be within the file, sorted and not overlap, with
+// __LINKEDIT last; the sections must be within their segments; and an
+// existing code signature must be within __LINKEDIT. It reports all
+// the problems it finds in a *ValidationError.
+func validate(mf *macho.File, size int64, li *loadInfo) error {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Load commands.
+	cmdEnd := int64(fileHeaderSize64) + int64(mf.Cmdsz)
+	if cmdEnd > size {
+		report("load commands end at %#x, beyond the end of the file at %#x", cmdEnd, size)
+	}
+	if start := dataStart(mf, size); cmdEnd > start {
+		report("load commands end at %#x, after the first section data at %#x", cmdEnd, start)
+	}
+	total := int64(0)
+	for i, l := range mf.Loads {
+		data := l.Raw()
+		if sz := get32le(data[4:]); sz%8 != 0 {
+			report("load command %d (%#x) at %#x: size %d is not a multiple of 8", i, get32le(data), fileHeaderSize64+total, sz)
+		}
+		total += int64(len(data))
+	}
+	if total != int64(mf.Cmdsz) {
+		report("load commands take %d bytes, header says %d", total, mf.Cmdsz)
+	}
+
+	// Segments, in load command order.
+	var segs []*macho.Segment
+	for _, l := range mf.Loads {
+		if seg, ok := l.(*macho.Segment); ok {
+			segs = append(segs, seg)
+		}
+	}
+	var prev *macho.Segment // previous segment with file data
+	for i, seg := range segs {
+		// Segments that are not mapped, like the Go linker's
+		// __DWARF, have file data but no VM size.
+		if seg.Memsz != 0 && seg.Filesz > seg.Memsz {
+			report("segment %s: file size %#x exceeds VM size %#x", seg.Name, seg.Filesz, seg.Memsz)
+		}
+		if i > 0 && seg.Addr < segs[i-1].Addr {
+			report("segment %s at address %#x precedes segment %s at %#x", seg.Name, seg.Addr, segs[i-1].Name, segs[i-1].Addr)
+		}
+		if seg.Filesz == 0 {
+			continue
+		}
+		if end := seg.Offset + seg.Filesz; end > uint64(size) {
+			report("segment %s: file range [%#x, %#x) beyond the end of the file at %#x", seg.Name, seg.Offset, end, size)
+		}
+		if prev != nil && seg.Offset < prev.Offset+prev.Filesz {
+			report("segment %s at file offset %#x overlaps or precedes segment %s [%#x, %#x)",
+				seg.Name, seg.Offset, prev.Name, prev.Offset, prev.Offset+prev.Filesz)
+		}
+		prev = seg
+	}
+	if prev != nil && li.linkeditSeg != prev {
+		report("segment %s follows __LINKEDIT in the file; the signature must be at the end", prev.Name)
+	}
+
+	// Sections.
+	segByName := make(map[string]*macho.Segment)
+	for _, seg := range segs {
+		segByName[seg.Name] = seg
+	}
+	for _, s := range mf.Sections {
+		if !hasFileData(s) {
+			continue
+		}
+		seg := segByName[s.Seg]
+		end := uint64(s.Offset) + s.Size
+		if seg == nil {
+			report("section %s,%s: no segment %s", s.Seg, s.Name, s.Seg)
+		} else if uint64(s.Offset) < seg.Offset || end > seg.Offset+seg.Filesz {
+			report("section %s,%s: file range [%#x, %#x) outside its segment [%#x, %#x)",
+				s.Seg, s.Name, s.Offset, end, seg.Offset, seg.Offset+seg.Filesz)
+		}
+	}
+
+	// Existing signature.
+	if li.sigSz != 0 {
+		end := int64(li.sigOff) + int64(li.sigSz)
+		switch {
+		case end > size:
+			report("code signature [%#x, %#x) beyond the end of the file at %#x", li.sigOff, end, size)
+		case uint64(li.sigOff) < li.linkeditSeg.Offset:
+			report("code signature at %#x precedes __LINKEDIT at %#x", li.sigOff, li.linkeditSeg.Offset)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{problems}
+	}
+	return nil
+}
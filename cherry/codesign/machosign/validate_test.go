@@ -0,0 +1,62 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package machosign
+
+import (
+	"bytes"
+	"debug/macho"
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	// Offsets of fields in the fixture's load commands.
+	const (
+		textSeg     = fileHeaderSize64
+		textSect    = textSeg + 72
+		linkeditSeg = textSect + 80
+	)
+	tests := []struct {
+		name    string
+		corrupt func(data []byte)
+		nprob   int
+	}{
+		{"section outside segment", func(data []byte) {
+			put32le(data[textSect+48:], fixtureText+0x100) // offset
+		}, 1},
+		{"segment beyond file", func(data []byte) {
+			put64le(data[linkeditSeg+48:], 0x1000) // filesize
+		}, 1},
+		{"segments overlap", func(data []byte) {
+			put64le(data[linkeditSeg+40:], fixtureText-0x10)     // fileoff
+			put64le(data[linkeditSeg+24:], fixtureVMAddr-0x1000) // vmaddr
+		}, 2},
+		{"commands overlap sections", func(data []byte) {
+			put32le(data[textSect+48:], 0x100) // offset
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := fixtureFile(fixture{typ: macho.TypeExec, headerSpace: true})
+			tt.corrupt(data)
+			orig := bytes.Clone(data)
+			b := NewBuffer(data)
+			err := Sign(b, Options{})
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Sign error = %v, want a ValidationError", err)
+			}
+			if !errors.Is(err, ErrNotMachO) {
+				t.Errorf("Sign error does not wrap ErrNotMachO")
+			}
+			if len(verr.Problems) != tt.nprob {
+				t.Errorf("got %d problems, want %d:\n%v", len(verr.Problems), tt.nprob, err)
+			}
+			if !bytes.Equal(b.Bytes(), orig) {
+				t.Errorf("Sign modified the invalid file")
+			}
+		})
+	}
+}
@@ -0,0 +1,646 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This programs does ad-hoc code signing fo Mach-O files.
+// It tries to do what darwin linker does.
+// The signing itself is implemented in package machosign.
+//
+// The signed binary is written to the file named by -o, leaving the
+// input untouched. Use -inplace to sign the input file itself.
+// The -uuid flag sets LC_UUID, either to the given value or, with
+// -uuid=auto, to a hash of the binary's contents, so that tools keying
+// caches on the UUID see the change. The hash leaves out what signing
+// changes, so signing the same binary again gives the same UUID (see
+// machosign.ComputeUUID).
+//
+// If the binary is "-", it is read from standard input and the signed
+// binary is written to standard output, for use in pipelines.
+//
+// If the input is an .app bundle directory, its main executable is
+// signed in place, with the hashes of the bundle's Info.plist and
+// _CodeSignature/CodeResources in the special slots of the
+// CodeDirectory. CodeResources is generated if it does not exist.
+// A .framework bundle is signed the same way, after signing the other
+// Mach-O files it contains, such as helper dylibs.
+//
+// If the input is a static archive (.a), the Mach-O members that can
+// be signed (not object files) are signed and the archive rewritten.
+//
+// Several binaries (or bundles) may be given, with -inplace; they are
+// signed concurrently and the outcome is reported for each. With -r,
+// directories are searched for Mach-O files to sign.
+//
+// A binary signed with a signing identity, e.g. by Apple's codesign,
+// is only re-signed with -f, which replaces the whole signature,
+// including the CMS signature and requirements, with an ad-hoc one.
+// When re-signing, the entitlements of the existing signature are kept
+// unless -entitlements replaces them or -strip-entitlements is given.
+//
+// With the -cdhash flag, it prints the CodeDirectory hash (cdhash) of
+// the signed binary, which notarization and stapling key off.
+//
+// With the -compare flag, it compares the signatures of two binaries
+// field by field, e.g. to debug differences from the Go linker's or
+// Apple's codesign output. It exits with status 1 if they differ.
+//
+// With the -d flag, it instead displays the existing signature,
+// similar to codesign -d -vvv, in text or (with -json) JSON form.
+// The JSON form describes the whole structure: the SuperBlob, the
+// type, offset and length of each blob, and the CodeDirectory fields
+// and hashes, including the hash of each code page.
+//
+// With the -n flag, it prints where the signature would be placed and
+// how the file would change, without writing anything, so that build
+// systems can reserve space. It exits with status 1 if there is not
+// enough header space to add LC_CODE_SIGNATURE and the file cannot be
+// rewritten to make room. Signing rewrites such files, if possible,
+// inserting a page after the load commands and moving the start of
+// __TEXT down in memory, so that the contents keep their addresses.
+//
+// Errors are reported with an exit status that tells apart the
+// common failures: 2 for usage errors, 3 if the input is not a
+// supported Mach-O file, 4 if it is already signed by a signing
+// identity (or, with -d or -compare, is not signed), 5 for I/O
+// errors, and 1 otherwise.
+
+package main
+
+import (
+	"debug/macho"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"strconv"
+
+	"golang.org/x/scratch/cherry/codesign/machosign"
+)
+
+var (
+	display      = flag.Bool("d", false, "display the existing signature instead of signing")
+	dryRun       = flag.Bool("n", false, "print the layout of the signature instead of signing")
+	compare      = flag.String("compare", "", "compare the signature with that of `binary` instead of signing")
+	jsonOut      = flag.Bool("json", false, "with -d, -n or -cdhash, print JSON")
+	cdhash       = flag.Bool("cdhash", false, "print the cdhash of the signed binary")
+	output       = flag.String("o", "", "write the signed binary to `file` instead of modifying the input")
+	inplace      = flag.Bool("inplace", false, "sign the input binary in place")
+	recursive    = flag.Bool("r", false, "sign the Mach-O files in the given directories recursively")
+	ident        = flag.String("i", "", "signing `identifier` (default \"a.out\")")
+	setUUID      = flag.String("uuid", "", "set LC_UUID to `uuid`, or to a hash of the contents if \"auto\"")
+	jobs         = flag.Int("j", runtime.GOMAXPROCS(0), "hash code pages with `n` goroutines")
+	scatter      = flag.String("scatter", "", "emit a scatter vector signing only the listed code pages, as `base:count[@target],...`")
+	entFile      = flag.String("entitlements", "", "embed the entitlements property list in `file`, in XML and DER form")
+	stripEnt     = flag.Bool("strip-entitlements", false, "drop the entitlements of an existing signature instead of keeping them")
+	runtimeFlag  = flag.Bool("runtime", false, "set the hardened runtime flag (CS_RUNTIME)")
+	libValFlag   = flag.Bool("library-validation", false, "set the library validation flag (CS_REQUIRE_LV)")
+	noLinkerFlag = flag.Bool("no-linker-signed", false, "do not set the linker-signed flag (CS_LINKER_SIGNED)")
+	debuggable   = flag.Bool("debuggable", false, "allow unsigned pages in a main executable (CS_EXECSEG_ALLOW_UNSIGNED), for debugging")
+	replace      = flag.Bool("f", false, "replace an existing signature, even one from a signing identity")
+	pgsize       = flag.Int("pagesize", 0, "code page `size` to hash, in bytes (default 16384 for arm64, 4096 otherwise)")
+)
+
+// scatterVector is the parsed -scatter flag.
+var scatterVector []machosign.Scatter
+
+// entitlements is the contents of the -entitlements file.
+var entitlements []byte
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: codesign [-f] [-i identifier] [-uuid uuid|auto] [-cdhash [-json]] (-o out | -inplace) <binary>")
+	fmt.Fprintln(os.Stderr, "       codesign [-f] [-i identifier] [-uuid uuid|auto] [-cdhash [-json]] -inplace <bundle.app|bundle.framework|archive.a>")
+	fmt.Fprintln(os.Stderr, "       codesign [-f] [-i identifier] [-uuid auto] [-cdhash [-json]] -inplace [-r] <binary or dir>...")
+	fmt.Fprintln(os.Stderr, "       codesign [-f] [-i identifier] [-uuid uuid|auto] [-cdhash [-json]] - < in > out")
+	fmt.Fprintln(os.Stderr, "       codesign -d [-json] <binary>")
+	fmt.Fprintln(os.Stderr, "       codesign -n [-json] [signing flags] <binary>")
+	fmt.Fprintln(os.Stderr, "       codesign -compare other <binary>")
+	flag.PrintDefaults()
+	os.Exit(exitUsage)
+}
+
+// Exit codes.
+const (
+	exitDiffer    = 1 // other failures, or with -compare, signatures differ
+	exitUsage     = 2
+	exitNotMachO  = 3 // input is not a supported Mach-O file
+	exitSignature = 4 // input is already signed, or with -d etc., not signed
+	exitIO        = 5 // I/O failure
+)
+
+// fatal reports err and exits with the exit code for err.
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "codesign: %v\n", err)
+	os.Exit(exitCode(err))
+}
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	var perr *fs.PathError
+	switch {
+	case errors.Is(err, machosign.ErrNotMachO):
+		return exitNotMachO
+	case errors.Is(err, machosign.ErrAlreadySigned), errors.Is(err, machosign.ErrNotSigned):
+		return exitSignature
+	case errors.As(err, &perr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrShortWrite):
+		return exitIO
+	}
+	return exitDiffer
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+	if *scatter != "" {
+		var err error
+		scatterVector, err = machosign.ParseScatterFlag(*scatter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "codesign: -scatter: %v\n", err)
+			usage()
+		}
+	}
+	if *entFile != "" {
+		var err error
+		entitlements, err = os.ReadFile(*entFile)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	fname := flag.Arg(0)
+	batch := flag.NArg() > 1 || *recursive
+	if batch && (*display || *compare != "" || *dryRun || fname == "-") {
+		fmt.Fprintln(os.Stderr, "codesign: -d, -compare, -n and - take a single binary")
+		usage()
+	}
+	if *display {
+		f, err := os.Open(fname)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		sig, err := machosign.ReadSignature(f)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", fname, err))
+		}
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			err = enc.Encode(sig)
+		} else {
+			err = printSignature(os.Stdout, fname, sig)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *compare != "" {
+		same, err := compareSignatures(os.Stdout, fname, *compare)
+		if err != nil {
+			fatal(err)
+		}
+		if !same {
+			os.Exit(exitDiffer)
+		}
+		return
+	}
+
+	if *dryRun {
+		f, err := os.Open(fname)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		st, err := f.Stat()
+		if err != nil {
+			fatal(err)
+		}
+		l, err := machosign.Plan(f, st.Size(), options(f))
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", fname, err))
+		}
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			err = enc.Encode(l)
+		} else {
+			err = printLayout(os.Stdout, fname, l)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if !l.HeaderSpaceOK && l.Shift == 0 {
+			os.Exit(exitDiffer)
+		}
+		return
+	}
+
+	if fname == "-" {
+		if *output != "" || *inplace {
+			fmt.Fprintln(os.Stderr, "codesign: -o and -inplace cannot be used with -")
+			usage()
+		}
+		if err := signStream(os.Stdout, os.Stdin); err != nil {
+			fatal(fmt.Errorf("standard input: %w", err))
+		}
+		return
+	}
+
+	switch {
+	case *output != "" && *inplace:
+		fmt.Fprintln(os.Stderr, "codesign: -o and -inplace are mutually exclusive")
+		usage()
+	case *output == "" && !*inplace:
+		fmt.Fprintln(os.Stderr, "codesign: one of -o or -inplace is required")
+		usage()
+	}
+
+	if batch {
+		if *output != "" {
+			fmt.Fprintln(os.Stderr, "codesign: multiple binaries can only be signed -inplace")
+			usage()
+		}
+		if *setUUID != "" && *setUUID != "auto" {
+			fmt.Fprintln(os.Stderr, "codesign: multiple binaries cannot share a -uuid")
+			usage()
+		}
+		failed, err := signBatch(os.Stdout, flag.Args())
+		if err != nil {
+			fatal(err)
+		}
+		if failed != nil {
+			os.Exit(exitCode(failed))
+		}
+		return
+	}
+
+	name, err := signFile(fname)
+	if err != nil {
+		fatal(err)
+	}
+	if *cdhash {
+		if err := printCDHash(os.Stdout, name); err != nil {
+			fatal(fmt.Errorf("%s: %w", name, err))
+		}
+	}
+}
+
+// signFile signs the binary or bundle fname, or with -o, a copy of it,
+// and returns the name of the signed binary.
+func signFile(fname string) (string, error) {
+	var b *bundle
+	if st, err := os.Stat(fname); err == nil && st.IsDir() {
+		if *output != "" {
+			return "", fmt.Errorf("%s: bundles can only be signed -inplace", fname)
+		}
+		b, err = openBundle(fname)
+		if err != nil {
+			return "", err
+		}
+		for _, n := range b.nested {
+			if _, err := signFile(n); err != nil {
+				return "", err
+			}
+		}
+		if err := b.readCodeResources(); err != nil {
+			return "", err
+		}
+		fname = b.exe
+	}
+
+	if *output != "" {
+		if err := copyFile(*output, fname); err != nil {
+			return "", err
+		}
+		fname = *output
+	}
+
+	f, err := os.OpenFile(fname, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if isArchive(f) {
+		if *cdhash {
+			err = errors.New("-cdhash is not supported for archives")
+		} else {
+			err = signArchive(f, fname)
+		}
+		if err != nil {
+			if *output != "" {
+				f.Close()
+				os.Remove(*output)
+			}
+			return "", fmt.Errorf("%s: %w", fname, err)
+		}
+		return fname, nil
+	}
+
+	opts := options(f)
+	if b != nil {
+		opts.InfoPlist = b.infoPlist
+		opts.CodeResources = b.codeResources
+	}
+	if err := signUUID(fname, f, opts); err != nil {
+		if *output != "" {
+			f.Close()
+			os.Remove(*output)
+		}
+		return "", fmt.Errorf("%s: %w", fname, err)
+	}
+	return fname, nil
+}
+
+// signStream signs the binary read from r and writes the result to w.
+// The whole binary is buffered in memory, as the signature size and
+// load commands at the start of the file depend on its total size.
+// With -cdhash, the cdhash is printed to standard error.
+func signStream(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	buf := machosign.NewBuffer(data)
+	if err := signUUID("-", buf, options(buf)); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if *cdhash {
+		h, err := readCDHash(buf)
+		if err != nil {
+			return err
+		}
+		return writeCDHash(os.Stderr, "-", h)
+	}
+	return nil
+}
+
+// signUUID signs f with opts, setting its LC_UUID as the -uuid flag
+// says, if set: to the UUID given, or with -uuid=auto, to the one Sign
+// computes. Sign sets it only once it has checked that it can sign f.
+// It reports the old and new values on standard error.
+func signUUID(fname string, f machosign.ReadWriteSeeker, opts machosign.Options) error {
+	if *setUUID == "" {
+		return machosign.Sign(f, opts)
+	}
+	old, err := machosign.ReadUUID(f)
+	if err != nil {
+		return err
+	}
+	if *setUUID != "auto" {
+		u, err := machosign.ParseUUID(*setUUID)
+		if err != nil {
+			return err
+		}
+		opts.UUID = &u
+	}
+	if err := machosign.Sign(f, opts); err != nil {
+		return err
+	}
+	u, err := machosign.ReadUUID(f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: LC_UUID %v -> %v\n", fname, old, u)
+	return nil
+}
+
+// options returns the signing options for the binary r.
+func options(r io.ReaderAt) machosign.Options {
+	opts := machosign.Options{Identifier: *ident, PageSize: *pgsize, Jobs: *jobs, Scatter: scatterVector, Entitlements: entitlements, StripEntitlements: *stripEnt, Replace: *replace, Debuggable: *debuggable}
+	opts.RegenerateUUID = *setUUID == "auto"
+	opts.Flags = machosign.CS_ADHOC | machosign.CS_LINKER_SIGNED
+	if *runtimeFlag {
+		opts.Flags |= machosign.CS_RUNTIME
+	}
+	if *libValFlag {
+		opts.Flags |= machosign.CS_REQUIRE_LV
+	}
+	if *noLinkerFlag {
+		opts.Flags &^= machosign.CS_LINKER_SIGNED
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = defaultPageSize(r)
+	}
+	return opts
+}
+
+// printCDHash prints the cdhash of the signed binary fname to w.
+func printCDHash(w io.Writer, fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := readCDHash(f)
+	if err != nil {
+		return err
+	}
+	return writeCDHash(w, fname, h)
+}
+
+// readCDHash returns the cdhash of the signed binary r.
+func readCDHash(r io.ReaderAt) (machosign.HexBytes, error) {
+	sig, err := machosign.ReadSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	if sig.CodeDirectory == nil {
+		return nil, errors.New("no CodeDirectory")
+	}
+	return sig.CodeDirectory.CDHash, nil
+}
+
+// writeCDHash writes the cdhash h of fname to w, in text or JSON form.
+func writeCDHash(w io.Writer, fname string, h machosign.HexBytes) error {
+	if *jsonOut {
+		return json.NewEncoder(w).Encode(struct {
+			File   string             `json:"file"`
+			CDHash machosign.HexBytes `json:"cdhash"`
+		}{fname, h})
+	}
+	_, err := fmt.Fprintln(w, h)
+	return err
+}
+
+// defaultPageSize returns the code page size for the binary r:
+// 16K for arm64, which uses 16K pages, and 4K otherwise.
+func defaultPageSize(r io.ReaderAt) int {
+	mf, err := macho.NewFile(r)
+	if err == nil && mf.Cpu == macho.CpuArm64 {
+		return 16 << 10
+	}
+	return 4 << 10
+}
+
+// copyFile copies the file src to dst, preserving its permission bits.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// compareSignatures prints the differences between the signatures of
+// the binaries a and b, blob by blob and CodeDirectory field by field,
+// and reports whether there are none.
+func compareSignatures(w io.Writer, a, b string) (bool, error) {
+	read := func(name string) (*machosign.Signature, *machosign.CodeDirectory, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		sig, err := machosign.ReadSignature(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		cd, err := machosign.ReadCodeDirectory(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return sig, cd, nil
+	}
+	sigA, cdA, err := read(a)
+	if err != nil {
+		return false, err
+	}
+	sigB, cdB, err := read(b)
+	if err != nil {
+		return false, err
+	}
+
+	same := true
+	p := func(format string, args ...any) {
+		if same {
+			fmt.Fprintf(w, "--- %s\n+++ %s\n", a, b)
+			same = false
+		}
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+	blobs := func(sig *machosign.Signature) map[uint32]machosign.BlobInfo {
+		m := make(map[uint32]machosign.BlobInfo)
+		for _, b := range sig.Blobs {
+			m[b.Slot] = b
+		}
+		return m
+	}
+	blobsA, blobsB := blobs(sigA), blobs(sigB)
+	for _, ba := range sigA.Blobs {
+		bb, ok := blobsB[ba.Slot]
+		switch {
+		case !ok:
+			p("slot=%#x: %s only in %s", ba.Slot, machosign.BlobName(ba.Magic), a)
+		case ba.Magic != bb.Magic:
+			p("slot=%#x: %s != %s", ba.Slot, machosign.BlobName(ba.Magic), machosign.BlobName(bb.Magic))
+		case ba.Length != bb.Length:
+			p("slot=%#x: %s length %d != %d", ba.Slot, machosign.BlobName(ba.Magic), ba.Length, bb.Length)
+		}
+	}
+	for _, bb := range sigB.Blobs {
+		if _, ok := blobsA[bb.Slot]; !ok {
+			p("slot=%#x: %s only in %s", bb.Slot, machosign.BlobName(bb.Magic), b)
+		}
+	}
+	for _, d := range machosign.Diff(cdA, cdB) {
+		switch d.Field {
+		case "Flags":
+			p("Flags: %s != %s", flagsString(d.A), flagsString(d.B))
+		default:
+			p("%v", d)
+		}
+	}
+	return same, nil
+}
+
+// flagsString formats a FieldDiff value of the Flags field.
+func flagsString(s string) string {
+	x, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return s
+	}
+	return machosign.FlagsString(uint32(x))
+}
+
+// printLayout prints the signature layout l of fname.
+func printLayout(w io.Writer, fname string, l *machosign.Layout) error {
+	p := func(format string, args ...any) {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+	p("Executable=%s", fname)
+	p("File size=%d -> %d", l.FileSize, l.NewFileSize)
+	p("Signature offset=%#x size=%d (was %d)", l.SigOffset, l.SigSize, l.OldSigSize)
+	action := "rewrite"
+	if l.AddCmd {
+		action = "add"
+	}
+	p("LC_CODE_SIGNATURE %s at %#x", action, l.CmdOffset)
+	space := "ok"
+	switch {
+	case !l.HeaderSpaceOK && l.Shift != 0:
+		space = fmt.Sprintf("insufficient, inserting %#x bytes", l.Shift)
+	case !l.HeaderSpaceOK:
+		space = "insufficient"
+	}
+	p("Header space=%d %s", l.HeaderSpace, space)
+	p("__LINKEDIT filesize=%#x -> %#x vmsize=%#x -> %#x", l.OldLinkeditFilesz, l.LinkeditFilesz, l.OldLinkeditVMSize, l.LinkeditVMSize)
+	return nil
+}
+
+// printSignature prints sig in a form similar to codesign -d -vvv.
+func printSignature(w io.Writer, fname string, sig *machosign.Signature) error {
+	p := func(format string, args ...any) {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+	p("Executable=%s", fname)
+	p("Format=Mach-O thin (%s)", sig.Arch)
+	p("Signature offset=%#x size=%d", sig.Offset, sig.Size)
+	p("SuperBlob magic=%#x length=%d count=%d", sig.Magic, sig.Length, len(sig.Blobs))
+	for _, b := range sig.Blobs {
+		p("  slot=%#x offset=%#x length=%d %s", b.Slot, b.Offset, b.Length, machosign.BlobName(b.Magic))
+	}
+	cd := sig.CodeDirectory
+	if cd == nil {
+		p("no CodeDirectory")
+		return nil
+	}
+	p("Identifier=%s", cd.Identifier)
+	if cd.TeamID != "" {
+		p("TeamIdentifier=%s", cd.TeamID)
+	}
+	p("CodeDirectory v=%x flags=%s hashes=%d+%d", cd.Version, machosign.FlagsString(cd.Flags), cd.NCodeSlots, cd.NSpecialSlots)
+	p("Hash type=%s size=%d", machosign.HashTypeName(cd.HashType), cd.HashSize)
+	p("Page size=%d", cd.PageSize)
+	p("Code limit=%#x", cd.CodeLimit)
+	for _, s := range cd.Scatter {
+		p("Scatter %v", s)
+	}
+	p("CDHash=%s", cd.CDHash)
+	p("Executable Segment base=%#x limit=%#x flags=%#x", cd.ExecSegBase, cd.ExecSegLimit, cd.ExecSegFlags)
+	return nil
+}
@@ -0,0 +1,486 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/macho"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/scratch/cherry/codesign/machosign"
+)
+
+// The command line tests run the test binary itself as the codesign
+// command, with $CODESIGN_TEST_MAIN set.
+
+func TestMain(m *testing.M) {
+	if os.Getenv("CODESIGN_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCodesign runs the codesign command with args, and returns its
+// standard output and error and its exit status.
+func runCodesign(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CODESIGN_TEST_MAIN=1")
+	var out, errb strings.Builder
+	cmd.Stdout, cmd.Stderr = &out, &errb
+	err := cmd.Run()
+	var ee *exec.ExitError
+	switch {
+	case errors.As(err, &ee):
+		code = ee.ExitCode()
+	case err != nil:
+		t.Fatalf("codesign %s: %v", strings.Join(args, " "), err)
+	}
+	if testing.Verbose() {
+		t.Logf("codesign %s: exit %d\n%s%s", strings.Join(args, " "), code, &out, &errb)
+	}
+	return out.String(), errb.String(), code
+}
+
+var hello struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// helloBinary returns a hello-world program built for darwin/amd64,
+// unsigned, as the Go linker only signs arm64 binaries.
+func helloBinary(t *testing.T) []byte {
+	t.Helper()
+	hello.once.Do(func() {
+		dir, err := os.MkdirTemp("", "codesign-test")
+		if err != nil {
+			hello.err = err
+			return
+		}
+		defer os.RemoveAll(dir)
+		src := filepath.Join(dir, "hello.go")
+		if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0o666); err != nil {
+			hello.err = err
+			return
+		}
+		exe := filepath.Join(dir, "hello")
+		cmd := exec.Command("go", "build", "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH=amd64", "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			hello.err = fmt.Errorf("%v\n%s", err, out)
+			return
+		}
+		hello.data, hello.err = os.ReadFile(exe)
+	})
+	if hello.err != nil {
+		t.Fatalf("building hello for darwin: %v", hello.err)
+	}
+	return hello.data
+}
+
+// writeFiles writes files, a map from slash-separated names relative
+// to dir to contents, creating the directories needed.
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, data, 0o777); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// infoPlist returns an Info.plist naming exe as the bundle executable.
+func infoPlist(exe string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleExecutable</key>
+	<string>` + exe + `</string>
+</dict>
+</plist>
+`)
+}
+
+// TestSignBundleNestedTwice checks that signing a framework again, here
+// with another identifier, which changes the signature of its nested
+// code, updates the hash of the latter in CodeResources.
+func TestSignBundleNestedTwice(t *testing.T) {
+	exe := helloBinary(t)
+	dir := filepath.Join(t.TempDir(), "Hello.framework")
+	writeFiles(t, dir, map[string][]byte{
+		"Info.plist":      infoPlist("Hello"),
+		"Hello":           exe,
+		"libHelper.dylib": exe,
+	})
+	defer func(old string) { *ident = old }(*ident)
+
+	hash2 := regexp.MustCompile(`<key>libHelper.dylib</key>\s*<dict>\s*<key>hash2</key>\s*<data>\s*(\S+)\s*</data>`)
+	var prev []byte
+	for _, id := range []string{"", "com.example.hello"} {
+		*ident = id
+		if _, err := signFile(dir); err != nil {
+			t.Fatalf("-i %q: %v", id, err)
+		}
+		helper, err := os.ReadFile(filepath.Join(dir, "libHelper.dylib"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(helper) == string(prev) {
+			t.Fatalf("-i %q: signing did not change libHelper.dylib", id)
+		}
+		prev = helper
+		cr, err := os.ReadFile(filepath.Join(dir, "_CodeSignature", "CodeResources"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := hash2.FindSubmatch(cr)
+		if m == nil {
+			t.Fatalf("-i %q: no hash2 for libHelper.dylib in CodeResources:\n%s", id, cr)
+		}
+		h := sha256.Sum256(helper)
+		if want := base64.StdEncoding.EncodeToString(h[:]); string(m[1]) != want {
+			t.Errorf("-i %q: CodeResources hash2 of libHelper.dylib = %s, want %s", id, m[1], want)
+		}
+	}
+}
+
+// signedHello returns helloBinary, ad-hoc signed.
+func signedHello(t *testing.T) []byte {
+	t.Helper()
+	b := machosign.NewBuffer(bytes.Clone(helloBinary(t)))
+	if err := machosign.Sign(b, machosign.Options{Identifier: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// addCMS returns the signed Mach-O file data with a CMS signature
+// blob added to its signature, as codesign adds with a signing
+// identity, growing the signature and __LINKEDIT to hold it.
+func addCMS(t *testing.T, data []byte) []byte {
+	t.Helper()
+	sig, err := machosign.ReadSignature(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// File offsets of LC_CODE_SIGNATURE and the __LINKEDIT segment command.
+	var sigCmd, linkeditCmd int
+	var linkedit *macho.Segment
+	off := 32
+	for _, l := range mf.Loads {
+		raw := l.Raw()
+		if binary.LittleEndian.Uint32(raw) == machosign.LC_CODE_SIGNATURE {
+			sigCmd = off
+		}
+		if seg, ok := l.(*macho.Segment); ok && seg.Name == "__LINKEDIT" {
+			linkeditCmd, linkedit = off, seg
+		}
+		off += len(raw)
+	}
+
+	be := binary.BigEndian
+	n := len(sig.Blobs)
+	blobs := data[sig.Offset+12+8*int64(n) : sig.Offset+int64(sig.Length)]
+	cms := []byte("not really a CMS signature")
+	hdrSize := 12 + 8*(n+1)
+	sb := be.AppendUint32(nil, machosign.CSMAGIC_EMBEDDED_SIGNATURE)
+	sb = be.AppendUint32(sb, uint32(hdrSize+len(blobs)+8+len(cms)))
+	sb = be.AppendUint32(sb, uint32(n+1))
+	for _, b := range sig.Blobs {
+		sb = be.AppendUint32(sb, b.Slot)
+		sb = be.AppendUint32(sb, b.Offset+8)
+	}
+	sb = be.AppendUint32(sb, machosign.CSSLOT_SIGNATURESLOT)
+	sb = be.AppendUint32(sb, uint32(hdrSize+len(blobs)))
+	sb = append(sb, blobs...)
+	sb = be.AppendUint32(sb, machosign.CSMAGIC_BLOBWRAPPER)
+	sb = be.AppendUint32(sb, uint32(8+len(cms)))
+	sb = append(sb, cms...)
+
+	out := append(bytes.Clone(data[:sig.Offset]), sb...)
+	le := binary.LittleEndian
+	le.PutUint32(out[sigCmd+12:], uint32(len(sb)))
+	filesz := uint64(len(out)) - linkedit.Offset
+	le.PutUint64(out[linkeditCmd+48:], filesz)
+	le.PutUint64(out[linkeditCmd+32:], max(linkedit.Memsz, (filesz+0x3fff)&^0x3fff))
+	return out
+}
+
+// TestUUIDAlreadySigned checks that -uuid leaves a binary that cannot
+// be signed, here as it is signed by a signing identity, as it was.
+func TestUUIDAlreadySigned(t *testing.T) {
+	cms := addCMS(t, signedHello(t))
+	exe := filepath.Join(t.TempDir(), "hello")
+	writeFiles(t, filepath.Dir(exe), map[string][]byte{"hello": cms})
+	const u = "01234567-89AB-CDEF-0123-456789ABCDEF"
+
+	_, stderr, code := runCodesign(t, "-inplace", "-uuid", u, exe)
+	if code != exitSignature || !strings.Contains(stderr, "already signed") {
+		t.Errorf("codesign -uuid over a CMS signature: exit %d, %q; want exit %d, already signed", code, stderr, exitSignature)
+	}
+	if data, err := os.ReadFile(exe); err != nil || !bytes.Equal(data, cms) {
+		t.Errorf("codesign -uuid changed the binary it failed to sign (%v)", err)
+	}
+
+	if _, stderr, code := runCodesign(t, "-inplace", "-f", "-uuid", u, exe); code != 0 {
+		t.Fatalf("codesign -f -uuid: exit %d\n%s", code, stderr)
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, err := machosign.ReadUUID(f); err != nil || got.String() != u {
+		t.Errorf("codesign -f -uuid: LC_UUID = %v, %v, want %s", got, err, u)
+	}
+}
+
+// TestExitCodes checks the exit status of the common failures.
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	cms := addCMS(t, signedHello(t))
+	writeFiles(t, dir, map[string][]byte{
+		"hello":  helloBinary(t),
+		"cms":    cms,
+		"script": []byte("#!/bin/sh\necho hello\n"),
+	})
+	file := func(name string) string { return filepath.Join(dir, name) }
+	for _, tt := range []struct {
+		args []string
+		code int
+		err  string // in standard error
+	}{
+		{[]string{}, exitUsage, "usage:"},
+		{[]string{file("hello")}, exitUsage, "one of -o or -inplace is required"},
+		{[]string{"-o", file("out"), "-inplace", file("hello")}, exitUsage, "mutually exclusive"},
+		{[]string{"-d", file("hello"), file("cms")}, exitUsage, "take a single binary"},
+		{[]string{"-scatter", "nonsense", "-inplace", file("hello")}, exitUsage, "-scatter"},
+		{[]string{"-inplace", file("script")}, exitNotMachO, "Mach-O file"},
+		{[]string{"-d", file("script")}, exitNotMachO, "Mach-O file"},
+		{[]string{"-inplace", file("cms")}, exitSignature, "already signed"},
+		{[]string{"-d", file("hello")}, exitSignature, "no LC_CODE_SIGNATURE"},
+		{[]string{"-compare", file("hello"), file("cms")}, exitSignature, "no LC_CODE_SIGNATURE"},
+		{[]string{"-inplace", file("missing")}, exitIO, "no such file"},
+		{[]string{"-d", file("missing")}, exitIO, "no such file"},
+		{[]string{"-entitlements", file("missing"), "-inplace", file("hello")}, exitIO, "no such file"},
+	} {
+		_, stderr, code := runCodesign(t, tt.args...)
+		if code != tt.code || !strings.Contains(stderr, tt.err) {
+			t.Errorf("codesign %s: exit %d, %q; want exit %d, %q", strings.Join(tt.args, " "), code, stderr, tt.code, tt.err)
+		}
+	}
+	if data, err := os.ReadFile(file("cms")); err != nil || !bytes.Equal(data, cms) {
+		t.Errorf("codesign -inplace changed the binary signed by a signing identity (%v)", err)
+	}
+}
+
+// TestReplace checks that -f replaces a signature with a CMS blob by an
+// ad-hoc one.
+func TestReplace(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string][]byte{"cms": addCMS(t, signedHello(t))})
+	in, out := filepath.Join(dir, "cms"), filepath.Join(dir, "out")
+	if _, stderr, code := runCodesign(t, "-f", "-o", out, in); code != 0 {
+		t.Fatalf("codesign -f: exit %d\n%s", code, stderr)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sig, err := machosign.ReadSignature(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range sig.Blobs {
+		if b.Slot == machosign.CSSLOT_SIGNATURESLOT || b.Magic == machosign.CSMAGIC_BLOBWRAPPER {
+			t.Errorf("codesign -f kept the %s in slot %#x", machosign.BlobName(b.Magic), b.Slot)
+		}
+	}
+	if cd := sig.CodeDirectory; cd == nil || cd.Flags&machosign.CS_ADHOC == 0 {
+		t.Errorf("codesign -f: CodeDirectory %+v, want an ad-hoc one", cd)
+	}
+	// The output is signed ad hoc, so signing it again needs no -f.
+	if _, stderr, code := runCodesign(t, "-inplace", out); code != 0 {
+		t.Errorf("codesign over the output of -f: exit %d\n%s", code, stderr)
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "hello")
+	writeFiles(t, filepath.Dir(exe), map[string][]byte{"hello": signedHello(t)})
+
+	stdout, stderr, code := runCodesign(t, "-d", exe)
+	if code != 0 {
+		t.Fatalf("codesign -d: exit %d\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Executable=" + exe + "\n",
+		"Format=Mach-O thin (x86_64)\n",
+		"Identifier=hello\n",
+		"Hash type=sha256 size=32\n",
+		"Page size=4096\n",
+		"CDHash=",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("codesign -d: no %q in\n%s", want, stdout)
+		}
+	}
+
+	stdout, stderr, code = runCodesign(t, "-d", "-json", exe)
+	if code != 0 {
+		t.Fatalf("codesign -d -json: exit %d\n%s", code, stderr)
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sig, err := machosign.ReadSignature(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.MarshalIndent(sig, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != string(want)+"\n" {
+		t.Errorf("codesign -d -json:\n%s\nwant:\n%s", stdout, want)
+	}
+	for _, field := range []string{`"code_directory"`, `"exec_seg_flags"`, `"blobs"`} {
+		if !strings.Contains(stdout, field) {
+			t.Errorf("codesign -d -json: no %s in\n%s", field, stdout)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "hello")
+	in := helloBinary(t)
+	writeFiles(t, filepath.Dir(exe), map[string][]byte{"hello": in})
+
+	stdout, stderr, code := runCodesign(t, "-n", exe)
+	if code != 0 {
+		t.Fatalf("codesign -n: exit %d\n%s", code, stderr)
+	}
+	for _, want := range []string{"Executable=" + exe + "\n", "LC_CODE_SIGNATURE add at ", "Header space="} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("codesign -n: no %q in\n%s", want, stdout)
+		}
+	}
+
+	stdout, stderr, code = runCodesign(t, "-n", "-json", exe)
+	if code != 0 {
+		t.Fatalf("codesign -n -json: exit %d\n%s", code, stderr)
+	}
+	var l machosign.Layout
+	if err := json.Unmarshal([]byte(stdout), &l); err != nil {
+		t.Fatalf("codesign -n -json: %v\n%s", err, stdout)
+	}
+	if data, err := os.ReadFile(exe); err != nil || !bytes.Equal(data, in) {
+		t.Fatalf("codesign -n changed the binary (%v)", err)
+	}
+
+	// The layout is that of the file signing writes.
+	if _, stderr, code := runCodesign(t, "-inplace", exe); code != 0 {
+		t.Fatalf("codesign: exit %d\n%s", code, stderr)
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := machosign.ReadSignature(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.FileSize != int64(len(in)) || l.NewFileSize != st.Size() || l.SigOffset != sig.Offset || l.SigSize != sig.Size || !l.AddCmd {
+		t.Errorf("codesign -n -json = %+v; signed file of %d bytes, signature at %#x of %d bytes", l, st.Size(), sig.Offset, sig.Size)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFiles(t, dir, map[string][]byte{"a": signedHello(t), "b": signedHello(t)})
+
+	if stdout, stderr, code := runCodesign(t, "-compare", b, a); code != 0 || stdout != "" {
+		t.Errorf("codesign -compare of the same signatures: exit %d\n%s%s", code, stdout, stderr)
+	}
+	if _, stderr, code := runCodesign(t, "-inplace", "-i", "other", "-runtime", b); code != 0 {
+		t.Fatalf("codesign: exit %d\n%s", code, stderr)
+	}
+	stdout, stderr, code := runCodesign(t, "-compare", b, a)
+	if code != exitDiffer {
+		t.Errorf("codesign -compare of different signatures: exit %d, want %d\n%s", code, exitDiffer, stderr)
+	}
+	for _, want := range []string{
+		"--- " + a + "\n+++ " + b + "\n",
+		"Identifier: \"hello\" != \"other\"\n",
+		"Flags: 0x20002(adhoc,linker-signed) != 0x30002(adhoc,runtime,linker-signed)\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("codesign -compare: no %q in\n%s", want, stdout)
+		}
+	}
+}
+
+func TestDebuggable(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string][]byte{"hello": helloBinary(t)})
+	for _, debug := range []bool{false, true} {
+		out := filepath.Join(dir, fmt.Sprint("hello-", debug))
+		args := []string{"-o", out, filepath.Join(dir, "hello")}
+		if debug {
+			args = append([]string{"-debuggable"}, args...)
+		}
+		if _, stderr, code := runCodesign(t, args...); code != 0 {
+			t.Fatalf("codesign %s: exit %d\n%s", strings.Join(args, " "), code, stderr)
+		}
+		f, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cd, err := machosign.ReadCodeDirectory(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := uint64(machosign.CS_EXECSEG_MAIN_BINARY)
+		if debug {
+			want |= machosign.CS_EXECSEG_ALLOW_UNSIGNED
+		}
+		if cd.ExecSegFlags != want {
+			t.Errorf("-debuggable=%v: exec segment flags %#x, want %#x", debug, cd.ExecSegFlags, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+
+	"go.chromium.org/luci/resultdb/pbutil"
+	rdbpb "go.chromium.org/luci/resultdb/proto/v1"
+
+	"golang.org/x/scratch/cherry/testtiming/report"
+)
+
+// ArtifactSizes returns the total size and number of artifacts of
+// the invocation, per test ID. Artifacts attached to the invocation
+// itself rather than to a test result are reported under the empty
+// test ID. If test is not empty, only artifacts of that test are
+// reported. Only artifact metadata is fetched, not the contents.
+func (c *LUCIClient) ArtifactSizes(ctx context.Context, invocation, test string) (map[string]*report.ArtifactUsage, error) {
+	if c.TraceSteps {
+		log.Println("QueryArtifacts", invocation)
+	}
+	pred := &rdbpb.ArtifactPredicate{
+		FollowEdges: &rdbpb.ArtifactPredicate_EdgeTypeSet{
+			IncludedInvocations: test == "",
+			TestResults:         true,
+		},
+	}
+	if test != "" {
+		pred.TestResultPredicate = &rdbpb.TestResultPredicate{
+			TestIdRegexp: regexp.QuoteMeta(test),
+		}
+	}
+	sizes := make(map[string]*report.ArtifactUsage)
+	var pageToken string
+nextPage:
+	if !c.Budget.Take() {
+		return sizes, nil
+	}
+	resp, err := c.ResultDBClient.QueryArtifacts(ctx, &rdbpb.QueryArtifactsRequest{
+		Invocations: []string{invocation},
+		Predicate:   pred,
+		PageSize:    1000,
+		PageToken:   pageToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range resp.GetArtifacts() {
+		_, testID, _, _, err := pbutil.ParseArtifactName(a.GetName())
+		if err != nil {
+			return nil, err
+		}
+		u := sizes[testID]
+		if u == nil {
+			u = &report.ArtifactUsage{Test: testID}
+			sizes[testID] = u
+		}
+		u.Count++
+		u.Bytes += a.GetSizeBytes()
+	}
+	if resp.GetNextPageToken() != "" {
+		pageToken = resp.GetNextPageToken()
+		goto nextPage
+	}
+	return sizes, nil
+}
+
+// ArtifactReport returns the storage used by artifacts per builder
+// and test over all builds of dash, largest first.
+func (c *LUCIClient) ArtifactReport(ctx context.Context, dash *Dashboard, test string) ([]report.ArtifactUsage, error) {
+	var usage []report.ArtifactUsage
+	for i, b := range dash.Builders {
+		total := make(map[string]*report.ArtifactUsage)
+		for _, r := range dash.Results[i] {
+			if r == nil {
+				continue
+			}
+			sizes, err := c.ArtifactSizes(ctx, r.InvocationID, test)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", buildURL(r.ID), err)
+			}
+			for id, u := range sizes {
+				t := total[id]
+				if t == nil {
+					t = &report.ArtifactUsage{Builder: b.Name, Test: id}
+					total[id] = t
+				}
+				t.Count += u.Count
+				t.Bytes += u.Bytes
+			}
+		}
+		for _, u := range total {
+			usage = append(usage, *u)
+		}
+	}
+	slices.SortFunc(usage, func(a, b report.ArtifactUsage) int {
+		if c := cmp.Compare(b.Bytes, a.Bytes); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(a.Builder, b.Builder), cmp.Compare(a.Test, b.Test))
+	})
+	return usage, nil
+}
@@ -5,6 +5,7 @@ go 1.22
 require (
 	go.chromium.org/luci v0.0.0-20240716011143-b5eb7a221b66
 	golang.org/x/sync v0.7.0
+	google.golang.org/grpc v1.61.0
 	google.golang.org/protobuf v1.34.2
 )
 
@@ -19,5 +20,4 @@ require (
 	google.golang.org/genproto v0.0.0-20240213162025-012b6fc9bca9 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20240213162025-012b6fc9bca9 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240213162025-012b6fc9bca9 // indirect
-	google.golang.org/grpc v1.61.0 // indirect
 )
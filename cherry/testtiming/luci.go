@@ -10,6 +10,25 @@
 //
 // The "builder" column is omitted if only one builder
 // is queried (the -builder flag).
+//
+// The -builder flag takes a comma-separated list of builders, which
+// are fetched first, in the given order. With the -budget flag, the
+// crawl is limited to a number of RPCs or an amount of time; builders
+// and builds are then prioritized (see PlanCrawl) and the output may
+// be partial.
+//
+// With the -artifacts flag, report the storage used by test artifacts
+// instead, as CSV with the following columns, largest first:
+//
+//	builder, test, artifact count, total bytes
+//
+// The test is empty for artifacts attached to a build's invocation
+// rather than to a test result. The -test flag is optional in this
+// mode and restricts the report to one test.
+//
+// With the -json flag, output a JSON object instead, which
+// includes a schema version (see package report) so consumers can
+// detect format changes.
 package main
 
 import (
@@ -19,6 +38,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
 	"slices"
 	"strings"
@@ -29,6 +49,7 @@ import (
 	gpb "go.chromium.org/luci/common/proto/gitiles"
 	"go.chromium.org/luci/grpc/prpc"
 	rdbpb "go.chromium.org/luci/resultdb/proto/v1"
+	"golang.org/x/scratch/cherry/testtiming/report"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -49,6 +70,13 @@ type LUCIClient struct {
 	// TraceSteps controls whether to log each step name as it's executed.
 	TraceSteps bool
 
+	// Plan, if set, is called by ReadBoard with the list of builders,
+	// and returns them in the order to fetch them, possibly dropping some.
+	Plan func(ctx context.Context, builders []Builder) ([]Builder, error)
+
+	// Budget, if set, limits the RPCs made to fetch builds.
+	Budget *Budget
+
 	nProc int
 }
 
@@ -182,6 +210,8 @@ done:
 
 // ListBuilders fetches the list of builders, on the given repo and goBranch.
 // If repo and goBranch are empty, it fetches all builders.
+// If builder is not empty, it is a comma-separated list of builders
+// to fetch, and the others are skipped.
 func (c *LUCIClient) ListBuilders(ctx context.Context, repo, goBranch, builder string) ([]Builder, error) {
 	if c.TraceSteps {
 		log.Println("ListBuilders", repo, goBranch)
@@ -204,7 +234,7 @@ nextPage:
 		json.Unmarshal([]byte(b.GetConfig().GetProperties()), &p)
 		if all || (p.Repo == repo && p.GoBranch == goBranch) {
 			bName := b.GetId().GetBuilder()
-			if builder != "" && bName != builder { // just want one builder, skip others
+			if builder != "" && !slices.Contains(strings.Split(builder, ","), bName) { // just want some builders, skip others
 				continue
 			}
 			builders = append(builders, Builder{bName, &p})
@@ -236,6 +266,9 @@ func (c *LUCIClient) GetBuilds(ctx context.Context, builder string, since time.T
 	var builds []*bbpb.Build
 	var pageToken string
 nextPage:
+	if !c.Budget.Take() {
+		return builds, nil
+	}
 	resp, err := c.BuildsClient.SearchBuilds(ctx, &bbpb.SearchBuildsRequest{
 		Predicate: pred,
 		Mask:      &bbpb.BuildMask{Fields: mask},
@@ -264,6 +297,12 @@ func (c *LUCIClient) ReadBoard(ctx context.Context, dash *Dashboard, builder str
 	if err != nil {
 		return err
 	}
+	if c.Plan != nil {
+		dash.Builders, err = c.Plan(ctx, dash.Builders)
+		if err != nil {
+			return err
+		}
+	}
 
 	dashMap := make([]map[string]*BuildResult, len(dash.Builders)) // indexed by builder, then keyed by commit hash
 
@@ -411,35 +450,73 @@ func shortHash(s string) string {
 var (
 	repo    = flag.String("repo", "go", "repo name (defualt: \"go\")")
 	branch  = flag.String("branch", "master", "branch (defualt: \"master\")")
-	builder = flag.String("builder", "", "builder to query, if unset, query all builders")
+	builder = flag.String("builder", "", "comma-separated builders to query, in priority order; if unset, query all builders")
 	test    = flag.String("test", "", "test name")
+	jsonOut = flag.Bool("json", false, "output JSON instead of CSV")
+	budget  = flag.String("budget", "", "limit the crawl to a number of RPCs (e.g. 500) or a duration (e.g. 10m)")
+	nProc   = flag.Int("j", 1, "number of builders to fetch concurrently")
+	artifs  = flag.Bool("artifacts", false, "report artifact storage per builder and test instead of test timing")
 )
 
 func main() {
 	flag.Parse()
-	if *test == "" {
+	if *test == "" && !*artifs {
 		flag.Usage()
 		log.Fatal("test name unset")
 	}
 
 	ctx := context.Background()
-	c := NewLUCIClient(1)
+	c := NewLUCIClient(*nProc)
 	c.TraceSteps = true
+	var err error
+	c.Budget, err = ParseBudget(*budget)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// LUCI keeps data up to 60 days, so there is no point to go back farther
 	startTime := time.Now().Add(-60 * 24 * time.Hour)
+	if c.Budget != nil {
+		var priority []string
+		if *builder != "" {
+			priority = strings.Split(*builder, ",")
+		}
+		c.Plan = PlanCrawl(c, priority, startTime)
+	}
 	dash := &Dashboard{Project: Project{*repo, *branch}}
 	c.ReadBoard(ctx, dash, *builder, startTime)
 
+	out := &report.Output{Repo: *repo, Branch: *branch, Test: *test}
+	if *artifs {
+		usage, err := c.ArtifactReport(ctx, dash, *test)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *jsonOut {
+			out.Artifacts = usage
+			if err := report.Write(os.Stdout, out); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		for _, u := range usage {
+			fmt.Printf("%s,%s,%d,%d\n", u.Builder, u.Test, u.Count, u.Bytes)
+		}
+		return
+	}
 	printBuilder := func(string) {}
 	if len(dash.Builders) > 1 {
 		printBuilder = func(s string) { fmt.Print(s, ",") }
 	}
+builderLoop:
 	for i, b := range dash.Builders {
 		for _, r := range dash.Results[i] {
 			if r == nil {
 				continue
 			}
+			if !c.Budget.Take() {
+				break builderLoop
+			}
 			if c.TraceSteps {
 				log.Println("QueryTestResultsRequest", b.Name, shortHash(r.Commit), r.Time)
 			}
@@ -460,6 +537,16 @@ func main() {
 					continue
 				}
 				dur := rr.GetDuration().AsDuration()
+				if *jsonOut {
+					out.Results = append(out.Results, report.TestTiming{
+						Commit:   r.Commit,
+						Time:     r.Time,
+						Builder:  b.Name,
+						Status:   status.String(),
+						Duration: dur.Seconds(),
+					})
+					continue
+				}
 				fmt.Print(shortHash(r.Commit), ",", r.Time, ",")
 				printBuilder(b.Name)
 				fmt.Print(status, ",")
@@ -474,4 +561,9 @@ func main() {
 			}
 		}
 	}
+	if *jsonOut {
+		if err := report.Write(os.Stdout, out); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,245 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	bbpb "go.chromium.org/luci/buildbucket/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Budget limits the work done by a crawl, either by number of RPCs
+// or by wall time. A nil *Budget is unlimited.
+type Budget struct {
+	mu       sync.Mutex
+	rpcs     int       // remaining RPCs, if limited by RPCs
+	deadline time.Time // if limited by time
+	warned   bool
+}
+
+// ParseBudget parses a -budget flag value, which is either a number
+// of RPCs (e.g. "500") or a duration (e.g. "10m"). The empty string
+// means no budget, and ParseBudget returns nil.
+func ParseBudget(s string) (*Budget, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return nil, fmt.Errorf("RPC budget is %d, want 1 or higher", n)
+		}
+		return &Budget{rpcs: n}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("budget %q is neither an RPC count nor a duration", s)
+	}
+	return &Budget{deadline: time.Now().Add(d)}, nil
+}
+
+// Take consumes one RPC from the budget. It reports false if the
+// budget is exhausted, in which case the RPC should not be made.
+func (b *Budget) Take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ok := true
+	switch {
+	case !b.deadline.IsZero():
+		ok = time.Now().Before(b.deadline)
+	case b.rpcs > 0:
+		b.rpcs--
+	default:
+		ok = false
+	}
+	if !ok && !b.warned {
+		log.Println("budget exhausted, results are partial")
+		b.warned = true
+	}
+	return ok
+}
+
+// RPCs returns the number of remaining RPCs, or -1 if the budget is
+// not limited by RPCs.
+func (b *Budget) RPCs() int {
+	if b == nil {
+		return -1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.deadline.IsZero() {
+		return -1
+	}
+	return b.rpcs
+}
+
+// countPage is the number of builds CountBuilds fetches, at most.
+const countPage = 100
+
+// CountBuilds estimates the number of builds of builder since the given
+// time, with a single RPC, which it takes from the budget. It fetches
+// the creation times of up to countPage builds, the newest, and if
+// there are more, extrapolates from the time the oldest of those was
+// created. It reports false, without making the RPC, if the budget is
+// exhausted.
+func (c *LUCIClient) CountBuilds(ctx context.Context, builder string, since time.Time) (n int, ok bool, err error) {
+	if c.TraceSteps {
+		log.Println("CountBuilds", builder)
+	}
+	if !c.Budget.Take() {
+		return 0, false, nil
+	}
+	pred := &bbpb.BuildPredicate{
+		Builder:    &bbpb.BuilderID{Project: "golang", Bucket: "ci", Builder: builder},
+		CreateTime: &bbpb.TimeRange{StartTime: timestamppb.New(since)},
+	}
+	mask, err := fieldmaskpb.New((*bbpb.Build)(nil), "create_time")
+	if err != nil {
+		return 0, false, err
+	}
+	now := time.Now()
+	resp, err := c.BuildsClient.SearchBuilds(ctx, &bbpb.SearchBuildsRequest{
+		Predicate: pred,
+		Mask:      &bbpb.BuildMask{Fields: mask},
+		PageSize:  countPage,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	builds := resp.GetBuilds()
+	if resp.GetNextPageToken() == "" || len(builds) == 0 {
+		return len(builds), true, nil
+	}
+	// Builds are returned newest first.
+	oldest := builds[len(builds)-1].GetCreateTime().AsTime()
+	return extrapolate(len(builds), oldest, since, now), true, nil
+}
+
+// extrapolate returns the number of builds since the given time, if
+// there were n from oldest until now, at the same rate.
+func extrapolate(n int, oldest, since, now time.Time) int {
+	span := now.Sub(oldest)
+	if span <= 0 {
+		return n
+	}
+	return max(n, int(float64(n)*float64(now.Sub(since))/float64(span)))
+}
+
+// PlanCrawl returns a Plan function for LUCIClient that orders
+// builders for fetching: first the builders in priority, in the given
+// order, then the others, fewest builds first, so that as many
+// builders as possible are covered if the budget runs out.
+//
+// Counting the builds of a builder takes an RPC of the budget (see
+// CountBuilds). The builders in priority are counted first, then the
+// others, in the order given. If the budget is limited by RPCs,
+// counting stops once what is left of it would only cover fetching
+// the builders counted so far, so that the budget is not all spent on
+// counting. Builders that could not be counted come last.
+//
+// If the budget is limited by RPCs, builders whose estimated cost
+// (see fetchCost) does not fit in the remaining budget are dropped,
+// except for priority builders, which are always kept and fetched
+// partially. So are builders that could not be counted.
+func PlanCrawl(c *LUCIClient, priority []string, since time.Time) func(context.Context, []Builder) ([]Builder, error) {
+	return func(ctx context.Context, builders []Builder) ([]Builder, error) {
+		var order []Builder // builders in the order to count them
+		for _, name := range priority {
+			if i := slices.IndexFunc(builders, func(b Builder) bool { return b.Name == name }); i >= 0 {
+				order = append(order, builders[i])
+			}
+		}
+		for _, b := range builders {
+			if !slices.Contains(priority, b.Name) {
+				order = append(order, b)
+			}
+		}
+		counts := make(map[string]int) // only of the builders counted
+		left := c.Budget.RPCs()
+		need := 0 // RPCs to fetch the builders counted so far that fit
+		for _, b := range order {
+			// Counting b must leave at least an RPC to fetch it.
+			if left >= 0 && left < need+2 {
+				if c.TraceSteps {
+					log.Printf("PlanCrawl: stop counting at %s: %d RPCs left, %d needed to fetch", b.Name, left, need)
+				}
+				break
+			}
+			n, ok, err := c.CountBuilds(ctx, b.Name, since)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			counts[b.Name] = n
+			if left >= 0 {
+				left--
+				if cost := fetchCost(n); slices.Contains(priority, b.Name) || need+cost <= left {
+					need += cost
+				}
+			}
+		}
+		rank := func(name string) int {
+			if i := slices.Index(priority, name); i >= 0 {
+				return i
+			}
+			return len(priority)
+		}
+		slices.SortStableFunc(builders, func(a, b Builder) int {
+			if ra, rb := rank(a.Name), rank(b.Name); ra != rb {
+				return ra - rb
+			}
+			na, oka := counts[a.Name]
+			nb, okb := counts[b.Name]
+			if oka != okb {
+				if oka {
+					return -1
+				}
+				return 1
+			}
+			return na - nb
+		})
+
+		left = c.Budget.RPCs()
+		if left < 0 {
+			return builders, nil
+		}
+		var plan []Builder
+		for _, b := range builders {
+			n, counted := counts[b.Name]
+			cost := fetchCost(n)
+			if (!counted || cost > left) && rank(b.Name) == len(priority) {
+				switch {
+				case !c.TraceSteps:
+				case !counted:
+					log.Printf("PlanCrawl: skip %s: builds not counted", b.Name)
+				default:
+					log.Printf("PlanCrawl: skip %s: %d builds, %d RPCs left", b.Name, n, left)
+				}
+				continue
+			}
+			plan = append(plan, b)
+			left -= min(cost, left)
+		}
+		return plan, nil
+	}
+}
+
+// fetchCost is the estimated number of RPCs to fetch n builds: one per
+// page of builds, at least one, plus one test result query per build.
+func fetchCost(n int) int {
+	return max(1, (n+999)/1000) + n
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	bbpb "go.chromium.org/luci/buildbucket/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		in      string
+		nil     bool
+		rpcs    int // -1 for a time budget
+		wantErr bool
+	}{
+		{in: "", nil: true, rpcs: -1},
+		{in: "500", rpcs: 500},
+		{in: "1", rpcs: 1},
+		{in: "10m", rpcs: -1},
+		{in: "0", wantErr: true},
+		{in: "-3", wantErr: true},
+		{in: "-1m", rpcs: -1},
+		{in: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		b, err := ParseBudget(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBudget(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if (b == nil) != tt.nil {
+			t.Errorf("ParseBudget(%q) = %v, want nil %v", tt.in, b, tt.nil)
+		}
+		if got := b.RPCs(); got != tt.rpcs {
+			t.Errorf("ParseBudget(%q).RPCs() = %d, want %d", tt.in, got, tt.rpcs)
+		}
+	}
+}
+
+func TestTake(t *testing.T) {
+	var unlimited *Budget
+	for range 3 {
+		if !unlimited.Take() {
+			t.Fatal("nil Budget: Take = false")
+		}
+	}
+
+	b := &Budget{rpcs: 2}
+	for i, want := range []bool{true, true, false, false} {
+		if got := b.Take(); got != want {
+			t.Errorf("2 RPCs: Take #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if b.RPCs() != 0 {
+		t.Errorf("2 RPCs, after Take: RPCs() = %d, want 0", b.RPCs())
+	}
+
+	if b := (&Budget{deadline: time.Now().Add(time.Hour)}); !b.Take() || !b.Take() {
+		t.Error("future deadline: Take = false")
+	}
+	if b := (&Budget{deadline: time.Now().Add(-time.Second)}); b.Take() {
+		t.Error("past deadline: Take = true")
+	}
+}
+
+func TestExtrapolate(t *testing.T) {
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	since := now.Add(-60 * 24 * time.Hour)
+	tests := []struct {
+		n      int
+		oldest time.Time
+		want   int
+	}{
+		{100, now.Add(-6 * 24 * time.Hour), 1000},
+		{100, now.Add(-30 * 24 * time.Hour), 200},
+		{100, since, 100},
+		{100, now, 100},
+	}
+	for _, tt := range tests {
+		if got := extrapolate(tt.n, tt.oldest, since, now); got != tt.want {
+			t.Errorf("extrapolate(%d, now-%v) = %d, want %d", tt.n, now.Sub(tt.oldest), got, tt.want)
+		}
+	}
+}
+
+// fakeBuilds is a BuildsClient with a number of builds per builder,
+// one every hour until now.
+type fakeBuilds struct {
+	bbpb.BuildsClient
+	builds   map[string]int
+	searches int
+}
+
+func (f *fakeBuilds) SearchBuilds(ctx context.Context, req *bbpb.SearchBuildsRequest, opts ...grpc.CallOption) (*bbpb.SearchBuildsResponse, error) {
+	f.searches++
+	n := f.builds[req.GetPredicate().GetBuilder().GetBuilder()]
+	resp := new(bbpb.SearchBuildsResponse)
+	now := time.Now()
+	for i := range min(n, int(req.GetPageSize())) {
+		resp.Builds = append(resp.Builds, &bbpb.Build{CreateTime: timestamppb.New(now.Add(-time.Duration(i) * time.Hour))})
+	}
+	if n > len(resp.Builds) {
+		resp.NextPageToken = "more"
+	}
+	return resp, nil
+}
+
+func TestPlanCrawl(t *testing.T) {
+	builds := map[string]int{"a": 50, "b": 10, "c": 30, "d": 5, "e": 2}
+	since := time.Now().Add(-60 * 24 * time.Hour)
+	tests := []struct {
+		name     string
+		budget   *Budget
+		priority []string
+		want     []string
+	}{
+		{"time budget", &Budget{deadline: time.Now().Add(time.Hour)}, nil, []string{"e", "d", "b", "c", "a"}},
+		{"priority", &Budget{deadline: time.Now().Add(time.Hour)}, []string{"c", "a"}, []string{"c", "a", "e", "d", "b"}},
+		// Counting takes 5 RPCs, leaving 45: e (1+2), d (1+5) and
+		// b (1+10) fit, but not c (1+30) after them.
+		{"RPC budget", &Budget{rpcs: 50}, nil, []string{"e", "d", "b"}},
+		// Priority builders are kept even if they do not fit, and
+		// leave nothing for the others.
+		{"RPC budget priority", &Budget{rpcs: 50}, []string{"d", "a"}, []string{"d", "a"}},
+		// e, in priority, is counted first, leaving 2 RPCs, which are
+		// kept for fetching it.
+		{"RPC budget spent counting", &Budget{rpcs: 3}, []string{"e"}, []string{"e"}},
+		// a, b and c are counted but do not fit; d (1+5) does, and
+		// leaves too little to count e.
+		{"RPC budget counting stops", &Budget{rpcs: 10}, nil, []string{"d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeBuilds{builds: builds}
+			c := &LUCIClient{BuildsClient: fake, Budget: tt.budget}
+			var in []Builder
+			for _, name := range []string{"a", "b", "c", "d", "e"} {
+				in = append(in, Builder{Name: name})
+			}
+			plan, err := PlanCrawl(c, tt.priority, since)(context.Background(), in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, b := range plan {
+				got = append(got, b.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("plan = %v, want %v", got, tt.want)
+			}
+			if fake.searches > len(in) {
+				t.Errorf("%d SearchBuilds RPCs for %d builders, want at most one each", fake.searches, len(in))
+			}
+			// Counting must leave some of the budget for fetching.
+			if left := tt.budget.RPCs(); left >= 0 && (len(plan) == 0 || left == 0) {
+				t.Errorf("plan %v with %d RPCs left after counting, want a builder to fetch and RPCs to fetch it", got, left)
+			}
+		})
+	}
+}
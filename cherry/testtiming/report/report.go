@@ -0,0 +1,102 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package report defines the JSON output of testtiming (its -json
+// flag), for the programs that read it.
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SchemaVersion is the version of the JSON output format.
+// It must be incremented whenever a field is removed, renamed or
+// changes meaning, and Migrate must be taught how to upgrade the old
+// format.
+//
+// Version history:
+//
+//	1: initial version.
+const SchemaVersion = 1
+
+// Output is the JSON output of testtiming.
+type Output struct {
+	SchemaVersion int          `json:"schema_version"`
+	Repo          string       `json:"repo"`
+	Branch        string       `json:"branch"`
+	Test          string       `json:"test"`
+	Results       []TestTiming `json:"results"`
+
+	// Artifacts is set instead of Results with the -artifacts flag.
+	// It was added without a version bump, as it is optional.
+	Artifacts []ArtifactUsage `json:"artifacts,omitempty"`
+}
+
+// TestTiming is the timing of a single test run.
+type TestTiming struct {
+	Commit   string    `json:"commit"`      // commit hash
+	Time     time.Time `json:"commit_time"` // commit time
+	Builder  string    `json:"builder"`
+	Status   string    `json:"status"`
+	Duration float64   `json:"duration"` // in seconds
+}
+
+// CheckVersion reports whether output written with schema version v
+// can be read, possibly after migration, by this version of the
+// package.
+func CheckVersion(v int) error {
+	switch {
+	case v <= 0:
+		return errors.New("missing schema version")
+	case v > SchemaVersion:
+		return fmt.Errorf("schema version %d is newer than supported version %d", v, SchemaVersion)
+	}
+	return nil
+}
+
+// Migrate upgrades o in place to the current schema version.
+func Migrate(o *Output) error {
+	if err := CheckVersion(o.SchemaVersion); err != nil {
+		return err
+	}
+	// There is only one version so far. Upgrades from older versions
+	// go here, one version step at a time.
+	o.SchemaVersion = SchemaVersion
+	return nil
+}
+
+// Read decodes JSON output from r, validating and migrating it to the
+// current schema version.
+func Read(r io.Reader) (*Output, error) {
+	var o Output
+	if err := json.NewDecoder(r).Decode(&o); err != nil {
+		return nil, err
+	}
+	if err := Migrate(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Write encodes o as JSON to w, stamping it with the current schema
+// version.
+func Write(w io.Writer, o *Output) error {
+	o.SchemaVersion = SchemaVersion
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(o)
+}
+
+// ArtifactUsage is the storage used by the artifacts of one test
+// on one builder, summed over all builds in the window.
+type ArtifactUsage struct {
+	Builder string `json:"builder"`
+	Test    string `json:"test"` // empty for invocation-level artifacts
+	Count   int    `json:"count"`
+	Bytes   int64  `json:"bytes"`
+}
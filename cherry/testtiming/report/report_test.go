@@ -0,0 +1,90 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string // empty if Read succeeds
+	}{
+		{"missing", `{"repo": "go"}`, "missing schema version"},
+		{"zero", `{"schema_version": 0}`, "missing schema version"},
+		{"negative", `{"schema_version": -1}`, "missing schema version"},
+		{"newer", fmt.Sprintf(`{"schema_version": %d}`, SchemaVersion+1), "newer than supported"},
+		{"not JSON", `schema_version: 1`, "invalid character"},
+	}
+	// Every version up to the current one can be read, and is migrated.
+	for v := 1; v <= SchemaVersion; v++ {
+		tests = append(tests, struct {
+			name    string
+			in      string
+			wantErr string
+		}{fmt.Sprint("version ", v), fmt.Sprintf(`{"schema_version": %d, "repo": "go"}`, v), ""})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := Read(strings.NewReader(tt.in))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Read(%s) = %v, %v, want error containing %q", tt.in, o, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read(%s): %v", tt.in, err)
+			}
+			if o.SchemaVersion != SchemaVersion || o.Repo != "go" {
+				t.Errorf("Read(%s) = %+v, want version %d and repo go", tt.in, o, SchemaVersion)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, o := range []*Output{
+		{
+			Repo:   "go",
+			Branch: "master",
+			Test:   "TestFoo",
+			Results: []TestTiming{
+				{Commit: "0123456789abcdef", Time: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), Builder: "gotip-linux-amd64", Status: "PASS", Duration: 1.5},
+				{Commit: "fedcba9876543210", Time: time.Date(2024, 7, 2, 8, 30, 0, 0, time.UTC), Builder: "gotip-darwin-arm64", Status: "FAIL", Duration: 30},
+			},
+		},
+		{
+			Repo:   "tools",
+			Branch: "gopls-release-branch.0.16",
+			Artifacts: []ArtifactUsage{
+				{Builder: "x_tools-gotip-linux-amd64", Test: "TestBar", Count: 3, Bytes: 1 << 20},
+				{Builder: "x_tools-gotip-linux-amd64", Count: 1, Bytes: 512},
+			},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := Write(&buf, o); err != nil {
+			t.Fatal(err)
+		}
+		if o.SchemaVersion != SchemaVersion {
+			t.Errorf("Write left schema version %d, want %d", o.SchemaVersion, SchemaVersion)
+		}
+		got, err := Read(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, o) {
+			t.Errorf("Read(Write(o)) = %+v, want %+v", got, o)
+		}
+	}
+}
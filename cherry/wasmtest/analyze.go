@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// With -analyze, the driver prints the size of each section of the
+// module, custom sections by name, and its imports and exports, so
+// that changes in the size of Go's Wasm output show up next to those
+// in its behavior.
+
+// sectionNames are the names of the known sections, by id.
+var sectionNames = []string{"custom", "type", "import", "function", "table", "memory", "global", "export", "start", "element", "code", "data", "datacount"}
+
+// A section is a section of a module, with the size of its contents.
+type section struct {
+	name string
+	size int
+}
+
+// sections returns the sections of the module buf.
+func sections(buf []byte) ([]section, error) {
+	if len(buf) < 8 || string(buf[:4]) != "\x00asm" {
+		return nil, fmt.Errorf("not a Wasm module")
+	}
+	var secs []section
+	for b := buf[8:]; len(b) > 0; {
+		id := b[0]
+		size, n := binary.Uvarint(b[1:])
+		if n <= 0 || size > uint64(len(b)-1-n) {
+			return nil, fmt.Errorf("section at %#x: bad size", len(buf)-len(b))
+		}
+		content := b[1+n : 1+n+int(size)]
+		b = b[1+n+int(size):]
+		var name string
+		switch {
+		case id == 0:
+			l, n := binary.Uvarint(content)
+			if n <= 0 || l > uint64(len(content)-n) {
+				return nil, fmt.Errorf("custom section: bad name")
+			}
+			name = "custom " + string(content[n:n+int(l)])
+		case int(id) < len(sectionNames):
+			name = sectionNames[id]
+		default:
+			name = fmt.Sprintf("unknown %d", id)
+		}
+		secs = append(secs, section{name, len(content)})
+	}
+	return secs, nil
+}
+
+// analyze writes the sizes of the sections of the module buf to w,
+// then its imports and exports.
+func analyze(ctx context.Context, w io.Writer, r wazero.Runtime, buf []byte) error {
+	secs, err := sections(buf)
+	if err != nil {
+		return err
+	}
+	cm, err := r.CompileModule(ctx, buf)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range secs {
+		fmt.Fprintf(w, "%-24s %10d %5.1f%%\n", s.name, s.size, 100*float64(s.size)/float64(len(buf)))
+	}
+	fmt.Fprintf(w, "%-24s %10d\n", "total", len(buf))
+
+	var imports []string
+	for _, def := range cm.ImportedFunctions() {
+		module, name, _ := def.Import()
+		imports = append(imports, module+"."+name+funcType(def))
+	}
+	fmt.Fprintf(w, "\n%d imports:\n", len(imports))
+	for _, s := range imports {
+		fmt.Fprintln(w, "\t"+s)
+	}
+
+	var exports []string
+	for _, def := range cm.ExportedFunctions() {
+		exports = append(exports, signature(def))
+	}
+	sort.Strings(exports)
+	fmt.Fprintf(w, "\n%d exports:\n", len(exports))
+	for _, s := range exports {
+		fmt.Fprintln(w, "\t"+s)
+	}
+	return nil
+}
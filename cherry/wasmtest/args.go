@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// The guest gets its arguments and environment through WASI, or for
+// GOOS=js in memory, like wasm_exec.js passes them (see gojs.go): the
+// name of the module, then the words of -guest-args, and the
+// variables of -guest-env, followed by those the driver sets itself,
+// such as GOCOVERDIR. The args scenario checks that a wasip1 guest
+// sees exactly those (see testprog/args.go).
+
+// guestArgs and guestEnv are the arguments and the environment, as
+// KEY=value, passed to the guest.
+var guestArgs, guestEnv []string
+
+// parseGuestEnv parses the -guest-env flag, a comma-separated list of
+// KEY=value.
+func parseGuestEnv(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	env := strings.Split(s, ",")
+	for _, kv := range env {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			return nil, fmt.Errorf("%q is not KEY=value", kv)
+		}
+	}
+	return env, nil
+}
+
+// withGuestEnv returns config with the environment variable kv, as
+// KEY=value, added to it and to guestEnv.
+func withGuestEnv(config wazero.ModuleConfig, kv string) wazero.ModuleConfig {
+	guestEnv = append(guestEnv, kv)
+	k, v, _ := strings.Cut(kv, "=")
+	return config.WithEnv(k, v)
+}
+
+// checkArgs checks the arguments and environment of the library module
+// m, initialized, from its Args and Environ exports.
+func checkArgs(g guestMem) error {
+	split := func(b []byte) []string {
+		if len(b) == 0 {
+			return nil
+		}
+		return strings.Split(string(b), "\x00")
+	}
+	if got := split(g.result(g.call("Args"))); !slices.Equal(got, guestArgs) {
+		return fmt.Errorf("guest args = %q, want %q", got, guestArgs)
+	}
+	if got := split(g.result(g.call("Environ"))); !slices.Equal(got, guestEnv) {
+		return fmt.Errorf("guest environment = %q, want %q", got, guestEnv)
+	}
+	fmt.Printf("host: args %q and environment %q OK\n", guestArgs, guestEnv)
+	return nil
+}
+
+// checkExecutableArgs runs a fresh instance of the compiled executable
+// module cm, with the arguments "exit 0" added, so that it only prints
+// its arguments and environment, and checks those.
+func checkExecutableArgs(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule) error {
+	args := slices.Concat(guestArgs, []string{"exit", "0"})
+	var out bytes.Buffer
+	m, err := reset(ctx, r, cm, nil, config.WithArgs(args...).WithStdout(&out).WithStderr(&out))
+	if err != nil {
+		return err
+	}
+	_, err = callExport(ctx, m.ExportedFunction("_start"))
+	if err := checkExit(err); err != nil {
+		return err
+	}
+	var gotArgs, gotEnv []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if a, ok := strings.CutPrefix(line, "arg: "); ok {
+			gotArgs = append(gotArgs, a)
+		} else if e, ok := strings.CutPrefix(line, "env: "); ok {
+			gotEnv = append(gotEnv, e)
+		}
+	}
+	if !slices.Equal(gotArgs, args) {
+		return fmt.Errorf("guest args = %q, want %q", gotArgs, args)
+	}
+	if !slices.Equal(gotEnv, guestEnv) {
+		return fmt.Errorf("guest environment = %q, want %q", gotEnv, guestEnv)
+	}
+	fmt.Printf("host: args %q and environment %q OK\n", args, guestEnv)
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// benchDepths are the recursion depths of the G benchmarks. G(d)
+// recurses d times, calling G directly at even levels and through the
+// host's J at odd ones.
+var benchDepths = []int32{1, 2, 4, 8, 16, 32, 64}
+
+// bench measures the round-trip latency of calls into the library
+// module m and prints it in ns/call: an export that does nothing
+// (Live), E followed by F, and G at various depths, so that changes
+// in the cost of wasmexport calls in the Go runtime show up.
+func bench(ctx context.Context, m api.Module) {
+	live := m.ExportedFunction("Live")
+	run := func(name string, calls int, f func()) {
+		r := testing.Benchmark(func(b *testing.B) {
+			for range b.N {
+				f()
+			}
+		})
+		ns := float64(r.NsPerOp())
+		fmt.Printf("%-8s %10d iterations %12.0f ns/op %10.0f ns/call\n", name, r.N, ns, ns/float64(calls))
+	}
+
+	run("Live", 1, func() {
+		if _, err := callExport(ctx, live); err != nil {
+			panic(err)
+		}
+	})
+	run("E+F", 2, func() {
+		E(argEa, argEb, argEc, argEd)
+		F()
+	})
+	for _, d := range benchDepths {
+		// G(d) makes d calls to G, one per level, and a call to
+		// J at each odd level, all crossing the host/guest boundary.
+		run(fmt.Sprintf("G(%d)", d), int(d+(d+1)/2), func() { G(d) })
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// The blocking scenario calls the BlockingImports export of testprog
+// (see testprog/blocking.go), whose goroutines call host imports that
+// block: Sleep, Timer, and Block (see hosterr.go), which another
+// goroutine of the host completes. The guest has a single thread, so
+// a blocked import blocks all its goroutines, and the imports run one
+// after the other; once an import returns, the guest must schedule its
+// goroutines as before, and its own timers must still fire.
+
+// Sleep and Timer block for ms milliseconds, with time.Sleep and a
+// time.Timer, and return ms.
+func Sleep(ms int32) int64 {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return int64(ms)
+}
+
+func Timer(ms int32) int64 {
+	t := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	<-t.C
+	return int64(ms)
+}
+
+// checkBlocking calls BlockingImports in the library module m and
+// checks its results.
+func checkBlocking(ctx context.Context, m api.Module) error {
+	const rounds, ms = 8, 5
+	start := time.Now()
+	res, err := callExport(ctx, m.ExportedFunction("BlockingImports"), api.EncodeI32(rounds), api.EncodeI32(ms))
+	if err != nil {
+		return fmt.Errorf("BlockingImports: %v", err)
+	}
+	elapsed := time.Since(start)
+	sum, ticks := int64(res[0])>>32, int64(uint32(res[0]))
+	if want := int64(rounds * (2*ms + blockValue)); sum != want {
+		return fmt.Errorf("BlockingImports: imports returned %d in all, want %d", sum, want)
+	}
+	if least := rounds * 2 * ms * time.Millisecond; elapsed < least {
+		return fmt.Errorf("BlockingImports took %v, want at least %v with the imports run one after the other", elapsed, least)
+	}
+	if ticks == 0 {
+		return fmt.Errorf("BlockingImports: the guest's sleeping goroutine never woke")
+	}
+	fmt.Printf("host: %d goroutines through blocking imports in %v OK\n", rounds, elapsed.Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// With -cases, the driver runs the call sequences in a JSON file
+// instead of its own script, so that new wasmexport edge cases need
+// no change to the driver. The file holds a list of cases, each run
+// against a fresh instance of a library module, for example:
+//
+//	[
+//		{"name": "goroutine switch", "calls": [
+//			{"export": "E", "args": [20, 3, 0.4, 0.05]},
+//			{"export": "F", "want": [12345]}
+//		]},
+//		{"name": "bad free", "calls": [
+//			{"export": "free", "args": [1], "panic": "free of a buffer not from alloc"}
+//		]}
+//	]
+//
+// Arguments and results are numbers, converted to the Wasm types of
+// the export. A call with "panic" must fail, with the guest's output
+// containing it; as the runtime exits, it must be the last of its
+// case. A case with "uninitialized" skips _initialize.
+// testdata/cases.json has cases for testprog.
+
+// A testCase is a call sequence of the -cases file.
+type testCase struct {
+	Name          string
+	Uninitialized bool
+	Calls         []testCall
+}
+
+type testCall struct {
+	Export string
+	Args   []json.Number
+	Want   []json.Number // nil means not checked
+	Panic  string
+}
+
+func readCases(file string) ([]testCase, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	d.DisallowUnknownFields()
+	var cases []testCase
+	if err := d.Decode(&cases); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return cases, nil
+}
+
+// runCases runs the cases in file against fresh instances of the
+// compiled library module cm, reporting each, and returns the number
+// that failed.
+func runCases(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule, file string) (int, error) {
+	cases, err := readCases(file)
+	if err != nil {
+		return 0, err
+	}
+	failed := 0
+	var m api.Module
+	defer func() {
+		if m != nil {
+			m.Close(ctx)
+		}
+	}()
+	for _, tc := range cases {
+		var out bytes.Buffer
+		w := io.Writer(&out)
+		if *verbose {
+			w = io.MultiWriter(&out, os.Stderr)
+		}
+		m, err = reset(ctx, r, cm, m, config.WithStdout(w).WithStderr(w))
+		if err != nil {
+			return failed, err
+		}
+		bindExports(ctx, m)
+		if err := runCase(ctx, m, tc, &out); err != nil {
+			fmt.Printf("FAIL %s: %v\n", tc.Name, err)
+			failed++
+		} else {
+			fmt.Printf("ok   %s\n", tc.Name)
+		}
+	}
+	return failed, nil
+}
+
+// runCase runs the calls of tc against m, whose output goes to out.
+func runCase(ctx context.Context, m api.Module, tc testCase, out *bytes.Buffer) error {
+	if !tc.Uninitialized {
+		if _, err := callExport(ctx, m.ExportedFunction("_initialize")); err != nil {
+			return fmt.Errorf("_initialize: %v", err)
+		}
+	}
+	for i, c := range tc.Calls {
+		f := m.ExportedFunction(c.Export)
+		if f == nil {
+			return fmt.Errorf("call %d: no export %s", i, c.Export)
+		}
+		def := f.Definition()
+		args, err := encodeValues(def.ParamTypes(), c.Args)
+		if err != nil {
+			return fmt.Errorf("call %d: %s arguments: %v", i, c.Export, err)
+		}
+		res, err := callExport(ctx, f, args...)
+		if c.Panic != "" {
+			if err == nil {
+				return fmt.Errorf("call %d: %s did not panic", i, c.Export)
+			}
+			if !strings.Contains(out.String(), c.Panic) {
+				return fmt.Errorf("call %d: %s failed with %v, but output lacks %q", i, c.Export, err, c.Panic)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("call %d: %s: %v", i, c.Export, err)
+		}
+		if c.Want == nil {
+			continue
+		}
+		want, err := encodeValues(def.ResultTypes(), c.Want)
+		if err != nil {
+			return fmt.Errorf("call %d: %s results: %v", i, c.Export, err)
+		}
+		for j := range want {
+			if res[j] != want[j] {
+				return fmt.Errorf("call %d: %s result %d = %s, want %s", i, c.Export, j,
+					formatValue(def.ResultTypes()[j], res[j]), c.Want[j])
+			}
+		}
+	}
+	return nil
+}
+
+// encodeValues converts the numbers vals to Wasm values of types.
+func encodeValues(types []api.ValueType, vals []json.Number) ([]uint64, error) {
+	if len(vals) != len(types) {
+		return nil, fmt.Errorf("have %d values, want %d", len(vals), len(types))
+	}
+	enc := make([]uint64, len(vals))
+	for i, v := range vals {
+		var err error
+		switch types[i] {
+		case api.ValueTypeI32:
+			var x int64
+			x, err = strconv.ParseInt(v.String(), 0, 32)
+			enc[i] = api.EncodeI32(int32(x))
+		case api.ValueTypeI64:
+			var x int64
+			x, err = strconv.ParseInt(v.String(), 0, 64)
+			enc[i] = api.EncodeI64(x)
+		case api.ValueTypeF32:
+			var x float64
+			x, err = strconv.ParseFloat(v.String(), 32)
+			enc[i] = api.EncodeF32(float32(x))
+		case api.ValueTypeF64:
+			var x float64
+			x, err = strconv.ParseFloat(v.String(), 64)
+			enc[i] = api.EncodeF64(x)
+		default:
+			err = fmt.Errorf("unsupported type %s", api.ValueTypeName(types[i]))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %v", i, err)
+		}
+	}
+	return enc, nil
+}
+
+func formatValue(t api.ValueType, v uint64) string {
+	switch t {
+	case api.ValueTypeI32:
+		return strconv.Itoa(int(api.DecodeI32(v)))
+	case api.ValueTypeI64:
+		return strconv.FormatInt(int64(v), 10)
+	case api.ValueTypeF32:
+		return strconv.FormatFloat(float64(api.DecodeF32(v)), 'g', -1, 32)
+	case api.ValueTypeF64:
+		return strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+	}
+	return fmt.Sprintf("%#x", v)
+}
@@ -0,0 +1,405 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"text/template"
+)
+
+// writeCHost writes to file a C program that hosts the test module
+// like this driver does: it provides the imports and makes the same
+// export calls, so the wasmexport ABI can be checked against a non-Go
+// host. It runs the executable, library, goroutine-switch and
+// reentrancy scenarios, checking the same results, and exits with
+// status 1 if one fails. The program uses the wasmtime C API, which
+// also provides WASI. Build it with
+//
+//	cc -o host host.c -lwasmtime
+//
+// and run it with the module as the argument, and optionally the
+// scenarios to run, as for -run.
+func writeCHost(file string) error {
+	var buf bytes.Buffer
+	err := cHostTmpl.Execute(&buf, map[string]string{
+		"Ea":    strconv.FormatInt(argEa, 10),
+		"Eb":    strconv.FormatInt(int64(argEb), 10),
+		"Ec":    strconv.FormatFloat(argEc, 'g', -1, 64),
+		"Ed":    strconv.FormatFloat(float64(argEd), 'g', -1, 32) + "f",
+		"G":     strconv.FormatInt(int64(argG), 10),
+		"F":     strconv.FormatInt(mulF, 10),
+		"Depth": strconv.FormatInt(int64(2*argG), 10),
+		"Block": strconv.Itoa(blockValue),
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0666)
+}
+
+var cHostTmpl = template.Must(template.New("host.c").Parse(`// Code generated by "go run . -gen-c"; DO NOT EDIT.
+
+// C host for the wasmexport test program, equivalent to w.go.
+// Build: cc -o host host.c -lwasmtime
+// Run:   ./host x.wasm [scenario,...]
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <wasi.h>
+#include <wasm.h>
+#include <wasmtime.h>
+
+static wasmtime_context_t *context;
+static wasmtime_instance_t instance;
+static const char *run; // the scenarios to run, as for -run, or NULL for all
+static int jcalls;      // calls of J, for the reentrancy scenario
+
+static void fail(const char *what, wasmtime_error_t *error, wasm_trap_t *trap) {
+	wasm_byte_vec_t msg;
+	if (error != NULL) {
+		wasmtime_error_message(error, &msg);
+	} else if (trap != NULL) {
+		wasm_trap_message(trap, &msg);
+	} else {
+		fprintf(stderr, "%s\n", what);
+		exit(1);
+	}
+	fprintf(stderr, "%s: %.*s\n", what, (int)msg.size, msg.data);
+	exit(1);
+}
+
+static int lookup(const char *name, wasmtime_func_t *f) {
+	wasmtime_extern_t item;
+	if (!wasmtime_instance_export_get(context, &instance, name, strlen(name), &item))
+		return 0;
+	if (item.kind != WASMTIME_EXTERN_FUNC)
+		return 0;
+	*f = item.of.func;
+	return 1;
+}
+
+static void call(const char *name, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	wasmtime_func_t f;
+	wasm_trap_t *trap = NULL;
+	wasmtime_error_t *error;
+
+	if (!lookup(name, &f)) {
+		fprintf(stderr, "export %s not found\n", name);
+		exit(1);
+	}
+	error = wasmtime_func_call(context, &f, args, nargs, results, nresults, &trap);
+	if (error != NULL || trap != NULL)
+		fail(name, error, trap);
+}
+
+// exported from wasm
+
+static void E(int64_t a, int32_t b, double c, float d) {
+	wasmtime_val_t args[4];
+	args[0].kind = WASMTIME_I64; args[0].of.i64 = a;
+	args[1].kind = WASMTIME_I32; args[1].of.i32 = b;
+	args[2].kind = WASMTIME_F64; args[2].of.f64 = c;
+	args[3].kind = WASMTIME_F32; args[3].of.f32 = d;
+	call("E", args, 4, NULL, 0);
+}
+
+static int64_t F(void) {
+	wasmtime_val_t result;
+	call("F", NULL, 0, &result, 1);
+	fprintf(stderr, "host: F = %lld\n", (long long)result.of.i64);
+	return result.of.i64;
+}
+
+static void G(int32_t x) {
+	wasmtime_val_t arg;
+	arg.kind = WASMTIME_I32;
+	arg.of.i32 = x;
+	call("G", &arg, 1, NULL, 0);
+}
+
+// imported by wasm
+
+static int64_t I(void) {
+	int64_t r;
+
+	fprintf(stderr, "I start\n");
+	E({{.Ea}}, {{.Eb}}, {{.Ec}}, {{.Ed}});
+	r = F() * {{.F}};
+	G({{.G}});
+	fprintf(stderr, "I end = %lld\n", (long long)r);
+	return r;
+}
+
+static void J(int32_t x) {
+	jcalls++;
+	fprintf(stderr, "J %d\n", x);
+	if (x > 0)
+		G(x);
+	fprintf(stderr, "J %d end\n", x);
+}
+
+// scenarios, as in scenario.go, which exit with status 1 if they fail
+
+// selected reports whether run selects the scenario name.
+static int selected(const char *name) {
+	size_t n = strlen(name);
+	const char *p = run;
+
+	if (run == NULL)
+		return 1;
+	while ((p = strstr(p, name)) != NULL) {
+		if ((p == run || p[-1] == ',') && (p[n] == ',' || p[n] == '\0'))
+			return 1;
+		p += n;
+	}
+	return 0;
+}
+
+static void heading(const char *mode, const char *what, const char *name) {
+	printf("\n%s mode: %s [%s]\n", mode, what, name);
+	fflush(stdout);
+}
+
+// want_F returns what F returns after E, computed like the guest
+// does, at run time.
+static int64_t want_F(void) {
+	return (int64_t)(((double){{.Ea}} + (double){{.Eb}} + {{.Ec}} + (double){{.Ed}} + 100) * 100);
+}
+
+static void library(void) {
+	int64_t got, want;
+
+	want = want_F() * {{.F}};
+	got = I();
+	printf("host: I = %lld\n", (long long)got);
+	if (got != want) {
+		fprintf(stderr, "I = %lld, want %lld\n", (long long)got, (long long)want);
+		exit(1);
+	}
+}
+
+static void goroutine_switch(int rounds) {
+	int64_t got, want;
+	int i;
+
+	want = want_F();
+	for (i = 0; i < rounds; i++) {
+		E({{.Ea}}, {{.Eb}}, {{.Ec}}, {{.Ed}});
+		got = F();
+		if (got != want) {
+			fprintf(stderr, "round %d: F = %lld, want %lld\n", i, (long long)got, (long long)want);
+			exit(1);
+		}
+	}
+	printf("host: %d rounds of E and F OK\n", rounds);
+}
+
+static void reentrancy(int32_t max_depth) {
+	int32_t x;
+
+	for (x = 1; x <= max_depth; x++) {
+		jcalls = 0;
+		G(x);
+		if (jcalls != (x + 1) / 2) {
+			fprintf(stderr, "G(%d) called J %d times, want %d\n", x, jcalls, (x + 1) / 2);
+			exit(1);
+		}
+	}
+	printf("host: G up to depth %d OK\n", max_depth);
+}
+
+static wasm_trap_t *I_callback(void *env, wasmtime_caller_t *caller, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	results[0].kind = WASMTIME_I64;
+	results[0].of.i64 = I();
+	return NULL;
+}
+
+static wasm_trap_t *J_callback(void *env, wasmtime_caller_t *caller, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	J(args[0].of.i32);
+	return NULL;
+}
+
+// EchoF32 and EchoF64 return their argument, with its exact bits.
+static wasm_trap_t *Echo_callback(void *env, wasmtime_caller_t *caller, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	results[0] = args[0];
+	return NULL;
+}
+
+// Sleep and Timer return at once.
+static wasm_trap_t *Sleep_callback(void *env, wasmtime_caller_t *caller, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	results[0].kind = WASMTIME_I64;
+	results[0].of.i64 = args[0].of.i32;
+	return NULL;
+}
+
+// Init does nothing.
+static wasm_trap_t *Init_callback(void *env, wasmtime_caller_t *caller, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	return NULL;
+}
+
+// Panic, Fail and Exit trap, and Block returns at once, for the
+// host-errors scenario, which only the Go host runs.
+static wasm_trap_t *Trap_callback(void *env, wasmtime_caller_t *caller, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	return wasmtime_trap_new("host import failed", 18);
+}
+
+static wasm_trap_t *Block_callback(void *env, wasmtime_caller_t *caller, const wasmtime_val_t *args, size_t nargs, wasmtime_val_t *results, size_t nresults) {
+	results[0].kind = WASMTIME_I64;
+	results[0].of.i64 = {{.Block}};
+	return NULL;
+}
+
+int main(int argc, char **argv) {
+	wasm_engine_t *engine;
+	wasmtime_store_t *store;
+	wasmtime_linker_t *linker;
+	wasmtime_module_t *module;
+	wasmtime_error_t *error;
+	wasm_trap_t *trap = NULL;
+	wasi_config_t *wasi;
+	wasm_functype_t *ty;
+	wasm_byte_vec_t wasm;
+	wasmtime_func_t entry;
+	FILE *file;
+	long size;
+	int status;
+
+	if (argc != 2 && argc != 3) {
+		fprintf(stderr, "usage: host x.wasm [scenario,...]\n");
+		return 2;
+	}
+	if (argc == 3)
+		run = argv[2];
+	file = fopen(argv[1], "rb");
+	if (file == NULL) {
+		perror(argv[1]);
+		return 1;
+	}
+	fseek(file, 0, SEEK_END);
+	size = ftell(file);
+	fseek(file, 0, SEEK_SET);
+	wasm_byte_vec_new_uninitialized(&wasm, size);
+	if (fread(wasm.data, size, 1, file) != 1) {
+		perror(argv[1]);
+		return 1;
+	}
+	fclose(file);
+
+	engine = wasm_engine_new();
+	store = wasmtime_store_new(engine, NULL, NULL);
+	context = wasmtime_store_context(store);
+	linker = wasmtime_linker_new(engine);
+
+	// provide import functions from host
+	ty = wasm_functype_new_0_1(wasm_valtype_new_i64());
+	error = wasmtime_linker_define_func(linker, "test", 4, "I", 1, ty, I_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define I", error, NULL);
+	ty = wasm_functype_new_1_0(wasm_valtype_new_i32());
+	error = wasmtime_linker_define_func(linker, "test", 4, "J", 1, ty, J_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define J", error, NULL);
+	ty = wasm_functype_new_1_1(wasm_valtype_new_f32(), wasm_valtype_new_f32());
+	error = wasmtime_linker_define_func(linker, "test", 4, "EchoF32", 7, ty, Echo_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define EchoF32", error, NULL);
+	ty = wasm_functype_new_1_1(wasm_valtype_new_f64(), wasm_valtype_new_f64());
+	error = wasmtime_linker_define_func(linker, "test", 4, "EchoF64", 7, ty, Echo_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define EchoF64", error, NULL);
+	ty = wasm_functype_new_0_0();
+	error = wasmtime_linker_define_func(linker, "test", 4, "Panic", 5, ty, Trap_callback, NULL, NULL);
+	if (error == NULL)
+		error = wasmtime_linker_define_func(linker, "test", 4, "Fail", 4, ty, Trap_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define Panic and Fail", error, NULL);
+	ty = wasm_functype_new_1_0(wasm_valtype_new_i32());
+	error = wasmtime_linker_define_func(linker, "test", 4, "Exit", 4, ty, Trap_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define Exit", error, NULL);
+	ty = wasm_functype_new_0_1(wasm_valtype_new_i64());
+	error = wasmtime_linker_define_func(linker, "test", 4, "Block", 5, ty, Block_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define Block", error, NULL);
+	ty = wasm_functype_new_1_1(wasm_valtype_new_i32(), wasm_valtype_new_i64());
+	error = wasmtime_linker_define_func(linker, "test", 4, "Sleep", 5, ty, Sleep_callback, NULL, NULL);
+	if (error == NULL)
+		error = wasmtime_linker_define_func(linker, "test", 4, "Timer", 5, ty, Sleep_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define Sleep and Timer", error, NULL);
+	ty = wasm_functype_new_0_0();
+	error = wasmtime_linker_define_func(linker, "test", 4, "Init", 4, ty, Init_callback, NULL, NULL);
+	wasm_functype_delete(ty);
+	if (error != NULL)
+		fail("define Init", error, NULL);
+
+	error = wasmtime_linker_define_wasi(linker);
+	if (error != NULL)
+		fail("define WASI", error, NULL);
+	wasi = wasi_config_new();
+	wasi_config_inherit_stdout(wasi);
+	wasi_config_inherit_stderr(wasi);
+	error = wasmtime_context_set_wasi(context, wasi);
+	if (error != NULL)
+		fail("WASI config", error, NULL);
+
+	error = wasmtime_module_new(engine, (uint8_t *)wasm.data, wasm.size, &module);
+	wasm_byte_vec_delete(&wasm);
+	if (error != NULL)
+		fail("compile", error, NULL);
+	error = wasmtime_linker_instantiate(linker, context, module, &instance, &trap);
+	if (error != NULL || trap != NULL)
+		fail("instantiate", error, trap);
+
+	if (lookup("_start", &entry)) {
+		// Executable mode.
+		if (!selected("executable"))
+			return 0;
+		printf("Executable mode: start\n");
+		fflush(stdout);
+		error = wasmtime_func_call(context, &entry, NULL, 0, NULL, 0, &trap);
+		if (error != NULL && wasmtime_error_exit_status(error, &status)) {
+			printf("module exited with code %d\n", status);
+			wasmtime_error_delete(error);
+		} else if (error != NULL || trap != NULL) {
+			fail("_start", error, trap);
+		}
+	} else {
+		// Library mode.
+		printf("Library mode: initialize\n");
+		fflush(stdout);
+		call("_initialize", NULL, 0, NULL, 0);
+		if (selected("library")) {
+			heading("Library", "call export functions", "library");
+			library();
+		}
+		if (selected("goroutine-switch")) {
+			heading("Library", "goroutine switch", "goroutine-switch");
+			goroutine_switch(3);
+		}
+		if (selected("reentrancy")) {
+			heading("Library", "reentrancy", "reentrancy");
+			reentrancy({{.Depth}});
+		}
+	}
+
+	wasmtime_module_delete(module);
+	wasmtime_linker_delete(linker);
+	wasmtime_store_delete(store);
+	wasm_engine_delete(engine);
+	return 0;
+}
+`))
@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// A guestClock provides the guest with the time and random bytes,
+// through WASI or the GOOS=js host. By default, they are real. With
+// -fake-time, the clocks start at the given time and advance by a
+// microsecond on each reading, and sleeping advances them instead of
+// waiting, and with -seed, the random bytes come from the seed, so
+// that the output of a guest that uses time.Now or rand is the same
+// from run to run, and can be checked with -golden.
+type guestClock struct {
+	fake  bool
+	start time.Time // wall time at mono 0
+	rand  io.Reader
+
+	mu   sync.Mutex // guest goroutines may read the clocks concurrently (see stress.go)
+	mono time.Duration
+}
+
+var clk = &guestClock{rand: crand.Reader}
+
+// setFakeTime makes the clocks of c fake, starting at start.
+func (c *guestClock) setFakeTime(start time.Time) {
+	c.fake = true
+	c.start = start
+}
+
+// setSeed makes the random bytes of c come from seed.
+func (c *guestClock) setSeed(seed uint64) {
+	c.rand = &seededReader{r: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// nanotime returns the monotonic time in nanoseconds.
+func (c *guestClock) nanotime() int64 {
+	if !c.fake {
+		return time.Since(processStart).Nanoseconds()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mono += time.Microsecond
+	return c.mono.Nanoseconds()
+}
+
+// now returns the wall time.
+func (c *guestClock) now() time.Time {
+	if !c.fake {
+		return time.Now()
+	}
+	return c.start.Add(time.Duration(c.nanotime()))
+}
+
+// sleep pauses for d, or with fake time, advances the clocks by d.
+func (c *guestClock) sleep(d time.Duration) {
+	if !c.fake {
+		time.Sleep(d)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mono += max(d, 0)
+}
+
+// processStart is the origin of the real monotonic clock.
+var processStart = time.Now()
+
+// configure returns config with the clocks and random source of c.
+func (c *guestClock) configure(config wazero.ModuleConfig) wazero.ModuleConfig {
+	return config.
+		WithWalltime(func() (int64, int32) {
+			t := c.now()
+			return t.Unix(), int32(t.Nanosecond())
+		}, sys.ClockResolution(time.Microsecond)).
+		WithNanotime(c.nanotime, sys.ClockResolution(time.Microsecond)).
+		WithNanosleep(func(ns int64) { c.sleep(time.Duration(ns)) }).
+		WithRandSource(c.rand)
+}
+
+// A seededReader reads pseudo-random bytes from r.
+type seededReader struct {
+	r   *rand.Rand
+	buf [8]byte
+	n   int // unread bytes at the end of buf
+}
+
+func (s *seededReader) Read(p []byte) (int, error) {
+	for i := range p {
+		if s.n == 0 {
+			binary.LittleEndian.PutUint64(s.buf[:], s.r.Uint64())
+			s.n = len(s.buf)
+		}
+		p[i] = s.buf[len(s.buf)-s.n]
+		s.n--
+	}
+	return len(p), nil
+}
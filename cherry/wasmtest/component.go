@@ -0,0 +1,23 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "bytes"
+
+// The driver cannot run components (the WASI preview 2 binary format)
+// yet: Go has no wasip2 port to build testprog with, and wazero only
+// runs core modules. Until both do, it recognizes components, to
+// reject them with a clear error rather than a decoding failure.
+//
+// A component starts with the core module magic, followed by version
+// 0x0d and layer 1, where a core module has version 1 and layer 0.
+
+var componentPreamble = []byte{0x00, 'a', 's', 'm', 0x0d, 0x00, 0x01, 0x00}
+
+// isComponent reports whether buf holds a component rather than a core
+// module.
+func isComponent(buf []byte) bool {
+	return bytes.HasPrefix(buf, componentPreamble)
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// With -cover, the driver collects the coverage data of a module built
+// with -cover: it mounts the directory for the data in the guest as
+// /cover and sets GOCOVERDIR to it. An executable writes the data when
+// it exits; a library, which never does, writes it when the driver
+// calls its WriteCoverage export (see testprog/cover.go), at the end
+// of the scenarios, so it only covers the calls into the main
+// instance. The driver then reports the coverage of all the data in
+// the directory, which merges that of earlier runs, and writes it in
+// the text format of go test -coverprofile, to coverage.txt.
+//
+// Coverage makes the guest allocate more, which with wazero's compiler
+// may crash it in the gc scenario (see gc.go); use -engine interpreter,
+// or -run to leave out some scenarios.
+
+const guestCoverDir = "/cover"
+
+// writeCoverage has the library module m write its coverage data.
+func writeCoverage(ctx context.Context, m api.Module) error {
+	f := m.ExportedFunction("WriteCoverage")
+	if f == nil {
+		return errors.New("module has no WriteCoverage export")
+	}
+	res, err := callExport(ctx, f)
+	if err != nil {
+		return err
+	}
+	if api.DecodeI32(res[0]) != 0 {
+		return errors.New("WriteCoverage failed; is the module built with -cover?")
+	}
+	return nil
+}
+
+// reportCoverage prints the coverage of the data in dir and writes it
+// to dir/coverage.txt.
+func reportCoverage(dir string) error {
+	out := filepath.Join(dir, "coverage.txt")
+	for _, args := range [][]string{
+		{"tool", "covdata", "percent", "-i", dir},
+		{"tool", "covdata", "textfmt", "-i", dir, "-o", out},
+	} {
+		cmd := exec.Command("go", args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go %s: %v", args[2], err)
+		}
+	}
+	fmt.Println("coverage profile in", out)
+	return nil
+}
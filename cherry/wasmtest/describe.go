@@ -0,0 +1,120 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ABIReport describes the exports and imports of a Wasm module.
+// It is printed as JSON by the -describe flag, so that it can be
+// diffed between toolchain versions.
+type ABIReport struct {
+	Exports []ABIFunc `json:"exports"`
+	Imports []ABIFunc `json:"imports"`
+}
+
+// ABIFunc is an exported or imported function.
+type ABIFunc struct {
+	Module  string   `json:"module,omitempty"` // for imports only
+	Name    string   `json:"name"`
+	Params  []string `json:"params"`
+	Results []string `json:"results"`
+	GoType  string   `json:"go_type,omitempty"` // from the go:wasmexport/go:wasmimport declaration, if found
+}
+
+// describe writes the ABI report of the module buf to w.
+// The Go types are taken from the declarations in the source
+// files in directory src.
+func describe(ctx context.Context, w io.Writer, r wazero.Runtime, buf []byte, src string) error {
+	cm, err := r.CompileModule(ctx, buf)
+	if err != nil {
+		return err
+	}
+	exports, imports, err := goDecls(src)
+	if err != nil {
+		return err
+	}
+
+	var rep ABIReport
+	for name, def := range cm.ExportedFunctions() {
+		f := abiFunc(def)
+		f.Name = name
+		f.GoType = exports[name]
+		rep.Exports = append(rep.Exports, f)
+	}
+	slices.SortFunc(rep.Exports, func(a, b ABIFunc) int { return strings.Compare(a.Name, b.Name) })
+	for _, def := range cm.ImportedFunctions() {
+		f := abiFunc(def)
+		f.Module, f.Name, _ = def.Import()
+		f.GoType = imports[f.Module+"."+f.Name]
+		rep.Imports = append(rep.Imports, f)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(rep)
+}
+
+func abiFunc(def api.FunctionDefinition) ABIFunc {
+	f := ABIFunc{Params: []string{}, Results: []string{}}
+	for _, t := range def.ParamTypes() {
+		f.Params = append(f.Params, api.ValueTypeName(t))
+	}
+	for _, t := range def.ResultTypes() {
+		f.Results = append(f.Results, api.ValueTypeName(t))
+	}
+	return f
+}
+
+// goDecls parses the Go files in directory dir and returns the Go
+// function types of the go:wasmexport functions, keyed by export
+// name, and of the go:wasmimport functions, keyed by "module.name".
+func goDecls(dir string) (exports, imports map[string]string, err error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, nil, err
+	}
+	exports = make(map[string]string)
+	imports = make(map[string]string)
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Doc == nil {
+				continue
+			}
+			var typ bytes.Buffer
+			printer.Fprint(&typ, fset, fd.Type)
+			for _, c := range fd.Doc.List {
+				fields := strings.Fields(c.Text)
+				switch {
+				case len(fields) == 2 && fields[0] == "//go:wasmexport":
+					exports[fields[1]] = typ.String()
+				case len(fields) == 3 && fields[0] == "//go:wasmimport":
+					imports[fields[1]+"."+fields[2]] = typ.String()
+				}
+			}
+		}
+	}
+	return exports, imports, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// discover lists the exported functions of the compiled library module
+// cm with their signatures, and calls each of those without parameters
+// in a fresh, initialized instance, printing its results or how it
+// failed. testprog can then grow exports without changes to the
+// driver to try them.
+func discover(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule) error {
+	exports := cm.ExportedFunctions()
+	var names []string
+	for name := range exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var m api.Module
+	defer func() {
+		if m != nil {
+			m.Close(ctx)
+		}
+	}()
+	for _, name := range names {
+		def := exports[name]
+		fmt.Print(signature(def))
+		if len(def.ParamTypes()) > 0 || name == "_initialize" {
+			fmt.Println()
+			continue
+		}
+		var err error
+		m, err = reset(ctx, r, cm, m, config)
+		if err != nil {
+			return err
+		}
+		if _, err := callExport(ctx, m.ExportedFunction("_initialize")); err != nil {
+			return fmt.Errorf("_initialize: %v", err)
+		}
+		guestStderr.Reset()
+		res, err := callExport(ctx, m.ExportedFunction(name))
+		if err != nil {
+			fmt.Printf(": %v%s\n", strings.SplitN(err.Error(), "\n", 2)[0], failure(guestStderr.String()))
+			continue
+		}
+		var vals []string
+		for i, v := range res {
+			vals = append(vals, formatValue(def.ResultTypes()[i], v))
+		}
+		fmt.Printf(" = %s\n", strings.Join(vals, ", "))
+	}
+	return nil
+}
+
+// failure returns the line in which the guest runtime reported a
+// failure in its output out, if any, to follow the error of a call.
+func failure(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "panic: ") || strings.HasPrefix(line, "fatal error: ") {
+			return " after " + line
+		}
+	}
+	return ""
+}
+
+// signature returns the Wasm signature of def, as name(params) results.
+func signature(def api.FunctionDefinition) string {
+	return def.ExportNames()[0] + funcType(def)
+}
+
+// funcType returns the Wasm type of def, as (params) results.
+func funcType(def api.FunctionDefinition) string {
+	names := func(types []api.ValueType) string {
+		var s []string
+		for _, t := range types {
+			s = append(s, api.ValueTypeName(t))
+		}
+		return strings.Join(s, ", ")
+	}
+	sig := "(" + names(def.ParamTypes()) + ")"
+	switch res := def.ResultTypes(); len(res) {
+	case 0:
+	case 1:
+		sig += " " + names(res)
+	default:
+		sig += " (" + names(res) + ")"
+	}
+	return sig
+}
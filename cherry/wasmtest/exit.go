@@ -0,0 +1,85 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// The exit scenario has testprog exit with each of exitCodes, through
+// WASI's proc_exit, and checks that the host sees the same code: an
+// executable exits from main when its last arguments are "exit
+// <code>", and a library from its Exit export (see testprog/exit.go).
+//
+// In executable mode, the driver then exits with the code of the
+// module, if it is not 0.
+
+var exitCodes = []int{0, 1, 2, 3, 125, 255}
+
+// checkExitCodes has a fresh instance of the compiled module cm, an
+// executable, or a library if library is set, exit with each of
+// exitCodes, and checks the code of the resulting sys.ExitError.
+func checkExitCodes(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule, library bool) error {
+	for _, code := range exitCodes {
+		var err error
+		if library {
+			err = exitLibrary(ctx, r, config, cm, code)
+		} else {
+			m, rerr := reset(ctx, r, cm, nil, config.WithArgs(slices.Concat(guestArgs, []string{"exit", strconv.Itoa(code)})...))
+			if rerr != nil {
+				return rerr
+			}
+			_, err = callExport(ctx, m.ExportedFunction("_start"))
+		}
+		var exit *sys.ExitError
+		switch {
+		case err == nil:
+			return fmt.Errorf("exit %d: returned, want an exit", code)
+		case !errors.As(err, &exit):
+			return fmt.Errorf("exit %d: %v, want an exit", code, err)
+		case exit.ExitCode() != uint32(code):
+			return fmt.Errorf("exit %d: exited with code %d", code, exit.ExitCode())
+		}
+	}
+	fmt.Printf("host: exit codes %v OK\n", exitCodes)
+	return nil
+}
+
+// exitLibrary calls the Exit export of a fresh instance of the library
+// module cm with code, and returns the error it ends with.
+func exitLibrary(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule, code int) error {
+	m, err := reset(ctx, r, cm, nil, config)
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+	if _, err := callExport(ctx, m.ExportedFunction("_initialize")); err != nil {
+		return fmt.Errorf("_initialize: %v", err)
+	}
+	_, err = callExport(ctx, m.ExportedFunction("Exit"), api.EncodeI32(int32(code)))
+	return err
+}
+
+// propagateExit exits the driver with the exit code of the executable
+// or GOOS=js module that ended with err, if it is not 0.
+func propagateExit(err error) {
+	var exit *sys.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() != 0 {
+		if !*verbose {
+			os.Stderr.Write(errbuf.Bytes())
+		}
+		fmt.Printf("module exited with code %d\n", exit.ExitCode())
+		os.Exit(int(exit.ExitCode()))
+	}
+}
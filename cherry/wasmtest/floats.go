@@ -0,0 +1,138 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// The floats scenario passes floating-point edge cases to the float
+// exports of testprog (see testprog/float.go), which echo them through
+// the host's EchoF32 and EchoF64 imports, convert them and negate
+// them. Values that cross the boundary, in either direction, must keep
+// their exact bits, NaN payloads and signs of zeros included.
+// Conversions of non-NaN values must round as the host's do. WebAssembly
+// leaves the payload of a converted NaN unspecified, so the result of
+// converting a NaN need only be a quiet NaN.
+
+var (
+	// convF32 and convF64 add to edgeF32 and edgeF64 values that
+	// convert or round in interesting ways.
+	convF32 = []uint32{
+		0x80000001, 0x807fffff, 0x00800000, // subnormals and the smallest normal
+		0x7fc12345, 0xffc00001, 0x7f812345, // NaNs with payloads
+		math.Float32bits(1), math.Float32bits(-1), math.Float32bits(0.1),
+	}
+	convF64 = []uint64{
+		0x8000000000000001,                                         // -smallest subnormal
+		0x7ff8000000012345, 0xfff8000000000001, 0x7ff0000000012345, // NaNs with payloads
+		math.Float64bits(1e40), math.Float64bits(-1e40), // overflow float32
+		math.Float64bits(1e-46), math.Float64bits(-1e-46), // underflow float32, keeping the sign
+		math.Float64bits(0x1p-149), math.Float64bits(0x1p-150), math.Float64bits(0x1.8p-150), // float32 subnormal rounding
+		math.Float64bits(1 + 0x1p-24), math.Float64bits(1 + 0x3p-24), // ties to even
+		math.Float64bits(0x1.fffffefp127), // rounds to MaxFloat32
+		math.Float64bits(0.1),
+	}
+)
+
+// hostF32 and hostF64 hold the bits of the last argument of the EchoF32
+// and EchoF64 imports.
+var hostF32, hostF64 uint64
+
+// EchoF32 and EchoF64 are imported by the guest. They take the raw
+// stack, so that no conversion can touch the bits they record and
+// return.
+func EchoF32(ctx context.Context, stack []uint64) { hostF32 = uint64(uint32(stack[0])) }
+func EchoF64(ctx context.Context, stack []uint64) { hostF64 = stack[0] }
+
+// isQuietNaN32 and isQuietNaN64 report whether bits are those of a
+// NaN with the quiet bit set, as WebAssembly's arithmetic NaNs are.
+func isQuietNaN32(bits uint32) bool { return bits&0x7fc00000 == 0x7fc00000 }
+func isQuietNaN64(bits uint64) bool { return bits&0x7ff8000000000000 == 0x7ff8000000000000 }
+
+// checkFloats calls the float exports of m with the edge cases and
+// checks their results.
+func checkFloats(ctx context.Context, m api.Module) error {
+	call := func(name string, x uint64) (uint64, error) {
+		r, err := callExport(ctx, m.ExportedFunction(name), x)
+		if err != nil {
+			return 0, fmt.Errorf("%s(%#x): %v", name, x, err)
+		}
+		return r[0], nil
+	}
+
+	for _, x := range append(edgeF32[:len(edgeF32):len(edgeF32)], convF32...) {
+		for _, name := range []string{"EchoF32", "ViaHostF32"} {
+			got, err := call(name, uint64(x))
+			if err != nil {
+				return err
+			}
+			if uint32(got) != x {
+				return fmt.Errorf("%s(%#x) = %#x, want the same bits", name, x, uint32(got))
+			}
+			if name == "ViaHostF32" && hostF32 != uint64(x) {
+				return fmt.Errorf("%s(%#x) passed %#x to the host", name, x, hostF32)
+			}
+		}
+
+		got, err := call("Widen", uint64(x))
+		if err != nil {
+			return err
+		}
+		f := math.Float32frombits(x)
+		switch want := math.Float64bits(float64(f)); {
+		case f != f:
+			if !isQuietNaN64(got) {
+				return fmt.Errorf("Widen(%#x) = %#x, want a quiet NaN", x, got)
+			}
+		case got != want:
+			return fmt.Errorf("Widen(%#x) = %#x, want %#x", x, got, want)
+		}
+	}
+
+	for _, x := range append(edgeF64[:len(edgeF64):len(edgeF64)], convF64...) {
+		for _, name := range []string{"EchoF64", "ViaHostF64"} {
+			got, err := call(name, x)
+			if err != nil {
+				return err
+			}
+			if got != x {
+				return fmt.Errorf("%s(%#x) = %#x, want the same bits", name, x, got)
+			}
+			if name == "ViaHostF64" && hostF64 != x {
+				return fmt.Errorf("%s(%#x) passed %#x to the host", name, x, hostF64)
+			}
+		}
+
+		got, err := call("Narrow", x)
+		if err != nil {
+			return err
+		}
+		f := math.Float64frombits(x)
+		switch want := math.Float32bits(float32(f)); {
+		case f != f:
+			if !isQuietNaN32(uint32(got)) {
+				return fmt.Errorf("Narrow(%#x) = %#x, want a quiet NaN", x, uint32(got))
+			}
+		case uint32(got) != want:
+			return fmt.Errorf("Narrow(%#x) = %#x, want %#x", x, uint32(got), want)
+		}
+
+		// Negation flips the sign bit alone, even of a NaN.
+		got, err = call("Neg", x)
+		if err != nil {
+			return err
+		}
+		if want := x ^ 1<<63; got != want {
+			return fmt.Errorf("Neg(%#x) = %#x, want %#x", x, got, want)
+		}
+	}
+	fmt.Println("host: float edge cases OK")
+	return nil
+}
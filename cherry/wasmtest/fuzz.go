@@ -0,0 +1,166 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// With -fuzz, the driver calls the echo exports of testprog (see
+// testprog/echo.go), including those that take many arguments, with
+// random arguments, about a quarter of them
+// edge cases: zeros, extremes, infinities and NaNs, and checks that
+// the results have the exact bits expected, NaN payloads included.
+
+var (
+	edgeI32 = []int32{0, 1, -1, math.MinInt32, math.MaxInt32}
+	edgeI64 = []int64{0, 1, -1, math.MinInt64, math.MaxInt64, math.MinInt32, math.MaxInt32 + 1}
+	edgeF32 = []uint32{
+		0, 1 << 31, // ±0
+		0x7f800000, 0xff800000, // ±Inf
+		0x7fc00000, 0xffc00000, 0x7f800001, 0x7fbfffff, // quiet and signaling NaNs
+		math.Float32bits(math.MaxFloat32), math.Float32bits(math.SmallestNonzeroFloat32),
+	}
+	edgeF64 = []uint64{
+		0, 1 << 63,
+		0x7ff0000000000000, 0xfff0000000000000,
+		0x7ff8000000000000, 0xfff8000000000000, 0x7ff0000000000001, 0x7ff7ffffffffffff,
+		math.Float64bits(math.MaxFloat64), math.Float64bits(math.SmallestNonzeroFloat64),
+	}
+)
+
+// A fuzzer generates the arguments.
+type fuzzer struct {
+	r *rand.Rand
+}
+
+func pick[T any](f fuzzer, edge []T, random func() T) T {
+	if f.r.IntN(4) == 0 {
+		return edge[f.r.IntN(len(edge))]
+	}
+	return random()
+}
+
+func (f fuzzer) i32() int32 { return pick(f, edgeI32, func() int32 { return int32(f.r.Uint32()) }) }
+func (f fuzzer) i64() int64 { return pick(f, edgeI64, func() int64 { return int64(f.r.Uint64()) }) }
+
+// f32 and f64 return the bits of a floating-point value.
+func (f fuzzer) f32() uint32 { return pick(f, edgeF32, f.r.Uint32) }
+func (f fuzzer) f64() uint64 { return pick(f, edgeF64, f.r.Uint64) }
+
+// spillTypes are the types of the arguments of SpillSum. Spill takes
+// all but the last, then the index of the argument to return.
+var spillTypes = []api.ValueType{
+	api.ValueTypeI32, api.ValueTypeF32, api.ValueTypeI64, api.ValueTypeF64,
+	api.ValueTypeI32, api.ValueTypeF32, api.ValueTypeI64, api.ValueTypeF64,
+	api.ValueTypeI32, api.ValueTypeF32, api.ValueTypeI64, api.ValueTypeF64,
+	api.ValueTypeI32, api.ValueTypeF32, api.ValueTypeI64, api.ValueTypeF64,
+}
+
+// checkSpill calls Spill with arguments from f, to return each of
+// them in turn, and SpillSum, and checks their results. These exports
+// take as many arguments as a wasmexport function can, so that some
+// are passed on the stack (see testprog/echo.go).
+func checkSpill(ctx context.Context, m api.Module, f fuzzer) error {
+	args := make([]uint64, len(spillTypes))
+	bits := make([]uint64, len(spillTypes)) // the bits that Spill returns
+	var sum float64
+	for i, t := range spillTypes {
+		switch t {
+		case api.ValueTypeI32:
+			x := f.i32()
+			args[i], bits[i] = api.EncodeI32(x), uint64(uint32(x))
+			sum += float64(x)
+		case api.ValueTypeI64:
+			x := f.i64()
+			args[i], bits[i] = api.EncodeI64(x), uint64(x)
+			sum += float64(x)
+		case api.ValueTypeF32:
+			x := f.f32()
+			args[i], bits[i] = uint64(x), uint64(x)
+			sum += float64(math.Float32frombits(x))
+		case api.ValueTypeF64:
+			x := f.f64()
+			args[i], bits[i] = x, x
+			sum += math.Float64frombits(x)
+		}
+	}
+
+	spill, spillSum := m.ExportedFunction("Spill"), m.ExportedFunction("SpillSum")
+	for sel := range len(spillTypes) - 1 {
+		res, err := callExport(ctx, spill, append(args[:len(args)-1:len(args)-1], api.EncodeI32(int32(sel)))...)
+		if err != nil {
+			return fmt.Errorf("Spill(%#x, %d): %v", args[:len(args)-1], sel, err)
+		}
+		if res[0] != bits[sel] {
+			return fmt.Errorf("Spill(%#x, %d) = %#x, want %#x", args[:len(args)-1], sel, res[0], bits[sel])
+		}
+	}
+	res, err := callExport(ctx, spillSum, args...)
+	if err != nil {
+		return fmt.Errorf("SpillSum(%#x): %v", args, err)
+	}
+	// Which NaN an operation returns varies between implementations.
+	if got := api.DecodeF64(res[0]); got != sum && !(math.IsNaN(got) && math.IsNaN(sum)) {
+		return fmt.Errorf("SpillSum(%#x) = %v, want %v", args, got, sum)
+	}
+	return nil
+}
+
+// fuzz calls the echo exports of the library module m n times each,
+// with arguments generated from seed, and reports the first result
+// that differs from what was expected.
+func fuzz(ctx context.Context, m api.Module, n int, seed uint64) error {
+	f := fuzzer{rand.New(rand.NewPCG(seed, seed))}
+	call := func(name string, args ...uint64) (uint64, error) {
+		res, err := callExport(ctx, m.ExportedFunction(name), args...)
+		if err != nil {
+			return 0, fmt.Errorf("%s(%#x): %v", name, args, err)
+		}
+		return res[0], nil
+	}
+	check := func(name string, args []uint64, got, want uint64) error {
+		if got != want {
+			return fmt.Errorf("%s(%#x) = %#x, want %#x", name, args, got, want)
+		}
+		return nil
+	}
+	for range n {
+		a, b, c, d := f.i64(), f.i32(), f.f64(), f.f32()
+		// The exports, their arguments and the bits of the results
+		// expected.
+		calls := []struct {
+			name string
+			args []uint64
+			want uint64
+		}{
+			{"EchoI32", []uint64{api.EncodeI32(b)}, api.EncodeI32(b)},
+			{"EchoI64", []uint64{api.EncodeI64(a)}, api.EncodeI64(a)},
+			{"EchoF32", []uint64{uint64(d)}, uint64(d)},
+			{"EchoF64", []uint64{c}, c},
+			{"Mix", []uint64{api.EncodeI64(a), api.EncodeI32(b), c, uint64(d)},
+				uint64(a ^ int64(b)<<32 ^ int64(c) ^ int64(d)<<16)},
+		}
+		for _, k := range calls {
+			got, err := call(k.name, k.args...)
+			if err != nil {
+				return err
+			}
+			if err := check(k.name, k.args, got, k.want); err != nil {
+				return err
+			}
+		}
+		if err := checkSpill(ctx, m, f); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("host: %d rounds of echo calls with seed %d OK\n", n, seed)
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// checkGC calls the gc exports of testprog (see testprog/gc.go) for
+// rounds rounds, each allocating garbage and objects with finalizers,
+// then collecting, and checks that the live heap stays the same size
+// from round to round, that the memory, which the first rounds may
+// grow while the GC paces itself, then does too, and that the
+// finalizers of each round have run by the next.
+//
+// The rounds allocate little in all: with wazero's compiler, after
+// some 20 MB of allocations, the guest runtime fails with "fatal error:
+// s.allocCount != s.nelems && freeIndex == s.nelems", even in a plain
+// executable, which it does not with -engine interpreter.
+func checkGC(g guestMem, rounds int) error {
+	const (
+		churn   = 1 << 20
+		tracked = 1000
+	)
+	var heap0 uint64
+	var pages0 uint32
+	// The scenario may have run before in the same instance.
+	fin0 := api.DecodeI32(g.call("Finalized"))
+	for i := range rounds {
+		g.call("Churn", api.EncodeI32(churn))
+		g.call("Track", api.EncodeI32(tracked))
+		heap := g.call("Collect")
+		fin := api.DecodeI32(g.call("Finalized")) - fin0
+		pages := memPages(g.m)
+		fmt.Printf("host: round %d: %d bytes live heap, %d pages, %d finalized\n", i, heap, pages, fin)
+		switch {
+		case i == 0:
+			heap0 = heap
+			continue
+		case i == 1:
+			pages0 = pages
+		case pages != pages0:
+			return fmt.Errorf("memory grew from %d to %d pages after %d rounds", pages0, pages, i)
+		}
+		if heap > 2*heap0 {
+			return fmt.Errorf("live heap grew from %d to %d bytes after %d rounds", heap0, heap, i)
+		}
+		if want := int32(i * tracked); fin < want {
+			return fmt.Errorf("%d finalizers run after %d rounds, want at least %d", fin, i+1, want)
+		}
+	}
+	g.call("Collect")
+	if fin, want := api.DecodeI32(g.call("Finalized"))-fin0, int32(rounds*tracked); fin != want {
+		return fmt.Errorf("%d finalizers run after a final GC, want %d", fin, want)
+	}
+	fmt.Println("host: gc and finalizers OK")
+	return nil
+}
@@ -0,0 +1,693 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// A GOOS=js module imports the functions of its runtime and of
+// syscall/js from the "gojs" module, which wasm_exec.js provides in
+// browsers and Node.js. jsHost provides them on top of wazero, with a
+// minimal model of JavaScript values: enough of globalThis for the
+// runtime and the syscall and os packages to initialize and to write
+// to standard output and error, which they do through fs.write.
+//
+// Like in wasm_exec.js, each function takes the Go stack pointer as
+// its only argument and finds its arguments and results on the stack,
+// and the module is run by calling its run export, then resume for
+// every event, here only timeouts, until it exits.
+
+// A JavaScript value is nil (null), jsUndefined, a float64, a bool,
+// a string, or one of the pointer types below.
+type jsUndefinedType struct{}
+
+var jsUndefined any = jsUndefinedType{}
+
+// A jsObject is an object, or a function if call is set.
+type jsObject struct {
+	props     map[string]any
+	call      func(this any, args []any) (result any, thrown *jsObject)
+	construct func(args []any) (result any, thrown *jsObject)
+}
+
+// A jsArray is an Array, such as the arguments of a call.
+type jsArray struct{ elems []any }
+
+// A jsBytes is a Uint8Array.
+type jsBytes struct{ b []byte }
+
+func jsFunc(f func(this any, args []any) (any, *jsObject)) *jsObject {
+	return &jsObject{props: map[string]any{}, call: f}
+}
+
+// jsError returns an Error, as thrown or passed to callbacks by
+// Node.js, with the given errno code.
+func jsError(code, msg string) *jsObject {
+	return &jsObject{props: map[string]any{"code": code, "message": msg}}
+}
+
+func enosys() *jsObject { return jsError("ENOSYS", "not implemented") }
+
+// jsString returns the result of String(v).
+func jsString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case jsUndefinedType:
+		return "undefined"
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case *jsObject:
+		if msg, ok := v.props["message"].(string); ok {
+			return "Error: " + msg
+		}
+		if v.call != nil {
+			return "function"
+		}
+	case *jsArray:
+		s := make([]string, len(v.elems))
+		for i, e := range v.elems {
+			s[i] = jsString(e)
+		}
+		return strings.Join(s, ",")
+	}
+	return "[object Object]"
+}
+
+func jsInt(v any) int {
+	f, _ := v.(float64)
+	return int(f)
+}
+
+// The reference ids of the values predefined by syscall/js.
+const (
+	jsNaNRef = iota
+	jsZeroRef
+	jsNullRef
+	jsTrueRef
+	jsFalseRef
+	jsGlobalRef
+	jsGoRef
+)
+
+// nanHead is the high half of a reference that is not a number: the
+// value is a NaN whose payload holds the type and the id.
+const nanHead = 0x7ff80000
+
+// jsHost is the JavaScript side of a GOOS=js module.
+type jsHost struct {
+	stdout, stderr io.Writer
+
+	values []any          // values the module refers to, by id
+	refs   []int          // number of references to values[id]; -1 for predefined values
+	ids    map[any]uint32 // ids of values
+	idPool []uint32       // unused ids
+
+	global *jsObject
+	goObj  *jsObject // the Go class instance, which holds the pending event
+
+	timeouts      map[int32]time.Time // scheduled timeout events
+	nextTimeoutID int32
+}
+
+func newJSHost(stdout, stderr io.Writer) *jsHost {
+	h := &jsHost{
+		stdout:        stdout,
+		stderr:        stderr,
+		ids:           make(map[any]uint32),
+		timeouts:      make(map[int32]time.Time),
+		nextTimeoutID: 1,
+	}
+	h.goObj = &jsObject{props: map[string]any{"_pendingEvent": nil}}
+	h.global = &jsObject{props: map[string]any{}}
+	h.values = []any{math.NaN(), float64(0), nil, true, false, h.global, h.goObj}
+	for id, v := range h.values {
+		h.refs = append(h.refs, -1)
+		if id != jsNaNRef {
+			h.ids[v] = uint32(id)
+		}
+	}
+	return h
+}
+
+// usesGoJS reports whether the module cm is a GOOS=js module.
+func usesGoJS(cm wazero.CompiledModule) bool {
+	for _, def := range cm.ImportedFunctions() {
+		if mod, _, _ := def.Import(); mod == "gojs" {
+			return true
+		}
+	}
+	return false
+}
+
+// initGlobals sets up globalThis for the module m: the objects that
+// wasm_exec.js provides or requires, as far as Go's packages use them.
+func (h *jsHost) initGlobals(ctx context.Context, m api.Module) {
+	g := h.global.props
+	g["globalThis"] = h.global
+
+	constants := map[string]any{}
+	for _, c := range []string{"O_WRONLY", "O_RDWR", "O_CREAT", "O_TRUNC", "O_APPEND", "O_EXCL", "O_DIRECTORY"} {
+		constants[c] = float64(-1) // unused
+	}
+	fs := &jsObject{props: map[string]any{"constants": &jsObject{props: constants}}}
+	write := func(args []any) (float64, *jsObject) {
+		if len(args) < 2 {
+			return 0, jsError("EINVAL", "invalid argument")
+		}
+		b, ok := args[1].(*jsBytes)
+		if !ok {
+			return 0, jsError("EINVAL", "invalid argument")
+		}
+		w := h.stdout
+		if jsInt(args[0]) == 2 {
+			w = h.stderr
+		}
+		n, err := w.Write(b.b)
+		if err != nil {
+			return 0, jsError("EIO", err.Error())
+		}
+		return float64(n), nil
+	}
+	fs.props["writeSync"] = jsFunc(func(this any, args []any) (any, *jsObject) {
+		return write(args)
+	})
+	// The asynchronous functions call their callback, the last
+	// argument, before returning.
+	callback := func(args []any, res ...any) (any, *jsObject) {
+		if len(args) == 0 {
+			return nil, jsError("EINVAL", "no callback")
+		}
+		cb, ok := args[len(args)-1].(*jsObject)
+		if !ok || cb.call == nil {
+			return nil, jsError("EINVAL", "callback is not a function")
+		}
+		return cb.call(jsUndefined, res)
+	}
+	fs.props["write"] = jsFunc(func(this any, args []any) (any, *jsObject) {
+		if len(args) != 6 || jsInt(args[2]) != 0 || args[4] != nil {
+			return callback(args, enosys())
+		}
+		n, err := write(args)
+		if err != nil {
+			return callback(args, err)
+		}
+		return callback(args, nil, n)
+	})
+	fs.props["fsync"] = jsFunc(func(this any, args []any) (any, *jsObject) {
+		return callback(args, nil)
+	})
+	for _, name := range []string{
+		"chmod", "chown", "close", "fchmod", "fchown", "fstat", "ftruncate", "lchown", "link", "lstat",
+		"mkdir", "open", "read", "readdir", "readlink", "rename", "rmdir", "stat", "symlink", "truncate", "unlink", "utimes",
+	} {
+		fs.props[name] = jsFunc(func(this any, args []any) (any, *jsObject) {
+			return callback(args, enosys())
+		})
+	}
+	g["fs"] = fs
+
+	minusOne := jsFunc(func(this any, args []any) (any, *jsObject) { return float64(-1), nil })
+	throw := jsFunc(func(this any, args []any) (any, *jsObject) { return nil, enosys() })
+	g["process"] = &jsObject{props: map[string]any{
+		"getuid": minusOne, "getgid": minusOne, "geteuid": minusOne, "getegid": minusOne,
+		"getgroups": throw, "umask": throw, "cwd": throw, "chdir": throw,
+		"pid": float64(-1), "ppid": float64(-1),
+	}}
+	g["path"] = &jsObject{props: map[string]any{
+		"resolve": jsFunc(func(this any, args []any) (any, *jsObject) {
+			s := make([]string, len(args))
+			for i, a := range args {
+				s[i] = jsString(a)
+			}
+			return strings.Join(s, "/"), nil
+		}),
+	}}
+
+	logTo := func(w io.Writer) *jsObject {
+		return jsFunc(func(this any, args []any) (any, *jsObject) {
+			s := make([]string, len(args))
+			for i, a := range args {
+				s[i] = jsString(a)
+			}
+			fmt.Fprintln(w, strings.Join(s, " "))
+			return jsUndefined, nil
+		})
+	}
+	g["console"] = &jsObject{props: map[string]any{
+		"log": logTo(h.stdout), "warn": logTo(h.stderr), "error": logTo(h.stderr),
+	}}
+
+	g["Object"] = &jsObject{props: map[string]any{}, construct: func(args []any) (any, *jsObject) {
+		return &jsObject{props: map[string]any{}}, nil
+	}}
+	g["Array"] = &jsObject{props: map[string]any{}, construct: func(args []any) (any, *jsObject) {
+		return &jsArray{}, nil
+	}}
+	g["Uint8Array"] = &jsObject{props: map[string]any{}, construct: func(args []any) (any, *jsObject) {
+		if len(args) != 1 {
+			return nil, enosys()
+		}
+		return &jsBytes{make([]byte, jsInt(args[0]))}, nil
+	}}
+	g["Date"] = &jsObject{props: map[string]any{}, construct: func(args []any) (any, *jsObject) {
+		return &jsObject{props: map[string]any{
+			"getTimezoneOffset": jsFunc(func(this any, args []any) (any, *jsObject) { return float64(0), nil }),
+		}}, nil
+	}}
+
+	// _makeFuncWrapper returns the JavaScript function that calls the
+	// js.Func with the given id: it records the call as the pending
+	// event and resumes the module, which handles it.
+	h.goObj.props["_makeFuncWrapper"] = jsFunc(func(this any, args []any) (any, *jsObject) {
+		id := args[0]
+		return jsFunc(func(this any, args []any) (any, *jsObject) {
+			event := &jsObject{props: map[string]any{"id": id, "this": this, "args": &jsArray{args}}}
+			h.goObj.props["_pendingEvent"] = event
+			if _, err := callExport(ctx, m.ExportedFunction("resume")); err != nil {
+				panic(err)
+			}
+			return event.props["result"], nil
+		}), nil
+	})
+}
+
+// jsGet returns v[name].
+func jsGet(v any, name string) any {
+	switch v := v.(type) {
+	case *jsObject:
+		if p, ok := v.props[name]; ok {
+			return p
+		}
+	case *jsArray:
+		if name == "length" {
+			return float64(len(v.elems))
+		}
+	case *jsBytes:
+		if name == "length" {
+			return float64(len(v.b))
+		}
+	case string:
+		if name == "length" {
+			return float64(len(v))
+		}
+	}
+	return jsUndefined
+}
+
+// Accessors for the memory of the module m, which panic if out of range.
+type jsMem struct{ m api.Memory }
+
+func (mem jsMem) getInt32(addr uint32) int32 {
+	v, ok := mem.m.ReadUint32Le(addr)
+	if !ok {
+		panic(fmt.Sprintf("gojs: reading at %#x out of range", addr))
+	}
+	return int32(v)
+}
+
+func (mem jsMem) getInt64(addr uint32) int64 {
+	v, ok := mem.m.ReadUint64Le(addr)
+	if !ok {
+		panic(fmt.Sprintf("gojs: reading at %#x out of range", addr))
+	}
+	return int64(v)
+}
+
+func (mem jsMem) setInt32(addr uint32, v int32) {
+	if !mem.m.WriteUint32Le(addr, uint32(v)) {
+		panic(fmt.Sprintf("gojs: writing at %#x out of range", addr))
+	}
+}
+
+func (mem jsMem) setInt64(addr uint32, v int64) {
+	if !mem.m.WriteUint64Le(addr, uint64(v)) {
+		panic(fmt.Sprintf("gojs: writing at %#x out of range", addr))
+	}
+}
+
+func (mem jsMem) setBool(addr uint32, v bool) {
+	b := byte(0)
+	if v {
+		b = 1
+	}
+	if !mem.m.WriteByte(addr, b) {
+		panic(fmt.Sprintf("gojs: writing at %#x out of range", addr))
+	}
+}
+
+// slice returns a view of the Go slice at addr.
+func (mem jsMem) slice(addr uint32) []byte {
+	p, n := mem.getInt64(addr), mem.getInt64(addr+8)
+	b, ok := mem.m.Read(uint32(p), uint32(n))
+	if !ok {
+		panic(fmt.Sprintf("gojs: slice [%#x, %#x) out of range", p, p+n))
+	}
+	return b
+}
+
+func (mem jsMem) string(addr uint32) string {
+	return string(mem.slice(addr))
+}
+
+func (h *jsHost) loadValue(mem jsMem, addr uint32) any {
+	bits := uint64(mem.getInt64(addr))
+	f := math.Float64frombits(bits)
+	if f == 0 {
+		return jsUndefined
+	}
+	if !math.IsNaN(f) {
+		return f
+	}
+	return h.values[uint32(bits)]
+}
+
+func (h *jsHost) loadValues(mem jsMem, addr uint32) []any {
+	p, n := uint32(mem.getInt64(addr)), uint32(mem.getInt64(addr+8))
+	vs := make([]any, n)
+	for i := range n {
+		vs[i] = h.loadValue(mem, p+8*i)
+	}
+	return vs
+}
+
+func (h *jsHost) storeValue(mem jsMem, addr uint32, v any) {
+	if f, ok := v.(float64); ok && f != 0 {
+		if math.IsNaN(f) {
+			mem.setInt64(addr, nanHead<<32)
+		} else {
+			mem.setInt64(addr, int64(math.Float64bits(f)))
+		}
+		return
+	}
+	if v == jsUndefined {
+		mem.setInt64(addr, 0)
+		return
+	}
+	id, ok := h.ids[v]
+	if !ok {
+		if n := len(h.idPool); n > 0 {
+			id = h.idPool[n-1]
+			h.idPool = h.idPool[:n-1]
+			h.values[id], h.refs[id] = v, 0
+		} else {
+			id = uint32(len(h.values))
+			h.values = append(h.values, v)
+			h.refs = append(h.refs, 0)
+		}
+		h.ids[v] = id
+	}
+	if h.refs[id] >= 0 {
+		h.refs[id]++
+	}
+	typeFlag := int64(0)
+	switch v := v.(type) {
+	case *jsObject:
+		typeFlag = 1
+		if v.call != nil {
+			typeFlag = 4
+		}
+	case *jsArray, *jsBytes:
+		typeFlag = 1
+	case string:
+		typeFlag = 2
+	}
+	mem.setInt64(addr, (nanHead|typeFlag)<<32|int64(id))
+}
+
+// call calls f with this and args, as Reflect.apply does.
+func jsCall(f, this any, args []any) (any, *jsObject) {
+	fn, ok := f.(*jsObject)
+	if !ok || fn.call == nil {
+		return nil, jsError("", jsString(f)+" is not a function")
+	}
+	return fn.call(this, args)
+}
+
+// instantiate instantiates the "gojs" host module in r.
+func (h *jsHost) instantiate(ctx context.Context, r wazero.Runtime) error {
+	b := r.NewHostModuleBuilder("gojs")
+	export := func(name string, f func(ctx context.Context, m api.Module, mem jsMem, sp uint32)) {
+		b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, sp uint32) {
+			f(ctx, m, jsMem{m.Memory()}, sp)
+		}).Export(name)
+	}
+	// getsp returns the stack pointer after a call that may have run
+	// Go code, which may have moved the stack.
+	getsp := func(ctx context.Context, m api.Module) uint32 {
+		res, err := callExport(ctx, m.ExportedFunction("getsp"))
+		if err != nil {
+			panic(err)
+		}
+		return api.DecodeU32(res[0])
+	}
+
+	export("runtime.wasmExit", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		code := uint32(mem.getInt32(sp + 8))
+		_ = m.CloseWithExitCode(ctx, code)
+		panic(sys.NewExitError(code))
+	})
+	export("runtime.wasmWrite", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		fd, p, n := mem.getInt64(sp+8), mem.getInt64(sp+16), mem.getInt32(sp+24)
+		b, ok := m.Memory().Read(uint32(p), uint32(n))
+		if !ok {
+			panic("gojs: wasmWrite out of range")
+		}
+		w := h.stdout
+		if fd == 2 {
+			w = h.stderr
+		}
+		w.Write(b)
+	})
+	export("runtime.resetMemoryDataView", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {})
+	export("runtime.nanotime1", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		mem.setInt64(sp+8, clk.nanotime())
+	})
+	export("runtime.walltime", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		now := clk.now()
+		mem.setInt64(sp+8, now.Unix())
+		mem.setInt32(sp+16, int32(now.Nanosecond()))
+	})
+	export("runtime.scheduleTimeoutEvent", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		id := h.nextTimeoutID
+		h.nextTimeoutID++
+		h.timeouts[id] = clk.now().Add(time.Duration(mem.getInt64(sp+8)) * time.Millisecond)
+		mem.setInt32(sp+16, id)
+	})
+	export("runtime.clearTimeoutEvent", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		delete(h.timeouts, mem.getInt32(sp+8))
+	})
+	export("runtime.getRandomData", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		io.ReadFull(clk.rand, mem.slice(sp+8))
+	})
+	export("syscall/js.finalizeRef", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		id := uint32(mem.getInt32(sp + 8))
+		if h.refs[id] < 0 {
+			return
+		}
+		if h.refs[id]--; h.refs[id] == 0 {
+			delete(h.ids, h.values[id])
+			h.values[id] = nil
+			h.idPool = append(h.idPool, id)
+		}
+	})
+	export("syscall/js.stringVal", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		h.storeValue(mem, sp+24, mem.string(sp+8))
+	})
+	export("syscall/js.valueGet", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		v := jsGet(h.loadValue(mem, sp+8), mem.string(sp+16))
+		sp = getsp(ctx, m)
+		h.storeValue(mem, sp+32, v)
+	})
+	export("syscall/js.valueSet", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		if o, ok := h.loadValue(mem, sp+8).(*jsObject); ok {
+			o.props[mem.string(sp+16)] = h.loadValue(mem, sp+32)
+		}
+	})
+	export("syscall/js.valueDelete", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		if o, ok := h.loadValue(mem, sp+8).(*jsObject); ok {
+			delete(o.props, mem.string(sp+16))
+		}
+	})
+	export("syscall/js.valueIndex", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		var v any = jsUndefined
+		i := mem.getInt64(sp + 16)
+		switch a := h.loadValue(mem, sp+8).(type) {
+		case *jsArray:
+			if i >= 0 && i < int64(len(a.elems)) {
+				v = a.elems[i]
+			}
+		case *jsBytes:
+			if i >= 0 && i < int64(len(a.b)) {
+				v = float64(a.b[i])
+			}
+		}
+		h.storeValue(mem, sp+24, v)
+	})
+	export("syscall/js.valueSetIndex", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		i, x := mem.getInt64(sp+16), h.loadValue(mem, sp+24)
+		switch a := h.loadValue(mem, sp+8).(type) {
+		case *jsArray:
+			for int64(len(a.elems)) <= i {
+				a.elems = append(a.elems, jsUndefined)
+			}
+			a.elems[i] = x
+		case *jsBytes:
+			if i >= 0 && i < int64(len(a.b)) {
+				a.b[i] = byte(jsInt(x))
+			}
+		}
+	})
+	// callResult stores the result of a call, or the value it threw,
+	// at res, and whether it succeeded at res+8, after the call, which
+	// may have run Go code.
+	callResult := func(ctx context.Context, m api.Module, mem jsMem, res uint32, v any, thrown *jsObject) {
+		sp := getsp(ctx, m)
+		if thrown != nil {
+			h.storeValue(mem, sp+res, thrown)
+			mem.setBool(sp+res+8, false)
+			return
+		}
+		h.storeValue(mem, sp+res, v)
+		mem.setBool(sp+res+8, true)
+	}
+	export("syscall/js.valueCall", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		v := h.loadValue(mem, sp+8)
+		f := jsGet(v, mem.string(sp+16))
+		r, thrown := jsCall(f, v, h.loadValues(mem, sp+32))
+		callResult(ctx, m, mem, 56, r, thrown)
+	})
+	export("syscall/js.valueInvoke", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		r, thrown := jsCall(h.loadValue(mem, sp+8), jsUndefined, h.loadValues(mem, sp+16))
+		callResult(ctx, m, mem, 40, r, thrown)
+	})
+	export("syscall/js.valueNew", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		var r any
+		thrown := jsError("", "not a constructor")
+		if c, ok := h.loadValue(mem, sp+8).(*jsObject); ok && c.construct != nil {
+			r, thrown = c.construct(h.loadValues(mem, sp+16))
+		}
+		callResult(ctx, m, mem, 40, r, thrown)
+	})
+	export("syscall/js.valueLength", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		mem.setInt64(sp+16, int64(jsInt(jsGet(h.loadValue(mem, sp+8), "length"))))
+	})
+	export("syscall/js.valuePrepareString", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		s := &jsBytes{[]byte(jsString(h.loadValue(mem, sp+8)))}
+		h.storeValue(mem, sp+16, s)
+		mem.setInt64(sp+24, int64(len(s.b)))
+	})
+	export("syscall/js.valueLoadString", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		if s, ok := h.loadValue(mem, sp+8).(*jsBytes); ok {
+			copy(mem.slice(sp+16), s.b)
+		}
+	})
+	export("syscall/js.valueInstanceOf", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		_, isBytes := h.loadValue(mem, sp+8).(*jsBytes)
+		mem.setBool(sp+24, isBytes && h.loadValue(mem, sp+16) == h.global.props["Uint8Array"])
+	})
+	copyBytes := func(mem jsMem, sp uint32, dst, src []byte) {
+		n := copy(dst, src)
+		mem.setInt64(sp+40, int64(n))
+		mem.setBool(sp+48, true)
+	}
+	export("syscall/js.copyBytesToGo", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		src, ok := h.loadValue(mem, sp+32).(*jsBytes)
+		if !ok {
+			mem.setBool(sp+48, false)
+			return
+		}
+		copyBytes(mem, sp, mem.slice(sp+8), src.b)
+	})
+	export("syscall/js.copyBytesToJS", func(ctx context.Context, m api.Module, mem jsMem, sp uint32) {
+		dst, ok := h.loadValue(mem, sp+8).(*jsBytes)
+		if !ok {
+			mem.setBool(sp+48, false)
+			return
+		}
+		copyBytes(mem, sp, dst.b, mem.slice(sp+16))
+	})
+	b.NewFunctionBuilder().WithFunc(func(v int32) {
+		fmt.Fprintln(h.stderr, "gojs: debug", v)
+	}).Export("debug")
+
+	_, err := b.Instantiate(ctx)
+	return err
+}
+
+// run runs the module m like wasm_exec.js: it passes the arguments
+// args and the environment env, as KEY=value, in memory and calls the
+// run export, then calls resume whenever a scheduled timeout expires,
+// until the module exits. It returns the error that ended the module,
+// such as a *sys.ExitError.
+func (h *jsHost) run(ctx context.Context, m api.Module, args, env []string) error {
+	h.initGlobals(ctx, m)
+
+	// Write the NUL-terminated strings, each 8-byte aligned, then
+	// pointers to those of argv and of the environment, sorted by
+	// key, each list ending with 0, at the address wasm_exec.js uses,
+	// below the data of the module.
+	mem := jsMem{m.Memory()}
+	const argAddr, dataAddr = 4096, 12288
+	offset := uint32(argAddr)
+	var ptrs []uint32
+	env = slices.Clone(env)
+	sort.Strings(env)
+	for _, list := range [][]string{args, env} {
+		for _, s := range list {
+			if !m.Memory().Write(offset, append([]byte(s), 0)) {
+				return fmt.Errorf("gojs: writing arguments out of range")
+			}
+			ptrs = append(ptrs, offset)
+			offset = (offset + uint32(len(s)) + 1 + 7) &^ 7
+		}
+		ptrs = append(ptrs, 0)
+	}
+	argv := offset
+	for _, p := range ptrs {
+		mem.setInt64(offset, int64(p))
+		offset += 8
+	}
+	if offset >= dataAddr {
+		return fmt.Errorf("gojs: arguments and environment too long")
+	}
+
+	if _, err := callExport(ctx, m.ExportedFunction("run"), api.EncodeI32(int32(len(args))), api.EncodeU32(argv)); err != nil {
+		return err
+	}
+	for {
+		// The module is paused, waiting for an event.
+		var next int32
+		for id, t := range h.timeouts {
+			if next == 0 || t.Before(h.timeouts[next]) {
+				next = id
+			}
+		}
+		if next == 0 {
+			return fmt.Errorf("gojs: module paused with no pending event (deadlock)")
+		}
+		clk.sleep(h.timeouts[next].Sub(clk.now()))
+		if _, err := callExport(ctx, m.ExportedFunction("resume")); err != nil {
+			return err
+		}
+	}
+}
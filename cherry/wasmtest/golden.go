@@ -0,0 +1,130 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// With -golden, the driver runs itself again with the same arguments
+// but -golden and -update, and compares the combined output of the
+// run, in the order it was written, with a transcript. The host's
+// println output goes directly to the process's standard error, so
+// capturing it needs another process.
+
+// pcOffset matches the PC offsets in tracebacks, which change with
+// any change to the code.
+var pcOffset = regexp.MustCompile(`\+0x[0-9a-f]+`)
+
+// runGolden runs the driver and compares its output with the transcript
+// in file, or, if update is set, writes the output to file.
+func runGolden(file string, update bool) error {
+	cmd, err := selfCommand("golden", "update")
+	if err != nil {
+		return err
+	}
+	// A single writer for both makes the child share one pipe for
+	// them, which keeps their order.
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) {
+			return err
+		}
+		fmt.Fprintf(&out, "exit status %d\n", ee.ExitCode())
+	}
+	got := pcOffset.ReplaceAll(out.Bytes(), []byte("+0x?"))
+
+	if update {
+		return os.WriteFile(file, got, 0666)
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("output differs from %s (-want +got):\n%s", file, lineDiff(string(want), string(got)))
+	}
+	fmt.Println("output matches", file)
+	return nil
+}
+
+// selfCommand returns the command to run the driver again with the
+// flags of this run, but those in skip, and the same arguments.
+func selfCommand(skip ...string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if !slices.Contains(skip, f.Name) {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	args = append(args, flag.Args()...)
+	return exec.Command(exe, args...), nil
+}
+
+// lineDiff returns the runs of lines of want and got that differ,
+// each after the number of its first line in want, prefixed with - and
+// +. It matches the lines with a longest common subsequence, which is
+// quadratic, but transcripts are short.
+func lineDiff(want, got string) string {
+	w := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	g := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	// lcs[i][j] is the length of the longest common subsequence of
+	// w[i:] and g[j:].
+	lcs := make([][]int, len(w)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(g)+1)
+	}
+	for i := len(w) - 1; i >= 0; i-- {
+		for j := len(g) - 1; j >= 0; j-- {
+			if w[i] == g[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(w) || j < len(g) {
+		if i < len(w) && j < len(g) && w[i] == g[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		fmt.Fprintf(&b, "@@ line %d @@\n", i+1)
+		var added []string
+		for i < len(w) || j < len(g) {
+			if i < len(w) && j < len(g) && w[i] == g[j] {
+				break
+			}
+			if j == len(g) || i < len(w) && lcs[i+1][j] >= lcs[i][j+1] {
+				b.WriteString("-" + w[i] + "\n")
+				i++
+			} else {
+				added = append(added, g[j])
+				j++
+			}
+		}
+		for _, l := range added {
+			b.WriteString("+" + l + "\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// The host-errors scenario calls the CallHost exports of testprog (see
+// testprog/hosterr.go), which call host imports that fail in the ways
+// a wazero host function can: by panicking with a value, with an error,
+// or with a *sys.ExitError. Either way, the import call does not return
+// to the guest: the export call fails, with the error the import
+// panicked with if it is one, and the guest code after the import does
+// not run. The last import blocks until another goroutine of the host
+// sends it its result, which the guest must then get.
+
+// errHost is the error that the Fail import panics with.
+var errHost = errors.New("host import failed")
+
+const (
+	panicValue = "host import panicked"
+	blockValue = 41
+)
+
+func Panic()          { panic(panicValue) }
+func Fail()           { panic(errHost) }
+func Exit(code int32) { panic(sys.NewExitError(uint32(code))) }
+
+func Block() int64 {
+	ch := make(chan int64)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ch <- blockValue
+	}()
+	return <-ch
+}
+
+// checkHostErrors calls each CallHost export in a fresh instance of
+// the compiled library module cm, and checks how the call ends.
+func checkHostErrors(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule) error {
+	call := func(export string, args ...uint64) (api.Module, []uint64, error) {
+		m, err := reset(ctx, r, cm, nil, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := callExport(ctx, m.ExportedFunction("_initialize")); err != nil {
+			return nil, nil, fmt.Errorf("_initialize: %v", err)
+		}
+		res, err := callExport(ctx, m.ExportedFunction(export), args...)
+		return m, res, err
+	}
+	reached := func(m api.Module) (bool, error) {
+		res, err := callExport(ctx, m.ExportedFunction("Reached"))
+		if err != nil {
+			return false, fmt.Errorf("Reached: %v", err)
+		}
+		return res[0] != 0, nil
+	}
+
+	for _, t := range []struct {
+		export string
+		check  func(error) bool
+		want   string
+	}{
+		{"CallHostPanic", func(err error) bool { return strings.Contains(err.Error(), panicValue) }, "an error with the panic value"},
+		{"CallHostFail", func(err error) bool { return errors.Is(err, errHost) }, "errHost"},
+	} {
+		m, _, err := call(t.export)
+		if err == nil {
+			return fmt.Errorf("%s returned, want %s", t.export, t.want)
+		}
+		if !t.check(err) {
+			return fmt.Errorf("%s: %v, want %s", t.export, err, t.want)
+		}
+		fmt.Printf("host: %s: %s\n", t.export, strings.SplitN(err.Error(), "\n", 2)[0])
+		if ok, err := reached(m); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("%s: guest ran past the failed import", t.export)
+		}
+		m.Close(ctx)
+	}
+
+	const code = 7
+	_, _, err := call("CallHostExit", api.EncodeI32(code))
+	var exit *sys.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != code {
+		return fmt.Errorf("CallHostExit(%d): %v, want exit code %d", code, err, code)
+	}
+	fmt.Printf("host: CallHostExit: %v\n", err)
+
+	m, res, err := call("CallHostBlock")
+	if err != nil {
+		return fmt.Errorf("CallHostBlock: %v", err)
+	}
+	defer m.Close(ctx)
+	if got := int64(res[0]); got != blockValue+1 {
+		return fmt.Errorf("CallHostBlock = %d, want %d", got, blockValue+1)
+	}
+	if ok, err := reached(m); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("CallHostBlock: guest did not run past the import")
+	}
+	fmt.Println("host: CallHostBlock OK")
+	return nil
+}
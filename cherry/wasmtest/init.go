@@ -0,0 +1,120 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// The init scenario checks how a library module behaves around
+// _initialize, in fresh instances:
+//
+//   - _initialize may only be called once: the second call fails with
+//     "fatal error: randinit twice", and traps;
+//   - the runtime is initialized before the package init functions
+//     run, so an export called during _initialize, here from the host's
+//     Init import, which testprog calls from an init function (see
+//     testprog/initcall.go), works;
+//   - two instances of the module in one runtime each have their own
+//     runtime and heap: buffers allocated in one are not live in the
+//     other.
+
+// initHook, if set, is called by the Init import.
+var initHook func(ctx context.Context, m api.Module)
+
+func Init(ctx context.Context, m api.Module) {
+	if initHook != nil {
+		initHook(ctx, m)
+	}
+}
+
+// doubleInitOutput is what the runtime prints when _initialize is
+// called again.
+const doubleInitOutput = "fatal error: randinit twice"
+
+// checkInit runs the init scenario with the compiled library module cm.
+func checkInit(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule) error {
+	initialize := func(m api.Module) error {
+		_, err := callExport(ctx, m.ExportedFunction("_initialize"))
+		return err
+	}
+
+	var out bytes.Buffer
+	w := io.Writer(&out)
+	if *verbose {
+		w = io.MultiWriter(&out, os.Stderr)
+	}
+	m, err := reset(ctx, r, cm, nil, config.WithStdout(w).WithStderr(w))
+	if err != nil {
+		return err
+	}
+	if err := initialize(m); err != nil {
+		return fmt.Errorf("_initialize: %v", err)
+	}
+	err = initialize(m)
+	m.Close(ctx)
+	switch {
+	case err == nil:
+		return fmt.Errorf("second _initialize returned, want a trap")
+	case !strings.HasPrefix(err.Error(), "wasm error: unreachable"):
+		return fmt.Errorf("second _initialize: %v, want an unreachable trap", err)
+	case !strings.Contains(out.String(), doubleInitOutput):
+		return fmt.Errorf("second _initialize trapped, but output lacks %q", doubleInitOutput)
+	}
+	fmt.Println("host: second _initialize trapped")
+
+	var during []uint64
+	var duringErr error
+	initHook = func(ctx context.Context, m api.Module) {
+		during, duringErr = callExport(ctx, m.ExportedFunction("EchoI32"), api.EncodeI32(7))
+	}
+	m, err = reset(ctx, r, cm, nil, config)
+	if err == nil {
+		err = initialize(m)
+	}
+	initHook = nil
+	if err != nil {
+		return fmt.Errorf("_initialize calling EchoI32: %v", err)
+	}
+	m.Close(ctx)
+	if duringErr != nil {
+		return fmt.Errorf("EchoI32 during _initialize: %v", duringErr)
+	}
+	if during == nil {
+		return fmt.Errorf("_initialize did not call the Init import")
+	}
+	if got := api.DecodeI32(during[0]); got != 7 {
+		return fmt.Errorf("EchoI32(7) during _initialize = %d", got)
+	}
+	fmt.Println("host: export call during _initialize OK")
+
+	var g [2]guestMem
+	for i := range g {
+		m, err := reset(ctx, r, cm, nil, config)
+		if err != nil {
+			return err
+		}
+		defer m.Close(ctx)
+		if err := initialize(m); err != nil {
+			return fmt.Errorf("_initialize of instance %d: %v", i, err)
+		}
+		g[i] = guestMem{ctx, m}
+	}
+	ptr, _ := g[0].putString("only in instance 0")
+	if n0, n1 := g[0].live(), g[1].live(); n0 != 1 || n1 != 0 {
+		return fmt.Errorf("instances have %d and %d live buffers, want 1 and 0", n0, n1)
+	}
+	g[0].free(ptr)
+	fmt.Println("host: two instances OK")
+	return nil
+}
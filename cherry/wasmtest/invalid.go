@@ -0,0 +1,107 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// With -invalid, the driver builds a program whose exports have
+// signatures that go:wasmexport does not support, such as
+// testprog-invalid, and checks that the compiler rejects each of them.
+// As in the tests of the Go repository, a line of the program that
+// must fail to compile ends with a comment
+//
+//	// ERROR "regexp"
+//
+// and the compiler must report an error on that line matching regexp,
+// and no other error.
+
+// errorComment matches the ERROR comments.
+var errorComment = regexp.MustCompile(`// ERROR "((?:[^"\\]|\\.)*)"\s*$`)
+
+// compilerError matches the errors of the compiler, as file:line:col: msg.
+var compilerError = regexp.MustCompile(`^(.*\.go):(\d+):\d+: (.*)$`)
+
+// checkInvalid builds the program in dir as a library and checks the
+// errors the compiler reports against its ERROR comments.
+func checkInvalid(dir string) error {
+	want := make(map[string]*regexp.Regexp) // by file:line
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		s := bufio.NewScanner(f)
+		for line := 1; s.Scan(); line++ {
+			m := errorComment.FindStringSubmatch(s.Text())
+			if m == nil {
+				continue
+			}
+			re, err := regexp.Compile(m[1])
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("%s:%d: %v", file, line, err)
+			}
+			want[filepath.Base(file)+":"+strconv.Itoa(line)] = re
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+	if len(want) == 0 {
+		return fmt.Errorf("%s: no ERROR comments", dir)
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = "." + string(filepath.Separator) + filepath.Clean(dir)
+	}
+	// -e reports all the errors, rather than the first ten.
+	cmd := exec.Command("go", "build", "-gcflags=-e", "-buildmode=c-shared", "-o", os.DevNull, dir)
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return fmt.Errorf("%s built, want compile errors", dir)
+	}
+
+	var errs []string
+	for _, line := range strings.Split(string(out), "\n") {
+		m := compilerError.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		pos := filepath.Base(m[1]) + ":" + m[2]
+		re, ok := want[pos]
+		switch {
+		case !ok:
+			errs = append(errs, "unexpected error: "+line)
+		case !re.MatchString(m[3]):
+			errs = append(errs, fmt.Sprintf("%s: error %q does not match %q", pos, m[3], re))
+		}
+		delete(want, pos)
+	}
+	for pos, re := range want {
+		errs = append(errs, fmt.Sprintf("%s: missing error %q", pos, re))
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s:\n%s\ncompiler output:\n%s", dir, strings.Join(errs, "\n"), out)
+	}
+	fmt.Println("compiler rejects the invalid exports as expected")
+	return nil
+}
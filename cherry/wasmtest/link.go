@@ -0,0 +1,60 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// link instantiates the library module buf, built from linkprog, in
+// the runtime r, where testprog is instantiated as module "x", so that
+// its imports resolve to the exports of testprog. It checks that the
+// linked module's runtime is initialized independently of testprog's,
+// then calls it, with calls chaining from one module into the other
+// and into the host (linkprog's Chain calls testprog's G, which calls
+// the host's J, which calls G again).
+func link(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, buf []byte) error {
+	cm, err := r.CompileModule(ctx, buf)
+	if err != nil {
+		return err
+	}
+	lm, err := reset(ctx, r, cm, nil, config)
+	if err != nil {
+		return err
+	}
+	if lm.ExportedFunction("_initialize") == nil {
+		return fmt.Errorf("-link requires a module built with -buildmode=c-shared")
+	}
+	chain := func(x int32) int32 {
+		res, err := callExport(ctx, lm.ExportedFunction("Chain"), api.EncodeI32(x))
+		if err != nil {
+			panic(err)
+		}
+		return api.DecodeI32(res[0])
+	}
+
+	fmt.Println("Link mode: call linked module before its initialization")
+	shouldPanic(func() { chain(1) })
+	// reset module
+	lm, err = reset(ctx, r, cm, lm, config)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Link mode: initialize linked module")
+	if _, err := callExport(ctx, lm.ExportedFunction("_initialize")); err != nil {
+		return err
+	}
+
+	fmt.Println("\nLink mode: call across modules")
+	const x = 4
+	if got := chain(x); got != 2*x {
+		return fmt.Errorf("Chain(%d) = %d, want %d", x, got, 2*x)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build wasm
+
+// linkprog is the source of a second Wasm module for the driver's
+// -link mode. Its imports from module "x" are resolved to the exports
+// of testprog, so calls go from one Go module to the other, each with
+// its own runtime.
+package main
+
+import "runtime"
+
+func init() {
+	println("linkprog: init function called")
+}
+
+var ch = make(chan int32)
+
+//go:wasmexport Chain
+func Chain(x int32) int32 {
+	println("=== Chain", x, "===")
+	go func() { ch <- x * 2 }() // goroutine in this module's runtime
+	G(x)                        // into testprog, and from there into the host
+	runtime.GC()
+	r := <-ch
+	println("=== Chain", x, "end =", r, "===")
+	return r
+}
+
+//go:wasmimport x G
+func G(int32)
+
+func main() {}
@@ -0,0 +1,196 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// guestMem passes strings and byte slices to and from the module m
+// through its linear memory, in buffers allocated by the guest's
+// alloc export (see testprog/mem.go). A buffer belongs to the host
+// until it is passed to free.
+type guestMem struct {
+	ctx context.Context
+	m   api.Module
+}
+
+// call calls the export name and returns its result, if any.
+func (g guestMem) call(name string, args ...uint64) uint64 {
+	exp := g.m.ExportedFunction(name)
+	if exp == nil {
+		panic("missing export " + name)
+	}
+	r, err := callExport(g.ctx, exp, args...)
+	if err != nil {
+		panic(err)
+	}
+	if len(r) == 0 {
+		return 0
+	}
+	return r[0]
+}
+
+// putBytes copies b to a new guest buffer and returns its address
+// and length.
+func (g guestMem) putBytes(b []byte) (ptr, n uint32) {
+	ptr = api.DecodeU32(g.call("alloc", api.EncodeI32(int32(len(b)))))
+	if !g.m.Memory().Write(ptr, b) {
+		panic(fmt.Sprintf("writing %d bytes at %#x: out of range", len(b), ptr))
+	}
+	return ptr, uint32(len(b))
+}
+
+func (g guestMem) putString(s string) (ptr, n uint32) {
+	return g.putBytes([]byte(s))
+}
+
+// bytes returns a copy of the n bytes at ptr. Memory.Read returns a
+// view of the memory, which is stale if the guest grows its memory.
+func (g guestMem) bytes(ptr, n uint32) []byte {
+	b, ok := g.m.Memory().Read(ptr, n)
+	if !ok {
+		panic(fmt.Sprintf("reading %d bytes at %#x: out of range", n, ptr))
+	}
+	return bytes.Clone(b)
+}
+
+// result returns the contents of the buffer that a guest function
+// returned as an address and a length packed in an int64, and frees
+// the buffer.
+func (g guestMem) result(r uint64) []byte {
+	ptr, n := uint32(r>>32), uint32(r)
+	b := g.bytes(ptr, n)
+	g.free(ptr)
+	return b
+}
+
+func (g guestMem) free(ptr uint32) {
+	g.call("free", api.EncodeU32(ptr))
+}
+
+func (g guestMem) live() int32 {
+	return api.DecodeI32(g.call("Live"))
+}
+
+// testMem passes strings and byte slices to the exports of a library
+// module and checks the results, that the buffers the host owns
+// survive guest GCs and allocations, and that none is left once the
+// host has freed them.
+func testMem(g guestMem) error {
+	s := "hello, wasm"
+	ptr, n := g.putString(s)
+	got := string(g.result(g.call("Upper", api.EncodeU32(ptr), api.EncodeU32(n))))
+	g.free(ptr)
+	fmt.Printf("host: Upper(%q) = %q\n", s, got)
+	if want := strings.ToUpper(s); got != want {
+		return fmt.Errorf("Upper(%q) = %q, want %q", s, got, want)
+	}
+
+	for _, b := range [][]byte{nil, {1, 2, 3}, bytes.Repeat([]byte{0xff}, 1<<16)} {
+		ptr, n := g.putBytes(b)
+		got := int64(g.call("Sum", api.EncodeU32(ptr), api.EncodeU32(n)))
+		g.free(ptr)
+		want := int64(0)
+		for _, c := range b {
+			want += int64(c)
+		}
+		if got != want {
+			return fmt.Errorf("Sum of %d bytes = %d, want %d", len(b), got, want)
+		}
+	}
+
+	// Buffers owned by the host must keep their contents while the
+	// guest collects garbage (Upper calls runtime.GC) and allocates.
+	var ptrs []uint32
+	var want [][]byte
+	for i := range 100 {
+		b := bytes.Repeat([]byte{byte(i)}, 100+i*37)
+		ptr, _ := g.putBytes(b)
+		ptrs = append(ptrs, ptr)
+		want = append(want, b)
+		p, n := g.putString(strings.Repeat("x", 1000))
+		g.result(g.call("Upper", api.EncodeU32(p), api.EncodeU32(n)))
+		g.free(p)
+	}
+	if n := g.live(); n != int32(len(ptrs)) {
+		return fmt.Errorf("guest has %d live buffers, want %d", n, len(ptrs))
+	}
+	for i, ptr := range ptrs {
+		if b := g.bytes(ptr, uint32(len(want[i]))); !bytes.Equal(b, want[i]) {
+			return fmt.Errorf("buffer %d at %#x changed after guest GC", i, ptr)
+		}
+		g.free(ptr)
+	}
+	if n := g.live(); n != 0 {
+		return fmt.Errorf("guest has %d live buffers after freeing them all", n)
+	}
+	fmt.Println("host: strings and byte slices OK")
+	return nil
+}
+
+// rect is testprog's Rect, which the host and the guest exchange
+// through linear memory, laid out as Go lays it out on wasm.
+type rect struct {
+	x, y, w, h int32
+	area       float64
+}
+
+const rectSize = 24
+
+// putRect copies r to a new guest buffer and returns its address.
+func (g guestMem) putRect(r rect) uint32 {
+	var b [rectSize]byte
+	for i, v := range []int32{r.x, r.y, r.w, r.h} {
+		binary.LittleEndian.PutUint32(b[4*i:], uint32(v))
+	}
+	binary.LittleEndian.PutUint64(b[16:], math.Float64bits(r.area))
+	ptr, _ := g.putBytes(b[:])
+	return ptr
+}
+
+// rect returns the rect at ptr.
+func (g guestMem) rect(ptr uint32) rect {
+	b := g.bytes(ptr, rectSize)
+	v := func(i int) int32 { return int32(binary.LittleEndian.Uint32(b[4*i:])) }
+	return rect{v(0), v(1), v(2), v(3), math.Float64frombits(binary.LittleEndian.Uint64(b[16:]))}
+}
+
+// testStructs passes rects to the exports of a library module by
+// pointer and checks the rects they return or modify in place.
+func testStructs(g guestMem) error {
+	a := g.putRect(rect{x: 0, y: 0, w: 10, h: 20})
+	b := g.putRect(rect{x: 5, y: -5, w: 10, h: 10})
+	if a%8 != 0 || b%8 != 0 {
+		return fmt.Errorf("rects at %#x and %#x, want 8-byte alignment", a, b)
+	}
+	u := uint32(g.call("Union", api.EncodeU32(a), api.EncodeU32(b)))
+	got := g.rect(u)
+	fmt.Printf("host: Union = %+v\n", got)
+	if want := (rect{x: 0, y: -5, w: 15, h: 25, area: 375}); got != want {
+		return fmt.Errorf("Union = %+v, want %+v", got, want)
+	}
+
+	g.call("Scale", api.EncodeU32(u), api.EncodeF64(0.5))
+	got = g.rect(u)
+	if want := (rect{x: 0, y: -5, w: 7, h: 12, area: 84}); got != want {
+		return fmt.Errorf("after Scale(0.5), rect = %+v, want %+v", got, want)
+	}
+	for _, p := range []uint32{a, b, u} {
+		g.free(p)
+	}
+	if n := g.live(); n != 0 {
+		return fmt.Errorf("guest has %d live buffers after freeing the rects", n)
+	}
+	fmt.Println("host: structs OK")
+	return nil
+}
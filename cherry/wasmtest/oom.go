@@ -0,0 +1,87 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// With -max-memory, the runtime denies memory.grow beyond the limit,
+// and the oom scenario calls the Exhaust export of testprog (see
+// testprog/oom.go), which allocates until the guest runtime runs out
+// of memory. The runtime must then throw, which traps like the
+// failures of the traps scenario, having kept the memory within the
+// limit, and a fresh instance must work as before.
+
+// oomOutput is what the guest runtime prints when it runs out of
+// memory.
+const oomOutput = "fatal error: out of memory"
+
+// memoryLimitPages returns the limit of -max-memory in pages.
+func memoryLimitPages() uint32 {
+	return uint32(*maxMemory) << 20 / 65536
+}
+
+// checkOOM has a fresh instance of the compiled library module cm run
+// out of memory, and checks how it fails.
+func checkOOM(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule) error {
+	var out bytes.Buffer
+	w := io.Writer(&out)
+	if *verbose {
+		w = io.MultiWriter(&out, os.Stderr)
+	}
+	m, err := reset(ctx, r, cm, nil, config.WithStdout(w).WithStderr(w))
+	if err != nil {
+		return err
+	}
+	if _, err := callExport(ctx, m.ExportedFunction("_initialize")); err != nil {
+		return fmt.Errorf("_initialize: %v", err)
+	}
+	_, err = callExport(ctx, m.ExportedFunction("Exhaust"), api.EncodeI32(1<<20))
+	pages := memPages(m)
+	var exit *sys.ExitError
+	switch {
+	case err == nil:
+		return fmt.Errorf("Exhaust returned, want a trap")
+	case errors.As(err, &exit):
+		return fmt.Errorf("Exhaust exited with code %d, want a trap", exit.ExitCode())
+	case !strings.HasPrefix(err.Error(), "wasm error: unreachable"):
+		return fmt.Errorf("Exhaust: %v, want an unreachable trap", err)
+	case !strings.Contains(out.String(), oomOutput):
+		return fmt.Errorf("Exhaust trapped, but output lacks %q", oomOutput)
+	case pages > memoryLimitPages():
+		return fmt.Errorf("memory grew to %d pages, over the limit of %d", pages, memoryLimitPages())
+	}
+	m.Close(ctx)
+	fmt.Printf("host: Exhaust ran out of memory at %d pages\n", pages)
+
+	m, err = reset(ctx, r, cm, nil, config)
+	if err != nil {
+		return err
+	}
+	defer m.Close(ctx)
+	if _, err := callExport(ctx, m.ExportedFunction("_initialize")); err != nil {
+		return fmt.Errorf("_initialize after out of memory: %v", err)
+	}
+	res, err := callExport(ctx, m.ExportedFunction("EchoI64"), api.EncodeI64(42))
+	if err != nil {
+		return fmt.Errorf("EchoI64 after out of memory: %v", err)
+	}
+	if res[0] != 42 {
+		return fmt.Errorf("EchoI64(42) after out of memory = %d", res[0])
+	}
+	fmt.Println("host: fresh instance OK")
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+)
+
+// Each line the guest writes is prefixed with the name of the module
+// and the stream, as in "[x stderr] ", so that the output of linked
+// modules, and of the host, which is not prefixed, can be told apart.
+//
+// With -log-file, the driver runs itself again with the same arguments
+// but -log-file, and -v, and writes the combined output of that run,
+// host trace included, to the file as well as to the standard output.
+// Like with -golden, capturing the host's println output needs another
+// process.
+
+// A prefixWriter writes to w, starting each line with prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	mid    bool // in the middle of a line
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	var out []byte
+	for len(b) > 0 {
+		if !p.mid {
+			out = append(out, p.prefix...)
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			out = append(out, b...)
+			p.mid = true
+			break
+		}
+		out = append(out, b[:i+1]...)
+		b = b[i+1:]
+		p.mid = false
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// guestOutput returns the writers for the standard output and error
+// of the module name. Without -v, they hold the output back in errbuf.
+// The standard error also goes to guestStderr, unprefixed.
+func guestOutput(name string) (stdout, stderr io.Writer) {
+	out, err := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if !*verbose {
+		out, err = &errbuf, &errbuf
+	}
+	stdout = &prefixWriter{w: out, prefix: []byte("[" + name + " stdout] ")}
+	stderr = &prefixWriter{w: err, prefix: []byte("[" + name + " stderr] ")}
+	if *verbose {
+		stderr = io.MultiWriter(stderr, &errbuf)
+	}
+	return stdout, io.MultiWriter(stderr, &guestStderr)
+}
+
+// runLogged runs the driver again, with -v, writing its output to file
+// and the standard output, and returns an error if it fails.
+func runLogged(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd, err := selfCommand("log-file")
+	if err != nil {
+		return err
+	}
+	cmd.Args = slices.Insert(cmd.Args, 1, "-v")
+	w := io.MultiWriter(os.Stdout, f)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err = cmd.Run()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		fmt.Fprintf(w, "exit status %d\n", ee.ExitCode())
+	}
+	return err
+}
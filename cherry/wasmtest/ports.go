@@ -0,0 +1,73 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// With -ports, the driver builds testprog as an executable for both
+// Wasm ports, wasip1 and js, runs each, with the WASI host and the
+// wasm_exec.js shim (see gojs.go) respectively, with -v, and diffs the
+// combined output of the runs, to show where the ports behave
+// differently. Both modules have the same name and get the same
+// arguments and environment, so that only their behavior differs.
+
+// ports lists the ports that -ports compares, the first as the base.
+var ports = []string{"wasip1", "js"}
+
+// comparePorts builds the test program in directory src for each port
+// and compares their runs. It returns an error if they differ.
+func comparePorts(src string) error {
+	dir, err := os.MkdirTemp("", "wasmtest-ports")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if !filepath.IsAbs(src) {
+		src = "." + string(filepath.Separator) + filepath.Clean(src)
+	}
+
+	var outs []string
+	for _, goos := range ports {
+		file := filepath.Join(dir, goos, "testprog.wasm")
+		cmd := exec.Command("go", "build", "-o", file, src)
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH=wasm")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("building %s for %s: %v\n%s", src, goos, err, out)
+		}
+
+		cmd, err := selfCommand("ports", "v", "run")
+		if err != nil {
+			return err
+		}
+		cmd.Args = append(cmd.Args, "-v", "-run=executable", file)
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			var ee *exec.ExitError
+			if !errors.As(err, &ee) {
+				return err
+			}
+			fmt.Fprintf(&out, "exit status %d\n", ee.ExitCode())
+		}
+		// The driver's own heading differs between the modes.
+		s := strings.Replace(out.String(), "JS mode: run [executable]\n", "Executable mode: start [executable]\n", 1)
+		outs = append(outs, pcOffset.ReplaceAllString(s, "+0x?"))
+	}
+
+	if outs[0] == outs[1] {
+		fmt.Printf("%s and %s behave the same\n", ports[0], ports[1])
+		return nil
+	}
+	return fmt.Errorf("%s and %s differ (-%s +%s):\n%s", ports[0], ports[1], ports[0], ports[1], lineDiff(outs[0], outs[1]))
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// With -pprof, the driver profiles a library module: it mounts the
+// directory for the profiles in the guest as /pprof, sets PPROFDIR to
+// it, and calls the profile exports of testprog (see testprog/prof.go)
+// around repeated calls of I, for a CPU profile, then after them, for
+// a heap profile, which samples every allocation. The guest's profiles name the functions already;
+// with -pprof-top, the driver also prints the top of each with go tool
+// pprof.
+//
+// The CPU profile of a wasm guest is empty: the Go runtime gets no
+// profiling signals on wasm. The export calls still go through
+// starting and stopping the profiler.
+
+const guestPprofDir = "/pprof"
+
+// profile profiles rounds calls of I in the library module m, writing
+// the profiles to dir.
+func profile(ctx context.Context, m api.Module, dir string, rounds int, top bool) error {
+	call := func(name string) error {
+		res, err := callExport(ctx, m.ExportedFunction(name))
+		if err != nil {
+			return err
+		}
+		if api.DecodeI32(res[0]) != 0 {
+			return fmt.Errorf("%s failed", name)
+		}
+		return nil
+	}
+	if err := call("StartCPUProfile"); err != nil {
+		return err
+	}
+	for range rounds {
+		I()
+	}
+	if err := call("StopCPUProfile"); err != nil {
+		return err
+	}
+	if err := call("WriteHeapProfile"); err != nil {
+		return err
+	}
+
+	for _, p := range []struct{ name, sample string }{
+		{"cpu.pprof", "samples"},
+		{"heap.pprof", "alloc_space"},
+	} {
+		file := filepath.Join(dir, p.name)
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("host: %s: %d bytes\n", file, info.Size())
+		if !top {
+			continue
+		}
+		cmd := exec.Command("go", "tool", "pprof", "-top", "-nodecount=10", "-sample_index="+p.sample, file)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go tool pprof %s: %v", p.name, err)
+		}
+	}
+	return nil
+}
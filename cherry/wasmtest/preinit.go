@@ -0,0 +1,59 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// preInitOutput is what the guest runtime prints when an export is
+// called before _initialize.
+const preInitOutput = "runtime: wasmexport function called before runtime initialization"
+
+// checkPreInit calls each export of the compiled library module cm but
+// _initialize, with zero arguments, in a fresh instance that has not
+// been initialized, and checks that the call fails after the runtime
+// prints preInitOutput. It finds the exports in the module, so new
+// exports of testprog are checked too.
+func checkPreInit(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule) error {
+	exports := cm.ExportedFunctions()
+	var names []string
+	for name := range exports {
+		if name != "_initialize" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var m api.Module
+	defer func() {
+		if m != nil {
+			m.Close(ctx)
+		}
+	}()
+	for _, name := range names {
+		var err error
+		m, err = reset(ctx, r, cm, m, config)
+		if err != nil {
+			return err
+		}
+		guestStderr.Reset()
+		args := make([]uint64, len(exports[name].ParamTypes()))
+		_, err = callExport(ctx, m.ExportedFunction(name), args...)
+		switch {
+		case err == nil:
+			return fmt.Errorf("%s returned before initialization, want a failure", name)
+		case !strings.Contains(guestStderr.String(), preInitOutput):
+			return fmt.Errorf("%s failed before initialization, but output lacks %q: %v", name, preInitOutput, err)
+		}
+	}
+	fmt.Printf("host: %d exports fail before initialization\n", len(names))
+	return nil
+}
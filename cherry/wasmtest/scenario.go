@@ -0,0 +1,223 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scenarios are the behaviors that the driver checks, which -run
+// selects and -shard splits, in the order they run without -shuffle:
+//
+//   - executable: run an executable or GOOS=js module from _start;
+//   - library: call each export of a library module before
+//     initialization (see preinit.go), then I, which calls all the exports, and check
+//     memory growth and passing strings and byte slices;
+//   - goroutine-switch: call E, which starts a goroutine, then F,
+//     which blocks until the goroutine sends to it;
+//   - reentrancy: call G, which recurses through the host's J;
+//   - blocking: call host imports that block from guest goroutines,
+//     and check that they all complete (see blocking.go);
+//   - args: check the arguments and environment the guest gets,
+//     in an executable or a library (see args.go);
+//   - spill: call exports that take 16 arguments, some of which are
+//     passed on the stack (see checkSpill);
+//   - floats: pass NaNs, signed zeros and values that round to and
+//     from the guest, and convert them (see floats.go);
+//   - gc: allocate, set finalizers and collect garbage, and check
+//     that the heap settles and the finalizers run (see gc.go);
+//   - tracebacks: check the tracebacks the guest prints in E and G
+//     (see traceback.go);
+//   - host-errors: call host imports that panic, fail, exit or
+//     block, and check how the calls end (see hosterr.go);
+//   - init: call _initialize twice, call an export during
+//     _initialize, and run two instances (see init.go);
+//   - exit: exit with various codes, from main in an executable and
+//     from an export in a library, and check the code the host sees
+//     (see exit.go);
+//   - traps: call exports that panic or otherwise fail, and check
+//     that they trap (see traps.go);
+//   - oom: with -max-memory, allocate until the guest runs out of
+//     memory, and check that it throws (see oom.go).
+var scenarios = []string{"executable", "library", "goroutine-switch", "reentrancy", "blocking", "args", "spill", "floats", "gc", "tracebacks", "host-errors", "init", "exit", "traps", "oom"}
+
+// parseRun parses the -run flag, a comma-separated list of scenarios,
+// and returns the set of those selected. An empty list selects all.
+func parseRun(s string) (map[string]bool, error) {
+	run := make(map[string]bool)
+	if s == "" {
+		for _, name := range scenarios {
+			run[name] = true
+		}
+		return run, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		if !slices.Contains(scenarios, name) {
+			return nil, fmt.Errorf("unknown scenario %q, want one of %s", name, strings.Join(scenarios, ", "))
+		}
+		run[name] = true
+	}
+	return run, nil
+}
+
+// anyLibrary reports whether run selects a scenario for library
+// modules.
+func anyLibrary(run map[string]bool) bool {
+	for _, name := range scenarios {
+		if run[name] && name != "executable" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseShard parses the -shard flag, i/n, which selects the i-th of n
+// shards, counting from 0.
+func parseShard(s string) (i, n int, err error) {
+	a, b, ok := strings.Cut(s, "/")
+	if ok {
+		i, err = strconv.Atoi(a)
+	}
+	if ok && err == nil {
+		n, err = strconv.Atoi(b)
+	}
+	if !ok || err != nil || n <= 0 || i < 0 || i >= n {
+		return 0, 0, fmt.Errorf("invalid shard %q, want i/n with 0 <= i < n", s)
+	}
+	return i, n, nil
+}
+
+// shard removes from run all the selected scenarios but those of shard
+// i of n, which are every n-th of them in order, starting with the
+// i-th. The shards of a run thus split its scenarios between them,
+// whatever it selects.
+func shard(run map[string]bool, i, n int) {
+	k := 0
+	for _, name := range scenarios {
+		if !run[name] {
+			continue
+		}
+		if k%n != i {
+			delete(run, name)
+		}
+		k++
+	}
+}
+
+// parseShuffle parses the -shuffle flag, like go test does: off, on,
+// which picks a seed from the time, or a seed. It reports whether to
+// shuffle.
+func parseShuffle(s string) (seed uint64, ok bool, err error) {
+	switch s {
+	case "off":
+		return 0, false, nil
+	case "on":
+		return uint64(time.Now().UnixNano()), true, nil
+	}
+	seed, err = strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value %q, want off, on or a seed", s)
+	}
+	return seed, true, nil
+}
+
+// A scenario is a check that the driver runs on the module in one mode.
+type scenario struct {
+	name    string // as in scenarios
+	heading string // what the check does
+	check   func() error
+}
+
+// runScenarios runs those of list that run selects, each -count times,
+// in order or, with -shuffle, in random order, and fails on the first
+// error. It heads each run with mode, what the scenario does, and its
+// stable name, the scenario's own followed by #k for the k-th run when
+// -count repeats them, so that the runs of different shards, orders
+// and repetitions can be matched up.
+func runScenarios(mode string, list []scenario, run map[string]bool) {
+	type runID struct {
+		s scenario
+		k int
+	}
+	count := max(*countFlag, 1)
+	var ids []runID
+	for k := range count {
+		for _, s := range list {
+			if run[s.name] {
+				ids = append(ids, runID{s, k + 1})
+			}
+		}
+	}
+	seed, ok, err := parseShuffle(*shuffleFlag)
+	if err != nil {
+		fail(fmt.Errorf("-shuffle: %v", err))
+	}
+	if ok {
+		fmt.Println("-shuffle", seed)
+		r := rand.New(rand.NewPCG(seed, 0))
+		r.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	}
+	for i, id := range ids {
+		name := id.s.name
+		if count > 1 {
+			name += "#" + strconv.Itoa(id.k)
+		}
+		// The library heading follows that of the initialization.
+		if i > 0 || mode == "Library" {
+			fmt.Println()
+		}
+		fmt.Printf("%s mode: %s [%s]\n", mode, id.s.heading, name)
+		if err := id.s.check(); err != nil {
+			fail(err)
+		}
+	}
+}
+
+// goroutineSwitch calls E then F, rounds times, and checks that F
+// returns what the goroutine started by E computed from E's arguments.
+func goroutineSwitch(rounds int) error {
+	// Computed like the guest does, at run time.
+	a, b, c, d := argEa, argEb, argEc, argEd
+	want := int64((float64(a) + float64(b) + c + float64(d) + 100) * 100)
+	for i := range rounds {
+		E(a, b, c, d)
+		if got := F(); got != want {
+			return fmt.Errorf("round %d: F = %d, want %d", i, got, want)
+		}
+	}
+	fmt.Printf("host: %d rounds of E and F OK\n", rounds)
+	return nil
+}
+
+// reentrancy calls G at each depth up to maxDepth and checks that the
+// guest called back into the host's J once for each odd level of the
+// recursion, and that all calls returned.
+func reentrancy(maxDepth int32) error {
+	for x := int32(1); x <= maxDepth; x++ {
+		jCalls = 0
+		G(x)
+		if want := int((x + 1) / 2); jCalls != want {
+			return fmt.Errorf("G(%d) called J %d times, want %d", x, jCalls, want)
+		}
+	}
+	fmt.Printf("host: G up to depth %d OK\n", maxDepth)
+	return nil
+}
+
+// fail reports err and exits. Without -v, it first prints the guest's
+// output, which was held back.
+func fail(err error) {
+	if !*verbose {
+		os.Stderr.Write(errbuf.Bytes())
+	}
+	fmt.Println(err)
+	os.Exit(1)
+}
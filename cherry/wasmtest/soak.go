@@ -0,0 +1,124 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// soak runs the scenarios of list that run selects in a loop for
+// duration d against the single instance m, sampling the guest memory
+// size and the host RSS every interval. It returns an error if a
+// scenario fails, or if either keeps growing (see leaking), which
+// indicates a leak in the host/guest bridge.
+//
+// The first run of the scenarios is a warm-up: it grows the guest heap
+// and the host's caches to their working size, which is not a leak,
+// so the first sample is taken after it.
+func soak(m api.Module, list []scenario, run map[string]bool, d, interval time.Duration, growth float64) error {
+	// The scenarios print what they check, which would add up to
+	// gigabytes over hours.
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+	stdout := os.Stdout
+	iterate := func() error {
+		os.Stdout = null
+		defer func() { os.Stdout = stdout }()
+		for _, s := range list {
+			if !run[s.name] {
+				continue
+			}
+			err := s.check()
+			// Output kept for the checks would add up too.
+			guestStderr.Reset()
+			errbuf.Reset()
+			if err != nil {
+				return fmt.Errorf("soak: %s: %v", s.name, err)
+			}
+		}
+		return nil
+	}
+
+	var pages, rss []uint64
+	sample := func(start time.Time, n int) {
+		p := uint64(m.Memory().Size()) / 65536
+		r := hostRSS()
+		pages = append(pages, p)
+		rss = append(rss, r)
+		fmt.Printf("soak: %v: %d iterations, %d guest pages, %d bytes host RSS\n",
+			time.Since(start).Round(time.Second), n, p, r)
+	}
+
+	start := time.Now()
+	if err := iterate(); err != nil {
+		return err
+	}
+	n := 1
+	next := time.Now()
+	for time.Since(start) < d {
+		if !time.Now().Before(next) {
+			sample(start, n)
+			next = next.Add(interval)
+		}
+		if err := iterate(); err != nil {
+			return err
+		}
+		n++
+	}
+	sample(start, n)
+
+	if leaking(pages, growth) {
+		return fmt.Errorf("soak: guest memory kept growing: %v pages", pages)
+	}
+	if leaking(rss, growth) {
+		return fmt.Errorf("soak: host RSS kept growing: %v bytes", rss)
+	}
+	return nil
+}
+
+// leaking reports whether samples, taken at regular intervals, keep
+// growing: the last exceeds the first by more than the fraction
+// growth, and the second half of the samples accounts for at least a
+// quarter of that. Growth that levels off is not a leak: the memory of
+// a Wasm instance never shrinks, so its samples never decrease, but
+// they stop growing once the heap reaches its working size. At least
+// three samples are needed to tell a trend from a one-off allocation.
+func leaking(samples []uint64, growth float64) bool {
+	if len(samples) < 3 {
+		return false
+	}
+	first, mid, last := samples[0], samples[len(samples)/2], samples[len(samples)-1]
+	if float64(last) <= float64(first)*(1+growth) || last <= mid {
+		return false
+	}
+	return (last-mid)*4 >= last-first
+}
+
+// hostRSS returns the resident set size of the host process. On
+// systems without /proc, it falls back to the memory obtained from
+// the OS by the Go runtime.
+func hostRSS() uint64 {
+	if statm, err := os.ReadFile("/proc/self/statm"); err == nil {
+		f := bytes.Fields(statm)
+		if len(f) > 1 {
+			if n, err := strconv.ParseUint(string(f[1]), 10, 64); err == nil {
+				return n * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.Sys
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// With -startup, the driver measures the start-up of the module
+// -count times, each in a new runtime, so that nothing is reused
+// between rounds but, with -cache, the compiled code. It prints the
+// minimum, median and maximum time of each step: compiling the
+// module, instantiating it and, for a library, calling _initialize,
+// which runs the Go runtime's initialization and the package inits.
+
+// startup measures the start-up of the module buf count times, with
+// the module configuration config, and prints the times.
+func startup(buf []byte, config wazero.ModuleConfig, count int) error {
+	if count < 1 {
+		return fmt.Errorf("-count %d: want at least 1", count)
+	}
+	var compile, inst, init []time.Duration
+	library := false
+	for range count {
+		r, ctx := newRuntime(context.Background())
+		if err := instantiateHost(ctx, r); err != nil {
+			return err
+		}
+		t0 := time.Now()
+		cm, err := r.CompileModule(ctx, buf)
+		if err != nil {
+			return err
+		}
+		compile = append(compile, time.Since(t0))
+		if usesGoJS(cm) {
+			if err := newJSHost(io.Discard, io.Discard).instantiate(ctx, r); err != nil {
+				return err
+			}
+		}
+		t0 = time.Now()
+		m, err := r.InstantiateModule(ctx, cm, config)
+		if err != nil {
+			return err
+		}
+		inst = append(inst, time.Since(t0))
+		if f := m.ExportedFunction("_initialize"); f != nil {
+			library = true
+			t0 = time.Now()
+			if _, err := callExport(ctx, f); err != nil {
+				return fmt.Errorf("_initialize: %v", err)
+			}
+			init = append(init, time.Since(t0))
+		}
+		r.Close(ctx)
+	}
+
+	fmt.Printf("%-12s %12s %12s %12s\n", "", "min", "median", "max")
+	row := func(name string, d []time.Duration) {
+		slices.Sort(d)
+		fmt.Printf("%-12s %12v %12v %12v\n", name, d[0], d[len(d)/2], d[len(d)-1])
+	}
+	row("compile", compile)
+	row("instantiate", inst)
+	if library {
+		row("_initialize", init)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// stress calls E and F from n host goroutines, rounds times each,
+// against the single instance m. The module must not be entered
+// concurrently, so a mutex serializes the calls, but not the pairs:
+// the goroutines started by E of one host goroutine may be received
+// from by F of another, and several may be blocked sending at once.
+// F blocks in the guest until one of them sends to it; if a wakeup
+// were lost, the guest would deadlock, and the call trap, or hang
+// until -timeout. The values received must add up to those sent.
+func stress(ctx context.Context, m api.Module, n, rounds int) error {
+	e, f := m.ExportedFunction("E"), m.ExportedFunction("F")
+	var (
+		mu      sync.Mutex
+		sent    int64 // sum of what E's goroutines send
+		got     int64 // sum of F's results
+		calls   int
+		firstEr error
+	)
+	// call calls an export with the mutex held, and reports whether
+	// to go on.
+	call := func(fn api.Function, args ...uint64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstEr != nil {
+			return false
+		}
+		res, err := callExport(ctx, fn, args...)
+		if err != nil {
+			firstEr = fmt.Errorf("%s after %d calls: %v", fn.Definition().Name(), calls, err)
+			return false
+		}
+		calls++
+		if len(res) > 0 {
+			got += int64(res[0])
+		}
+		return true
+	}
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range rounds {
+				a := int64(i*rounds + j)
+				mu.Lock()
+				sent += (a + 100) * 100 // E's goroutine sends a+100, F multiplies by 100
+				mu.Unlock()
+				if !call(e, api.EncodeI64(a), 0, api.EncodeF64(0), api.EncodeF32(0)) {
+					return
+				}
+				runtime.Gosched() // let other host goroutines in between E and F
+				if !call(f) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstEr != nil {
+		return firstEr
+	}
+	if got != sent {
+		return fmt.Errorf("F received %d in total, want %d", got, sent)
+	}
+	fmt.Printf("host: %d goroutines made %d calls OK\n", n, calls)
+	return nil
+}
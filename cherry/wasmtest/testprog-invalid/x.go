@@ -0,0 +1,38 @@
+//go:build wasm
+
+// This program has exports with signatures that go:wasmexport does not
+// support, each marked with the error that the compiler must report,
+// for the driver's -invalid mode. It does not build.
+package main
+
+//go:wasmexport StringResult
+func StringResult() string { return "" } // ERROR "unsupported result type string"
+
+//go:wasmexport Struct
+func Struct(r struct{ X, Y int32 }) {} // ERROR "unsupported parameter type struct"
+
+//go:wasmexport StructResult
+func StructResult() struct{ X int32 } { return struct{ X int32 }{} } // ERROR "unsupported result type struct"
+
+//go:wasmexport Chan
+func Chan(c chan int32) {} // ERROR "unsupported parameter type chan int32"
+
+//go:wasmexport Slice
+func Slice(b []byte) {} // ERROR "unsupported parameter type \[\]byte"
+
+//go:wasmexport Map
+func Map(m map[int32]int32) {} // ERROR "unsupported parameter type map\[int32\]int32"
+
+//go:wasmexport Interface
+func Interface(x any) {} // ERROR "unsupported parameter type any"
+
+//go:wasmexport Func
+func Func(f func()) {} // ERROR "unsupported parameter type func\(\)"
+
+//go:wasmexport TwoResults
+func TwoResults() (int32, int32) { return 0, 0 } // ERROR "too many return values"
+
+//go:wasmexport Complex
+func Complex(c complex128) {} // ERROR "unsupported parameter type complex128"
+
+func main() {}
@@ -0,0 +1,31 @@
+//go:build wasm
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// The host passes arguments and environment variables through WASI,
+// which main prints, one per line, and the Args and Environ exports
+// return, separated by NULs, for the driver's args scenario.
+
+func printArgs() {
+	for _, a := range os.Args {
+		println("arg:", a)
+	}
+	for _, e := range os.Environ() {
+		println("env:", e)
+	}
+}
+
+//go:wasmexport Args
+func Args() int64 {
+	return result([]byte(strings.Join(os.Args, "\x00")))
+}
+
+//go:wasmexport Environ
+func Environ() int64 {
+	return result([]byte(strings.Join(os.Environ(), "\x00")))
+}
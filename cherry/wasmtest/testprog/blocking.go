@@ -0,0 +1,53 @@
+//go:build wasm
+
+package main
+
+import "time"
+
+// BlockingImports starts rounds goroutines, each of which calls host
+// imports that block: Sleep and Timer for ms milliseconds, and Block,
+// which another goroutine of the host completes. Meanwhile another
+// goroutine sleeps in the guest, in a loop. It returns the sum of the
+// results of the imports in the high 32 bits, and the number of times
+// the sleeping goroutine woke in the low ones, for the driver's
+// blocking scenario.
+//
+//go:wasmexport BlockingImports
+func BlockingImports(rounds, ms int32) int64 {
+	results := make(chan int64)
+	for range rounds {
+		go func() {
+			a := hostSleep(ms)
+			time.Sleep(time.Millisecond) // let the scheduler idle
+			b := hostTimer(ms)
+			c := hostBlock()
+			results <- a + b + c
+		}()
+	}
+	done := make(chan bool)
+	ticks := make(chan int64)
+	go func() {
+		var n int64
+		for {
+			select {
+			case <-done:
+				ticks <- n
+				return
+			case <-time.After(time.Millisecond):
+				n++
+			}
+		}
+	}()
+	var sum int64
+	for range rounds {
+		sum += <-results
+	}
+	done <- true
+	return sum<<32 | <-ticks
+}
+
+//go:wasmimport test Sleep
+func hostSleep(ms int32) int64
+
+//go:wasmimport test Timer
+func hostTimer(ms int32) int64
@@ -0,0 +1,21 @@
+//go:build wasm
+
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Now and Rand return what the guest gets from the host's clocks and
+// random source, which the driver can make deterministic.
+
+//go:wasmexport Now
+func Now() int64 {
+	return time.Now().UnixNano()
+}
+
+//go:wasmexport Rand
+func Rand() int64 {
+	return rand.Int64()
+}
@@ -0,0 +1,31 @@
+//go:build wasm
+
+package main
+
+import (
+	"os"
+	"runtime/coverage"
+)
+
+// WriteCoverage writes the coverage data of a library built with
+// -cover -covermode=atomic, which writing the counters of a running
+// program requires, to $GOCOVERDIR, as an executable does when it
+// exits, which a library never does. It returns 0 on success, and -1 if there is
+// no coverage data or it cannot be written.
+//
+//go:wasmexport WriteCoverage
+func WriteCoverage() int32 {
+	dir := os.Getenv("GOCOVERDIR")
+	if dir == "" {
+		return -1
+	}
+	if err := coverage.WriteMetaDir(dir); err != nil {
+		println("WriteCoverage:", err.Error())
+		return -1
+	}
+	if err := coverage.WriteCountersDir(dir); err != nil {
+		println("WriteCoverage:", err.Error())
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+//go:build wasm
+
+package main
+
+import "math"
+
+// The Echo exports return their argument, and Mix combines the bits
+// of its arguments, for the driver's -fuzz mode to check that values
+// cross wasmexport intact.
+
+//go:wasmexport EchoI32
+func EchoI32(x int32) int32 { return x }
+
+//go:wasmexport EchoI64
+func EchoI64(x int64) int64 { return x }
+
+//go:wasmexport EchoF32
+func EchoF32(x float32) float32 { return x }
+
+//go:wasmexport EchoF64
+func EchoF64(x float64) float64 { return x }
+
+//go:wasmexport Mix
+func Mix(a int64, b int32, c float64, d float32) int64 {
+	return a ^ int64(b)<<32 ^ int64(math.Float64bits(c)) ^ int64(math.Float32bits(d))<<16
+}
+
+// Spill and SpillSum take 16 arguments, of all types in turn, which
+// the wasmexport wrappers pass to the Go functions on the stack. Spill
+// returns the bits of argument sel, and SpillSum the sum of all as
+// float64. 16 is the most a wasmexport function can take: with 17,
+// the compiler fails with "internal compiler error: panic: bad Get:
+// invalid register".
+
+//go:wasmexport Spill
+func Spill(a0 int32, a1 float32, a2 int64, a3 float64, a4 int32, a5 float32, a6 int64, a7 float64, a8 int32, a9 float32, a10 int64, a11 float64, a12 int32, a13 float32, a14 int64, sel int32) int64 {
+	switch sel {
+	case 0:
+		return int64(uint32(a0))
+	case 1:
+		return int64(math.Float32bits(a1))
+	case 2:
+		return a2
+	case 3:
+		return int64(math.Float64bits(a3))
+	case 4:
+		return int64(uint32(a4))
+	case 5:
+		return int64(math.Float32bits(a5))
+	case 6:
+		return a6
+	case 7:
+		return int64(math.Float64bits(a7))
+	case 8:
+		return int64(uint32(a8))
+	case 9:
+		return int64(math.Float32bits(a9))
+	case 10:
+		return a10
+	case 11:
+		return int64(math.Float64bits(a11))
+	case 12:
+		return int64(uint32(a12))
+	case 13:
+		return int64(math.Float32bits(a13))
+	case 14:
+		return a14
+	}
+	panic("Spill: bad argument index")
+}
+
+//go:wasmexport SpillSum
+func SpillSum(a0 int32, a1 float32, a2 int64, a3 float64, a4 int32, a5 float32, a6 int64, a7 float64, a8 int32, a9 float32, a10 int64, a11 float64, a12 int32, a13 float32, a14 int64, a15 float64) float64 {
+	return float64(a0) + float64(a1) + float64(a2) + a3 + float64(a4) + float64(a5) + float64(a6) + a7 + float64(a8) + float64(a9) + float64(a10) + a11 + float64(a12) + float64(a13) + float64(a14) + a15
+}
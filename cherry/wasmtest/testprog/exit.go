@@ -0,0 +1,29 @@
+//go:build wasm
+
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// For the driver's exit scenario, main exits with the code in its
+// last arguments "exit <code>", and a library exits from the Exit
+// export.
+
+// exitIfAsked exits with the code in the arguments, if any.
+func exitIfAsked() {
+	if n := len(os.Args); n >= 3 && os.Args[n-2] == "exit" {
+		code, err := strconv.Atoi(os.Args[n-1])
+		if err != nil {
+			panic(err)
+		}
+		println("exiting with", code)
+		os.Exit(code)
+	}
+}
+
+//go:wasmexport Exit
+func Exit(code int32) {
+	os.Exit(int(code))
+}
@@ -0,0 +1,29 @@
+//go:build wasm
+
+package main
+
+// The float exports convert between float32 and float64, and pass
+// their argument through the host's Echo imports and back, for the
+// driver's floats scenario to check NaN payloads, signed zeros and
+// rounding in both directions.
+
+//go:wasmexport Widen
+func Widen(x float32) float64 { return float64(x) }
+
+//go:wasmexport Narrow
+func Narrow(x float64) float32 { return float32(x) }
+
+//go:wasmexport Neg
+func Neg(x float64) float64 { return -x }
+
+//go:wasmexport ViaHostF32
+func ViaHostF32(x float32) float32 { return hostEchoF32(x) }
+
+//go:wasmexport ViaHostF64
+func ViaHostF64(x float64) float64 { return hostEchoF64(x) }
+
+//go:wasmimport test EchoF32
+func hostEchoF32(float32) float32
+
+//go:wasmimport test EchoF64
+func hostEchoF64(float64) float64
@@ -0,0 +1,50 @@
+//go:build wasm
+
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// The gc exports allocate, set finalizers and collect garbage, for the
+// driver's gc scenario to check how the heap and finalizers behave
+// across repeated entries.
+
+var (
+	sink      []byte
+	finalized atomic.Int32
+)
+
+//go:wasmexport Churn
+func Churn(n int32) { // allocate n bytes of garbage, in 1 KB pieces
+	for range n / 1024 {
+		sink = make([]byte, 1024)
+	}
+	sink = nil
+}
+
+type tracked struct {
+	buf [64]byte
+}
+
+//go:wasmexport Track
+func Track(n int32) { // allocate n objects with finalizers, then drop them
+	for range n {
+		t := new(tracked)
+		runtime.SetFinalizer(t, func(*tracked) { finalized.Add(1) })
+	}
+}
+
+//go:wasmexport Finalized
+func Finalized() int32 { // number of finalizers run
+	return finalized.Load()
+}
+
+//go:wasmexport Collect
+func Collect() int64 { // collect garbage, and return the live heap
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapAlloc)
+}
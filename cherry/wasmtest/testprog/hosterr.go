@@ -0,0 +1,52 @@
+//go:build wasm
+
+package main
+
+// The CallHost exports call host imports that panic, fail with an
+// error, exit, or block until another host goroutine wakes them, for
+// the driver's host-errors scenario. A failing import unwinds the
+// guest, so reached, which the driver reads with Reached, stays 0.
+
+var reached int32
+
+//go:wasmimport test Panic
+func hostPanic()
+
+//go:wasmimport test Fail
+func hostFail()
+
+//go:wasmimport test Exit
+func hostExit(code int32)
+
+//go:wasmimport test Block
+func hostBlock() int64
+
+//go:wasmexport CallHostPanic
+func CallHostPanic() {
+	hostPanic()
+	reached = 1
+}
+
+//go:wasmexport CallHostFail
+func CallHostFail() {
+	hostFail()
+	reached = 1
+}
+
+//go:wasmexport CallHostExit
+func CallHostExit(code int32) {
+	hostExit(code)
+	reached = 1
+}
+
+//go:wasmexport CallHostBlock
+func CallHostBlock() int64 {
+	x := hostBlock()
+	reached = 1
+	return x + 1
+}
+
+//go:wasmexport Reached
+func Reached() int32 {
+	return reached
+}
@@ -0,0 +1,13 @@
+//go:build wasm
+
+package main
+
+// init calls the host's Init import, from which the driver's init
+// scenario calls an export while the module is being initialized.
+
+//go:wasmimport test Init
+func hostInit()
+
+func init() {
+	hostInit()
+}
@@ -0,0 +1,106 @@
+//go:build wasm
+
+package main
+
+import (
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// The host passes strings and byte slices through linear memory, in
+// buffers it gets from alloc. A buffer belongs to the host from alloc
+// until free; meanwhile pinned keeps it reachable, so that the GC
+// neither frees nor reuses it.
+var pinned = make(map[int32][]byte)
+
+//go:wasmexport alloc
+func alloc(size int32) int32 {
+	b := make([]byte, max(size, 1)) // distinct address even if empty
+	p := int32(uintptr(unsafe.Pointer(&b[0])))
+	pinned[p] = b
+	return p
+}
+
+//go:wasmexport free
+func free(p int32) {
+	if _, ok := pinned[p]; !ok {
+		panic("free of a buffer not from alloc")
+	}
+	delete(pinned, p)
+}
+
+// hostBytes returns the first n bytes of the host buffer at p.
+func hostBytes(p, n int32) []byte {
+	b, ok := pinned[p]
+	if !ok || int(n) > len(b) {
+		panic("not a buffer from alloc")
+	}
+	return b[:n]
+}
+
+// result returns b to the host in a new buffer, as its address and
+// length packed in an int64. The host must free it.
+func result(b []byte) int64 {
+	p := alloc(int32(len(b)))
+	copy(hostBytes(p, int32(len(b))), b)
+	return int64(p)<<32 | int64(len(b))
+}
+
+//go:wasmexport Upper
+func Upper(p, n int32) int64 { // string argument and result
+	s := string(hostBytes(p, n)) // copy, the host may free the buffer
+	runtime.GC()                 // host buffers must survive
+	return result([]byte(strings.ToUpper(s)))
+}
+
+//go:wasmexport Sum
+func Sum(p, n int32) int64 { // byte slice argument
+	var sum int64
+	for _, c := range hostBytes(p, n) {
+		sum += int64(c)
+	}
+	return sum
+}
+
+//go:wasmexport Live
+func Live() int32 { // number of buffers the host owns
+	return int32(len(pinned))
+}
+
+// A Rect is passed by pointer to a buffer from alloc, in which the
+// host lays it out as Go does on wasm: each field at its natural
+// alignment, little endian, 24 bytes in all.
+type Rect struct {
+	X, Y, W, H int32
+	Area       float64 // set by the guest
+}
+
+// hostRect returns the Rect in the host buffer at p.
+func hostRect(p int32) *Rect {
+	return (*Rect)(unsafe.Pointer(&hostBytes(p, int32(unsafe.Sizeof(Rect{})))[0]))
+}
+
+func (r *Rect) setArea() {
+	r.Area = float64(r.W) * float64(r.H)
+}
+
+//go:wasmexport Union
+func Union(a, b int32) int32 { // struct pointer arguments and result
+	ra, rb := hostRect(a), hostRect(b)
+	p := alloc(int32(unsafe.Sizeof(Rect{})))
+	u := hostRect(p)
+	u.X, u.Y = min(ra.X, rb.X), min(ra.Y, rb.Y)
+	u.W = max(ra.X+ra.W, rb.X+rb.W) - u.X
+	u.H = max(ra.Y+ra.H, rb.Y+rb.H) - u.Y
+	u.setArea()
+	return p
+}
+
+//go:wasmexport Scale
+func Scale(p int32, k float64) { // struct modified in place
+	r := hostRect(p)
+	r.W = int32(float64(r.W) * k)
+	r.H = int32(float64(r.H) * k)
+	r.setArea()
+}
@@ -0,0 +1,17 @@
+//go:build wasm
+
+package main
+
+// hoard keeps what Exhaust allocates live.
+var hoard [][]byte
+
+// Exhaust allocates chunks of size bytes and keeps them until the
+// runtime runs out of memory, for the driver's oom scenario, which
+// limits the memory of the module.
+//
+//go:wasmexport Exhaust
+func Exhaust(size int32) {
+	for {
+		hoard = append(hoard, make([]byte, size))
+	}
+}
@@ -0,0 +1,82 @@
+//go:build wasm
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+)
+
+// The profile exports write pprof profiles to $PPROFDIR, for the
+// driver's -pprof mode. They return 0 on success and -1 on failure.
+
+var cpuProfile *os.File
+
+func init() {
+	if os.Getenv("PPROFDIR") != "" {
+		runtime.MemProfileRate = 1 // the calls allocate little
+	}
+}
+
+func createProfile(name string) *os.File {
+	dir := os.Getenv("PPROFDIR")
+	if dir == "" {
+		println("profile: PPROFDIR not set")
+		return nil
+	}
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		println("profile:", err.Error())
+		return nil
+	}
+	return f
+}
+
+//go:wasmexport StartCPUProfile
+func StartCPUProfile() int32 { // profile the calls until StopCPUProfile
+	f := createProfile("cpu.pprof")
+	if f == nil {
+		return -1
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		println("profile:", err.Error())
+		f.Close()
+		return -1
+	}
+	cpuProfile = f
+	return 0
+}
+
+//go:wasmexport StopCPUProfile
+func StopCPUProfile() int32 {
+	if cpuProfile == nil {
+		return -1
+	}
+	pprof.StopCPUProfile()
+	err := cpuProfile.Close()
+	cpuProfile = nil
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+//go:wasmexport WriteHeapProfile
+func WriteHeapProfile() int32 {
+	f := createProfile("heap.pprof")
+	if f == nil {
+		return -1
+	}
+	runtime.GC() // up to date statistics
+	err := pprof.WriteHeapProfile(f)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		println("profile:", err.Error())
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+//go:build wasm
+
+package main
+
+import "runtime"
+
+// The trap exports fail in the ways a Go function can, for the
+// driver's traps scenario to check how each reaches the host.
+
+//go:wasmexport Panic
+func Panic() {
+	panic("intentional panic")
+}
+
+//go:wasmexport Goexit
+func Goexit() {
+	runtime.Goexit()
+}
+
+var small = []int32{1, 2, 3}
+
+//go:wasmexport Index
+func Index(i int32) int32 {
+	return small[i]
+}
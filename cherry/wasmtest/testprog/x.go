@@ -34,6 +34,9 @@ func F() int64 { // no arg, has result
 //go:wasmexport G
 func G(x int32) {
 	println("G", x)
+	if x == 1 {
+		debug.PrintStack() // traceback through host frames
+	}
 	if x%2 == 0 {
 		G(x - 1) // simple recursion within this module
 	} else {
@@ -49,6 +52,8 @@ func I() int64
 func J(int32)
 
 func main() {
+	printArgs()
+	exitIfAsked()
 	println("hello")
 	println("main: I =", I())
 }
@@ -0,0 +1,150 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// With -threads, the runtime enables the threads proposal, as a
+// testbed for Go wasm threading work, and the driver first checks
+// that shared memory and atomics work: goroutines each call their own
+// instance of a module that atomically adds to a counter in a memory
+// they all share.
+//
+// Go cannot build a module with shared memory yet, so that variant of
+// the test program is written in Wasm directly, by sharedMemModule
+// and atomicAddModule below. Go modules must still run unchanged with
+// the proposal enabled.
+
+// Wasm binary encoding.
+const (
+	secType     = 1
+	secImport   = 2
+	secFunction = 3
+	secMemory   = 5
+	secExport   = 7
+	secCode     = 10
+
+	kindFunc   = 0
+	kindMemory = 2
+
+	limitsSharedMax = 3 // min and max, shared
+	typeI32         = 0x7f
+)
+
+// uleb appends x to b in unsigned LEB128.
+func uleb(b []byte, x uint32) []byte {
+	return binary.AppendUvarint(b, uint64(x))
+}
+
+func wasmName(b []byte, s string) []byte {
+	return append(uleb(b, uint32(len(s))), s...)
+}
+
+// wasmModule returns a module made of sections, which alternate
+// section IDs and contents.
+func wasmModule(sections ...any) []byte {
+	b := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	for i := 0; i < len(sections); i += 2 {
+		b = append(b, byte(sections[i].(int)))
+		content := sections[i+1].([]byte)
+		b = uleb(b, uint32(len(content)))
+		b = append(b, content...)
+	}
+	return b
+}
+
+// sharedMemModule returns a module exporting a shared memory of one
+// page, as "memory":
+//
+//	(module (memory (export "memory") 1 1 shared))
+func sharedMemModule() []byte {
+	mem := []byte{1, limitsSharedMax, 1, 1}
+	exp := append(wasmName([]byte{1}, "memory"), kindMemory, 0)
+	return wasmModule(secMemory, mem, secExport, exp)
+}
+
+// atomicAddModule returns a module importing the memory of
+// sharedMemModule and exporting add, which atomically adds its
+// argument to the int32 at address 0 and returns its old value:
+//
+//	(module
+//		(import "shared" "memory" (memory 1 1 shared))
+//		(func (export "add") (param i32) (result i32)
+//			(i32.atomic.rmw.add (i32.const 0) (local.get 0))))
+func atomicAddModule() []byte {
+	typ := []byte{1, 0x60, 1, typeI32, 1, typeI32}
+	imp := wasmName(wasmName([]byte{1}, "shared"), "memory")
+	imp = append(imp, kindMemory, limitsSharedMax, 1, 1)
+	fn := []byte{1, 0}
+	exp := append(wasmName([]byte{1}, "add"), kindFunc, 0)
+	body := []byte{
+		0,          // no locals
+		0x41, 0x00, // i32.const 0
+		0x20, 0x00, // local.get 0
+		0xfe, 0x1e, 0x02, 0x00, // i32.atomic.rmw.add align=4 offset=0
+		0x0b, // end
+	}
+	code := append(uleb([]byte{1}, uint32(len(body))), body...)
+	return wasmModule(secType, typ, secImport, imp, secFunction, fn, secExport, exp, secCode, code)
+}
+
+// checkThreads has n goroutines each add 1 to the shared counter
+// rounds times, concurrently, through their own instance of
+// atomicAddModule, and checks the total.
+func checkThreads(ctx context.Context, r wazero.Runtime, n, rounds int) error {
+	shared, err := r.InstantiateWithConfig(ctx, sharedMemModule(), wazero.NewModuleConfig().WithName("shared"))
+	if err != nil {
+		return fmt.Errorf("shared memory module: %v", err)
+	}
+	defer shared.Close(ctx)
+	code, err := r.CompileModule(ctx, atomicAddModule())
+	if err != nil {
+		return fmt.Errorf("atomic add module: %v", err)
+	}
+	defer code.Close(ctx)
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range n {
+		// Instances of a module must not be called concurrently,
+		// so each goroutine needs its own.
+		m, err := r.InstantiateModule(ctx, code, wazero.NewModuleConfig().WithName(fmt.Sprintf("add%d", i)))
+		if err != nil {
+			return err
+		}
+		defer m.Close(ctx)
+		add := m.ExportedFunction("add")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				if _, err := add.Call(ctx, api.EncodeI32(1)); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	got, _ := shared.Memory().ReadUint32Le(0)
+	if want := uint32(n * rounds); got != want {
+		return fmt.Errorf("shared counter = %d after %d atomic adds", got, want)
+	}
+	fmt.Printf("host: %d goroutines added %d times to shared memory OK\n", n, rounds)
+	return nil
+}
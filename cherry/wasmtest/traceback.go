@@ -0,0 +1,125 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// guestStderr captures the guest's standard error, apart from its
+// standard output, for the tracebacks scenario.
+var guestStderr bytes.Buffer
+
+// A frame is a frame of a traceback printed by the guest.
+type frame struct {
+	fn   string // function, without arguments
+	args string
+	pos  string // file:line
+}
+
+// parseTracebacks returns the frames of each traceback in out, from
+// the innermost.
+func parseTracebacks(out []byte) [][]frame {
+	var tbs [][]frame
+	var cur []frame
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "goroutine ") && strings.HasSuffix(line, ":"):
+			cur = []frame{}
+			tbs = append(tbs, nil)
+		case cur == nil:
+		case strings.HasPrefix(line, "\t") && len(cur) > 0:
+			pos, _, _ := strings.Cut(strings.TrimPrefix(line, "\t"), " ")
+			cur[len(cur)-1].pos = pos
+			tbs[len(tbs)-1] = cur
+		default:
+			fn, args, ok := strings.Cut(line, "(")
+			if !ok {
+				cur = nil // end of the traceback
+				continue
+			}
+			cur = append(cur, frame{fn: fn, args: strings.TrimSuffix(args, ")")})
+			tbs[len(tbs)-1] = cur
+		}
+	}
+	return tbs
+}
+
+func funcs(tb []frame) []string {
+	var fns []string
+	for _, f := range tb {
+		fns = append(fns, f.fn)
+	}
+	return fns
+}
+
+// printStack are the frames of debug.PrintStack itself.
+var printStack = []string{"runtime/debug.Stack", "runtime/debug.PrintStack"}
+
+// checkTracebacks checks the tracebacks that the guest prints in E,
+// and in G at the bottom of its recursion through the host. The
+// traceback of an export called by the host must end at the export's
+// frame, and that of G must go on past each host frame, to the G that
+// called J, so that every level of the recursion is there.
+func checkTracebacks() error {
+	guestStderr.Reset()
+	E(argEa, argEb, argEc, argEd)
+	F()
+	tbs := parseTracebacks(guestStderr.Bytes())
+	if len(tbs) != 2 {
+		return fmt.Errorf("E printed %d tracebacks, want 2", len(tbs))
+	}
+	if want := append(printStack, "main.E"); !slices.Equal(funcs(tbs[0]), want) {
+		return fmt.Errorf("traceback in E: %v, want %v", funcs(tbs[0]), want)
+	}
+	grow := tbs[1]
+	if fns := funcs(grow); !strings.HasPrefix(strings.Join(fns, " "), strings.Join(append(printStack, "main.grow"), " ")) ||
+		fns[len(fns)-1] != "main.E" {
+		return fmt.Errorf("traceback in grow: %v, want grow frames ending at main.E", fns)
+	}
+	fmt.Printf("host: traceback in E ends at the wasmexport frame, %d frames deep after stack growth\n", len(grow))
+
+	guestStderr.Reset()
+	G(argG)
+	tbs = parseTracebacks(guestStderr.Bytes())
+	if len(tbs) != 1 {
+		return fmt.Errorf("G printed %d tracebacks, want 1", len(tbs))
+	}
+	tb := tbs[0]
+	if len(tb) != len(printStack)+int(argG) {
+		return fmt.Errorf("traceback in G(%d): %v, want a frame for each level", argG, funcs(tb))
+	}
+	// G(x) calls G(x-1) directly if x is even, else through J.
+	var direct, viaHost string
+	for i, f := range tb[len(printStack):] {
+		x := i + 1
+		if f.fn != "main.G" || f.args != fmt.Sprintf("%#x", x) {
+			return fmt.Errorf("traceback in G(%d): frame %d is %s(%s), want main.G(%#x)", argG, i, f.fn, f.args, x)
+		}
+		if x == 1 {
+			continue
+		}
+		p := &direct
+		if x%2 == 1 {
+			p = &viaHost
+		}
+		if *p == "" {
+			*p = f.pos
+		} else if *p != f.pos {
+			return fmt.Errorf("traceback in G(%d): G(%d) at %s, want %s", argG, x, f.pos, *p)
+		}
+	}
+	if direct == viaHost {
+		return fmt.Errorf("traceback in G(%d): calls of G and of J both at %s", argG, direct)
+	}
+	fmt.Printf("host: traceback in G(%d) has all %d levels, across the host\n", argG, argG)
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// A failing export call of a library module does not exit: the
+// runtime prints the failure, then executes unreachable, which the
+// host sees as a trap. In an executable, the same failures exit with
+// status 2 instead.
+
+// traps lists the exports of testprog that fail (see testprog/trap.go)
+// and what the runtime prints when they do.
+var traps = []struct {
+	export string
+	args   []uint64
+	output string
+}{
+	{"Panic", nil, "panic: intentional panic"},
+	{"Goexit", nil, "fatal error: no goroutines (main called runtime.Goexit) - deadlock!"},
+	{"Index", []uint64{api.EncodeI32(5)}, "panic: runtime error: index out of range [5] with length 3"},
+}
+
+// checkTraps calls each failing export in a fresh instance of the
+// compiled library module cm, and checks that the call traps, rather than
+// returning or exiting, after the expected output.
+func checkTraps(ctx context.Context, r wazero.Runtime, config wazero.ModuleConfig, cm wazero.CompiledModule) error {
+	for _, t := range traps {
+		var out bytes.Buffer
+		w := io.Writer(&out)
+		if *verbose {
+			w = io.MultiWriter(&out, os.Stderr)
+		}
+		m, err := reset(ctx, r, cm, nil, config.WithStdout(w).WithStderr(w))
+		if err != nil {
+			return err
+		}
+		if _, err := callExport(ctx, m.ExportedFunction("_initialize")); err != nil {
+			return fmt.Errorf("_initialize: %v", err)
+		}
+		_, err = callExport(ctx, m.ExportedFunction(t.export), t.args...)
+		m.Close(ctx)
+		var exit *sys.ExitError
+		switch {
+		case err == nil:
+			return fmt.Errorf("%s returned, want a trap", t.export)
+		case errors.As(err, &exit):
+			return fmt.Errorf("%s exited with code %d, want a trap", t.export, exit.ExitCode())
+		case !strings.HasPrefix(err.Error(), "wasm error: unreachable"):
+			return fmt.Errorf("%s: %v, want an unreachable trap", t.export, err)
+		case !strings.Contains(out.String(), t.output):
+			return fmt.Errorf("%s trapped, but output lacks %q", t.export, t.output)
+		}
+		fmt.Printf("host: %s trapped\n", t.export)
+	}
+	return nil
+}
+
+// checkExit checks that err, from running an executable or GOOS=js
+// module, is a successful exit.
+func checkExit(err error) error {
+	var exit *sys.ExitError
+	if !errors.As(err, &exit) {
+		return fmt.Errorf("_start: %v, want an exit", err)
+	}
+	if exit.ExitCode() != 0 {
+		return fmt.Errorf("exited with code %d", exit.ExitCode())
+	}
+	return nil
+}
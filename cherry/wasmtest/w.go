@@ -14,16 +14,167 @@
 // To build it as a library:
 // GOARCH=wasm GOOS=wasip1 go build -buildmode=c-shared -o /tmp/x.wasm ./testprog
 //
-// Then run the driver (which works for both modes):
-// go run w.go /tmp/x.wasm
+// To build it for GOOS=js, which only has the executable mode:
+// GOARCH=wasm GOOS=js go build -o /tmp/x.wasm ./testprog
+//
+// Then run the driver (which works for all modes):
+// go run . /tmp/x.wasm
+//
+// Or, to build the modules in all modes and run the driver on each,
+// one scenario per test (see w_test.go):
+// go test
+//
+// The driver prints the scenarios it runs and their results. To also
+// see the host's trace and the guest's output, add -v. To run only
+// some scenarios (see scenario.go), for example to debug the calls
+// into a library module that switch goroutines:
+// go run . -v -run goroutine-switch /tmp/x.wasm
+//
+// The driver heads each scenario with its name, in brackets, followed
+// by #k for its k-th run when -count repeats the scenarios. To hunt for
+// flakes, run them several times in random order, where -shuffle on
+// picks and prints a seed that -shuffle then takes to repeat the order;
+// to split them across machines, run one of n shards on each:
+// go run . -count 10 -shuffle on /tmp/x.wasm
+// go run . -shard 0/2 /tmp/x.wasm
+//
+// To list the exports of a library module and call those without
+// parameters, each in a fresh instance (see discover.go):
+// go run . -discover /tmp/x.wasm
+//
+// To run call sequences described in a JSON file against a library
+// module instead of the driver's own (see cases.go):
+// go run . -cases testdata/cases.json /tmp/x.wasm
+//
+// To call the echo exports of a library module with random arguments,
+// including NaNs, infinities and extreme values (see fuzz.go):
+// go run . -fuzz 10000 -seed 1 /tmp/x.wasm
+//
+// To run the module in wasmtime instead of wazero, with the C host
+// below, built against the wasmtime C API in $WASMTIME (see
+// wasmtime.go):
+// go run . -runtime wasmtime /tmp/x.wasm
+//
+// To call the exports of a library module from many host goroutines
+// at once, serialized, while the guest's goroutines wait to be woken
+// (see stress.go):
+// go run . -stress 8 /tmp/x.wasm
+//
+// To enable the threads proposal in the runtime, and check shared
+// memory and atomics with a module written in Wasm, as Go cannot build
+// one yet (see threads.go):
+// go run . -threads /tmp/x.wasm
+//
+// wazero compiles the module to machine code. Its interpreter runs
+// the same scenarios through other code, so a failure under only one
+// of the engines points at that engine (see gc.go for one):
+// go run . -engine interpreter /tmp/x.wasm
+//
+// To pass arguments and environment variables to the module, after
+// its name and before those the driver sets (see args.go):
+// go run . -guest-args "a b" -guest-env K=v,L=w /tmp/x.wasm
+//
+// To limit the linear memory of the module, and check that the guest
+// runtime throws when it runs out (see oom.go):
+// go run . -max-memory 64 /tmp/x.wasm
+//
+// The driver compiles the module once, and resets it between
+// scenarios by instantiating the compiled module again. To also keep
+// the compiled modules across runs, which saves most of the start-up
+// time:
+// go run . -cache /tmp/wasmtest-cache /tmp/x.wasm
+//
+// The guest gets the real time and random bytes by default. To make
+// them deterministic, so that runs can be compared (see clock.go):
+// go run . -fake-time 2024-01-01T00:00:00Z -seed 1 /tmp/x.wasm
+//
+// To collect the coverage of the guest, build the module with -cover
+// -covermode=atomic, then (see cover.go):
+// go run . -cover /tmp/cover /tmp/x.wasm
+//
+// To write CPU and heap profiles of calls into a library module, and
+// print their top functions (see pprof.go):
+// go run . -pprof /tmp/prof -pprof-top /tmp/x.wasm
+//
+// The driver prefixes each line of guest output with the module and
+// the stream. To also write the whole output, host trace included, to
+// a file (see output.go):
+// go run . -log-file /tmp/x.log /tmp/x.wasm
+//
+// To check the output of a run against a transcript, which -update
+// writes (see golden.go):
+// go run . -golden /tmp/x.golden -update /tmp/x.wasm
+// go run . -golden /tmp/x.golden /tmp/x.wasm
+//
+// To build testprog as an executable for both wasip1 and js, run
+// both, and diff their output, to find where the ports differ (see
+// ports.go):
+// go run . -ports
+//
+// To check that the compiler rejects exports with unsupported
+// signatures, each marked with the expected error in
+// testprog-invalid (see invalid.go):
+// go run . -invalid testprog-invalid
+//
+// To generate an equivalent host program in C, using the wasmtime
+// C API, which performs the same export calls as this driver:
+// go run . -gen-c /tmp/host.c
+//
+// To print the size of each section of the module, and its imports
+// and exports, to follow the size of Go's Wasm output (see
+// analyze.go):
+// go run . -analyze /tmp/x.wasm
+//
+// To print a JSON report of the module's exports and imports,
+// with their Wasm signatures and the Go types declared in testprog:
+// go run . -describe /tmp/x.wasm
+//
+// In library mode, the driver checks that the memory size settles
+// after repeated E calls, which grow the stack and run the GC. To also
+// dump a region of the linear memory afterwards:
+// go run . -dump 0x10000:256 /tmp/x.wasm
+//
+// To look for slow leaks, run the scenarios of a library module in a
+// loop for a long time against a single instance, sampling the guest
+// memory size and host RSS (see soak.go), -run selecting them as usual:
+// go run . -soak 4h /tmp/x.wasm
+//
+// To measure the latency of export calls and host/guest recursion
+// in library mode (see bench.go), without the overhead of the
+// watchdog's function listener:
+// go run . -bench -timeout 0 /tmp/x.wasm
+//
+// To measure the time to compile the module, instantiate it and, for
+// a library, initialize it, each in a new runtime, so that start-up
+// regressions show up (see startup.go):
+// go run . -startup -count 20 /tmp/x.wasm
+//
+// Every export call must return within -timeout (1m by default), or
+// the driver prints the guest stack and fails (see watchdog.go):
+// go run . -timeout 10s /tmp/x.wasm
+//
+// In library mode, the driver also passes strings, byte slices and
+// structs to the module through its linear memory, in buffers
+// allocated by the guest (see mem.go).
+//
+// To check calls between two Go modules, build linkprog as a library
+// and link it with a library testprog, whose exports it imports:
+// GOARCH=wasm GOOS=wasip1 go build -buildmode=c-shared -o /tmp/l.wasm ./linkprog
+// go run . -link /tmp/l.wasm /tmp/x.wasm
 package main
 
 import (
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
@@ -35,117 +186,670 @@ var E func(a int64, b int32, c float64, d float32)
 var F func() int64
 var G func(int32)
 
+// Arguments of the export calls made by I. They are shared with the
+// generated C host (see cgen.go), so that both hosts run the same calls.
+const (
+	argEa int64   = 20
+	argEb int32   = 3
+	argEc float64 = 0.4
+	argEd float32 = 0.05
+	argG  int32   = 4
+	mulF  int64   = 2
+)
+
+// quiet suppresses the host's output, without -v and for -soak.
+var quiet bool
+
+// jCalls counts the calls of J, for the reentrancy scenario.
+var jCalls int
+
 func I() int64 {
-	println("I start")
-	E(20, 3, 0.4, 0.05)
-	r := F() * 2
-	G(4)
-	println("I end =", r)
+	if !quiet {
+		println("I start")
+	}
+	E(argEa, argEb, argEc, argEd)
+	r := F() * mulF
+	G(argG)
+	if !quiet {
+		println("I end =", r)
+	}
 	return r
 }
 
 func J(x int32) {
-	println("J", x)
+	jCalls++
+	if !quiet {
+		println("J", x)
+	}
 	if x > 0 {
 		G(x)
 	}
-	println("J", x, "end")
+	if !quiet {
+		println("J", x, "end")
+	}
 }
 
+var (
+	engineFlag   = flag.String("engine", "compiler", "run modules with wazero's `engine`: compiler, or interpreter")
+	coverDir     = flag.String("cover", "", "collect the coverage data of a module built with -cover in `dir`, and report it (see cover.go)")
+	pprofDir     = flag.String("pprof", "", "in library mode, write CPU and heap profiles of calls of I to `dir` instead (see pprof.go)")
+	pprofTop     = flag.Bool("pprof-top", false, "with -pprof, print the top of the profiles")
+	maxMemory    = flag.Uint("max-memory", 0, "limit the linear memory of modules to `MiB` (default 4 GiB), and check running out of it (see oom.go)")
+	cacheDir     = flag.String("cache", "", "keep compiled modules in `dir`, to reuse them in later runs")
+	runtimeFlag  = flag.String("runtime", "wazero", "run the module in `engine`: wazero, or wasmtime (see wasmtime.go)")
+	argsFlag     = flag.String("guest-args", "", "pass the space-separated `args` to the module, after its name")
+	envFlag      = flag.String("guest-env", "", "pass the comma-separated `KEY=value` list to the module as its environment")
+	runFlag      = flag.String("run", "", "run only the comma-separated `scenarios`: executable, library, goroutine-switch, reentrancy, blocking, args, spill, floats, gc, tracebacks, host-errors, init, exit, traps, oom (default all)")
+	verbose      = flag.Bool("v", false, "print the host's trace and the guest's output as they run")
+	logFile      = flag.String("log-file", "", "also write the full transcript of the run, as with -v, to `file`")
+	goldenFile   = flag.String("golden", "", "compare the combined output of the run with the transcript in `file`")
+	updateFlag   = flag.Bool("update", false, "with -golden, write the output to the file instead; in go test, rewrite the golden files in testdata")
+	analyzeFlag  = flag.Bool("analyze", false, "print the sizes of the module's sections, and its imports and exports, then exit (see analyze.go)")
+	describeFlag = flag.Bool("describe", false, "print a JSON report of the module's exports and imports, then exit")
+	testprogDir  = flag.String("testprog", "testprog", "directory of the test program `source`, for -describe and -ports")
+	invalidDir   = flag.String("invalid", "", "check that the compiler rejects the exports of the program in `dir`, such as testprog-invalid, then exit (see invalid.go)")
+	portsFlag    = flag.Bool("ports", false, "build the test program for wasip1 and js, run both and diff their output, then exit (see ports.go)")
+	genC         = flag.String("gen-c", "", "write an equivalent C host program to `file`, then exit")
+	linkFile     = flag.String("link", "", "in library mode, also instantiate the linkprog module in `file`, importing from this one, and call it")
+	dumpFlag     = flag.String("dump", "", "in library mode, hex dump the linear memory in `off:len` after the calls")
+	discoverFlag = flag.Bool("discover", false, "in library mode, list the exports and call those without parameters instead (see discover.go)")
+	casesFile    = flag.String("cases", "", "in library mode, run the call sequences in the JSON `file` instead (see cases.go)")
+	fuzzN        = flag.Int("fuzz", 0, "in library mode, call the echo exports with `n` rounds of random arguments instead (see fuzz.go)")
+	fuzzSeed     = flag.Uint64("seed", 1, "the `seed` of the arguments of -fuzz and, if set, of the guest's random bytes")
+	fakeTime     = flag.String("fake-time", "", "start the guest's clocks at `time`, in RFC 3339 format, and advance them deterministically (see clock.go)")
+	stressN      = flag.Int("stress", 0, "in library mode, call E and F from `n` host goroutines instead (see stress.go)")
+	stressRounds = flag.Int("stress-rounds", 100, "with -stress, the number of E and F calls of each goroutine")
+	threadsFlag  = flag.Bool("threads", false, "enable the threads proposal, and check shared memory and atomics first (see threads.go)")
+	startupFlag  = flag.Bool("startup", false, "measure the time to compile, instantiate and initialize the module instead (see startup.go)")
+	countFlag    = flag.Int("count", 0, "run each selected scenario `n` times, or with -startup, measure n times (default 1, or 10 with -startup)")
+	shuffleFlag  = flag.String("shuffle", "off", "run the selected scenarios in random order: `off`, on, or with the given seed")
+	shardFlag    = flag.String("shard", "", "run only shard `i/n` of the selected scenarios, every n-th of them from the i-th")
+	benchFlag    = flag.Bool("bench", false, "in library mode, measure the latency of export calls instead of checking them")
+	timeout      = flag.Duration("timeout", time.Minute, "fail an export call that does not return within `duration`, printing the guest stack (0 to disable)")
+	soakDur      = flag.Duration("soak", 0, "in library mode, run the scenarios in a loop for `duration`, checking for leaks")
+	soakInterval = flag.Duration("soak-interval", time.Minute, "with -soak, sample memory usage every `interval`")
+	soakGrowth   = flag.Float64("soak-growth", 0.1, "with -soak, fail if memory keeps growing, by more than this `fraction` overall")
+)
+
+// Guest output goes to stdout and stderr, which prefix its lines (see
+// output.go) and, without -v, both hold it back in errbuf, to print on
+// failure. shouldPanic also looks for the runtime's messages in errbuf.
 var errbuf bytes.Buffer
-var stderr = io.MultiWriter(os.Stderr, &errbuf)
+var stdout, stderr io.Writer
 
 func main() {
-	ctx := context.Background()
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: w [flags] x.wasm")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *genC != "" {
+		if err := writeCHost(*genC); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if *invalidDir != "" {
+		if err := checkInvalid(*invalidDir); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *portsFlag {
+		if err := comparePorts(*testprogDir); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	run, err := parseRun(*runFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-run:", err)
+		os.Exit(2)
+	}
+	if *shardFlag != "" {
+		i, n, err := parseShard(*shardFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-shard:", err)
+			os.Exit(2)
+		}
+		shard(run, i, n)
+	}
+	if *goldenFile != "" {
+		if err := runGolden(*goldenFile, *updateFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *logFile != "" {
+		if err := runLogged(*logFile); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(flag.Arg(0)), ".wasm")
+	stdout, stderr = guestOutput(name)
+	guestArgs = append([]string{name}, strings.Fields(*argsFlag)...)
+	env, err := parseGuestEnv(*envFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-guest-env:", err)
+		os.Exit(2)
+	}
+	quiet = !*verbose
+	if *fakeTime != "" {
+		t, err := time.Parse(time.RFC3339, *fakeTime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-fake-time:", err)
+			os.Exit(2)
+		}
+		clk.setFakeTime(t)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			clk.setSeed(*fuzzSeed)
+		}
+	})
+	switch *engineFlag {
+	case "compiler", "interpreter":
+	default:
+		fmt.Fprintf(os.Stderr, "-engine: unknown engine %q, want compiler or interpreter\n", *engineFlag)
+		os.Exit(2)
+	}
+	switch *runtimeFlag {
+	case "wazero":
+	case "wasmtime":
+		if err := runWasmtime(flag.Arg(0), run); err != nil {
+			fail(err)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "-runtime: unknown engine %q, want wazero or wasmtime\n", *runtimeFlag)
+		os.Exit(2)
+	}
 
-	r := wazero.NewRuntime(ctx)
+	r, ctx := newRuntime(context.Background())
 	defer r.Close(ctx)
 
-	// provide import functions from host
-	_, err := r.NewHostModuleBuilder("test").
-		NewFunctionBuilder().WithFunc(I).Export("I").
-		NewFunctionBuilder().WithFunc(J).Export("J").
-		Instantiate(ctx)
+	buf, err := os.ReadFile(flag.Arg(0))
 	if err != nil {
 		panic(err)
 	}
 
-	buf, err := os.ReadFile(os.Args[1])
-	if err != nil {
+	if *analyzeFlag {
+		if err := analyze(ctx, os.Stdout, r, buf); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	if *describeFlag {
+		if err := describe(ctx, os.Stdout, r, buf, *testprogDir); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if err := instantiateHost(ctx, r); err != nil {
 		panic(err)
 	}
 
-	config := wazero.NewModuleConfig().
-		WithStdout(os.Stdout).WithStderr(stderr).
+	config := clk.configure(wazero.NewModuleConfig()).
+		WithStdout(stdout).WithStderr(stderr).
+		WithArgs(guestArgs...).
 		WithStartFunctions() // don't call _start
+	for _, kv := range env {
+		config = withGuestEnv(config, kv)
+	}
+	// Host directories for the guest to write to, which it finds
+	// in the environment.
+	fsConfig := wazero.NewFSConfig()
+	for _, d := range []struct {
+		dir        *string
+		guest, env string
+	}{
+		{coverDir, guestCoverDir, "GOCOVERDIR"},
+		{pprofDir, guestPprofDir, "PPROFDIR"},
+	} {
+		if *d.dir == "" {
+			continue
+		}
+		dir, err := filepath.Abs(*d.dir)
+		if err == nil {
+			err = os.MkdirAll(dir, 0777)
+		}
+		if err != nil {
+			fail(err)
+		}
+		*d.dir = dir
+		fsConfig = fsConfig.WithDirMount(dir, d.guest)
+		config = withGuestEnv(config, d.env+"="+d.guest)
+	}
+	config = config.WithFSConfig(fsConfig)
 
-	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+	if *startupFlag {
+		count := *countFlag
+		if count == 0 {
+			count = 10
+		}
+		if err := startup(buf, config.WithStdout(io.Discard).WithStderr(io.Discard), count); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	if *threadsFlag {
+		fmt.Println("Threads: shared memory and atomics")
+		if err := checkThreads(ctx, r, 8, 10000); err != nil {
+			fail(err)
+		}
+		fmt.Println()
+	}
+
+	if isComponent(buf) {
+		fmt.Fprintln(os.Stderr, flag.Arg(0)+": is a component (wasip2), which the driver cannot run yet (see component.go)")
+		os.Exit(2)
+	}
+	cm, err := r.CompileModule(ctx, buf)
+	if err != nil {
+		panic(err)
+	}
+	var js *jsHost
+	if usesGoJS(cm) {
+		js = newJSHost(stdout, stderr)
+		if err := js.instantiate(ctx, r); err != nil {
+			panic(err)
+		}
+	}
 
-	m, err := r.InstantiateWithConfig(ctx, buf, config)
+	m, err := r.InstantiateModule(ctx, cm, config)
 	if err != nil {
 		panic(err)
 	}
 
-	// get export functions from the module
+	bindExports(ctx, m)
+
+	entry := m.ExportedFunction("_start")
+	if (js != nil || entry != nil) && (*soakDur > 0 || *linkFile != "" || *benchFlag || *casesFile != "" || *fuzzN > 0 || *stressN > 0 || *discoverFlag || *pprofDir != "") {
+		fmt.Fprintln(os.Stderr, "-soak, -link, -bench, -cases, -fuzz, -stress, -discover and -pprof require a module built with -buildmode=c-shared")
+		os.Exit(2)
+	}
+	if entry == nil && js == nil && !anyLibrary(run) ||
+		js != nil && !run["executable"] ||
+		entry != nil && !run["executable"] && !run["args"] && !run["exit"] {
+		fmt.Println("no selected scenario applies to this module")
+		return
+	}
+	// fresh returns m the first time, and a new instance after, as
+	// the module exits when it runs, and -count may run it again.
+	used := false
+	fresh := func() (api.Module, error) {
+		var err error
+		if used {
+			m, err = reset(ctx, r, cm, m, config)
+		}
+		used = true
+		return m, err
+	}
+	if js != nil && *coverDir != "" {
+		fmt.Fprintln(os.Stderr, "-cover is not supported for GOOS=js modules")
+		os.Exit(2)
+	}
+	if js != nil {
+		// JS mode: like executable mode, but started the way
+		// wasm_exec.js does (see gojs.go).
+		runScenarios("JS", []scenario{
+			{"executable", "run", func() error {
+				m, err := fresh()
+				if err != nil {
+					return err
+				}
+				err = js.run(ctx, m, guestArgs, guestEnv)
+				fmt.Println(err)
+				propagateExit(err)
+				return checkExit(err)
+			}},
+		}, run)
+		return
+	}
+
+	if entry != nil {
+		// Executable mode.
+		runScenarios("Executable", []scenario{
+			{"executable", "start", func() error {
+				m, err := fresh()
+				if err != nil {
+					return err
+				}
+				_, err = callExport(ctx, m.ExportedFunction("_start"))
+				fmt.Println(err)
+				propagateExit(err)
+				return checkExit(err)
+			}},
+			{"args", "args and environment", func() error { return checkExecutableArgs(ctx, r, config, cm) }},
+			{"exit", "exit codes", func() error { return checkExitCodes(ctx, r, config, cm, false) }},
+		}, run)
+		if *coverDir != "" {
+			fmt.Println()
+			if err := reportCoverage(*coverDir); err != nil {
+				fail(err)
+			}
+		}
+		return
+	}
+
+	// Library mode.
+	if *discoverFlag {
+		if err := discover(ctx, r, config, cm); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if *casesFile != "" {
+		failed, err := runCases(ctx, r, config, cm, *casesFile)
+		if err != nil {
+			fail(err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if run["library"] {
+		fmt.Println("Libaray mode: call export before initialization")
+		shouldPanic(func() { I() })
+		if err := checkPreInit(ctx, r, config, cm); err != nil {
+			fail(err)
+		}
+	}
+	// reset module
+	switch {
+	case *soakDur > 0:
+		// Likewise, but the tracebacks scenario reads the standard
+		// error in guestStderr, which soak resets.
+		config = config.WithStdout(io.Discard).WithStderr(&guestStderr)
+	case *benchFlag || *stressN > 0:
+		// Guest output would accumulate in errbuf.
+		config = config.WithStdout(io.Discard).WithStderr(io.Discard)
+	}
+	// named, so that the -link module can import from it
+	m, err = reset(ctx, r, cm, m, config.WithName("x"))
+	if err != nil {
+		panic(err)
+	}
+	bindExports(ctx, m)
+	fmt.Println("Library mode: initialize")
+	entry = m.ExportedFunction("_initialize")
+	_, err = callExport(ctx, entry)
+	if err != nil {
+		panic(err)
+	}
+	if *benchFlag {
+		fmt.Println("\nLibrary mode: benchmark")
+		quiet = true
+		bench(ctx, m)
+		return
+	}
+	if *pprofDir != "" {
+		fmt.Println("\nLibrary mode: profile")
+		if err := profile(ctx, m, *pprofDir, 100, *pprofTop); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if *stressN > 0 {
+		fmt.Println("\nLibrary mode: stress with", *stressN, "goroutines")
+		if err := stress(ctx, m, *stressN, *stressRounds); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if *fuzzN > 0 {
+		fmt.Println("\nLibrary mode: fuzz")
+		if err := fuzz(ctx, m, *fuzzN, *fuzzSeed); err != nil {
+			fail(err)
+		}
+		return
+	}
+	library := []scenario{
+		{"library", "call export functions", func() error {
+			fmt.Println("host: I =", I())
+
+			fmt.Println("\nLibrary mode: memory growth")
+			if err := checkGrowth(m, 3); err != nil {
+				return err
+			}
+
+			fmt.Println("\nLibrary mode: pass strings and byte slices")
+			if err := testMem(guestMem{ctx, m}); err != nil {
+				return err
+			}
+
+			fmt.Println("\nLibrary mode: pass structs by pointer")
+			return testStructs(guestMem{ctx, m})
+		}},
+		{"goroutine-switch", "goroutine switch", func() error { return goroutineSwitch(3) }},
+		{"reentrancy", "reentrancy", func() error { return reentrancy(2 * argG) }},
+		{"blocking", "blocking host imports", func() error { return checkBlocking(ctx, m) }},
+		{"args", "args and environment", func() error { return checkArgs(guestMem{ctx, m}) }},
+		{"spill", "many arguments", func() error {
+			f := fuzzer{rand.New(rand.NewPCG(1, 1))}
+			for range 100 {
+				if err := checkSpill(ctx, m, f); err != nil {
+					return err
+				}
+			}
+			fmt.Println("host: Spill and SpillSum OK")
+			return nil
+		}},
+		{"floats", "floating-point edge cases", func() error { return checkFloats(ctx, m) }},
+		{"gc", "gc and finalizers", func() error { return checkGC(guestMem{ctx, m}, 6) }},
+		{"tracebacks", "tracebacks", checkTracebacks},
+		{"host-errors", "host imports that fail or block", func() error { return checkHostErrors(ctx, r, config, cm) }},
+		{"init", "initialization", func() error { return checkInit(ctx, r, config, cm) }},
+		{"exit", "exit codes", func() error { return checkExitCodes(ctx, r, config, cm, true) }},
+		{"traps", "traps", func() error { return checkTraps(ctx, r, config, cm) }},
+		{"oom", "out of memory", func() error {
+			if *maxMemory == 0 {
+				fmt.Println("host: skipped, without -max-memory")
+				return nil
+			}
+			return checkOOM(ctx, r, config, cm)
+		}},
+	}
+	if *soakDur > 0 {
+		fmt.Println("\nLibrary mode: soak for", *soakDur)
+		quiet = true
+		if err := soak(m, library, run, *soakDur, *soakInterval, *soakGrowth); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	runScenarios("Library", library, run)
+
+	if *dumpFlag != "" {
+		off, n, err := parseRange(*dumpFlag)
+		if err == nil {
+			fmt.Printf("\nLibrary mode: memory at %#x\n", off)
+			err = dumpMemory(os.Stdout, m, off, n)
+		}
+		if err != nil {
+			fail(fmt.Errorf("-dump: %v", err))
+		}
+	}
+
+	if *linkFile != "" {
+		lbuf, err := os.ReadFile(*linkFile)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println()
+		lout, lerr := guestOutput(strings.TrimSuffix(filepath.Base(*linkFile), ".wasm"))
+		if err := link(ctx, r, config.WithStdout(lout).WithStderr(lerr), lbuf); err != nil {
+			fail(err)
+		}
+	}
+
+	if *coverDir != "" {
+		fmt.Println("\nLibrary mode: coverage")
+		if err := writeCoverage(ctx, m); err != nil {
+			fail(err)
+		}
+		if err := reportCoverage(*coverDir); err != nil {
+			fail(err)
+		}
+	}
+}
+
+// instantiateHost instantiates in r the modules that provide the
+// imports of the guest: the host's own, as module "test", and WASI.
+func instantiateHost(ctx context.Context, r wazero.Runtime) error {
+	_, err := r.NewHostModuleBuilder("test").
+		NewFunctionBuilder().WithFunc(I).Export("I").
+		NewFunctionBuilder().WithFunc(J).Export("J").
+		NewFunctionBuilder().WithGoFunction(api.GoFunc(EchoF32), []api.ValueType{api.ValueTypeF32}, []api.ValueType{api.ValueTypeF32}).Export("EchoF32").
+		NewFunctionBuilder().WithGoFunction(api.GoFunc(EchoF64), []api.ValueType{api.ValueTypeF64}, []api.ValueType{api.ValueTypeF64}).Export("EchoF64").
+		NewFunctionBuilder().WithFunc(Panic).Export("Panic").
+		NewFunctionBuilder().WithFunc(Fail).Export("Fail").
+		NewFunctionBuilder().WithFunc(Exit).Export("Exit").
+		NewFunctionBuilder().WithFunc(Block).Export("Block").
+		NewFunctionBuilder().WithFunc(Sleep).Export("Sleep").
+		NewFunctionBuilder().WithFunc(Timer).Export("Timer").
+		NewFunctionBuilder().WithFunc(Init).Export("Init").
+		Instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = wasi_snapshot_preview1.Instantiate(ctx, r)
+	return err
+}
+
+// reset closes the instance m, if any, and returns a fresh instance of
+// the compiled module cm, with its initial memory and globals.
+// Instantiating a compiled module skips decoding and compiling it
+// again, which takes most of the time for a Go module.
+func reset(ctx context.Context, r wazero.Runtime, cm wazero.CompiledModule, m api.Module, config wazero.ModuleConfig) (api.Module, error) {
+	if m != nil {
+		if err := m.Close(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return r.InstantiateModule(ctx, cm, config)
+}
+
+// bindExports sets E, F and G to call the exports of m.
+func bindExports(ctx context.Context, m api.Module) {
 	E = func(a int64, b int32, c float64, d float32) {
 		exp := m.ExportedFunction("E")
-		_, err := exp.Call(ctx, api.EncodeI64(a), api.EncodeI32(b), api.EncodeF64(c), api.EncodeF32(d))
+		_, err := callExport(ctx, exp, api.EncodeI64(a), api.EncodeI32(b), api.EncodeF64(c), api.EncodeF32(d))
 		if err != nil {
 			panic(err)
 		}
 	}
 	F = func() int64 {
 		exp := m.ExportedFunction("F")
-		r, err := exp.Call(ctx)
+		r, err := callExport(ctx, exp)
 		if err != nil {
 			panic(err)
 		}
 		rr := int64(r[0])
-		println("host: F =", rr)
+		if !quiet {
+			println("host: F =", rr)
+		}
 		return rr
 	}
 	G = func(x int32) {
 		exp := m.ExportedFunction("G")
-		_, err := exp.Call(ctx, api.EncodeI32(x))
+		_, err := callExport(ctx, exp, api.EncodeI32(x))
 		if err != nil {
 			panic(err)
 		}
 	}
+}
 
-	entry := m.ExportedFunction("_start")
-	if entry != nil {
-		// Executable mode.
-		fmt.Println("Executable mode: start")
-		_, err := entry.Call(ctx)
-		fmt.Println(err)
-		return
+// memPages returns the size of the linear memory of m, in pages.
+func memPages(m api.Module) uint32 {
+	return m.Memory().Size() / 65536
+}
+
+// readMemory returns a copy of the n bytes at offset off in the linear
+// memory of m.
+func readMemory(m api.Module, off, n uint32) ([]byte, error) {
+	b, ok := m.Memory().Read(off, n)
+	if !ok {
+		return nil, fmt.Errorf("memory [%#x, %#x) out of range: size is %#x", off, uint64(off)+uint64(n), m.Memory().Size())
 	}
+	return bytes.Clone(b), nil
+}
 
-	// Library mode.
-	fmt.Println("Libaray mode: call export before initialization")
-	shouldPanic(func() { I() })
-	// reset module
-	m, err = r.InstantiateWithConfig(ctx, buf, config)
+// dumpMemory writes a hex dump of the n bytes at offset off in the
+// linear memory of m to w, with their addresses.
+func dumpMemory(w io.Writer, m api.Module, off, n uint32) error {
+	b, err := readMemory(m, off, n)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Println("Library mode: initialize")
-	entry = m.ExportedFunction("_initialize")
-	_, err = entry.Call(ctx)
-	if err != nil {
-		panic(err)
+	for i := 0; i < len(b); i += 16 {
+		line := b[i:min(i+16, len(b))]
+		text := bytes.Map(func(r rune) rune {
+			if r < ' ' || r > '~' {
+				return '.'
+			}
+			return r
+		}, line)
+		fmt.Fprintf(w, "%08x  %-47s  |%s|\n", off+uint32(i), fmt.Sprintf("% x", line), text)
+	}
+	return nil
+}
+
+// parseRange parses a memory range given as off:len.
+func parseRange(s string) (off, n uint32, err error) {
+	o, l, ok := strings.Cut(s, ":")
+	x, err1 := strconv.ParseUint(o, 0, 32)
+	y, err2 := strconv.ParseUint(l, 0, 32)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("bad range %q, want off:len", s)
+	}
+	return uint32(x), uint32(y), nil
+}
+
+// checkGrowth calls E, then F, which receives what E's goroutine
+// sends, rounds times, and reports the memory size before and after
+// each call. E grows the stack and runs the GC, which may grow the
+// memory the first time, but once the heap and the stacks have reached
+// their size, repeating it must not grow the memory any more.
+func checkGrowth(m api.Module, rounds int) error {
+	var first uint32
+	for i := range rounds {
+		before := memPages(m)
+		E(argEa, argEb, argEc, argEd)
+		afterE := memPages(m)
+		F()
+		after := memPages(m)
+		fmt.Printf("host: round %d: %d pages before E, %d after E, %d after F\n", i, before, afterE, after)
+		if i == 0 {
+			first = after
+		} else if after != first {
+			return fmt.Errorf("memory grew from %d to %d pages after %d rounds of E and F", first, after, i)
+		}
 	}
-	fmt.Println("\nLibrary mode: call export functions")
-	I()
+	return nil
 }
 
 func shouldPanic(f func()) {
+	errbuf.Reset()
 	defer func() {
 		e := recover()
 		if e == nil {
 			panic("did not panic")
 		}
-		if !bytes.Contains(errbuf.Bytes(), []byte("runtime: wasmexport function called before runtime initialization")) {
+		if !bytes.Contains(errbuf.Bytes(), []byte(preInitOutput)) {
 			panic("expected error message missing")
 		}
 	}()
@@ -0,0 +1,301 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The tests build testprog and linkprog in each mode, then run the
+// driver on them, one scenario or mode per test, so that
+//
+//	go test
+//
+// replaces building the modules and running the driver by hand. The
+// driver is the test binary itself, run with $WASMTEST_DRIVER set.
+
+// modules are the paths of the modules that TestMain builds, by name.
+var modules = make(map[string]string)
+
+// testCacheDir keeps the compiled modules across the runs of the
+// driver.
+var testCacheDir string
+
+var builds = []struct {
+	name, dir, goos string
+	lib             bool
+}{
+	{"exe", "./testprog", "wasip1", false},
+	{"lib", "./testprog", "wasip1", true},
+	{"js", "./testprog", "js", false},
+	{"link", "./linkprog", "wasip1", true},
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv("WASMTEST_DRIVER") != "" {
+		main()
+		os.Exit(0)
+	}
+	dir, err := os.MkdirTemp("", "wasmtest")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := 1
+	if err := buildModules(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		code = m.Run()
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// buildModules builds the modules in dir, and sets modules and
+// testCacheDir.
+func buildModules(dir string) error {
+	for _, b := range builds {
+		file := filepath.Join(dir, b.name+".wasm")
+		args := []string{"build", "-o", file}
+		if b.lib {
+			args = append(args, "-buildmode=c-shared")
+		}
+		cmd := exec.Command("go", append(args, b.dir)...)
+		cmd.Env = append(os.Environ(), "GOOS="+b.goos, "GOARCH=wasm")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("building %s for %s: %v\n%s", b.dir, b.goos, err, out)
+		}
+		modules[b.name] = file
+	}
+	testCacheDir = filepath.Join(dir, "cache")
+	return nil
+}
+
+// runDriver runs the driver with args, fails t if it fails, and
+// returns its standard output.
+func runDriver(t *testing.T, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-cache", testCacheDir}, args...)...)
+	cmd.Env = append(os.Environ(), "WASMTEST_DRIVER=1")
+	var stdout, out bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, &out)
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("driver %s: %v\n%s", strings.Join(args, " "), err, &out)
+	}
+	if testing.Verbose() {
+		t.Logf("%s", &out)
+	}
+	return stdout.Bytes()
+}
+
+// engines are the wazero engines, which the mode tests each run.
+var engines = []string{"compiler", "interpreter"}
+
+func TestExecutableMode(t *testing.T) {
+	for _, e := range engines {
+		t.Run(e, func(t *testing.T) {
+			runDriver(t, "-engine", e, modules["exe"])
+		})
+	}
+}
+
+func TestJSMode(t *testing.T) {
+	runDriver(t, modules["js"])
+}
+
+func TestLibraryMode(t *testing.T) {
+	for _, e := range engines {
+		for _, s := range scenarios {
+			if s == "executable" {
+				continue
+			}
+			t.Run(e+"/"+s, func(t *testing.T) {
+				runDriver(t, "-engine", e, "-run", s, modules["lib"])
+			})
+		}
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	for i := range 2 {
+		t.Run(fmt.Sprint("shard", i), func(t *testing.T) {
+			runDriver(t, "-count", "2", "-shuffle", "1", "-shard", fmt.Sprint(i, "/2"), modules["lib"])
+		})
+	}
+}
+
+func TestLinkMode(t *testing.T) {
+	runDriver(t, "-link", modules["link"], modules["lib"])
+}
+
+func TestOOM(t *testing.T) {
+	runDriver(t, "-max-memory", "64", "-run", "oom", modules["lib"])
+}
+
+func TestArgs(t *testing.T) {
+	for _, m := range []string{"exe", "lib"} {
+		t.Run(m, func(t *testing.T) {
+			runDriver(t, "-guest-args", "one two", "-guest-env", "A=1,B=x=y", "-run", "args", modules[m])
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	for _, m := range []string{"exe", "lib"} {
+		t.Run(m, func(t *testing.T) {
+			runDriver(t, "-analyze", modules[m])
+		})
+	}
+}
+
+// TestDescribe compares the ABI report of the library build of
+// testprog with testdata/describe.json, which -update rewrites. A new
+// Go toolchain may change the imports of the runtime, and so the
+// report; the diff of the file then shows how.
+func TestDescribe(t *testing.T) {
+	got := runDriver(t, "-describe", modules["lib"])
+	golden := filepath.Join("testdata", "describe.json")
+	if *updateFlag {
+		if err := os.WriteFile(golden, got, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("-describe %s =\n%s\nwant (%s)\n%s", modules["lib"], got, golden, want)
+	}
+}
+
+// TestCHost compares the C host of -gen-c with
+// testdata/host.c.golden, which -update rewrites.
+func TestCHost(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "host.c")
+	if err := writeCHost(file); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "host.c.golden")
+	if *updateFlag {
+		if err := os.WriteFile(golden, got, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("-gen-c output differs from %s; run go test -run CHost -update and check the diff", golden)
+	}
+}
+
+// TestWasmtime builds the C host and runs the modules with it, if
+// $WASMTIME names the wasmtime C API to build against.
+func TestWasmtime(t *testing.T) {
+	if os.Getenv("WASMTIME") == "" {
+		t.Skip("$WASMTIME not set")
+	}
+	for _, m := range []string{"exe", "lib"} {
+		t.Run(m, func(t *testing.T) {
+			runDriver(t, "-runtime", "wasmtime", modules[m])
+		})
+	}
+}
+
+func TestPorts(t *testing.T) {
+	runDriver(t, "-ports")
+}
+
+func TestInvalid(t *testing.T) {
+	runDriver(t, "-invalid", "testprog-invalid")
+}
+
+func TestCases(t *testing.T) {
+	runDriver(t, "-cases", "testdata/cases.json", modules["lib"])
+}
+
+func TestDiscover(t *testing.T) {
+	runDriver(t, "-discover", modules["lib"])
+}
+
+func TestFuzz(t *testing.T) {
+	runDriver(t, "-fuzz", "1000", modules["lib"])
+}
+
+func TestStress(t *testing.T) {
+	runDriver(t, "-stress", "4", modules["lib"])
+}
+
+func TestSoak(t *testing.T) {
+	runDriver(t, "-soak", "2s", "-soak-interval", "500ms", "-run", "library,goroutine-switch,reentrancy,gc,tracebacks", modules["lib"])
+}
+
+func TestLeaking(t *testing.T) {
+	tests := []struct {
+		samples []uint64
+		want    bool
+	}{
+		{[]uint64{10, 100}, false},                         // too few to tell
+		{[]uint64{10, 10, 10, 10}, false},                  // flat
+		{[]uint64{10, 20, 20, 20, 20}, false},              // Wasm pages, grown to the working size
+		{[]uint64{10, 12, 14, 16, 18}, true},               // steady growth
+		{[]uint64{100, 101, 102, 103}, false},              // under 10%
+		{[]uint64{100, 90, 120, 115, 140, 135, 160}, true}, // RSS, noisy but growing
+		{[]uint64{100, 150, 148, 151, 149, 150}, false},    // RSS, noisy but level
+		{[]uint64{100, 200, 200, 200, 200, 202}, false},    // grew little in the second half
+	}
+	for _, tt := range tests {
+		if got := leaking(tt.samples, 0.1); got != tt.want {
+			t.Errorf("leaking(%v, 0.1) = %v, want %v", tt.samples, got, tt.want)
+		}
+	}
+}
+
+func TestComponent(t *testing.T) {
+	core, err := os.ReadFile(modules["lib"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isComponent(core) {
+		t.Errorf("isComponent(%s) = true for a core module", modules["lib"])
+	}
+	// The smallest component: the preamble alone, then a custom section.
+	component := append(slices.Clone(componentPreamble), 0, 5, 4, 'n', 'a', 'm', 'e')
+	if !isComponent(component) {
+		t.Errorf("isComponent(%x) = false", component)
+	}
+
+	file := filepath.Join(t.TempDir(), "component.wasm")
+	if err := os.WriteFile(file, component, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-cache", testCacheDir, file)
+	cmd.Env = append(os.Environ(), "WASMTEST_DRIVER=1")
+	out, err := cmd.CombinedOutput()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 2 || !bytes.Contains(out, []byte("is a component (wasip2)")) {
+		t.Errorf("driver on a component: %v, want exit status 2 and a component error\n%s", err, out)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	runDriver(t, "-threads", "-run", "library", modules["lib"])
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// With -runtime wasmtime, the driver runs the module in wasmtime
+// instead of wazero, to catch differences between the engines: it
+// builds the C host of -gen-c (see cgen.go) and runs it. The C host
+// only runs the scenarios in wasmtimeScenarios, which -run selects as
+// usual, and none of the other modes of the driver.
+//
+// The C host is built with $CC, or cc, against the wasmtime C API in
+// $WASMTIME, a directory with include and lib subdirectories, such as
+// an unpacked wasmtime-*-c-api release, or else against a system-wide
+// install. go test runs the modules this way only if $WASMTIME is set.
+
+// wasmtimeFlags are the flags that apply to the wasmtime runtime.
+var wasmtimeFlags = map[string]bool{"runtime": true, "run": true, "v": true, "golden": true, "update": true}
+
+// wasmtimeScenarios are the scenarios that the C host runs.
+var wasmtimeScenarios = []string{"executable", "library", "goroutine-switch", "reentrancy"}
+
+// runWasmtime runs the module file with the C host.
+func runWasmtime(file string, run map[string]bool) error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if !wasmtimeFlags[f.Name] && err == nil {
+			err = fmt.Errorf("-%s is not supported with -runtime wasmtime", f.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	args := []string{file}
+	if *runFlag != "" {
+		for name := range run {
+			if !slices.Contains(wasmtimeScenarios, name) {
+				return fmt.Errorf("scenario %s is not supported with -runtime wasmtime", name)
+			}
+		}
+		args = append(args, *runFlag)
+	}
+
+	dir, err := os.MkdirTemp("", "wasmtest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	src, host := filepath.Join(dir, "host.c"), filepath.Join(dir, "host")
+	if err := writeCHost(src); err != nil {
+		return err
+	}
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	ccArgs := []string{"-o", host, src}
+	if w := os.Getenv("WASMTIME"); w != "" {
+		lib := filepath.Join(w, "lib")
+		ccArgs = append(ccArgs, "-I", filepath.Join(w, "include"), "-L", lib, "-Wl,-rpath,"+lib)
+	}
+	ccArgs = append(ccArgs, "-lwasmtime")
+	if out, err := exec.Command(cc, ccArgs...).CombinedOutput(); err != nil {
+		return fmt.Errorf("building the C host: %v\n%s", err, out)
+	}
+
+	cmd := exec.Command(host, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if !*verbose {
+		cmd.Stderr = &errbuf
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("wasmtime: %v", strings.TrimSpace(err.Error()))
+	}
+	return nil
+}
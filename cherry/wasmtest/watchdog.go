@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// Every export call goes through callExport, which gives it -timeout
+// to return, as a deadlock in the guest runtime would otherwise hang
+// the driver forever. The runtime closes a module when the context of
+// a call into it is done, which aborts the call, and stackRecorder, a
+// function listener, records the functions unwound on the way out,
+// which make up the guest stack at the time.
+
+// A stackRecorder records the functions whose calls are aborted.
+type stackRecorder struct {
+	depth  int      // nesting of callExport calls
+	frames []string // functions aborted since the outermost call began, innermost first
+}
+
+var recorder stackRecorder
+
+func (s *stackRecorder) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener {
+	return s
+}
+
+func (s *stackRecorder) Before(context.Context, api.Module, api.FunctionDefinition, []uint64, experimental.StackIterator) {
+}
+
+func (s *stackRecorder) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {}
+
+func (s *stackRecorder) Abort(_ context.Context, _ api.Module, def api.FunctionDefinition, _ error) {
+	s.frames = append(s.frames, def.DebugName())
+}
+
+// newRuntime returns the runtime for the modules, and the context to
+// instantiate them with, which, with -timeout, records the stack of
+// aborted calls. With -threads, the runtime supports the threads
+// proposal (see threads.go). With -engine, it uses the compiler or
+// the interpreter. With -max-memory, it limits the linear memory of
+// modules. With -cache, compiled modules are kept in a directory.
+func newRuntime(ctx context.Context) (wazero.Runtime, context.Context) {
+	config := wazero.NewRuntimeConfigCompiler()
+	if *engineFlag == "interpreter" {
+		config = wazero.NewRuntimeConfigInterpreter()
+	}
+	if *maxMemory > 0 {
+		config = config.WithMemoryLimitPages(memoryLimitPages())
+	}
+	if *cacheDir != "" {
+		cache, err := wazero.NewCompilationCacheWithDir(*cacheDir)
+		if err != nil {
+			fail(err)
+		}
+		config = config.WithCompilationCache(cache)
+	}
+	if *threadsFlag {
+		config = config.WithCoreFeatures(api.CoreFeaturesV2 | experimental.CoreFeaturesThreads)
+	}
+	if *timeout > 0 {
+		config = config.WithCloseOnContextDone(true)
+		ctx = experimental.WithFunctionListenerFactory(ctx, &recorder)
+	}
+	return wazero.NewRuntimeWithConfig(ctx, config), ctx
+}
+
+// callExport calls the exported function f like f.Call. With -timeout,
+// if the call does not return in time, the module is closed and
+// callExport prints the guest stack and returns an error.
+func callExport(ctx context.Context, f api.Function, params ...uint64) ([]uint64, error) {
+	if f == nil {
+		return nil, errors.New("missing export")
+	}
+	if *timeout <= 0 {
+		return f.Call(ctx, params...)
+	}
+	if recorder.depth == 0 {
+		recorder.frames = recorder.frames[:0]
+	}
+	recorder.depth++
+	defer func() { recorder.depth-- }()
+
+	cctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	res, err := f.Call(cctx, params...)
+	if err != nil && errors.Is(cctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		name := f.Definition().Name()
+		fmt.Fprintf(os.Stderr, "watchdog: %s did not return within %v; guest stack:\n", name, *timeout)
+		for _, fr := range recorder.frames {
+			fmt.Fprintf(os.Stderr, "\t%s\n", fr)
+		}
+		return nil, fmt.Errorf("%s: timed out after %v", name, *timeout)
+	}
+	return res, err
+}
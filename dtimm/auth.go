@@ -0,0 +1,53 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authenticate returns a handler that serves requests with h if they
+// carry credentials, and answers others with 401 Unauthorized. The
+// credentials are either those of basic authentication, userPass as
+// user:pass, or the bearer token, whichever are set. Health checks, at
+// /healthz, need none.
+func authenticate(userPass, token string, h http.Handler) http.Handler {
+	var challenges []string
+	if userPass != "" {
+		challenges = append(challenges, `Basic realm="dtimm", charset="UTF-8"`)
+	}
+	if token != "" {
+		challenges = append(challenges, `Bearer realm="dtimm"`)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || authorized(r, userPass, token) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for _, c := range challenges {
+			w.Header().Add("WWW-Authenticate", c)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// authorized reports whether r carries the credentials.
+func authorized(r *http.Request, userPass, token string) bool {
+	if user, pass, ok := r.BasicAuth(); ok && userPass != "" {
+		return equal(user+":"+pass, userPass)
+	}
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return equal(t, token)
+	}
+	return false
+}
+
+// equal compares secrets in constant time, so that the time to refuse
+// a guess tells nothing about how close it was.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
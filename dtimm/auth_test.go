@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	const (
+		basic  = `Basic realm="dtimm", charset="UTF-8"`
+		bearer = `Bearer realm="dtimm"`
+	)
+	for _, tt := range []struct {
+		name            string
+		userPass, token string // of the server
+		path            string
+		setup           func(r *http.Request)
+		code            int
+		challenges      []string // on 401
+	}{
+		{"token/missing", "", "s3cret", "/", nil, 401, []string{bearer}},
+		{"token/wrong", "", "s3cret", "/", bearerAuth("guess"), 401, []string{bearer}},
+		{"token/prefix", "", "s3cret", "/", bearerAuth("s3cre"), 401, []string{bearer}},
+		{"token/valid", "", "s3cret", "/", bearerAuth("s3cret"), 200, nil},
+		{"token/as-basic", "", "s3cret", "/", basicAuth("s3cret", ""), 401, []string{bearer}},
+		{"token/healthz", "", "s3cret", "/healthz", nil, 200, nil},
+		{"basic/missing", "gopher:pass", "", "/", nil, 401, []string{basic}},
+		{"basic/wrong", "gopher:pass", "", "/", basicAuth("gopher", "guess"), 401, []string{basic}},
+		{"basic/valid", "gopher:pass", "", "/", basicAuth("gopher", "pass"), 200, nil},
+		{"basic/as-bearer", "gopher:pass", "", "/", bearerAuth("gopher:pass"), 401, []string{basic}},
+		{"either/missing", "gopher:pass", "s3cret", "/", nil, 401, []string{basic, bearer}},
+		{"either/basic", "gopher:pass", "s3cret", "/", basicAuth("gopher", "pass"), 200, nil},
+		{"either/token", "gopher:pass", "s3cret", "/", bearerAuth("s3cret"), 200, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+			w := httptest.NewRecorder()
+			authenticate(tt.userPass, tt.token, ok).ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Errorf("status %d, want %d", w.Code, tt.code)
+			}
+			if got := w.Header().Values("WWW-Authenticate"); !slices.Equal(got, tt.challenges) {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.challenges)
+			}
+		})
+	}
+}
+
+func basicAuth(user, pass string) func(*http.Request) {
+	return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+}
+
+func bearerAuth(token string) func(*http.Request) {
+	return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compress returns a handler that serves requests with h, and
+// compresses the responses with gzip for clients that accept it, if
+// they are at least minSize bytes long, and of a type that compresses.
+// Smaller responses would hardly shrink, if at all.
+func compress(minSize int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, min: minSize}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header accepts gzip.
+func acceptsGzip(accept string) bool {
+	for _, e := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(e, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		v, err := strconv.ParseFloat(q, 64)
+		return err == nil && v > 0
+	}
+	return false
+}
+
+// compressible reports whether content of type ct compresses well.
+func compressible(ct string) bool {
+	t, _, _ := mime.ParseMediaType(ct)
+	switch {
+	case strings.HasPrefix(t, "text/"),
+		t == "application/json",
+		t == "application/javascript",
+		t == "application/xml",
+		t == "image/svg+xml",
+		t == "application/wasm":
+		return true
+	}
+	return false
+}
+
+// A gzipWriter holds back the header and the start of a response until
+// it has min bytes of it, or the response ends or is flushed, and then
+// decides whether to compress it.
+type gzipWriter struct {
+	http.ResponseWriter
+	min     int
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer // if compressing
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(b)
+	case w.decided:
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.min {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide writes the header and what is held back of the response,
+// compressed if it is long enough and compresses.
+func (w *gzipWriter) decide() error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// As the server would, but from the uncompressed bytes.
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if len(w.buf) < w.min || w.status != http.StatusOK || h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buf)
+		return err
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	return err
+}
+
+// FlushError sends what the handler wrote so far, for
+// http.ResponseController.
+func (w *gzipWriter) FlushError() error {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Flush is FlushError, for http.Flusher.
+func (w *gzipWriter) Flush() {
+	w.FlushError()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close ends the response once the handler returns.
+func (w *gzipWriter) close() error {
+	if !w.decided {
+		if w.status == 0 {
+			// Nothing written: leave it to the server.
+			return nil
+		}
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
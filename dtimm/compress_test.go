@@ -0,0 +1,163 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tt := range []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.5", true},
+		{"gzip; q=0", false},
+		{"gzip;q=0.0", false},
+		{"br", false},
+		{"br, *", true},
+		{"*;q=0", false},
+		{"identity", false},
+		{"x-gzip", false},
+	} {
+		if got := acceptsGzip(tt.accept); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	long := strings.Repeat("Hello, Gopher! ", 100)
+	for _, tt := range []struct {
+		name   string
+		accept string
+		min    int
+		ctype  string // set by the handler, if not ""
+		status int
+		body   string
+		gzip   bool
+	}{
+		{"gzip", "gzip", 1024, "text/plain", 200, long, true},
+		{"not-accepted", "", 1024, "text/plain", 200, long, false},
+		{"refused", "gzip;q=0", 1024, "text/plain", 200, long, false},
+		{"any", "br, *", 1024, "text/plain", 200, long, true},
+		{"short", "gzip", 1024, "text/plain", 200, "Hello, Gopher!", false},
+		{"min-0", "gzip", 0, "text/plain", 200, "Hello, Gopher!", true},
+		{"json", "gzip", 1024, "application/json", 200, `"` + long + `"`, true},
+		{"detected", "gzip", 1024, "", 200, "<!DOCTYPE html>" + long, true},
+		{"incompressible", "gzip", 1024, "image/png", 200, long, false},
+		{"error", "gzip", 1024, "text/plain", 404, long, false},
+		{"empty", "gzip", 0, "", 204, "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := compress(tt.min, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.ctype != "" {
+					w.Header().Set("Content-Type", tt.ctype)
+				}
+				w.WriteHeader(tt.status)
+				// In pieces, some shorter than min and some longer.
+				for s := tt.body; s != ""; {
+					n := min(len(s), 700)
+					io.WriteString(w, s[:n])
+					s = s[n:]
+				}
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			resp := w.Result()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			if tt.ctype == "" && tt.body != "" {
+				if got, want := resp.Header.Get("Content-Type"), "text/html; charset=utf-8"; got != want {
+					t.Errorf("Content-Type = %q, want %q", got, want)
+				}
+			}
+			body := resp.Body
+			if enc := resp.Header.Get("Content-Encoding"); tt.gzip {
+				if enc != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", enc)
+				}
+				zr, err := gzip.NewReader(body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			} else if enc != "" {
+				t.Fatalf("Content-Encoding = %q, want none", enc)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+// TestCompressEvents checks that the events of /events reach the client
+// when they are sent, compressed or not, rather than when the stream
+// ends, which it never does.
+func TestCompressEvents(t *testing.T) {
+	for _, tt := range []struct {
+		min  int
+		gzip bool
+	}{
+		{1024, false}, // an event is shorter than that
+		{0, true},
+	} {
+		srv := httptest.NewServer(compress(tt.min, http.HandlerFunc(events)))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events?name=Gopher", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Set explicitly, the Transport leaves the body compressed.
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body io.Reader = resp.Body
+		if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" && tt.gzip || enc != "" && !tt.gzip {
+			t.Errorf("min %d: Content-Encoding = %q", tt.min, enc)
+		}
+		if tt.gzip {
+			if body, err = gzip.NewReader(body); err != nil {
+				t.Fatalf("min %d: %v", tt.min, err)
+			}
+		}
+		// Reading the first event must not wait for the stream to end,
+		// or it times out.
+		line, err := bufio.NewReader(body).ReadString('\n')
+		if err != nil || line != "id: 1\n" {
+			t.Errorf("min %d: first line = %q, %v, want %q", tt.min, line, err, "id: 1\n")
+		}
+		cancel()
+		resp.Body.Close()
+		srv.Close()
+	}
+}
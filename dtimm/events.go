@@ -0,0 +1,59 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// stopping is closed when the server shuts down, to end the event
+// streams, which Shutdown would otherwise wait for.
+var stopping = make(chan struct{})
+
+// An event is the data of an event of /events.
+type event struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// events streams the greeting, for the name in the query, if any, with
+// the time, every second, as server-sent events, until the client goes
+// away or the server shuts down.
+func events(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlasts -write-timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+
+	tr, name := localize(r), r.URL.Query().Get("name")
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for id := 1; ; id++ {
+		data, err := json.Marshal(event{message(tr, name), time.Now()})
+		if err != nil {
+			panic(err) // cannot happen
+		}
+		fmt.Fprintf(w, "id: %d\nevent: greeting\ndata: %s\n\n", id, data)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-tick.C:
+		case <-r.Context().Done():
+			return
+		case <-stopping:
+			return
+		}
+	}
+}
@@ -0,0 +1,12 @@
+module golang.org/x/scratch/dtimm
+
+go 1.22
+
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	golang.org/x/net v0.23.0
+	golang.org/x/text v0.14.0
+	golang.org/x/time v0.5.0
+)
+
+require golang.org/x/sys v0.18.0 // indirect
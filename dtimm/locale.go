@@ -0,0 +1,45 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// A translation is the greeting in one language, as formats of the
+// year and, for the named greeting, the name.
+type translation struct {
+	tag             language.Tag
+	everyone, named string
+}
+
+// translations are the languages of the greeting. The first, English,
+// is the one for clients that accept none of them.
+var translations = []translation{
+	{language.English, "Hello from GopherCon %[1]d!", "Hello %[2]s, from GopherCon %[1]d!"},
+	{language.French, "Bonjour de la GopherCon %[1]d !", "Bonjour %[2]s, de la GopherCon %[1]d !"},
+	{language.German, "Hallo von der GopherCon %[1]d!", "Hallo %[2]s, von der GopherCon %[1]d!"},
+	{language.Spanish, "¡Hola desde la GopherCon %[1]d!", "¡Hola %[2]s, desde la GopherCon %[1]d!"},
+	{language.Italian, "Ciao dalla GopherCon %[1]d!", "Ciao %[2]s, dalla GopherCon %[1]d!"},
+	{language.Japanese, "GopherCon %[1]d からこんにちは！", "%[2]sさん、GopherCon %[1]d からこんにちは！"},
+}
+
+var matcher = func() language.Matcher {
+	var tags []language.Tag
+	for _, t := range translations {
+		tags = append(tags, t.tag)
+	}
+	return language.NewMatcher(tags)
+}()
+
+// localize returns the translation for r: in the language of its lang
+// parameter, if it names one, or else the best for its Accept-Language
+// header.
+func localize(r *http.Request) *translation {
+	_, i := language.MatchStrings(matcher, r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+	return &translations[i]
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalize(t *testing.T) {
+	for _, tt := range []struct {
+		lang   string // parameter
+		accept string // Accept-Language
+		want   string
+	}{
+		{"", "", "en"},
+		{"", "fr", "fr"},
+		{"", "fr-CA", "fr"},
+		{"", "de-AT,de;q=0.9,en;q=0.8", "de"},
+		{"", "en;q=0.5, ja", "ja"},
+		{"", "es-419", "es"},
+		{"", "pt-BR", "en"},
+		{"", "sw, it;q=0.1", "it"},
+		{"", "*", "en"},
+		{"", "garbage;;", "en"},
+		{"it", "", "it"},
+		{"it", "fr", "it"},
+		{"ja-JP", "de", "ja"},
+		{"not a language", "es", "es"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.lang != "" {
+			r.URL.RawQuery = "lang=" + strings.ReplaceAll(tt.lang, " ", "+")
+		}
+		if tt.accept != "" {
+			r.Header.Set("Accept-Language", tt.accept)
+		}
+		if got := localize(r).tag.String(); got != tt.want {
+			t.Errorf("lang=%q, Accept-Language %q: localize = %s, want %s", tt.lang, tt.accept, got, tt.want)
+		}
+	}
+}
+
+// TestIndexLang checks that the landing page declares the language of
+// its greeting, but none when the greeting is that of -message-file.
+func TestIndexLang(t *testing.T) {
+	get := func(accept string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", accept)
+		w := httptest.NewRecorder()
+		index(w, r)
+		if w.Code != 200 {
+			t.Fatalf("GET / with Accept-Language %q: status %d: %s", accept, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+	for _, tt := range []struct{ accept, html, greeting string }{
+		{"fr", `<html lang="fr">`, "Bonjour de la GopherCon"},
+		{"ja", `<html lang="ja">`, "からこんにちは"},
+		{"pt", `<html lang="en">`, "Hello from GopherCon"},
+	} {
+		body := get(tt.accept)
+		if !strings.Contains(body, tt.html) || !strings.Contains(body, tt.greeting) {
+			t.Errorf("GET / with Accept-Language %q: want %s and %q in\n%s", tt.accept, tt.html, tt.greeting, body)
+		}
+	}
+
+	m := "Welcome!"
+	fileMessage.Store(&m)
+	t.Cleanup(func() { fileMessage.Store(nil) })
+	body := get("fr")
+	if !strings.Contains(body, "<html>") || !strings.Contains(body, m) {
+		t.Errorf("GET / with -message-file: want <html> and %q in\n%s", m, body)
+	}
+}
@@ -2,19 +2,259 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// dtimm command hosts a friendly message on port :8080.
+// dtimm command hosts a friendly message on port :8080, in a page
+// made from index.html. To edit the page and see the changes on reload,
+// run it from this directory with -dev.
+//
+// It also serves the message as JSON, for API clients to practice on:
+//
+//	GET /api/greeting?name=Gopher
+//
+// and streams it, with the time, every second, as server-sent events
+// (see events.go):
+//
+//	GET /events?name=Gopher
+//
+// All greet in the language of the lang parameter or, without one, of
+// the Accept-Language header, if they know it, or else in English (see
+// locale.go).
+//
+// With -static, it also serves the files in a directory under /static/.
+//
+// With -rps, it limits the rate of requests of each client, and answers
+// those over the limit with 429 Too Many Requests. Over -unix, all
+// requests count as those of one client.
+//
+// With -message-file, the greeting is the contents of a file instead,
+// which it reads again on SIGHUP or when the file changes.
+//
+// With -h2c, it also serves HTTP/2 without TLS, to clients that start
+// with it, such as curl --http2-prior-knowledge, or upgrade to it.
+//
+// It compresses responses with gzip, for clients that accept it, if
+// they are at least -gzip-min bytes long.
+//
+// With -auth or -auth-token, it requires basic authentication or a
+// bearer token, or either, for all but /healthz, which reports that the
+// server is up.
+//
+// With -unix, it listens on a Unix domain socket instead of port
+// :8080, with the permissions of -unix-mode, for a proxy or another
+// process on the same machine, and removes the socket when it stops.
+// It stops on SIGINT or SIGTERM, after serving the requests under way.
+//
+// The server times out slow clients and idle connections, as the
+// -read-header-timeout, -read-timeout, -write-timeout and -idle-timeout
+// flags say.
+//
+// It logs each request with log/slog, as text or, with -log-format
+// json, as JSON, to standard error.
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// year is the year of the conference in the greeting.
+const year = 2018
+
+var (
+	logFormat = flag.String("log-format", "text", "log requests as `text` or json")
+	dev       = flag.Bool("dev", false, "parse index.html from the current directory for each request")
+	staticDir = flag.String("static", "", "serve the files in `dir` under /static/")
+	rps       = flag.Float64("rps", 0, "limit each client to `n` requests per second, on average (default no limit)")
+	burst     = flag.Int("burst", 10, "with -rps, let each client send `n` requests at once")
+	msgFile   = flag.String("message-file", "", "greet with the contents of `file`, reloaded when it changes")
+	h2cFlag   = flag.Bool("h2c", false, "also serve HTTP/2 without TLS (h2c)")
+	authFlag  = flag.String("auth", "", "require basic authentication as `user:pass`")
+	authToken = flag.String("auth-token", "", "require the bearer `token`")
+	unixPath  = flag.String("unix", "", "listen on the Unix domain socket `path` instead of :8080")
+	unixMode  = flag.String("unix-mode", "0660", "with -unix, the permissions of the socket, in `octal`")
+	gzipMin   = flag.Int("gzip-min", 1024, "compress responses of at least `n` bytes with gzip, if the client accepts it (-1 to never compress)")
+
+	readHeaderTimeout = flag.Duration("read-header-timeout", 5*time.Second, "time out reading the header of a request after `duration`")
+	readTimeout       = flag.Duration("read-timeout", 10*time.Second, "time out reading a whole request after `duration`")
+	writeTimeout      = flag.Duration("write-timeout", 30*time.Second, "time out writing a response after `duration` from the end of the request header")
+	idleTimeout       = flag.Duration("idle-timeout", 2*time.Minute, "close connections idle for `duration` between requests")
 )
 
 func main() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		io.WriteString(w, "Hello from GopherCon 2018!")
+	flag.Parse()
+	var lh slog.Handler
+	switch *logFormat {
+	case "text":
+		lh = slog.NewTextHandler(os.Stderr, nil)
+	case "json":
+		lh = slog.NewJSONHandler(os.Stderr, nil)
+	default:
+		log.Fatalf("-log-format: unknown format %q, want text or json", *logFormat)
+	}
+	logger := slog.New(lh)
+	if *msgFile != "" {
+		if _, err := loadMessage(*msgFile); err != nil {
+			log.Fatal(err)
+		}
+		if err := watchMessage(*msgFile, logger); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", index)
+	mux.HandleFunc("GET /api/greeting", apiGreeting)
+	mux.HandleFunc("GET /events", events)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	if *staticDir != "" {
+		mux.Handle("GET /static/", http.StripPrefix("/static", serveStatic(http.Dir(*staticDir))))
+	}
+
+	var h http.Handler = mux
+	if *authFlag != "" || *authToken != "" {
+		h = authenticate(*authFlag, *authToken, h)
+	}
+	if *rps > 0 {
+		h = newLimiter(*rps, *burst).limit(h)
+	}
+	if *gzipMin >= 0 {
+		h = compress(*gzipMin, h)
+	}
+	h = logRequests(logger, h)
+	if *h2cFlag {
+		h = h2c.NewHandler(h, &http2.Server{IdleTimeout: *idleTimeout})
+	}
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+	srv.RegisterOnShutdown(func() { close(stopping) })
+	ln, err := listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		logger.Info("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("shutting down", "err", err)
+		}
+	}()
+	// Closing the listener, as Serve does on Shutdown, removes the
+	// socket of -unix.
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// Serve returns at once, but Shutdown waits for the requests.
+	<-done
+}
+
+// listen returns the listener of the server: on -unix, if set, or else
+// on :8080.
+func listen() (net.Listener, error) {
+	if *unixPath == "" {
+		return net.Listen("tcp", ":8080")
+	}
+	mode, err := strconv.ParseUint(*unixMode, 8, 32)
+	if err != nil || mode&^0777 != 0 {
+		return nil, fmt.Errorf("-unix-mode: invalid permissions %q", *unixMode)
+	}
+	// Remove the socket of an earlier run that did not stop cleanly,
+	// but nothing else.
+	if fi, err := os.Lstat(*unixPath); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		os.Remove(*unixPath)
+	}
+	ln, err := net.Listen("unix", *unixPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(*unixPath, fs.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serveStatic returns a handler that serves the files in dir, which
+// clients may cache for an hour, or with -dev, only after checking that
+// they have not changed. The file server sets Last-Modified, and
+// answers If-Modified-Since, for that.
+func serveStatic(dir http.Dir) http.Handler {
+	fs := http.FileServer(dir)
+	cc := "public, max-age=3600"
+	if *dev {
+		cc = "no-cache"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Don't let clients cache errors.
+		if f, err := dir.Open(r.URL.Path); err == nil {
+			f.Close()
+			w.Header().Set("Cache-Control", cc)
+		}
+		fs.ServeHTTP(w, r)
 	})
+}
+
+// message returns the greeting for name, or for everyone if name is
+// empty, in the language of tr. The greeting from -message-file, if
+// any, is for everyone, so a name goes before it, and is in its own
+// language.
+func message(tr *translation, name string) string {
+	if m := fileMessage.Load(); m != nil {
+		if name == "" {
+			return *m
+		}
+		return fmt.Sprintf("Hello %s! %s", name, *m)
+	}
+	if name == "" {
+		return fmt.Sprintf(tr.everyone, year)
+	}
+	return fmt.Sprintf(tr.named, year, name)
+}
+
+// A greeting is the response of /api/greeting.
+type greeting struct {
+	Message  string `json:"message"`
+	Year     int    `json:"year"`
+	Hostname string `json:"hostname"`
+}
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// apiGreeting serves the greeting as JSON, for the name in the query,
+// if any.
+func apiGreeting(w http.ResponseWriter, r *http.Request) {
+	host, err := os.Hostname()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	g := greeting{Message: message(localize(r), r.URL.Query().Get("name")), Year: year, Hostname: host}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(g)
 }
@@ -0,0 +1,85 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileMessage is the greeting read from -message-file, if any.
+var fileMessage atomic.Pointer[string]
+
+// loadMessage reads the greeting from file, and reports whether it
+// changed.
+func loadMessage(file string) (bool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	s := strings.TrimSpace(string(b))
+	if old := fileMessage.Load(); old != nil && *old == s {
+		return false, nil
+	}
+	fileMessage.Store(&s)
+	return true, nil
+}
+
+// watchMessage reads the greeting from file again whenever the process
+// gets SIGHUP or the file may have changed, and logs the changes to
+// logger. If reading fails, the greeting stays as it was.
+func watchMessage(file string, logger *slog.Logger) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watch the directory rather than the file: editors replace a file
+	// by renaming another over it, which would end a watch of the file
+	// itself. Kubernetes, for mounted ConfigMaps, instead swaps a
+	// symbolic link, ..data, which the file links to through another;
+	// the file itself has no events at all. So any file created or
+	// renamed in the directory may change the greeting, and it is read
+	// again to see.
+	if err := w.Add(filepath.Dir(file)); err != nil {
+		w.Close()
+		return err
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reload := func(cause string) {
+		changed, err := loadMessage(file)
+		if err != nil {
+			logger.Error("reloading message", "file", file, "cause", cause, "err", err)
+			return
+		}
+		if changed {
+			logger.Info("reloaded message", "file", file, "cause", cause)
+		}
+	}
+	go func() {
+		for {
+			select {
+			case <-hup:
+				reload("SIGHUP")
+			case ev := <-w.Events:
+				if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Rename) ||
+					ev.Has(fsnotify.Write) && filepath.Clean(ev.Name) == filepath.Clean(file) {
+					reload(ev.Op.String() + " " + filepath.Base(ev.Name))
+				}
+			case err := <-w.Errors:
+				logger.Error("watching message", "file", file, "err", err)
+			}
+		}
+	}()
+	return nil
+}
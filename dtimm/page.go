@@ -0,0 +1,54 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+//go:embed index.html
+var embedded embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(embedded, "index.html"))
+
+// A page is what index.html shows.
+type page struct {
+	Lang      string // BCP 47 tag of the language of Greeting, if known
+	Greeting  string
+	Time      time.Time
+	GoVersion string
+}
+
+// index serves the landing page. With -dev, it parses index.html from
+// the current directory first, so that edits show on reload.
+func index(w http.ResponseWriter, r *http.Request) {
+	t := indexTemplate
+	if *dev {
+		var err error
+		t, err = template.ParseFiles("index.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	tr := localize(r)
+	p := page{Lang: tr.tag.String(), Greeting: message(tr, ""), Time: time.Now(), GoVersion: runtime.Version()}
+	if fileMessage.Load() != nil {
+		p.Lang = "" // the greeting is in the language of the file
+	}
+	// Execute into a buffer, so that an error can still be reported.
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
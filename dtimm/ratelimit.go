@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A limiter limits the rate of requests of each client, by IP address,
+// with a token bucket per client.
+//
+// Requests over a Unix domain socket, as with -unix, carry no address
+// (their RemoteAddr is "@" or ""), so all their clients share one
+// bucket. That is the proxy in front of the server, which sends the
+// requests of all its clients; the proxy must limit them itself.
+type limiter struct {
+	rps   rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*client
+	swept   time.Time // when idle clients were last removed
+}
+
+type client struct {
+	bucket *rate.Limiter
+	seen   time.Time
+}
+
+// idle is how long a client is kept after its last request. By then,
+// its bucket is full again, so forgetting it changes nothing.
+const idle = 5 * time.Minute
+
+func newLimiter(rps float64, burst int) *limiter {
+	return &limiter{rps: rate.Limit(rps), burst: burst, clients: make(map[string]*client)}
+}
+
+// reserve takes a token for a request from ip, and returns how long
+// the request must wait for it, or 0 if it need not.
+func (l *limiter) reserve(ip string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > idle {
+		for ip, c := range l.clients {
+			if now.Sub(c.seen) > idle {
+				delete(l.clients, ip)
+			}
+		}
+		l.swept = now
+	}
+	c := l.clients[ip]
+	if c == nil {
+		c = &client{bucket: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[ip] = c
+	}
+	c.seen = now
+	r := c.bucket.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Duration(math.MaxInt64)
+	}
+	d := r.DelayFrom(now)
+	if d > 0 {
+		// The request is refused, not delayed, so it does not
+		// use the token.
+		r.CancelAt(now)
+	}
+	return d
+}
+
+// limit returns a handler that serves requests with h, unless their
+// client has sent too many, which it answers with 429 Too Many
+// Requests, and when to retry.
+func (l *limiter) limit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if d := l.reserve(ip, time.Now()); d > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(min(d, time.Hour).Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
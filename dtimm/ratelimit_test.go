@@ -0,0 +1,69 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimit(t *testing.T) {
+	h := newLimiter(1, 2).limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range []struct {
+		addr string
+		code int
+	}{
+		{"192.0.2.1:1234", http.StatusOK},
+		{"192.0.2.1:1235", http.StatusOK},
+		{"192.0.2.1:1236", http.StatusTooManyRequests}, // another port, the same client
+		{"192.0.2.2:1234", http.StatusOK},
+		{"[2001:db8::1]:1234", http.StatusOK},
+		// Clients over a Unix domain socket share one bucket.
+		{"@", http.StatusOK},
+		{"@", http.StatusOK},
+		{"@", http.StatusTooManyRequests},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("request from %s: status %d, want %d", tt.addr, w.Code, tt.code)
+		}
+		want := ""
+		if tt.code == http.StatusTooManyRequests {
+			want = "1"
+		}
+		if got := w.Header().Get("Retry-After"); got != want {
+			t.Errorf("request from %s: Retry-After %q, want %q", tt.addr, got, want)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	l := newLimiter(2, 1)
+	now := time.Now()
+	for _, tt := range []struct {
+		after time.Duration // since now
+		ip    string
+		wait  time.Duration
+	}{
+		{0, "a", 0},
+		{0, "a", 500 * time.Millisecond},
+		{0, "b", 0},
+		{100 * time.Millisecond, "a", 400 * time.Millisecond}, // the refused request took no token
+		{500 * time.Millisecond, "a", 0},
+		{2 * idle, "a", 0},
+	} {
+		if got := l.reserve(tt.ip, now.Add(tt.after)); got != tt.wait {
+			t.Errorf("reserve(%q) after %v = %v, want %v", tt.ip, tt.after, got, tt.wait)
+		}
+	}
+	if _, ok := l.clients["b"]; ok {
+		t.Errorf("idle client b was not forgotten")
+	}
+}
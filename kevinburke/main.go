@@ -2,27 +2,248 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// kevinburke prints a random quote.
+//
+// With -quotes, it picks from the quotes in a file instead, one per
+// line, each either the text alone, or a weight, a tab and the text,
+// or a weight, a tab, comma-separated tags, a tab and the text. A
+// quote of weight 3 comes up three times as often as one of weight 1,
+// the weight of a quote without one.
+//
+// With -tag, it picks only from the quotes with one of the tags given,
+// and with -list-tags, it lists the tags of the quotes instead.
+//
+// With -json, it prints the quote as a JSON object, with the source,
+// character, season and episode of the quote, where known, and its
+// tags, for programs to read.
+//
+// It picks with crypto/rand. With -seed, it picks with math/rand, seeded
+// with the value given, instead, so that it picks the same quote every
+// time for the same seed and quotes, for scripts and tests.
 package main
 
 import (
+	"bufio"
 	"crypto/rand"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"log"
+	"maps"
+	"math"
 	"math/big"
 	mrand "math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 )
 
-var quotes = []string{
-	"This here’s a gun powder activated, 27 caliber, full auto, no kickback, nail-throwing mayhem man",
-	"You come at the king, you best not miss.",
-	"A life. A life, Jimmy, you know what that is? It's the stuff that happens while you're waiting for moments that never come.",
+// A quote is a quote, where it comes from, if known, its tags, and its
+// weight in the selection. Its exported fields are what -json prints.
+type quote struct {
+	Text      string   `json:"text"`
+	Source    string   `json:"source,omitempty"`
+	Character string   `json:"character,omitempty"`
+	Season    int      `json:"season,omitempty"`
+	Episode   int      `json:"episode,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	weight    int
 }
 
+var quotes = []quote{
+	{
+		Text:      "This here’s a gun powder activated, 27 caliber, full auto, no kickback, nail-throwing mayhem man",
+		Source:    "The Wire",
+		Character: "Snoop",
+		Season:    4,
+		Episode:   1,
+		Tags:      []string{"wire", "crime"},
+		weight:    1,
+	},
+	{
+		Text:      "You come at the king, you best not miss.",
+		Source:    "The Wire",
+		Character: "Omar Little",
+		Season:    1,
+		Episode:   8,
+		Tags:      []string{"wire", "crime"},
+		weight:    1,
+	},
+	{
+		Text:      "A life. A life, Jimmy, you know what that is? It's the stuff that happens while you're waiting for moments that never come.",
+		Source:    "The Wire",
+		Character: "Lester Freamon",
+		Tags:      []string{"wire", "life"},
+		weight:    1,
+	},
+}
+
+var (
+	quotesFile = flag.String("quotes", "", "pick from the quotes in `file`, one per line, each [weight<TAB>[tags<TAB>]]text")
+	seed       = flag.Int64("seed", 0, "pick with the `seed` given, rather than a random one")
+	tagFlag    = flag.String("tag", "", "pick only from the quotes with one of the comma-separated `tags`")
+	listTags   = flag.Bool("list-tags", false, "list the tags of the quotes, and how many have each, instead")
+	jsonFlag   = flag.Bool("json", false, "print the quote as JSON, with where it comes from")
+)
+
 func main() {
-	n, err := rand.Int(rand.Reader, big.NewInt(2<<32-1))
+	log.SetFlags(0)
+	log.SetPrefix("kevinburke: ")
+	flag.Parse()
+	if *quotesFile != "" {
+		var err error
+		quotes, err = readQuotes(*quotesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tagFlag != "" {
+		quotes = tagged(quotes, strings.Split(*tagFlag, ","))
+		if len(quotes) == 0 {
+			log.Fatalf("no quotes tagged %s", *tagFlag)
+		}
+	}
+	if *listTags {
+		counts := make(map[string]int)
+		for _, q := range quotes {
+			for _, t := range q.Tags {
+				counts[t]++
+			}
+		}
+		for _, t := range slices.Sorted(maps.Keys(counts)) {
+			fmt.Printf("%s\t%d\n", t, counts[t])
+		}
+		return
+	}
+
+	intn := cryptoIntn
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			intn = seededIntn(*seed)
+		}
+	})
+	q, err := choose(quotes, intn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *jsonFlag {
+		if err := json.NewEncoder(os.Stdout).Encode(q); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Println(q.Text)
+}
+
+// maxTotal is the largest total weight of the quotes, so that it fits
+// in an int, and so a random int in [0, total) can be drawn, everywhere.
+const maxTotal = math.MaxInt32
+
+// total returns the sum of the weights of qs, which readQuotes keeps
+// at most maxTotal.
+func total(qs []quote) int {
+	t := 0
+	for _, q := range qs {
+		t += q.weight
+	}
+	return t
+}
+
+// choose returns a quote of qs, picked with intn, which returns a
+// uniformly random int in [0, n), so that a quote comes up as often as
+// its weight says.
+func choose(qs []quote, intn func(n int) (int, error)) (quote, error) {
+	x, err := intn(total(qs))
+	if err != nil {
+		return quote{}, err
+	}
+	return pick(qs, x), nil
+}
+
+// cryptoIntn returns a uniformly random int in [0, n), drawn from
+// crypto/rand, which is unbiased and needs no seed.
+func cryptoIntn(n int) (int, error) {
+	x, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(x.Int64()), nil
+}
+
+// seededIntn returns a function that returns a uniformly random int in
+// [0, n) drawn from math/rand seeded with seed, so that it returns the
+// same ints in turn for the same seed.
+func seededIntn(seed int64) func(n int) (int, error) {
+	r := mrand.New(mrand.NewSource(seed))
+	return func(n int) (int, error) { return r.Intn(n), nil }
+}
+
+// pick returns the quote of qs at x, in [0, total(qs)), where each
+// quote takes up as many places as its weight.
+func pick(qs []quote, x int) quote {
+	for _, q := range qs {
+		if x < q.weight {
+			return q
+		}
+		x -= q.weight
+	}
+	panic("pick: out of range")
+}
+
+// tagged returns the quotes of qs with any of tags.
+func tagged(qs []quote, tags []string) []quote {
+	var out []quote
+	for _, q := range qs {
+		if slices.ContainsFunc(q.Tags, func(t string) bool { return slices.Contains(tags, t) }) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+// readQuotes reads the quotes in file, skipping blank lines.
+func readQuotes(file string) ([]quote, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	defer f.Close()
+	var qs []quote
+	sum := 0
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		q := quote{Text: text, weight: 1}
+		if f := strings.SplitN(text, "\t", 3); len(f) > 1 {
+			n, err := strconv.Atoi(f[0])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("%s:%d: invalid weight %q", file, line, f[0])
+			}
+			q.weight = n
+			q.Text = strings.TrimSpace(f[len(f)-1])
+			if len(f) == 3 {
+				for _, t := range strings.Split(f[1], ",") {
+					if t = strings.TrimSpace(t); t != "" {
+						q.Tags = append(q.Tags, t)
+					}
+				}
+			}
+		}
+		if q.weight > maxTotal-sum {
+			return nil, fmt.Errorf("%s:%d: weight %d takes the total weight over %d", file, line, q.weight, maxTotal)
+		}
+		sum += q.weight
+		qs = append(qs, q)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(qs) == 0 {
+		return nil, fmt.Errorf("%s: no quotes", file)
 	}
-	r := mrand.New(mrand.NewSource(n.Int64()))
-	choice := r.Intn(len(quotes))
-	fmt.Println(quotes[choice])
+	return qs, nil
 }
@@ -0,0 +1,185 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var weighted = []quote{
+	{Text: "a", weight: 1},
+	{Text: "b", weight: 3},
+	{Text: "c", weight: 2},
+}
+
+func TestPick(t *testing.T) {
+	// total(weighted) is 6: a at 0, b at 1 to 3, c at 4 and 5.
+	for x, want := range []string{"a", "b", "b", "b", "c", "c"} {
+		if got := pick(weighted, x).Text; got != want {
+			t.Errorf("pick(%d) = %q, want %q", x, got, want)
+		}
+	}
+}
+
+func TestChoose(t *testing.T) {
+	tests := []struct {
+		x    int
+		err  error
+		want string
+	}{
+		{x: 0, want: "a"},
+		{x: 3, want: "b"},
+		{x: 5, want: "c"},
+		{err: errors.New("no randomness")},
+	}
+	for _, tt := range tests {
+		var n int
+		intn := func(m int) (int, error) {
+			n = m
+			return tt.x, tt.err
+		}
+		q, err := choose(weighted, intn)
+		if n != 6 {
+			t.Errorf("choose called intn(%d), want intn(6), the total weight", n)
+		}
+		if err != tt.err || q.Text != tt.want {
+			t.Errorf("choose with intn returning %d, %v = %q, %v, want %q, %v", tt.x, tt.err, q.Text, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestReadQuotesTotal(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr string
+	}{
+		{fmt.Sprintf("%d\tmost\n", maxTotal), ""},
+		{fmt.Sprintf("%d\tmost\none\n", maxTotal-1), ""},
+		{fmt.Sprintf("%d\ttoo much\n", maxTotal+1), "over"},
+		{fmt.Sprintf("%d\tmost\none more\n", maxTotal), "over"},
+		{fmt.Sprintf("%d\ta\n%d\tb\n", maxTotal/2+1, maxTotal/2+1), "over"},
+		{"99999999999999999999\toverflows int\n", "invalid weight"},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "quotes")
+		if err := os.WriteFile(file, []byte(tt.in), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		qs, err := readQuotes(file)
+		if tt.wantErr == "" {
+			if err != nil || total(qs) <= 0 {
+				t.Errorf("readQuotes(%q) = total %d, %v, want no error", tt.in, total(qs), err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("readQuotes(%q) error = %v, want one containing %q", tt.in, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadQuotesTags(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quotes")
+	in := "plain\n2\tweighted\n3\twire, crime ,\ttagged\n1\t\tno tags\n"
+	if err := os.WriteFile(file, []byte(in), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	qs, err := readQuotes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []quote{
+		{Text: "plain", weight: 1},
+		{Text: "weighted", weight: 2},
+		{Text: "tagged", Tags: []string{"wire", "crime"}, weight: 3},
+		{Text: "no tags", weight: 1},
+	}
+	if !reflect.DeepEqual(qs, want) {
+		t.Errorf("readQuotes(%q) = %+v, want %+v", in, qs, want)
+	}
+}
+
+func TestTagged(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want []string // texts of the quotes
+	}{
+		{[]string{"wire"}, []string{"This here’s", "You come at the king", "A life."}},
+		{[]string{"crime"}, []string{"This here’s", "You come at the king"}},
+		{[]string{"life"}, []string{"A life."}},
+		{[]string{"life", "crime"}, []string{"This here’s", "You come at the king", "A life."}},
+		{[]string{"Wire"}, nil},
+		{[]string{"nothing"}, nil},
+		{[]string{""}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, q := range tagged(quotes, tt.tags) {
+			got = append(got, q.Text)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("tagged(%q) = %q, want %d quotes", tt.tags, got, len(tt.want))
+			continue
+		}
+		for i, text := range got {
+			if !strings.HasPrefix(text, tt.want[i]) {
+				t.Errorf("tagged(%q)[%d] = %q, want one starting %q", tt.tags, i, text, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		q    quote
+		want string
+	}{
+		{quote{Text: "plain", weight: 3}, `{"text":"plain"}`},
+		{
+			quote{Text: "You come at the king, you best not miss.", Source: "The Wire", Character: "Omar Little", Season: 1, Episode: 8, Tags: []string{"wire", "crime"}, weight: 1},
+			`{"text":"You come at the king, you best not miss.","source":"The Wire","character":"Omar Little","season":1,"episode":8,"tags":["wire","crime"]}`,
+		},
+		{quote{Text: "no episode", Source: "The Wire", Season: 2}, `{"text":"no episode","source":"The Wire","season":2}`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("json.Marshal(%+v) = %s, want %s", tt.q, b, tt.want)
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	draw := func(seed int64) []string {
+		intn := seededIntn(seed)
+		var texts []string
+		for range 20 {
+			q, err := choose(weighted, intn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			texts = append(texts, q.Text)
+		}
+		return texts
+	}
+	a, b := draw(1), draw(1)
+	if !slices.Equal(a, b) {
+		t.Errorf("seed 1 picked %q, then %q", a, b)
+	}
+	if c := draw(2); slices.Equal(a, c) {
+		t.Errorf("seeds 1 and 2 both picked %q", a)
+	}
+}